@@ -8,7 +8,6 @@ import (
 
 	apperrors "github.com/fathindos/agit/internal/errors"
 	"github.com/fathindos/agit/internal/git"
-	"github.com/fathindos/agit/internal/registry"
 	"github.com/fathindos/agit/internal/ui"
 )
 
@@ -46,7 +45,7 @@ var addCmd = &cobra.Command{
 		}
 
 		// Open registry and add
-		db, err := registry.Open()
+		db, err := openRegistry()
 		if err != nil {
 			return fmt.Errorf("could not open registry: %w", err)
 		}
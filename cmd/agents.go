@@ -7,7 +7,7 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/fathindos/agit/internal/config"
-	"github.com/fathindos/agit/internal/registry"
+	apperrors "github.com/fathindos/agit/internal/errors"
 	"github.com/fathindos/agit/internal/ui"
 )
 
@@ -23,12 +23,18 @@ type agentJSON struct {
 var agentsCmd = &cobra.Command{
 	Use:   "agents",
 	Short: "List and manage registered agents",
-	Long:  `List all registered agents, sweep stale agents, or remove an agent by name.`,
+	Long: `List all registered agents, sweep stale agents, or remove an agent by name.
+
+--sweep also reclaims tasks whose lease has expired: they return to pending,
+or are failed once they reach agent.max_task_attempts.
+
+agit agents token issues the bearer tokens agents use to authenticate to
+agit serve --transport http.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		sweep, _ := cmd.Flags().GetBool("sweep")
 		remove, _ := cmd.Flags().GetString("remove")
 
-		db, err := registry.Open()
+		db, err := openRegistry()
 		if err != nil {
 			return fmt.Errorf("could not open registry: %w", err)
 		}
@@ -48,10 +54,26 @@ var agentsCmd = &cobra.Command{
 			if err != nil {
 				return err
 			}
+			reclaimed, err := db.ReclaimExpiredTasks()
+			if err != nil {
+				return err
+			}
 			if ui.IsJSON() {
-				return ui.RenderJSON(map[string]interface{}{"status": "ok", "message": "swept", "count": count})
+				return ui.RenderJSON(map[string]interface{}{
+					"status":         "ok",
+					"message":        "swept",
+					"count":          count,
+					"tasks_requeued": reclaimed.Requeued,
+					"tasks_failed":   reclaimed.Failed,
+				})
 			}
 			ui.Success("Swept %d stale agent(s)", count)
+			if n := len(reclaimed.Requeued); n > 0 {
+				ui.Info("Requeued %d task(s) with expired leases", n)
+			}
+			if n := len(reclaimed.Failed); n > 0 {
+				ui.Warning("Failed %d task(s) that exceeded agent.max_task_attempts", n)
+			}
 			return nil
 		}
 
@@ -136,8 +158,163 @@ var agentsCmd = &cobra.Command{
 	},
 }
 
+type agentTokenJSON struct {
+	ID         string `json:"id"`
+	Agent      string `json:"agent"`
+	Hint       string `json:"hint"`
+	CreatedAt  string `json:"created_at"`
+	LastUsedAt string `json:"last_used_at,omitempty"`
+}
+
+var agentsTokenCmd = &cobra.Command{
+	Use:   "token",
+	Short: "Manage the bearer tokens agents use with agit serve --transport http",
+	Long: `Agent tokens authenticate agents to the HTTP transport of agit serve. A
+request carrying a token acts as its agent: tools default to that agent, and
+every request counts as its heartbeat.`,
+}
+
+var agentsTokenCreateCmd = &cobra.Command{
+	Use:   "create <name>",
+	Short: "Issue a token for an agent, registering it if needed",
+	Long: `Issues a bearer token for an agent. An agent that isn't registered yet is
+registered with type custom. The token is shown once; agit only keeps a hash
+of it.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		db, err := openRegistry()
+		if err != nil {
+			return fmt.Errorf("could not open registry: %w", err)
+		}
+		defer db.Close()
+
+		agent, err := db.GetAgentByName(args[0])
+		if err != nil {
+			return err
+		}
+		if agent == nil {
+			agent, err = db.RegisterAgent(args[0], "custom")
+			if err != nil {
+				return err
+			}
+		}
+		token, t, err := db.CreateAgentToken(agent.ID)
+		if err != nil {
+			return err
+		}
+
+		if ui.IsJSON() {
+			return ui.RenderJSON(map[string]string{
+				"status":   "ok",
+				"id":       t.ID,
+				"agent":    agent.Name,
+				"agent_id": agent.ID,
+				"token":    token,
+			})
+		}
+		ui.Success("Created token %s for agent %q", t.ID[:12], agent.Name)
+		fmt.Println(token)
+		ui.Warning("Store it now; it cannot be shown again")
+		return nil
+	},
+}
+
+var agentsTokenListCmd = &cobra.Command{
+	Use:   "list [name]",
+	Short: "List agent tokens",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		db, err := openRegistry()
+		if err != nil {
+			return fmt.Errorf("could not open registry: %w", err)
+		}
+		defer db.Close()
+
+		var agentID string
+		if len(args) == 1 {
+			agent, err := db.GetAgentByName(args[0])
+			if err != nil {
+				return err
+			}
+			if agent == nil {
+				return apperrors.NewUserErrorf("agent %q not found", args[0])
+			}
+			agentID = agent.ID
+		}
+		tokens, err := db.ListAgentTokens(agentID)
+		if err != nil {
+			return err
+		}
+
+		items := make([]agentTokenJSON, 0, len(tokens))
+		for _, t := range tokens {
+			name := t.AgentID
+			if a, err := db.GetAgent(t.AgentID); err == nil {
+				name = a.Name
+			}
+			item := agentTokenJSON{
+				ID:        t.ID[:12],
+				Agent:     name,
+				Hint:      t.Hint + "...",
+				CreatedAt: t.CreatedAt.Format("2006-01-02 15:04"),
+			}
+			if t.LastUsedAt != nil {
+				item.LastUsedAt = t.LastUsedAt.Format("2006-01-02 15:04")
+			}
+			items = append(items, item)
+		}
+		if ui.IsJSON() {
+			return ui.RenderJSON(items)
+		}
+		if len(items) == 0 {
+			fmt.Println("No agent tokens. Create one with agit agents token create <name>.")
+			return nil
+		}
+
+		table := ui.NewTable("ID", "Agent", "Token", "Created", "Last Used")
+		for _, item := range items {
+			lastUsed := item.LastUsedAt
+			if lastUsed == "" {
+				lastUsed = "never"
+			}
+			table.Append([]string{item.ID, item.Agent, item.Hint, item.CreatedAt, lastUsed})
+		}
+		table.Render()
+		return nil
+	},
+}
+
+var agentsTokenRevokeCmd = &cobra.Command{
+	Use:   "revoke <id>",
+	Short: "Revoke an agent token",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		db, err := openRegistry()
+		if err != nil {
+			return fmt.Errorf("could not open registry: %w", err)
+		}
+		defer db.Close()
+
+		t, err := db.FindAgentToken(args[0])
+		if err != nil {
+			return apperrors.NewUserError(err.Error())
+		}
+		if err := db.RevokeAgentToken(t.ID); err != nil {
+			return err
+		}
+
+		if ui.IsJSON() {
+			return ui.RenderJSON(map[string]string{"status": "ok", "message": "revoked", "id": t.ID})
+		}
+		ui.Success("Revoked token %s", t.ID[:12])
+		return nil
+	},
+}
+
 func init() {
 	agentsCmd.Flags().Bool("sweep", false, "Mark stale agents as disconnected")
 	agentsCmd.Flags().String("remove", "", "Remove an agent by name")
+	agentsTokenCmd.AddCommand(agentsTokenCreateCmd, agentsTokenListCmd, agentsTokenRevokeCmd)
+	agentsCmd.AddCommand(agentsTokenCmd)
 	rootCmd.AddCommand(agentsCmd)
 }
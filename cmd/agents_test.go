@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"encoding/json"
 	"strings"
 	"testing"
 )
@@ -142,3 +143,36 @@ func TestAgentsRemoveJSON(t *testing.T) {
 		t.Errorf("expected 'removed' in JSON output, got: %s", stdout)
 	}
 }
+
+func TestAgentsTokenLifecycle(t *testing.T) {
+	env := newTestEnv(t)
+	env.init()
+
+	stdout, err := env.runJSON("agents", "token", "create", "builder")
+	if err != nil {
+		t.Fatalf("agents token create failed: %v", err)
+	}
+	var created map[string]string
+	if err := json.Unmarshal([]byte(stdout), &created); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, stdout)
+	}
+	if !strings.HasPrefix(created["token"], "agit_") || created["agent"] != "builder" {
+		t.Fatalf("unexpected output: %s", stdout)
+	}
+
+	stdout, err = env.run("agents", "token", "list", "builder")
+	if err != nil {
+		t.Fatalf("agents token list failed: %v", err)
+	}
+	if !strings.Contains(stdout, created["token"][:11]) || strings.Contains(stdout, created["token"]) {
+		t.Errorf("expected the list to show only the start of the token, got: %s", stdout)
+	}
+
+	if _, err := env.run("agents", "token", "revoke", created["id"][:8]); err != nil {
+		t.Fatalf("agents token revoke failed: %v", err)
+	}
+	stdout, _ = env.run("agents", "token", "list")
+	if !strings.Contains(stdout, "No agent tokens") {
+		t.Errorf("expected no tokens after revoking, got: %s", stdout)
+	}
+}
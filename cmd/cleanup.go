@@ -6,7 +6,6 @@ import (
 
 	"github.com/spf13/cobra"
 
-	"github.com/fathindos/agit/internal/config"
 	gitops "github.com/fathindos/agit/internal/git"
 	"github.com/fathindos/agit/internal/hooks"
 	"github.com/fathindos/agit/internal/registry"
@@ -18,6 +17,9 @@ var cleanupCmd = &cobra.Command{
 	Use:   "cleanup",
 	Short: "Remove completed or stale worktrees",
 	Long: `Cleans up worktrees that are completed, stale, or no longer needed.
+--all also removes worktrees left in setup_failed. A pre.worktree.remove hook
+(see agit config) that exits non-zero keeps that worktree; its stderr is
+reported and cleanup moves on to the next one.
 
 With -i (interactive), presents a multi-select list of eligible worktrees
 and asks for confirmation before removal.`,
@@ -26,7 +28,7 @@ and asks for confirmation before removal.`,
 		staleOnly, _ := cmd.Flags().GetBool("stale")
 		isInteractive, _ := cmd.Flags().GetBool("interactive")
 
-		db, err := registry.Open()
+		db, err := openRegistry()
 		if err != nil {
 			return fmt.Errorf("could not open registry: %w", err)
 		}
@@ -37,10 +39,6 @@ and asks for confirmation before removal.`,
 			return err
 		}
 
-		cfg, _ := config.Load()
-		hookRunner := hooks.NewRunner(cfg)
-		defer hookRunner.Wait()
-
 		// Prune orphaned worktrees first
 		for _, repo := range repos {
 			db.PruneOrphanedWorktrees(repo.ID)
@@ -56,6 +54,12 @@ and asks for confirmation before removal.`,
 			Status string `json:"status"`
 		}
 		var removedItems []removedEntry
+		type keptEntry struct {
+			ID    string `json:"id"`
+			Repo  string `json:"repo"`
+			Error string `json:"error"`
+		}
+		var keptItems []keptEntry
 
 		removed := 0
 		for _, repo := range repos {
@@ -63,11 +67,13 @@ and asks for confirmation before removal.`,
 			if err != nil {
 				continue
 			}
+			cfg, _ := loadRepoConfig(repo)
+			hookRunner := hooks.NewRunner(cfg, db)
 
 			for _, wt := range worktrees {
 				shouldRemove := false
 				if all {
-					shouldRemove = wt.Status == "completed" || wt.Status == "stale"
+					shouldRemove = wt.Status == "completed" || wt.Status == "stale" || wt.Status == "setup_failed"
 				} else if staleOnly {
 					shouldRemove = wt.Status == "stale"
 				} else {
@@ -77,18 +83,22 @@ and asks for confirmation before removal.`,
 				if !shouldRemove {
 					continue
 				}
+				if err := hookRunner.Check(hooks.PreWorktreeRemove, hooks.WorktreeEnv(repo, wt)); err != nil {
+					keptItems = append(keptItems, keptEntry{ID: wt.ID[:12], Repo: repo.Name, Error: err.Error()})
+					if !ui.IsJSON() {
+						fmt.Fprintf(os.Stderr, "  Kept: %s (%s) - %v\n", wt.ID[:12], repo.Name, err)
+					}
+					continue
+				}
 
 				if err := gitops.RemoveWorktree(repo.Path, wt.Path); err != nil {
 					fmt.Fprintf(os.Stderr, "  Warning: could not remove worktree %s: %v\n", wt.ID[:12], err)
 				}
-				gitops.DeleteBranch(repo.Path, wt.Branch)
+				if wt.OwnsBranch() {
+					gitops.DeleteBranch(repo.Path, wt.Branch)
+				}
 				db.DeleteWorktree(wt.ID)
 
-				hookRunner.Fire("worktree.removed", map[string]string{
-					"AGIT_REPO":        repo.Name,
-					"AGIT_WORKTREE_ID": wt.ID,
-				})
-
 				removedItems = append(removedItems, removedEntry{
 					ID:     wt.ID[:12],
 					Repo:   repo.Name,
@@ -103,11 +113,15 @@ and asks for confirmation before removal.`,
 		}
 
 		if ui.IsJSON() {
-			return ui.RenderJSON(map[string]interface{}{
+			result := map[string]interface{}{
 				"status":  "ok",
 				"removed": removedItems,
 				"count":   removed,
-			})
+			}
+			if len(keptItems) > 0 {
+				result["kept"] = keptItems
+			}
+			return ui.RenderJSON(result)
 		}
 
 		if removed == 0 {
@@ -135,7 +149,7 @@ func cleanupInteractive(db *registry.DB, repos []*registry.Repo) error {
 			continue
 		}
 		for _, wt := range worktrees {
-			if wt.Status == "completed" || wt.Status == "stale" {
+			if wt.Status == "completed" || wt.Status == "stale" || wt.Status == "setup_failed" {
 				candidates = append(candidates, candidate{wt: wt, repo: repo})
 			}
 		}
@@ -187,10 +201,17 @@ func cleanupInteractive(db *registry.DB, repos []*registry.Repo) error {
 		if !selectedIDs[c.wt.ID] {
 			continue
 		}
+		cfg, _ := loadRepoConfig(c.repo)
+		if err := hooks.NewRunner(cfg, db).Check(hooks.PreWorktreeRemove, hooks.WorktreeEnv(c.repo, c.wt)); err != nil {
+			fmt.Fprintf(os.Stderr, "  Kept: %s (%s) - %v\n", c.wt.ID[:12], c.repo.Name, err)
+			continue
+		}
 		if err := gitops.RemoveWorktree(c.repo.Path, c.wt.Path); err != nil {
 			fmt.Fprintf(os.Stderr, "  Warning: could not remove worktree %s: %v\n", c.wt.ID[:12], err)
 		}
-		gitops.DeleteBranch(c.repo.Path, c.wt.Branch)
+		if c.wt.OwnsBranch() {
+			gitops.DeleteBranch(c.repo.Path, c.wt.Branch)
+		}
 		db.DeleteWorktree(c.wt.ID)
 		fmt.Printf("  Removed: %s (%s) - %s\n", ui.T.Muted(c.wt.ID[:12]), c.repo.Name, ui.StatusColor(c.wt.Status))
 		removed++
@@ -202,7 +223,7 @@ func cleanupInteractive(db *registry.DB, repos []*registry.Repo) error {
 }
 
 func init() {
-	cleanupCmd.Flags().Bool("all", false, "Remove all completed and stale worktrees")
+	cleanupCmd.Flags().Bool("all", false, "Remove all completed and stale worktrees, and those whose setup failed")
 	cleanupCmd.Flags().Bool("stale", false, "Remove only stale worktrees")
 	rootCmd.AddCommand(cleanupCmd)
 }
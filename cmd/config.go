@@ -2,47 +2,155 @@ package cmd
 
 import (
 	"fmt"
+	"sort"
 
-	toml "github.com/pelletier/go-toml/v2"
 	"github.com/spf13/cobra"
 
 	"github.com/fathindos/agit/internal/config"
 	apperrors "github.com/fathindos/agit/internal/errors"
+	"github.com/fathindos/agit/internal/registry"
 	"github.com/fathindos/agit/internal/ui"
 )
 
 var configCmd = &cobra.Command{
 	Use:   "config",
 	Short: "Manage agit configuration",
-	Long:  `View and modify agit configuration stored in ~/.agit/config.toml.`,
+	Long: `View and modify agit configuration stored in ~/.agit/config.toml.
+
+Settings are layered, lowest precedence first:
+
+  default        built-in default
+  global         config.toml in the agit directory (~/.agit, or --home / AGIT_HOME)
+  profile        profiles/<name>.toml, selected with --profile or AGIT_PROFILE
+  repo file      .agit.toml at the root of a repository
+  repo setting   stored in the registry with agit config set --repo
+  env            AGIT_<SECTION>_<KEY>, e.g. AGIT_DEFAULTS_BRANCH_PREFIX
+
+The repository layers apply to commands that act on that repository.
+
+Hooks are shell commands keyed by event, e.g. hooks.worktree.created; the
+config file can give an event an array of commands, run in order. They run
+in the background once the event has happened, except for pre.spawn,
+pre.merge, pre.task.claim and pre.worktree.remove: those run before the
+operation and refuse it by exiting non-zero, with their stderr reported as
+the error.
+
+Webhooks POST events as signed JSON to a URL, configured per name with
+webhooks.<name>.url, .events, .secret, .attempts, .backoff and
+.headers.<Header>. Deliveries that fail every attempt are kept for
+agit hooks redeliver.`,
 }
 
 var configShowCmd = &cobra.Command{
 	Use:   "show",
 	Short: "Display current configuration",
+	Long: `Displays the configuration in effect and the layer each value came from
+(see agit config --help). With --repo, includes that repository's .agit.toml
+and registry overrides.
+
+JSON output without --repo is the merged configuration object. Webhook
+secrets and credential headers (Authorization, Cookie, *-Token, *-Key) are
+masked.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		cfg, err := config.Load()
-		if err != nil {
-			return fmt.Errorf("could not load config: %w", err)
+		repoName, _ := cmd.Flags().GetString("repo")
+		if repoName != "" {
+			return showRepoConfig(repoName)
 		}
 
 		if ui.IsJSON() {
-			return ui.RenderJSON(cfg)
+			cfg, err := config.Load()
+			if err != nil {
+				return fmt.Errorf("could not load config: %w", err)
+			}
+			return ui.RenderJSON(cfg.Redacted())
 		}
 
-		data, err := toml.Marshal(cfg)
+		cfg, sources, err := config.LoadLayers("", nil)
 		if err != nil {
-			return fmt.Errorf("could not format config: %w", err)
+			return fmt.Errorf("could not load config: %w", err)
 		}
-		fmt.Print(string(data))
+		renderConfigValues(configValues(cfg, sources))
 		return nil
 	},
 }
 
+type configValueJSON struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// configValues lists every config key, then any hooks and webhook settings,
+// with its value and the layer it came from. Webhook secrets and credential
+// headers are masked.
+func configValues(cfg *config.Config, sources map[string]string) []configValueJSON {
+	keys := config.AllKeys()
+	var hookKeys []string
+	for event := range cfg.Hooks {
+		hookKeys = append(hookKeys, "hooks."+event)
+	}
+	sort.Strings(hookKeys)
+	keys = append(keys, hookKeys...)
+	keys = append(keys, cfg.WebhookKeys()...)
+
+	values := make([]configValueJSON, 0, len(keys))
+	for _, key := range keys {
+		value, _ := cfg.GetByDotKey(key)
+		if config.IsSecretKey(key) {
+			value = config.MaskedValue
+		}
+		values = append(values, configValueJSON{Key: key, Value: value, Source: sources[key]})
+	}
+	return values
+}
+
+func renderConfigValues(values []configValueJSON) {
+	table := ui.NewTable("Key", "Value", "Source")
+	for _, v := range values {
+		source := v.Source
+		if source == config.SourceDefault {
+			source = ui.T.Muted(source)
+		}
+		table.Append([]string{v.Key, v.Value, source})
+	}
+	table.Render()
+}
+
+func showRepoConfig(repoName string) error {
+	db, err := openRegistry()
+	if err != nil {
+		return fmt.Errorf("could not open registry: %w", err)
+	}
+	defer db.Close()
+
+	repo, err := db.GetRepo(repoName)
+	if err != nil {
+		return err
+	}
+	cfg, sources, err := config.LoadLayers(repo.Path, repo.ConfigOverrides())
+	if err != nil {
+		return apperrors.NewUserErrorf("invalid config for %s: %v", repo.Name, err)
+	}
+
+	values := configValues(cfg, sources)
+	if ui.IsJSON() {
+		return ui.RenderJSON(map[string]interface{}{"repo": repo.Name, "config": values})
+	}
+	renderConfigValues(values)
+	return nil
+}
+
 var configSetCmd = &cobra.Command{
 	Use:   "set <key> <value>",
 	Short: "Set a configuration value",
-	Args:  cobra.ExactArgs(2),
+	Long: `Sets a value in the global configuration file.
+
+With --profile, stores the value in that profile instead, creating it if
+needed; an empty value removes the key from the profile. With --repo, stores
+the value on one repository in the registry. It takes precedence over the
+global config, profiles and the repository's .agit.toml wherever agit acts
+on that repository; an empty value removes the override.`,
+	Args: cobra.ExactArgs(2),
 	ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
 		if len(args) == 0 {
 			return config.AllKeys(), cobra.ShellCompDirectiveNoFileComp
@@ -51,8 +159,16 @@ var configSetCmd = &cobra.Command{
 	},
 	RunE: func(cmd *cobra.Command, args []string) error {
 		key, value := args[0], args[1]
+		repoName, _ := cmd.Flags().GetString("repo")
+		if repoName != "" {
+			return setRepoConfig(repoName, key, value)
+		}
+
+		if name := config.Profile(); name != "" {
+			return setProfileConfig(name, key, value)
+		}
 
-		cfg, err := config.Load()
+		cfg, err := config.LoadGlobal()
 		if err != nil {
 			return fmt.Errorf("could not load config: %w", err)
 		}
@@ -86,11 +202,100 @@ var configSetCmd = &cobra.Command{
 	},
 }
 
+func setProfileConfig(name, key, value string) error {
+	cfg, err := config.LoadGlobal()
+	if err != nil {
+		return fmt.Errorf("could not load config: %w", err)
+	}
+	if err := cfg.SetByDotKey(key, value); err != nil {
+		return apperrors.NewUserErrorf("%v", err)
+	}
+	if value != "" {
+		if err := cfg.Validate(); err != nil {
+			return apperrors.NewUserErrorf("invalid value: %v", err)
+		}
+	}
+	if err := config.SetProfileValue(name, key, value); err != nil {
+		return apperrors.NewUserError(err.Error())
+	}
+
+	if ui.IsJSON() {
+		return ui.RenderJSON(map[string]string{
+			"status":  "ok",
+			"profile": name,
+			"key":     key,
+			"value":   value,
+		})
+	}
+
+	if value == "" {
+		ui.Success("Removed %s from profile %s", key, name)
+	} else {
+		ui.Success("Set %s = %s in profile %s", key, value, name)
+	}
+	return nil
+}
+
+func setRepoConfig(repoName, key, value string) error {
+	db, err := openRegistry()
+	if err != nil {
+		return fmt.Errorf("could not open registry: %w", err)
+	}
+	defer db.Close()
+
+	repo, err := db.GetRepo(repoName)
+	if err != nil {
+		return err
+	}
+
+	if _, err := config.DefaultConfig().GetByDotKey(key); err != nil {
+		return apperrors.NewUserErrorf("%v", err)
+	}
+
+	// Check the override against the repo's other layers before storing it
+	overrides := repo.ConfigOverrides()
+	if overrides == nil {
+		overrides = make(map[string]string)
+	}
+	if value == "" {
+		delete(overrides, key)
+	} else {
+		overrides[key] = value
+	}
+	if _, err := config.LoadForRepo(repo.Path, overrides); err != nil {
+		return apperrors.NewUserErrorf("invalid value: %v", err)
+	}
+
+	if err := db.SetRepoConfig(repo.ID, key, value); err != nil {
+		return err
+	}
+
+	if ui.IsJSON() {
+		return ui.RenderJSON(map[string]string{
+			"status": "ok",
+			"repo":   repo.Name,
+			"key":    key,
+			"value":  value,
+		})
+	}
+
+	if value == "" {
+		ui.Success("Removed %s override for %s", key, repo.Name)
+	} else {
+		ui.Success("Set %s = %s for %s", key, value, repo.Name)
+	}
+	return nil
+}
+
 var configPathCmd = &cobra.Command{
 	Use:   "path",
 	Short: "Print the configuration file path",
+	Long:  `Prints the path of the config file, or of the active profile's settings file.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		path, err := config.ConfigPath()
+		if name := config.Profile(); name != "" {
+			path, err = config.ProfilePath(name)
+		}
 		if err != nil {
 			return err
 		}
@@ -126,7 +331,17 @@ var configResetCmd = &cobra.Command{
 	},
 }
 
+// loadRepoConfig returns the config in effect for a repo: the global config
+// with the repo's .agit.toml and registry overrides layered on top
+func loadRepoConfig(repo *registry.Repo) (*config.Config, error) {
+	return config.LoadForRepo(repo.Path, repo.ConfigOverrides())
+}
+
 func init() {
+	configShowCmd.Flags().String("repo", "", "Show the effective config for a repository and where each value came from")
+	configSetCmd.Flags().String("repo", "", "Store the value as an override for a repository")
+	_ = configShowCmd.RegisterFlagCompletionFunc("repo", completeRepoNames)
+	_ = configSetCmd.RegisterFlagCompletionFunc("repo", completeRepoNames)
 	configCmd.AddCommand(configShowCmd)
 	configCmd.AddCommand(configSetCmd)
 	configCmd.AddCommand(configPathCmd)
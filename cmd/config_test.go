@@ -1,6 +1,9 @@
 package cmd
 
 import (
+	"encoding/json"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -25,6 +28,40 @@ func TestConfigShowJSON(t *testing.T) {
 	}
 }
 
+func TestConfigShowMasksCredentials(t *testing.T) {
+	env := newTestEnv(t)
+	env.init()
+	for _, kv := range [][2]string{
+		{"webhooks.ci.url", "https://ci.example.com/agit"},
+		{"webhooks.ci.secret", "s3cret"},
+		{"webhooks.ci.headers.Authorization", "Bearer abc"},
+		{"webhooks.ci.headers.X-Api-Key", "apikey"},
+	} {
+		if _, err := env.run("config", "set", kv[0], kv[1]); err != nil {
+			t.Fatalf("config set %s failed: %v", kv[0], err)
+		}
+	}
+
+	text, err := env.run("config", "show")
+	if err != nil {
+		t.Fatalf("config show failed: %v", err)
+	}
+	jsonOut, err := env.runJSON("config", "show")
+	if err != nil {
+		t.Fatalf("config show --output json failed: %v", err)
+	}
+	for _, out := range []string{text, jsonOut} {
+		for _, secret := range []string{"s3cret", "Bearer abc", "apikey"} {
+			if strings.Contains(out, secret) {
+				t.Errorf("expected %q to be masked, got: %s", secret, out)
+			}
+		}
+		if !strings.Contains(out, "https://ci.example.com/agit") {
+			t.Errorf("expected the webhook URL in the output, got: %s", out)
+		}
+	}
+}
+
 func TestConfigSet(t *testing.T) {
 	env := newTestEnv(t)
 	env.init()
@@ -94,3 +131,105 @@ func TestConfigSetJSON(t *testing.T) {
 		t.Errorf("expected JSON with status field, got: %s", stdout)
 	}
 }
+
+func TestConfigRepoLayers(t *testing.T) {
+	repoPath := setupTestGitRepo(t)
+	env := newTestEnv(t)
+	env.init()
+	if _, err := env.run("add", repoPath); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+	writeFileInWorktree(t, repoPath, ".agit.toml", "[defaults]\nbranch_prefix = \"fe/\"\n")
+	if _, err := env.run("config", "set", "--repo", "test-repo", "defaults.worktree_dir", ".agents"); err != nil {
+		t.Fatalf("config set --repo failed: %v", err)
+	}
+	if _, err := env.run("config", "set", "--repo", "test-repo", "defaults.watch", "maybe"); err == nil {
+		t.Error("expected invalid repo override to be rejected")
+	}
+
+	showRepo := func() map[string]configValueJSON {
+		stdout, err := env.runJSON("config", "show", "--repo", "test-repo")
+		if err != nil {
+			t.Fatalf("config show --repo failed: %v", err)
+		}
+		var shown struct {
+			Config []configValueJSON `json:"config"`
+		}
+		if err := json.Unmarshal([]byte(stdout), &shown); err != nil {
+			t.Fatalf("invalid JSON: %v\n%s", err, stdout)
+		}
+		values := make(map[string]configValueJSON)
+		for _, v := range shown.Config {
+			values[v.Key] = v
+		}
+		return values
+	}
+	sources := showRepo()
+	if v := sources["defaults.branch_prefix"]; v.Value != "fe/" || v.Source != "repo file" {
+		t.Errorf("unexpected branch_prefix: %+v", v)
+	}
+	if v := sources["defaults.worktree_dir"]; v.Value != ".agents" || v.Source != "repo setting" {
+		t.Errorf("unexpected worktree_dir: %+v", v)
+	}
+	// agit init writes the defaults out to the global file
+	if v := sources["server.transport"]; v.Source != "global" {
+		t.Errorf("unexpected server.transport: %+v", v)
+	}
+
+	// Global config is unchanged, but spawning in the repo uses its layers
+	if global, _ := env.run("config", "show"); strings.Contains(global, ".agents") {
+		t.Errorf("repo override leaked into global config: %s", global)
+	}
+	stdout, err := env.runJSON("spawn", "test-repo", "--task", "layered")
+	if err != nil {
+		t.Fatalf("spawn failed: %v", err)
+	}
+	var spawned map[string]string
+	json.Unmarshal([]byte(stdout), &spawned)
+	if !strings.HasPrefix(spawned["branch"], "fe/") || !strings.Contains(spawned["path"], filepath.Join(repoPath, ".agents")) {
+		t.Errorf("expected spawn to use the repo's config, got %v", spawned)
+	}
+
+	if _, err := env.run("config", "set", "--repo", "test-repo", "defaults.worktree_dir", ""); err != nil {
+		t.Fatalf("removing override failed: %v", err)
+	}
+	if v := showRepo()["defaults.worktree_dir"]; v.Value != ".worktrees" || v.Source != "global" {
+		t.Errorf("expected override removed, got %+v", v)
+	}
+}
+
+func TestHomeAndProfileFlags(t *testing.T) {
+	env := newTestEnv(t)
+	home := filepath.Join(t.TempDir(), "sandbox")
+
+	if _, err := env.run("--home", home, "init"); err != nil {
+		t.Fatalf("init --home failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(home, "agit.db")); err != nil {
+		t.Errorf("expected registry under --home: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(env.home, ".agit")); !os.IsNotExist(err) {
+		t.Errorf("expected ~/.agit untouched, got %v", err)
+	}
+
+	if _, err := env.run("--home", home, "--profile", "ci", "config", "show"); err == nil {
+		t.Error("expected error for a profile that does not exist")
+	}
+	if _, err := env.run("--home", home, "--profile", "ci", "config", "set", "defaults.branch_prefix", "ci/"); err != nil {
+		t.Fatalf("config set --profile failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(home, "profiles", "ci.toml")); err != nil {
+		t.Errorf("expected profile file under --home: %v", err)
+	}
+
+	stdout, err := env.run("--home", home, "--profile", "ci", "config", "show")
+	if err != nil {
+		t.Fatalf("config show --profile failed: %v", err)
+	}
+	if !strings.Contains(stdout, "ci/") || !strings.Contains(stdout, "profile ci") {
+		t.Errorf("expected profile value and source, got: %s", stdout)
+	}
+	if stdout, _ := env.run("--home", home, "config", "show"); strings.Contains(stdout, "ci/") {
+		t.Errorf("expected profile ignored without --profile, got: %s", stdout)
+	}
+}
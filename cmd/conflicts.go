@@ -5,10 +5,7 @@ import (
 
 	"github.com/spf13/cobra"
 
-	"github.com/fathindos/agit/internal/config"
 	"github.com/fathindos/agit/internal/conflicts"
-	gitops "github.com/fathindos/agit/internal/git"
-	"github.com/fathindos/agit/internal/hooks"
 	"github.com/fathindos/agit/internal/registry"
 	"github.com/fathindos/agit/internal/ui"
 )
@@ -16,29 +13,73 @@ import (
 type conflictJSON struct {
 	Repo      string           `json:"repo"`
 	File      string           `json:"file"`
+	Kind      string           `json:"kind"` // committed or in_flight
 	Worktrees []conflictWtJSON `json:"worktrees"`
 }
 
 type conflictWtJSON struct {
-	ID    string `json:"id"`
-	Agent string `json:"agent,omitempty"`
-	Task  string `json:"task,omitempty"`
+	ID          string   `json:"id"`
+	Agent       string   `json:"agent,omitempty"`
+	Task        string   `json:"task,omitempty"`
+	Lines       []string `json:"lines,omitempty"`
+	WholeFile   bool     `json:"whole_file,omitempty"`
+	Uncommitted bool     `json:"uncommitted,omitempty"`
+}
+
+type lockViolationJSON struct {
+	Repo        string `json:"repo"`
+	File        string `json:"file"`
+	Worktree    string `json:"worktree"`
+	Uncommitted bool   `json:"uncommitted,omitempty"`
+	Lock        string `json:"lock"`
+	Pattern     string `json:"pattern"`
+	HeldBy      string `json:"held_by"`
+}
+
+func toLockViolationJSON(repoName string, v registry.LockViolation) lockViolationJSON {
+	return lockViolationJSON{
+		Repo:        repoName,
+		File:        v.Path,
+		Worktree:    v.WorktreeID[:12],
+		Uncommitted: v.Uncommitted,
+		Lock:        v.Lock.ID,
+		Pattern:     v.Lock.Pattern,
+		HeldBy:      v.Lock.Holder(),
+	}
 }
 
 type conflictsOutputJSON struct {
-	Conflicts   []conflictJSON         `json:"conflicts"`
-	Suggestions []conflicts.Suggestion `json:"suggestions,omitempty"`
+	Conflicts      []conflictJSON         `json:"conflicts"`
+	LockViolations []lockViolationJSON    `json:"lock_violations"`
+	Suggestions    []conflicts.Suggestion `json:"suggestions,omitempty"`
 }
 
 var conflictsCmd = &cobra.Command{
 	Use:   "conflicts [repo]",
 	Short: "Check for overlapping file changes across worktrees",
-	Long: `Scans all active worktrees and detects files that have been modified
-in more than one worktree, indicating potential merge conflicts.`,
+	Long: `Scans all active worktrees and detects changes that overlap between
+worktrees, indicating potential merge conflicts.
+
+Modified files are compared hunk by hunk: two worktrees only conflict when
+their changed line ranges overlap or sit within defaults.conflict_context_lines
+of each other. Added, deleted and binary files conflict on any overlap.
+
+Uncommitted edits in each worktree are included. A conflict where every
+worktree has committed its change is reported as committed; one where at
+least one side is still an uncommitted edit is reported as in-flight.
+
+Changes that fall under a lock held by another agent or worktree (see
+agit lock) are reported as well, even when only one worktree is active.
+
+With --simulate, every pair of active worktree branches is merged in memory
+with git merge-tree and the result is shown as a mergeability matrix along
+with the exact conflicted paths. Nothing on disk is modified.`,
 	Args:              cobra.MaximumNArgs(1),
 	ValidArgsFunction: completeRepoNames,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		db, err := registry.Open()
+		simulate, _ := cmd.Flags().GetBool("simulate")
+
+		db, err := openRegistry()
 		if err != nil {
 			return fmt.Errorf("could not open registry: %w", err)
 		}
@@ -58,13 +99,14 @@ in more than one worktree, indicating potential merge conflicts.`,
 			}
 		}
 
-		cfg, _ := config.Load()
-		hookRunner := hooks.NewRunner(cfg)
-		defer hookRunner.Wait()
+		if simulate {
+			return runMergeSimulation(db, repos)
+		}
 
 		allConflicts := make([]conflictJSON, 0)
 		var allSuggestions []conflicts.Suggestion
-		totalConflicts := 0
+		allViolations := make([]lockViolationJSON, 0)
+		totalConflicts, totalViolations := 0, 0
 
 		for _, repo := range repos {
 			activeStatus := "active"
@@ -72,37 +114,50 @@ in more than one worktree, indicating potential merge conflicts.`,
 			if err != nil {
 				return err
 			}
-
 			if len(worktrees) < 2 {
 				if !ui.IsJSON() {
 					ui.Info("%s: < 2 active worktrees, no conflicts possible", repo.Name)
 				}
-				continue
-			}
-
-			if !ui.IsJSON() {
+			} else if !ui.IsJSON() {
 				ui.Info("Scanning %d active worktrees in %s...", len(worktrees), repo.Name)
 				ui.Blank()
 			}
 
 			// Update file touches for each worktree
 			for _, wt := range worktrees {
-				files, err := gitops.ModifiedFilesWithStatus(repo.Path, repo.DefaultBranch, wt.Branch)
+				touches, err := conflicts.LiveTouches(repo, wt)
 				if err != nil {
 					if !ui.IsJSON() {
 						ui.Warning("could not get diff for %s: %v", wt.ID[:8], err)
 					}
 					continue
 				}
+				db.RecordFileTouches(repo.ID, wt.ID, touches)
+			}
 
-				var touches []registry.FileTouch
-				for path, changeType := range files {
-					touches = append(touches, registry.FileTouch{
-						FilePath:   path,
-						ChangeType: changeType,
-					})
+			// Changes under someone else's lock count even without a second worktree
+			violations, err := db.FindLockViolations(repo.ID)
+			if err != nil {
+				return fmt.Errorf("could not check locks: %w", err)
+			}
+			for _, v := range violations {
+				if ui.IsJSON() {
+					allViolations = append(allViolations, toLockViolationJSON(repo.Name, v))
+					continue
 				}
-				db.RecordFileTouches(repo.ID, wt.ID, touches)
+				desc := ui.T.Muted(v.WorktreeID[:12])
+				if v.Uncommitted {
+					desc = fmt.Sprintf("%s %s", desc, ui.T.Info("[uncommitted]"))
+				}
+				fmt.Printf("%s %s %s\n", ui.T.Warning("LOCKED:"), v.Path,
+					ui.T.Muted(fmt.Sprintf("(locked by %s on %s)", v.Lock.Holder(), v.Lock.Pattern)))
+				fmt.Printf("  Modified in: %s\n", desc)
+				ui.Blank()
+			}
+			totalViolations += len(violations)
+
+			if len(worktrees) < 2 {
+				continue
 			}
 
 			// Find conflicts
@@ -121,7 +176,7 @@ in more than one worktree, indicating potential merge conflicts.`,
 
 			for _, c := range repoConflicts {
 				if ui.IsJSON() {
-					cj := conflictJSON{Repo: repo.Name, File: c.FilePath}
+					cj := conflictJSON{Repo: repo.Name, File: c.FilePath, Kind: conflicts.Kind(c)}
 					for i, wtID := range c.Worktrees {
 						wj := conflictWtJSON{ID: wtID[:12]}
 						if i < len(c.AgentIDs) && c.AgentIDs[i] != "" {
@@ -133,11 +188,21 @@ in more than one worktree, indicating potential merge conflicts.`,
 						if i < len(c.TaskDescs) && c.TaskDescs[i] != "" {
 							wj.Task = c.TaskDescs[i]
 						}
+						if i < len(c.Ranges) {
+							if c.Ranges[i] == nil {
+								wj.WholeFile = true
+							} else {
+								wj.Lines = conflicts.RangeStrings(c.Ranges[i])
+							}
+						}
+						if i < len(c.Uncommitted) {
+							wj.Uncommitted = c.Uncommitted[i]
+						}
 						cj.Worktrees = append(cj.Worktrees, wj)
 					}
 					allConflicts = append(allConflicts, cj)
 				} else {
-					fmt.Printf("%s %s\n", ui.T.Warning("CONFLICT:"), c.FilePath)
+					fmt.Printf("%s %s %s\n", ui.T.Warning("CONFLICT:"), c.FilePath, ui.T.Muted("("+conflicts.KindLabel(c)+")"))
 					for i, wtID := range c.Worktrees {
 						agentStr := ""
 						if i < len(c.AgentIDs) && c.AgentIDs[i] != "" {
@@ -151,8 +216,14 @@ in more than one worktree, indicating potential merge conflicts.`,
 							taskStr = c.TaskDescs[i]
 						}
 						desc := ui.T.Muted(wtID[:12])
+						if i < len(c.Ranges) {
+							desc = fmt.Sprintf("%s %s", desc, conflicts.FormatRanges(c.Ranges[i]))
+						}
+						if i < len(c.Uncommitted) && c.Uncommitted[i] {
+							desc = fmt.Sprintf("%s %s", desc, ui.T.Info("[uncommitted]"))
+						}
 						if agentStr != "" {
-							desc = fmt.Sprintf("%s (%s: %s)", ui.T.Muted(wtID[:12]), agentStr, taskStr)
+							desc = fmt.Sprintf("%s (%s: %s)", desc, agentStr, taskStr)
 						}
 						fmt.Printf("  Modified in: %s\n", desc)
 					}
@@ -176,27 +247,120 @@ in more than one worktree, indicating potential merge conflicts.`,
 
 			totalConflicts += len(repoConflicts)
 
-			// Fire conflict.detected hook
-			hookRunner.Fire("conflict.detected", map[string]string{
-				"AGIT_REPO": repo.Name,
-			})
+			conflicts.RecordDetected(db, repo, repoConflicts)
 		}
 
 		if ui.IsJSON() {
 			return ui.RenderJSON(conflictsOutputJSON{
-				Conflicts:   allConflicts,
-				Suggestions: allSuggestions,
+				Conflicts:      allConflicts,
+				LockViolations: allViolations,
+				Suggestions:    allSuggestions,
 			})
 		}
 
 		if totalConflicts > 0 {
 			fmt.Printf("%d conflict(s) detected.\n", totalConflicts)
 		}
+		if totalViolations > 0 {
+			fmt.Printf("%d change(s) to locked files.\n", totalViolations)
+		}
 
 		return nil
 	},
 }
 
+type simulationJSON struct {
+	Repo  string                     `json:"repo"`
+	Pairs []conflicts.PairSimulation `json:"pairs"`
+}
+
+// runMergeSimulation renders the pairwise merge-tree matrix for each repo
+func runMergeSimulation(db *registry.DB, repos []*registry.Repo) error {
+	results := make([]simulationJSON, 0)
+
+	for _, repo := range repos {
+		activeStatus := "active"
+		worktrees, err := db.ListWorktrees(repo.ID, &activeStatus)
+		if err != nil {
+			return err
+		}
+
+		if len(worktrees) < 2 {
+			if !ui.IsJSON() {
+				ui.Info("%s: < 2 active worktrees, nothing to simulate", repo.Name)
+			}
+			continue
+		}
+
+		pairs := conflicts.SimulatePairs(repo, worktrees)
+		if ui.IsJSON() {
+			results = append(results, simulationJSON{Repo: repo.Name, Pairs: pairs})
+			continue
+		}
+
+		ui.Info("Simulated merges across %d active worktrees in %s", len(worktrees), repo.Name)
+		ui.Blank()
+
+		cell := make(map[[2]string]string)
+		for _, p := range pairs {
+			mark := ui.T.Success("ok")
+			switch {
+			case p.Error != "":
+				mark = ui.T.Muted("error")
+			case !p.Clean:
+				mark = ui.T.Warning(fmt.Sprintf("%d", len(p.ConflictedPaths)))
+			}
+			cell[[2]string{p.WorktreeA, p.WorktreeB}] = mark
+			cell[[2]string{p.WorktreeB, p.WorktreeA}] = mark
+		}
+
+		headers := []string{""}
+		for _, wt := range worktrees {
+			headers = append(headers, wt.ID[:8])
+		}
+		table := ui.NewTable(headers...)
+		for _, row := range worktrees {
+			line := []string{row.ID[:8] + " " + ui.T.Muted(row.Branch)}
+			for _, col := range worktrees {
+				if row.ID == col.ID {
+					line = append(line, "-")
+				} else {
+					line = append(line, cell[[2]string{row.ID, col.ID}])
+				}
+			}
+			table.Append(line)
+		}
+		table.Render()
+		ui.Blank()
+
+		conflicting := 0
+		for _, p := range pairs {
+			switch {
+			case p.Error != "":
+				ui.Warning("could not simulate %s + %s: %s", p.WorktreeA[:8], p.WorktreeB[:8], p.Error)
+			case !p.Clean:
+				conflicting++
+				fmt.Printf("%s %s + %s\n", ui.T.Warning("CONFLICT:"), p.WorktreeA[:12], p.WorktreeB[:12])
+				for _, path := range p.ConflictedPaths {
+					fmt.Printf("  %s\n", path)
+				}
+			}
+		}
+		if conflicting == 0 {
+			ui.Success("All %d pair(s) in %s merge cleanly", len(pairs), repo.Name)
+		} else {
+			fmt.Printf("%d of %d pair(s) would conflict.\n", conflicting, len(pairs))
+		}
+		ui.Blank()
+	}
+
+	if ui.IsJSON() {
+		return ui.RenderJSON(map[string]interface{}{"simulations": results})
+	}
+	return nil
+}
+
 func init() {
+	conflictsCmd.Flags().Bool("simulate", false, "Simulate pairwise merges of all active worktrees with git merge-tree")
 	rootCmd.AddCommand(conflictsCmd)
 }
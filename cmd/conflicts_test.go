@@ -1,7 +1,10 @@
 package cmd
 
 import (
+	"encoding/json"
+	"fmt"
 	"os/exec"
+	"sort"
 	"strings"
 	"testing"
 )
@@ -165,3 +168,217 @@ func TestConflictsWithSuggestions(t *testing.T) {
 		t.Errorf("expected 'suggestions' in JSON output, got: %s", stdoutJSON)
 	}
 }
+
+func TestConflictsHunkLevel(t *testing.T) {
+	repoPath := setupTestGitRepo(t)
+
+	var lines []string
+	for i := 1; i <= 40; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+	}
+	writeFileInWorktree(t, repoPath, "big.txt", strings.Join(lines, "\n")+"\n")
+	runGit(t, repoPath, "add", "big.txt")
+	runGit(t, repoPath, "commit", "-m", "add big.txt")
+
+	env := newTestEnv(t)
+	env.init()
+	if _, err := env.run("add", repoPath); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+
+	stdout1, err := env.runJSON("spawn", "test-repo", "--task", "top", "--agent", "h1")
+	if err != nil {
+		t.Fatalf("spawn 1 failed: %v", err)
+	}
+	_, path1 := extractSpawnJSON(t, stdout1)
+	stdout2, err := env.runJSON("spawn", "test-repo", "--task", "bottom", "--agent", "h2")
+	if err != nil {
+		t.Fatalf("spawn 2 failed: %v", err)
+	}
+	_, path2 := extractSpawnJSON(t, stdout2)
+
+	edit := func(wtPath string, line int, text string) {
+		t.Helper()
+		edited := append([]string(nil), lines...)
+		edited[line-1] = text
+		writeFileInWorktree(t, wtPath, "big.txt", strings.Join(edited, "\n")+"\n")
+		runGit(t, wtPath, "commit", "-am", "edit big.txt")
+	}
+
+	// Far-apart edits to the same file are not a conflict
+	edit(path1, 2, "top edit")
+	edit(path2, 30, "bottom edit")
+
+	stdout, err := env.runJSON("conflicts", "test-repo")
+	if err != nil {
+		t.Fatalf("conflicts failed: %v", err)
+	}
+	if strings.Contains(stdout, "big.txt") {
+		t.Fatalf("expected no conflict for distant hunks, got: %s", stdout)
+	}
+
+	// Moving the second edit within the context window is
+	edit(path2, 4, "near edit")
+
+	stdout, err = env.runJSON("conflicts", "test-repo")
+	if err != nil {
+		t.Fatalf("conflicts failed: %v", err)
+	}
+	var out conflictsOutputJSON
+	if err := json.Unmarshal([]byte(stdout), &out); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, stdout)
+	}
+	if len(out.Conflicts) != 1 || out.Conflicts[0].File != "big.txt" {
+		t.Fatalf("expected a big.txt conflict, got: %s", stdout)
+	}
+	var got []string
+	for _, wt := range out.Conflicts[0].Worktrees {
+		got = append(got, strings.Join(wt.Lines, ","))
+	}
+	sort.Strings(got)
+	if strings.Join(got, " ") != "2 4" {
+		t.Errorf("expected overlapping lines 2 and 4, got %v", got)
+	}
+}
+
+func TestConflictsUncommittedEdits(t *testing.T) {
+	repoPath := setupTestGitRepo(t)
+	env := newTestEnv(t)
+	env.init()
+	if _, err := env.run("add", repoPath); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+
+	_, path1 := spawnWithCommit(t, env, "committed", "shared.txt")
+	stdout, err := env.runJSON("spawn", "test-repo", "--task", "editing", "--agent", "editor")
+	if err != nil {
+		t.Fatalf("spawn failed: %v", err)
+	}
+	wt2, path2 := extractSpawnJSON(t, stdout)
+
+	// Only edited in the working tree, never committed
+	writeFileInWorktree(t, path2, "shared.txt", "draft\n")
+
+	stdout, err = env.runJSON("conflicts", "test-repo")
+	if err != nil {
+		t.Fatalf("conflicts failed: %v", err)
+	}
+	var out conflictsOutputJSON
+	if err := json.Unmarshal([]byte(stdout), &out); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, stdout)
+	}
+	if len(out.Conflicts) != 1 || out.Conflicts[0].File != "shared.txt" {
+		t.Fatalf("expected a shared.txt conflict, got: %s", stdout)
+	}
+	if out.Conflicts[0].Kind != "in_flight" {
+		t.Errorf("expected in_flight conflict, got %q", out.Conflicts[0].Kind)
+	}
+	for _, wt := range out.Conflicts[0].Worktrees {
+		if wt.Uncommitted != strings.HasPrefix(wt2, wt.ID) {
+			t.Errorf("worktree %s: uncommitted = %v", wt.ID, wt.Uncommitted)
+		}
+	}
+
+	text, err := env.run("conflicts", "test-repo")
+	if err != nil {
+		t.Fatalf("conflicts failed: %v", err)
+	}
+	if !strings.Contains(text, "in-flight edit") || !strings.Contains(text, "[uncommitted]") {
+		t.Errorf("expected in-flight conflict in text output, got: %s", text)
+	}
+
+	// Once both sides are committed, the conflict is reported as committed
+	runGit(t, path2, "add", "shared.txt")
+	runGit(t, path2, "commit", "-m", "add shared.txt")
+	writeFileInWorktree(t, path1, "unrelated.txt", "scratch\n")
+
+	stdout, err = env.runJSON("conflicts", "test-repo")
+	if err != nil {
+		t.Fatalf("conflicts failed: %v", err)
+	}
+	out = conflictsOutputJSON{}
+	if err := json.Unmarshal([]byte(stdout), &out); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, stdout)
+	}
+	if len(out.Conflicts) != 1 || out.Conflicts[0].Kind != "committed" {
+		t.Errorf("expected one committed conflict, got: %s", stdout)
+	}
+}
+
+func TestConflictsSimulate(t *testing.T) {
+	repoPath := setupTestGitRepo(t)
+	env := newTestEnv(t)
+	env.init()
+
+	if _, err := env.run("add", repoPath); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+
+	var paths []string
+	for _, task := range []string{"sim1", "sim2", "sim3"} {
+		stdout, err := env.runJSON("spawn", "test-repo", "--task", task, "--agent", task)
+		if err != nil {
+			t.Fatalf("spawn %s failed: %v", task, err)
+		}
+		_, p := extractSpawnJSON(t, stdout)
+		paths = append(paths, p)
+	}
+
+	// The first two rewrite README.md differently; the third adds its own file
+	writeFileInWorktree(t, paths[0], "README.md", "# One\n")
+	runGit(t, paths[0], "commit", "-am", "one")
+	writeFileInWorktree(t, paths[1], "README.md", "# Two\n")
+	runGit(t, paths[1], "commit", "-am", "two")
+	writeFileInWorktree(t, paths[2], "other.txt", "three\n")
+	runGit(t, paths[2], "add", "other.txt")
+	runGit(t, paths[2], "commit", "-m", "three")
+
+	stdout, err := env.runJSON("conflicts", "test-repo", "--simulate")
+	if err != nil {
+		t.Fatalf("conflicts --simulate failed: %v", err)
+	}
+	var out struct {
+		Simulations []struct {
+			Repo  string `json:"repo"`
+			Pairs []struct {
+				Clean           bool     `json:"clean"`
+				ConflictedPaths []string `json:"conflicted_paths"`
+			} `json:"pairs"`
+		} `json:"simulations"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &out); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, stdout)
+	}
+	if len(out.Simulations) != 1 || len(out.Simulations[0].Pairs) != 3 {
+		t.Fatalf("expected 3 simulated pairs, got: %s", stdout)
+	}
+	conflicting := 0
+	for _, p := range out.Simulations[0].Pairs {
+		if !p.Clean {
+			conflicting++
+			if len(p.ConflictedPaths) != 1 || p.ConflictedPaths[0] != "README.md" {
+				t.Errorf("expected README.md conflict, got %v", p.ConflictedPaths)
+			}
+		}
+	}
+	if conflicting != 1 {
+		t.Errorf("expected exactly 1 conflicting pair, got %d", conflicting)
+	}
+
+	// The main checkout must be left alone
+	status, err := exec.Command("git", "-C", repoPath, "status", "--porcelain", "--untracked-files=no").Output()
+	if err != nil {
+		t.Fatalf("git status failed: %v", err)
+	}
+	if strings.TrimSpace(string(status)) != "" {
+		t.Errorf("expected clean checkout after simulation, got:\n%s", status)
+	}
+
+	text, err := env.run("conflicts", "test-repo", "--simulate")
+	if err != nil {
+		t.Fatalf("conflicts --simulate failed: %v", err)
+	}
+	if !strings.Contains(text, "1 of 3 pair(s) would conflict") {
+		t.Errorf("expected summary in output, got: %s", text)
+	}
+}
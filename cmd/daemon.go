@@ -0,0 +1,291 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fathindos/agit/internal/config"
+	"github.com/fathindos/agit/internal/daemon"
+	apperrors "github.com/fathindos/agit/internal/errors"
+	"github.com/fathindos/agit/internal/ui"
+)
+
+// daemonStopTimeout is how long agit daemon stop waits for the process to exit
+const daemonStopTimeout = 10 * time.Second
+
+type daemonStatusJSON struct {
+	Running   bool               `json:"running"`
+	PID       int                `json:"pid,omitempty"`
+	StartedAt string             `json:"started_at,omitempty"`
+	Interval  string             `json:"interval,omitempty"`
+	LastTick  *daemon.TickResult `json:"last_tick,omitempty"`
+}
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Run background sweeps and conflict scans",
+	Long: `Manages the agit daemon, a background process that keeps the registry
+tidy without anyone having to run agit by hand. On every pass it:
+
+  - marks agents disconnected once their heartbeat is older than
+    agent.stale_after
+  - requeues tasks whose lease has expired, or fails them once they have
+    used up agent.max_task_attempts
+  - releases locks whose TTL has lapsed (see agit lock)
+  - marks worktrees stale when their directory is gone, or when no active
+    agent has touched them within defaults.cleanup_stale_after
+  - scans every repo for overlapping changes (if defaults.auto_conflict_check
+    is set) and fires the conflict.detected hook for each new overlap
+
+While it runs, the daemon also watches every active worktree for edits (if
+defaults.watch is set). Once a worktree has been quiet for
+defaults.watch_debounce, its file touches are refreshed, uncommitted edits
+included, and its repo is checked for new conflicts straight away. Entries
+in the merge queues (agit queue, agit_enqueue_merge) are merged in the
+background, one at a time per repo.
+
+Passes run every agent.heartbeat_interval unless --interval is given. The
+daemon's PID is kept in ~/.agit/daemon.pid and its output in
+~/.agit/daemon.log. agit serve --scheduler runs the same passes inside the
+MCP server instead.`,
+}
+
+var daemonStartCmd = &cobra.Command{
+	Use:   "start",
+	Short: "Start the daemon in the background",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		foreground, _ := cmd.Flags().GetBool("foreground")
+		interval, _ := cmd.Flags().GetDuration("interval")
+
+		if foreground {
+			return runDaemon(interval)
+		}
+
+		paths, err := daemon.DefaultPaths()
+		if err != nil {
+			return err
+		}
+		if pid, err := paths.Running(); err != nil {
+			return err
+		} else if pid != 0 {
+			return apperrors.NewUserErrorf("daemon is already running (pid %d)", pid)
+		}
+
+		if err := config.EnsureDir(); err != nil {
+			return err
+		}
+		exe, err := os.Executable()
+		if err != nil {
+			return fmt.Errorf("could not locate agit executable: %w", err)
+		}
+		logFile, err := os.OpenFile(paths.Log, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("could not open daemon log: %w", err)
+		}
+		defer logFile.Close()
+
+		child := exec.Command(exe, "daemon", "run", "--interval", interval.String())
+		// Carry --home and --profile over to the detached process
+		home, err := config.AgitDir()
+		if err != nil {
+			return err
+		}
+		child.Env = append(os.Environ(), "AGIT_HOME="+home, "AGIT_PROFILE="+config.Profile())
+		child.Stdout = logFile
+		child.Stderr = logFile
+		if err := daemon.Detach(child); err != nil {
+			return fmt.Errorf("could not start daemon: %w", err)
+		}
+		pid := child.Process.Pid
+		child.Process.Release()
+
+		// Wait for the daemon to claim the pidfile so a failed start is reported
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			running, _ := paths.Running()
+			if running == pid {
+				break
+			}
+			if time.Now().After(deadline) {
+				return fmt.Errorf("daemon did not start; see %s", paths.Log)
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		if ui.IsJSON() {
+			return ui.RenderJSON(map[string]interface{}{
+				"status": "started",
+				"pid":    pid,
+				"log":    paths.Log,
+			})
+		}
+		ui.Success("Daemon started (pid %d)", pid)
+		ui.Bullet("Log: %s", paths.Log)
+		return nil
+	},
+}
+
+var daemonRunCmd = &cobra.Command{
+	Use:    "run",
+	Short:  "Run the daemon in the foreground",
+	Hidden: true,
+	Args:   cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		interval, _ := cmd.Flags().GetDuration("interval")
+		return runDaemon(interval)
+	},
+}
+
+var daemonStopCmd = &cobra.Command{
+	Use:   "stop",
+	Short: "Stop the running daemon",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		paths, err := daemon.DefaultPaths()
+		if err != nil {
+			return err
+		}
+		pid, err := paths.Stop(daemonStopTimeout)
+		if err != nil {
+			return err
+		}
+
+		if ui.IsJSON() {
+			status := "stopped"
+			if pid == 0 {
+				status = "not_running"
+			}
+			return ui.RenderJSON(map[string]interface{}{
+				"status": status,
+				"pid":    pid,
+			})
+		}
+		if pid == 0 {
+			fmt.Println("Daemon is not running.")
+			return nil
+		}
+		ui.Success("Daemon stopped (pid %d)", pid)
+		return nil
+	},
+}
+
+var daemonStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show whether the daemon is running and what its last pass did",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		paths, err := daemon.DefaultPaths()
+		if err != nil {
+			return err
+		}
+		pid, err := paths.Running()
+		if err != nil {
+			return err
+		}
+
+		out := daemonStatusJSON{Running: pid != 0, PID: pid}
+		if pid != 0 {
+			state, err := paths.ReadState()
+			if err != nil {
+				return err
+			}
+			if state != nil {
+				out.StartedAt = state.StartedAt.Format(time.RFC3339)
+				out.Interval = state.Interval
+				out.LastTick = state.LastTick
+			}
+		}
+
+		if ui.IsJSON() {
+			return ui.RenderJSON(out)
+		}
+		if !out.Running {
+			fmt.Println("Daemon is not running. Start it with: agit daemon start")
+			return nil
+		}
+
+		fmt.Printf("Daemon %s (pid %d)\n", ui.StatusColor("active"), pid)
+		if out.StartedAt != "" {
+			ui.Bullet("Started: %s", out.StartedAt)
+			ui.Bullet("Interval: %s", out.Interval)
+		}
+		if t := out.LastTick; t != nil {
+			ui.Bullet("Last pass: %s", t.At.Format(time.RFC3339))
+			ui.Bullet("Agents swept: %d, tasks requeued: %d, tasks failed: %d",
+				t.AgentsSwept, len(t.TasksRequeued), len(t.TasksFailed))
+			ui.Bullet("Worktrees marked stale: %d, locks expired: %d, new conflicts: %d",
+				t.WorktreesStaled, t.LocksExpired, t.NewConflicts)
+			for _, e := range t.Errors {
+				ui.Warning("%s", e)
+			}
+		}
+		return nil
+	},
+}
+
+// runDaemon holds the pidfile and runs the scheduler until interrupted
+func runDaemon(interval time.Duration) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("could not load config: %w", err)
+	}
+
+	db, err := openRegistry()
+	if err != nil {
+		return fmt.Errorf("could not open registry: %w", err)
+	}
+	defer db.Close()
+
+	paths, err := daemon.DefaultPaths()
+	if err != nil {
+		return err
+	}
+	if err := paths.Acquire(); err != nil {
+		return apperrors.NewUserError(err.Error())
+	}
+	defer paths.Remove()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	sched := daemon.NewScheduler(db, cfg, interval)
+	state := &daemon.State{
+		PID:       os.Getpid(),
+		StartedAt: time.Now(),
+		Interval:  sched.Interval().String(),
+	}
+	if err := paths.WriteState(state); err != nil {
+		return err
+	}
+	sched.OnTick = func(result *daemon.TickResult) {
+		state.LastTick = result
+		if err := paths.WriteState(state); err != nil {
+			log.Printf("daemon: %v", err)
+		}
+	}
+
+	log.Printf("agit daemon started (pid %d, every %s)", state.PID, state.Interval)
+	sched.Run(ctx)
+	log.Println("agit daemon stopped")
+	return nil
+}
+
+func init() {
+	daemonStartCmd.Flags().Bool("foreground", false, "Run in the foreground instead of detaching")
+	daemonStartCmd.Flags().Duration("interval", 0, "Time between passes (default: agent.heartbeat_interval)")
+	daemonRunCmd.Flags().Duration("interval", 0, "Time between passes (default: agent.heartbeat_interval)")
+	daemonCmd.AddCommand(daemonStartCmd)
+	daemonCmd.AddCommand(daemonRunCmd)
+	daemonCmd.AddCommand(daemonStopCmd)
+	daemonCmd.AddCommand(daemonStatusCmd)
+	rootCmd.AddCommand(daemonCmd)
+}
@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fathindos/agit/internal/config"
+	"github.com/fathindos/agit/internal/daemon"
+)
+
+func TestDaemonStatusNotRunning(t *testing.T) {
+	env := newTestEnv(t)
+	env.init()
+
+	stdout, err := env.run("daemon", "status")
+	if err != nil {
+		t.Fatalf("daemon status failed: %v", err)
+	}
+	if !strings.Contains(stdout, "not running") {
+		t.Errorf("expected not running, got: %s", stdout)
+	}
+
+	stdout, err = env.run("daemon", "stop")
+	if err != nil {
+		t.Fatalf("daemon stop failed: %v", err)
+	}
+	if !strings.Contains(stdout, "not running") {
+		t.Errorf("expected not running, got: %s", stdout)
+	}
+}
+
+func TestDaemonStatusReportsLastTick(t *testing.T) {
+	env := newTestEnv(t)
+	env.init()
+
+	// Pose as a running daemon
+	paths := daemon.PathsIn(filepath.Join(env.home, ".agit"))
+	if err := paths.Acquire(); err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	paths.WriteState(&daemon.State{
+		PID:       os.Getpid(),
+		StartedAt: time.Now(),
+		Interval:  "30s",
+		LastTick:  &daemon.TickResult{At: time.Now(), AgentsSwept: 2},
+	})
+
+	stdout, err := env.runJSON("daemon", "status")
+	if err != nil {
+		t.Fatalf("daemon status failed: %v", err)
+	}
+	var out daemonStatusJSON
+	if err := json.Unmarshal([]byte(stdout), &out); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, stdout)
+	}
+	if !out.Running || out.PID != os.Getpid() || out.Interval != "30s" {
+		t.Errorf("unexpected status: %+v", out)
+	}
+	if out.LastTick == nil || out.LastTick.AgentsSwept != 2 {
+		t.Errorf("expected last tick with 2 agents swept, got %+v", out.LastTick)
+	}
+
+	if _, err := env.run("daemon", "start"); err == nil {
+		t.Error("expected start to refuse while a daemon is running")
+	}
+}
+
+func TestSchedulerFiresConflictHookOnce(t *testing.T) {
+	repoPath := setupTestGitRepo(t)
+	env := newTestEnv(t)
+	env.init()
+	if _, err := env.run("add", repoPath); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+
+	hookOutput := filepath.Join(env.home, "conflict_hook.txt")
+	if _, err := env.run("config", "set", "hooks.conflict.detected", "echo \"$AGIT_FILE $AGIT_WORKTREES\" >> "+hookOutput); err != nil {
+		t.Fatalf("config set hook failed: %v", err)
+	}
+
+	wt1, _ := spawnWithCommit(t, env, "first", "shared.txt")
+	wt2, _ := spawnWithCommit(t, env, "second", "shared.txt")
+
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("config.Load: %v", err)
+	}
+	// The daemon's registry has hooks attached, as every command's does
+	db, err := openRegistry()
+	if err != nil {
+		t.Fatalf("openRegistry: %v", err)
+	}
+	defer db.Close()
+
+	sched := daemon.NewScheduler(db, cfg, 0)
+	result := sched.Tick()
+	if len(result.Errors) > 0 {
+		t.Fatalf("unexpected errors: %v", result.Errors)
+	}
+	if result.NewConflicts != 1 {
+		t.Fatalf("expected 1 new conflict, got %d", result.NewConflicts)
+	}
+	// The same overlap is not reported twice
+	if result := sched.Tick(); result.NewConflicts != 0 {
+		t.Errorf("expected no new conflicts on second pass, got %d", result.NewConflicts)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		data, _ := os.ReadFile(hookOutput)
+		if len(data) > 0 {
+			got := string(data)
+			if !strings.HasPrefix(got, "shared.txt ") || !strings.Contains(got, wt1) || !strings.Contains(got, wt2) {
+				t.Errorf("unexpected hook output: %q", got)
+			}
+			if strings.Count(got, "\n") != 1 {
+				t.Errorf("expected hook to fire once, got %q", got)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("conflict.detected hook did not run within 3s")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fathindos/agit/internal/registry"
+	"github.com/fathindos/agit/internal/ui"
+)
+
+type migrationJSON struct {
+	Version   int    `json:"version"`
+	Name      string `json:"name"`
+	Applied   bool   `json:"applied"`
+	AppliedAt string `json:"applied_at,omitempty"`
+}
+
+func toMigrationJSON(statuses []registry.MigrationStatus) []migrationJSON {
+	items := make([]migrationJSON, 0, len(statuses))
+	for _, st := range statuses {
+		item := migrationJSON{Version: st.Version, Name: st.Name, Applied: st.Applied}
+		if st.AppliedAt != nil {
+			item.AppliedAt = st.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Inspect and maintain the agit registry database",
+	Long:  `Maintenance commands for the SQLite registry stored in ~/.agit/agit.db.`,
+}
+
+var dbMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Apply pending schema migrations",
+	Long: `Applies pending registry schema migrations in order. Each migration runs
+in its own transaction and is recorded in the schema_migrations table.
+
+Migrations are also applied automatically whenever agit opens the registry.
+Use --status to list every known migration, or --dry-run to see what would
+be applied without changing the database.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		status, _ := cmd.Flags().GetBool("status")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		db, err := registry.OpenNoMigrate()
+		if err != nil {
+			return fmt.Errorf("could not open registry: %w", err)
+		}
+		defer db.Close()
+
+		if status {
+			statuses, err := db.MigrationStatus()
+			if err != nil {
+				return err
+			}
+			version, err := db.SchemaVersion()
+			if err != nil {
+				return err
+			}
+
+			if ui.IsJSON() {
+				return ui.RenderJSON(map[string]interface{}{
+					"version":    version,
+					"latest":     registry.LatestSchemaVersion(),
+					"migrations": toMigrationJSON(statuses),
+				})
+			}
+
+			fmt.Printf("Schema version: %d (latest known: %d)\n\n", version, registry.LatestSchemaVersion())
+			table := ui.NewTable("Version", "Name", "Status", "Applied At")
+			for _, st := range statuses {
+				state, at := ui.StatusColor("pending"), "-"
+				if st.Applied {
+					state = ui.T.Success("applied")
+					at = st.AppliedAt.Format("2006-01-02 15:04")
+				}
+				table.Append([]string{fmt.Sprintf("%d", st.Version), st.Name, state, at})
+			}
+			table.Render()
+			if version > registry.LatestSchemaVersion() {
+				ui.Blank()
+				ui.Warning("Database was migrated by a newer agit binary; upgrade agit before using it")
+			}
+			return nil
+		}
+
+		if dryRun {
+			pending, err := db.PendingMigrations()
+			if err != nil {
+				return err
+			}
+			if ui.IsJSON() {
+				return ui.RenderJSON(map[string]interface{}{
+					"status":  "ok",
+					"dry_run": true,
+					"pending": toMigrationJSON(pending),
+				})
+			}
+			if len(pending) == 0 {
+				ui.Success("Database schema is up to date")
+				return nil
+			}
+			fmt.Printf("%d pending migration(s):\n", len(pending))
+			for _, st := range pending {
+				ui.Bullet("%d: %s", st.Version, st.Name)
+			}
+			return nil
+		}
+
+		applied, err := db.Migrate()
+		if err != nil {
+			return err
+		}
+
+		if ui.IsJSON() {
+			return ui.RenderJSON(map[string]interface{}{
+				"status":  "ok",
+				"applied": toMigrationJSON(applied),
+				"version": registry.LatestSchemaVersion(),
+			})
+		}
+
+		if len(applied) == 0 {
+			ui.Success("Database schema is up to date")
+			return nil
+		}
+		for _, st := range applied {
+			ui.Success("Applied migration %d: %s", st.Version, st.Name)
+		}
+		return nil
+	},
+}
+
+func init() {
+	dbMigrateCmd.Flags().Bool("status", false, "List all migrations and whether they are applied")
+	dbMigrateCmd.Flags().Bool("dry-run", false, "Show pending migrations without applying them")
+	dbCmd.AddCommand(dbMigrateCmd)
+	rootCmd.AddCommand(dbCmd)
+}
@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDBMigrateUpToDate(t *testing.T) {
+	stdout, err := executeCommandWithInit(t, "db", "migrate")
+	if err != nil {
+		t.Fatalf("db migrate failed: %v", err)
+	}
+	if !strings.Contains(stdout, "up to date") {
+		t.Errorf("expected 'up to date' in output, got: %s", stdout)
+	}
+}
+
+func TestDBMigrateStatus(t *testing.T) {
+	stdout, err := executeCommandWithInit(t, "db", "migrate", "--status")
+	if err != nil {
+		t.Fatalf("db migrate --status failed: %v", err)
+	}
+	if !strings.Contains(stdout, "initial schema") {
+		t.Errorf("expected migration names in output, got: %s", stdout)
+	}
+	if !strings.Contains(stdout, "applied") {
+		t.Errorf("expected applied migrations in output, got: %s", stdout)
+	}
+}
+
+func TestDBMigrateDryRunFreshDatabase(t *testing.T) {
+	env := newTestEnv(t)
+	env.init()
+
+	// Start from an empty database so every migration is pending
+	if err := os.Remove(filepath.Join(env.home, ".agit", "agit.db")); err != nil {
+		t.Fatalf("could not remove database: %v", err)
+	}
+
+	stdout, err := env.runJSON("db", "migrate", "--dry-run")
+	if err != nil {
+		t.Fatalf("db migrate --dry-run failed: %v", err)
+	}
+	if !strings.Contains(stdout, `"initial schema"`) {
+		t.Errorf("expected pending initial schema migration, got: %s", stdout)
+	}
+}
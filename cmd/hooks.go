@@ -0,0 +1,567 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fathindos/agit/internal/config"
+	apperrors "github.com/fathindos/agit/internal/errors"
+	"github.com/fathindos/agit/internal/hooks"
+	"github.com/fathindos/agit/internal/registry"
+	"github.com/fathindos/agit/internal/ui"
+)
+
+type deliveryJSON struct {
+	ID        string `json:"id"`
+	Webhook   string `json:"webhook"`
+	Event     string `json:"event"`
+	URL       string `json:"url"`
+	Status    string `json:"status"`
+	Attempts  int    `json:"attempts"`
+	LastError string `json:"last_error,omitempty"`
+	CreatedAt string `json:"created_at"`
+}
+
+func toDeliveryJSON(d *registry.WebhookDelivery) deliveryJSON {
+	item := deliveryJSON{
+		ID:        d.ID,
+		Webhook:   d.Webhook,
+		Event:     d.Event,
+		URL:       d.URL,
+		Status:    d.Status,
+		Attempts:  d.Attempts,
+		CreatedAt: d.CreatedAt.Format(time.RFC3339),
+	}
+	if d.LastError != nil {
+		item.LastError = *d.LastError
+	}
+	return item
+}
+
+type hookJSON struct {
+	Event    string   `json:"event"`
+	Commands []string `json:"commands"`
+	Blocking bool     `json:"blocking"`
+	Source   string   `json:"source"`
+}
+
+type webhookJSON struct {
+	Name   string   `json:"name"`
+	URL    string   `json:"url"`
+	Events []string `json:"events"` // empty for every event
+	Signed bool     `json:"signed"`
+}
+
+type hookRunJSON struct {
+	ID         int64  `json:"id,omitempty"`
+	Event      string `json:"event"`
+	Command    string `json:"command"`
+	Repo       string `json:"repo,omitempty"`
+	ExitCode   int    `json:"exit_code"`
+	DurationMS int64  `json:"duration_ms"`
+	Stdout     string `json:"stdout,omitempty"`
+	Stderr     string `json:"stderr,omitempty"`
+	TimedOut   bool   `json:"timed_out"`
+	Time       string `json:"time"`
+}
+
+func toHookRunJSON(r *registry.HookRun) hookRunJSON {
+	return hookRunJSON{
+		ID:         r.ID,
+		Event:      r.Event,
+		Command:    r.Command,
+		Repo:       r.Repo,
+		ExitCode:   r.ExitCode,
+		DurationMS: r.DurationMS,
+		Stdout:     r.Stdout,
+		Stderr:     r.Stderr,
+		TimedOut:   r.TimedOut,
+		Time:       r.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+// hookRunStatus summarizes how a hook run ended
+func hookRunStatus(r *registry.HookRun) string {
+	switch {
+	case r.TimedOut:
+		return "timed out"
+	case r.ExitCode != 0:
+		return fmt.Sprintf("exit %d", r.ExitCode)
+	default:
+		return "ok"
+	}
+}
+
+// lastLine returns the last non-empty line of a command's output, shortened
+// to fit a table column
+func lastLine(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	line := strings.TrimSpace(lines[len(lines)-1])
+	if len(line) > 60 {
+		line = line[:57] + "..."
+	}
+	return line
+}
+
+var hooksCmd = &cobra.Command{
+	Use:   "hooks",
+	Short: "Manage hooks and webhook deliveries",
+	Long: `Hooks run on agit events: shell commands under [hooks], and webhooks
+under [webhooks.<name>] that POST each event to a URL as signed JSON (see
+agit config). An event's hook is a command or an array of commands, run in
+order; a repository's .agit.toml can set its own.
+
+Every change recorded in the event log (see agit log) fires its event, from
+the CLI, the MCP server and the daemon alike. Hooks get the event's payload
+as AGIT_* environment variables and as a JSON document on stdin.
+
+Every command run is recorded: agit hooks history shows recent runs with
+their exit status and output, and agit hooks test fires an event on demand.
+
+A webhook delivery is retried with exponential backoff; one that still fails
+after its last attempt is kept in the registry for agit hooks redeliver.`,
+}
+
+// openRegistry opens the registry with the configured hooks attached, so
+// every event a command records fires its hooks and webhooks. Closing the
+// registry waits for them.
+func openRegistry() (*registry.DB, error) {
+	db, err := registry.Open()
+	if err != nil {
+		return nil, err
+	}
+	if cfg, err := config.Load(); err == nil {
+		hooks.Attach(db, cfg)
+	}
+	return db, nil
+}
+
+// hooksConfig loads the config whose hooks a subcommand acts on: that of the
+// named repository, or the global one when repoName is empty. The repo is
+// nil in the latter case.
+func hooksConfig(db *registry.DB, repoName string) (*config.Config, map[string]string, *registry.Repo, error) {
+	if repoName == "" {
+		cfg, sources, err := config.LoadLayers("", nil)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("could not load config: %w", err)
+		}
+		return cfg, sources, nil, nil
+	}
+	repo, err := db.GetRepo(repoName)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	cfg, sources, err := config.LoadLayers(repo.Path, repo.ConfigOverrides())
+	if err != nil {
+		return nil, nil, nil, apperrors.NewUserErrorf("invalid config for %s: %v", repo.Name, err)
+	}
+	return cfg, sources, repo, nil
+}
+
+var hooksListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Show configured hooks and webhooks",
+	Long: `Lists the hook commands for each event, in the order they run, with the
+layer each event's hooks came from (see agit config --help), then the
+webhooks. With --repo, includes that repository's .agit.toml and registry
+overrides.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		repoName, _ := cmd.Flags().GetString("repo")
+
+		db, err := openRegistry()
+		if err != nil {
+			return fmt.Errorf("could not open registry: %w", err)
+		}
+		defer db.Close()
+
+		cfg, sources, _, err := hooksConfig(db, repoName)
+		if err != nil {
+			return err
+		}
+
+		events := make([]string, 0, len(cfg.Hooks))
+		for event := range cfg.Hooks {
+			events = append(events, event)
+		}
+		sort.Strings(events)
+		hookItems := make([]hookJSON, 0, len(events))
+		for _, event := range events {
+			hookItems = append(hookItems, hookJSON{
+				Event:    event,
+				Commands: cfg.Hooks[event],
+				Blocking: hooks.Blocking(event),
+				Source:   sources["hooks."+event],
+			})
+		}
+
+		names := make([]string, 0, len(cfg.Webhooks))
+		for name := range cfg.Webhooks {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		webhookItems := make([]webhookJSON, 0, len(names))
+		for _, name := range names {
+			w := cfg.Webhooks[name]
+			webhookItems = append(webhookItems, webhookJSON{
+				Name:   name,
+				URL:    w.URL,
+				Events: append([]string{}, w.Events...),
+				Signed: w.Secret != "",
+			})
+		}
+
+		if ui.IsJSON() {
+			return ui.RenderJSON(map[string]interface{}{"hooks": hookItems, "webhooks": webhookItems})
+		}
+		if len(hookItems) == 0 && len(webhookItems) == 0 {
+			fmt.Println("No hooks configured. Add one with: agit config set hooks.<event> <command>")
+			return nil
+		}
+
+		if len(hookItems) > 0 {
+			ui.Section("Hooks")
+			table := ui.NewTable("Event", "Command", "Source")
+			for _, h := range hookItems {
+				for i, command := range h.Commands {
+					event, source := h.Event, h.Source
+					if i > 0 {
+						event, source = "", ""
+					}
+					table.Append([]string{event, command, source})
+				}
+			}
+			table.Render()
+		}
+		if len(webhookItems) > 0 {
+			ui.Section("Webhooks")
+			table := ui.NewTable("Name", "URL", "Events", "Signed")
+			for _, w := range webhookItems {
+				events := strings.Join(w.Events, ", ")
+				if events == "" {
+					events = "*"
+				}
+				table.Append([]string{w.Name, w.URL, events, fmt.Sprintf("%v", w.Signed)})
+			}
+			table.Render()
+		}
+		return nil
+	},
+}
+
+var hooksTestCmd = &cobra.Command{
+	Use:   "test <event>",
+	Short: "Fire an event to test its hooks",
+	Long: `Runs the hook commands for an event now and waits for them, then sends one
+delivery to each webhook subscribed to it, without retries. Commands see
+AGIT_EVENT and AGIT_TEST=1, plus AGIT_REPO with --repo and any --env
+KEY=VALUE pairs; webhook payloads carry the same values and "test": true.
+
+With --repo, the repository's own hooks are used. For pre.* events the
+commands stop at the first refusal, as they would before a real operation.
+Runs are recorded in agit hooks history.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		event := args[0]
+		repoName, _ := cmd.Flags().GetString("repo")
+		pairs, _ := cmd.Flags().GetStringArray("env")
+
+		env := map[string]string{"AGIT_TEST": "1"}
+		for _, pair := range pairs {
+			key, value, ok := strings.Cut(pair, "=")
+			if !ok || key == "" {
+				return apperrors.NewUserErrorf("invalid --env %q: expected KEY=VALUE", pair)
+			}
+			env[key] = value
+		}
+
+		db, err := openRegistry()
+		if err != nil {
+			return fmt.Errorf("could not open registry: %w", err)
+		}
+		defer db.Close()
+
+		cfg, _, repo, err := hooksConfig(db, repoName)
+		if err != nil {
+			return err
+		}
+		if repo != nil {
+			env["AGIT_REPO"] = repo.Name
+		}
+
+		runner := hooks.NewRunner(cfg, db)
+		data := map[string]any{"test": true}
+		runs := runner.Run(event, env, data)
+		deliveries := runner.Ping(event, env, data)
+		if len(runs) == 0 && len(deliveries) == 0 {
+			return apperrors.NewUserErrorf("no hooks or webhooks configured for %s", event)
+		}
+
+		failed := 0
+		runItems := make([]hookRunJSON, 0, len(runs))
+		for _, r := range runs {
+			if r.ExitCode != 0 {
+				failed++
+			}
+			runItems = append(runItems, toHookRunJSON(r))
+		}
+		webhooks := make([]string, 0, len(deliveries))
+		for name := range deliveries {
+			webhooks = append(webhooks, name)
+		}
+		sort.Strings(webhooks)
+		deliveryItems := make([]map[string]string, 0, len(webhooks))
+		for _, name := range webhooks {
+			item := map[string]string{"webhook": name, "status": "delivered"}
+			if err := deliveries[name]; err != nil {
+				failed++
+				item["status"], item["error"] = "failed", err.Error()
+			}
+			deliveryItems = append(deliveryItems, item)
+		}
+
+		if ui.IsJSON() {
+			status := "ok"
+			if failed > 0 {
+				status = "failed"
+			}
+			return ui.RenderJSON(map[string]interface{}{
+				"status":   status,
+				"event":    event,
+				"runs":     runItems,
+				"webhooks": deliveryItems,
+			})
+		}
+
+		for _, r := range runs {
+			if r.ExitCode == 0 {
+				ui.Success("%s (%dms)", r.Command, r.DurationMS)
+			} else {
+				ui.Warning("%s: %s (%dms)", r.Command, hookRunStatus(r), r.DurationMS)
+			}
+			for _, output := range []string{r.Stdout, r.Stderr} {
+				if output = strings.TrimRight(output, "\n"); output != "" {
+					fmt.Println(output)
+				}
+			}
+		}
+		for _, name := range webhooks {
+			if err := deliveries[name]; err != nil {
+				ui.Warning("webhook %s: %v", name, err)
+			} else {
+				ui.Success("webhook %s delivered", name)
+			}
+		}
+		if len(runs) < len(cfg.Hooks[event]) {
+			ui.Info("Skipped %d later commands after the refusal", len(cfg.Hooks[event])-len(runs))
+		}
+		if failed > 0 {
+			return apperrors.NewUserErrorf("%d of %d hooks for %s failed", failed, len(runs)+len(deliveries), event)
+		}
+		return nil
+	},
+}
+
+var hooksHistoryCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Show recent hook runs",
+	Long: `Shows recent hook command runs, newest first: the event, the command, how
+it ended and how long it took, with the last line of its stderr. The JSON
+output includes the captured stdout and stderr (the last 4 KiB of each).
+
+The registry keeps the most recent 1000 runs.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		event, _ := cmd.Flags().GetString("event")
+		repoName, _ := cmd.Flags().GetString("repo")
+		failedOnly, _ := cmd.Flags().GetBool("failed")
+		limit, _ := cmd.Flags().GetInt("limit")
+
+		db, err := openRegistry()
+		if err != nil {
+			return fmt.Errorf("could not open registry: %w", err)
+		}
+		defer db.Close()
+
+		runs, err := db.ListHookRuns(registry.HookRunFilter{Event: event, Repo: repoName, Failed: failedOnly, Limit: limit})
+		if err != nil {
+			return err
+		}
+
+		if ui.IsJSON() {
+			items := make([]hookRunJSON, 0, len(runs))
+			for _, r := range runs {
+				items = append(items, toHookRunJSON(r))
+			}
+			return ui.RenderJSON(items)
+		}
+		if len(runs) == 0 {
+			fmt.Println("No hook runs recorded.")
+			return nil
+		}
+
+		table := ui.NewTable("Time", "Event", "Repo", "Status", "Duration", "Command", "Stderr")
+		for _, r := range runs {
+			status := hookRunStatus(r)
+			if r.ExitCode != 0 {
+				status = ui.T.Warning(status)
+			}
+			table.Append([]string{
+				ui.T.Muted(r.CreatedAt.Format("2006-01-02 15:04:05")),
+				r.Event,
+				r.Repo,
+				status,
+				fmt.Sprintf("%dms", r.DurationMS),
+				r.Command,
+				lastLine(r.Stderr),
+			})
+		}
+		table.Render()
+		return nil
+	},
+}
+
+var hooksRedeliverCmd = &cobra.Command{
+	Use:   "redeliver [delivery-id...]",
+	Short: "Retry webhook deliveries that failed",
+	Long: `Sends failed webhook deliveries again: the given ones (full IDs or unique
+prefixes), or every failed delivery when no ID is given. Each is retried once,
+with the original body and delivery ID but the webhook's current URL, headers
+and secret. Deliveries that fail again stay queued.
+
+--list shows the failed deliveries without sending anything.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		list, _ := cmd.Flags().GetBool("list")
+
+		db, err := openRegistry()
+		if err != nil {
+			return fmt.Errorf("could not open registry: %w", err)
+		}
+		defer db.Close()
+
+		var deliveries []*registry.WebhookDelivery
+		if len(args) > 0 {
+			for _, id := range args {
+				d, err := db.FindDelivery(id)
+				if err != nil {
+					return apperrors.NewUserError(err.Error())
+				}
+				if d.Status == "delivered" && !list {
+					return apperrors.NewUserErrorf("delivery %s was already delivered", d.ID[:12])
+				}
+				deliveries = append(deliveries, d)
+			}
+		} else if deliveries, err = db.ListFailedDeliveries(); err != nil {
+			return err
+		}
+
+		if list {
+			if ui.IsJSON() {
+				items := make([]deliveryJSON, 0, len(deliveries))
+				for _, d := range deliveries {
+					items = append(items, toDeliveryJSON(d))
+				}
+				return ui.RenderJSON(items)
+			}
+			if len(deliveries) == 0 {
+				fmt.Println("No failed webhook deliveries.")
+				return nil
+			}
+			table := ui.NewTable("ID", "Webhook", "Event", "Attempts", "Last Error")
+			for _, d := range deliveries {
+				lastErr := ""
+				if d.LastError != nil {
+					lastErr = *d.LastError
+				}
+				table.Append([]string{d.ID[:12], d.Webhook, d.Event, fmt.Sprintf("%d", d.Attempts), lastErr})
+			}
+			table.Render()
+			return nil
+		}
+
+		if len(deliveries) == 0 {
+			if ui.IsJSON() {
+				return ui.RenderJSON(map[string]interface{}{"status": "ok", "delivered": 0, "failed": 0, "results": []deliveryJSON{}})
+			}
+			fmt.Println("No failed webhook deliveries.")
+			return nil
+		}
+
+		results := make([]deliveryJSON, 0, len(deliveries))
+		failed := 0
+		for _, d := range deliveries {
+			cfg, err := deliveryConfig(db, d)
+			if err != nil {
+				return err
+			}
+			sendErr := hooks.Redeliver(db, cfg, d)
+			if updated, err := db.FindDelivery(d.ID); err == nil {
+				d = updated
+			}
+			item := toDeliveryJSON(d)
+			if sendErr != nil {
+				failed++
+				item.LastError = sendErr.Error()
+			}
+			results = append(results, item)
+
+			if ui.IsJSON() {
+				continue
+			}
+			if sendErr != nil {
+				ui.Warning("%s %s to %s: %v", d.ID[:12], d.Event, d.Webhook, sendErr)
+			} else {
+				ui.Success("%s %s delivered to %s", d.ID[:12], d.Event, d.Webhook)
+			}
+		}
+
+		if ui.IsJSON() {
+			return ui.RenderJSON(map[string]interface{}{
+				"status":    "ok",
+				"delivered": len(deliveries) - failed,
+				"failed":    failed,
+				"results":   results,
+			})
+		}
+		if failed > 0 {
+			return apperrors.NewUserErrorf("%d of %d deliveries failed again; they stay queued for redelivery", failed, len(deliveries))
+		}
+		return nil
+	},
+}
+
+// deliveryConfig returns the config a delivery's webhook is defined in: that
+// of the repo named in its payload, falling back to the global config
+func deliveryConfig(db *registry.DB, d *registry.WebhookDelivery) (*config.Config, error) {
+	var payload hooks.Payload
+	if err := json.Unmarshal([]byte(d.Body), &payload); err == nil {
+		if name, ok := payload.Data["repo"].(string); ok && name != "" {
+			if repo, err := db.GetRepo(name); err == nil {
+				return loadRepoConfig(repo)
+			}
+		}
+	}
+	return config.Load()
+}
+
+func init() {
+	hooksListCmd.Flags().String("repo", "", "Show the hooks in effect for a repository")
+	hooksTestCmd.Flags().String("repo", "", "Use a repository's hooks and set AGIT_REPO")
+	hooksTestCmd.Flags().StringArray("env", nil, "Extra environment variable for the hooks, as KEY=VALUE (repeatable)")
+	hooksHistoryCmd.Flags().String("event", "", "Only show runs for this event")
+	hooksHistoryCmd.Flags().String("repo", "", "Only show runs for this repository")
+	hooksHistoryCmd.Flags().Bool("failed", false, "Only show runs that failed or timed out")
+	hooksHistoryCmd.Flags().Int("limit", 20, "Show at most this many recent runs (0 for all)")
+	for _, c := range []*cobra.Command{hooksListCmd, hooksTestCmd, hooksHistoryCmd} {
+		_ = c.RegisterFlagCompletionFunc("repo", completeRepoNames)
+	}
+	hooksRedeliverCmd.Flags().Bool("list", false, "List failed deliveries without sending them")
+	hooksCmd.AddCommand(hooksListCmd)
+	hooksCmd.AddCommand(hooksTestCmd)
+	hooksCmd.AddCommand(hooksHistoryCmd)
+	hooksCmd.AddCommand(hooksRedeliverCmd)
+	rootCmd.AddCommand(hooksCmd)
+}
@@ -1,10 +1,28 @@
 package cmd
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+
+	"github.com/fathindos/agit/internal/config"
+	"github.com/fathindos/agit/internal/hooks"
+	mcpserver "github.com/fathindos/agit/internal/mcp"
+	"github.com/fathindos/agit/internal/registry"
 )
 
 func TestHookFiresOnSpawn(t *testing.T) {
@@ -48,3 +66,396 @@ func TestHookFiresOnSpawn(t *testing.T) {
 		}
 	}
 }
+
+func TestPreSpawnHookVetoes(t *testing.T) {
+	repoPath := setupTestGitRepo(t)
+	env := newTestEnv(t)
+	env.init()
+
+	if _, err := env.run("add", repoPath); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+	hook := `test "$AGIT_AGENT" != intruder || { echo "$AGIT_AGENT may not spawn in $AGIT_REPO" >&2; exit 1; }`
+	if _, err := env.run("config", "set", "hooks.pre.spawn", hook); err != nil {
+		t.Fatalf("config set hook failed: %v", err)
+	}
+
+	_, err := env.run("spawn", "test-repo", "--agent", "intruder")
+	if err == nil || err.Error() != "pre.spawn hook refused: intruder may not spawn in test-repo" {
+		t.Fatalf("expected spawn to be refused with the hook's stderr, got %v", err)
+	}
+	if created, _ := filepath.Glob(filepath.Join(repoPath, ".worktrees", "agit-*")); len(created) != 0 {
+		t.Errorf("expected no worktree after a refused spawn, got %v", created)
+	}
+
+	if _, err := env.run("spawn", "test-repo", "--agent", "welcome"); err != nil {
+		t.Fatalf("expected hook to allow other agents, got %v", err)
+	}
+}
+
+func TestPreMergeAndRemoveHooksVeto(t *testing.T) {
+	repoPath := setupTestGitRepo(t)
+	env := newTestEnv(t)
+	env.init()
+
+	if _, err := env.run("add", repoPath); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+	wtID, wtPath := spawnWithCommit(t, env, "frozen", "frozen.txt")
+
+	if _, err := env.run("config", "set", "hooks.pre.merge", `echo "$AGIT_BASE_BRANCH is frozen ($AGIT_STRATEGY)" >&2; exit 1`); err != nil {
+		t.Fatalf("config set hook failed: %v", err)
+	}
+	_, err := env.run("merge", wtID)
+	if err == nil || !strings.Contains(err.Error(), "pre.merge hook refused: main is frozen (merge)") {
+		t.Fatalf("expected merge to be refused with the hook's stderr, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(repoPath, "frozen.txt")); err == nil {
+		t.Error("expected refused merge to leave main untouched")
+	}
+
+	// Lift the freeze, but keep worktrees from being removed
+	env.run("config", "set", "hooks.pre.merge", "")
+	env.run("config", "set", "hooks.pre.worktree.remove", `echo "keep $AGIT_BRANCH for review" >&2; exit 1`)
+	stdout, err := env.runJSON("merge", wtID, "--cleanup")
+	if err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	var result map[string]any
+	if err := json.Unmarshal([]byte(stdout), &result); err != nil {
+		t.Fatalf("could not parse merge JSON: %v\n%s", err, stdout)
+	}
+	if result["cleanup"] != "refused" || !strings.Contains(result["cleanup_error"].(string), "for review") {
+		t.Errorf("expected cleanup to be refused, got %v", result)
+	}
+	if _, err := os.Stat(wtPath); err != nil {
+		t.Errorf("expected worktree to be kept: %v", err)
+	}
+}
+
+func TestWebhookRedeliver(t *testing.T) {
+	repoPath := setupTestGitRepo(t)
+	env := newTestEnv(t)
+	env.init()
+
+	var up atomic.Bool
+	var received atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !up.Load() {
+			http.Error(w, "maintenance", http.StatusServiceUnavailable)
+			return
+		}
+		if r.Header.Get(hooks.HeaderSignature) != hooks.Sign("s3cret", body) {
+			http.Error(w, "bad signature", http.StatusUnauthorized)
+			return
+		}
+		received.Add(1)
+	}))
+	defer srv.Close()
+
+	if _, err := env.run("add", repoPath); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+	for _, kv := range [][2]string{
+		{"webhooks.ci.url", srv.URL},
+		{"webhooks.ci.events", "worktree.created"},
+		{"webhooks.ci.secret", "s3cret"},
+		{"webhooks.ci.attempts", "1"},
+	} {
+		if _, err := env.run("config", "set", kv[0], kv[1]); err != nil {
+			t.Fatalf("config set %s failed: %v", kv[0], err)
+		}
+	}
+
+	if _, err := env.run("spawn", "test-repo", "--agent", "webhook-agent"); err != nil {
+		t.Fatalf("spawn failed: %v", err)
+	}
+
+	stdout, err := env.runJSON("hooks", "redeliver", "--list")
+	if err != nil {
+		t.Fatalf("hooks redeliver --list failed: %v", err)
+	}
+	var failed []deliveryJSON
+	if err := json.Unmarshal([]byte(stdout), &failed); err != nil {
+		t.Fatalf("could not parse JSON: %v\n%s", err, stdout)
+	}
+	if len(failed) != 1 || failed[0].Event != "worktree.created" || !strings.Contains(failed[0].LastError, "HTTP 503") {
+		t.Fatalf("expected one failed worktree.created delivery, got %+v", failed)
+	}
+
+	// Still down: the delivery stays queued
+	if _, err := env.run("hooks", "redeliver"); err == nil || !strings.Contains(err.Error(), "1 of 1 deliveries failed again") {
+		t.Fatalf("expected redelivery to fail, got %v", err)
+	}
+
+	up.Store(true)
+	stdout, err = env.runJSON("hooks", "redeliver", failed[0].ID[:8])
+	if err != nil {
+		t.Fatalf("hooks redeliver failed: %v", err)
+	}
+	if !strings.Contains(stdout, `"delivered": 1`) || received.Load() != 1 {
+		t.Fatalf("expected one signed delivery, got %d: %s", received.Load(), stdout)
+	}
+	if stdout, _ := env.runJSON("hooks", "redeliver", "--list"); strings.TrimSpace(stdout) != "[]" {
+		t.Errorf("expected no failed deliveries left, got %s", stdout)
+	}
+}
+
+func TestHooksListTestAndHistory(t *testing.T) {
+	repoPath := setupTestGitRepo(t)
+	env := newTestEnv(t)
+	env.init()
+
+	if _, err := env.run("add", repoPath); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+	if _, err := env.run("config", "set", "hooks.task.failed", "echo global"); err != nil {
+		t.Fatalf("config set hook failed: %v", err)
+	}
+	local := "[hooks]\n\"task.failed\" = [\"echo from $AGIT_REPO\", \"echo broken >&2; exit 3\"]\n"
+	if err := os.WriteFile(filepath.Join(repoPath, ".agit.toml"), []byte(local), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, err := env.runJSON("hooks", "list", "--repo", "test-repo")
+	if err != nil {
+		t.Fatalf("hooks list failed: %v", err)
+	}
+	var listed struct {
+		Hooks []hookJSON `json:"hooks"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &listed); err != nil {
+		t.Fatalf("could not parse JSON: %v\n%s", err, stdout)
+	}
+	if len(listed.Hooks) != 1 || len(listed.Hooks[0].Commands) != 2 || listed.Hooks[0].Source != "repo file" {
+		t.Fatalf("expected the repo's two task.failed commands, got %+v", listed.Hooks)
+	}
+
+	_, err = env.run("hooks", "test", "task.failed", "--repo", "test-repo")
+	if err == nil || !strings.Contains(err.Error(), "1 of 2 hooks for task.failed failed") {
+		t.Fatalf("expected one failing hook, got %v", err)
+	}
+	if _, err := env.run("hooks", "test", "task.failed"); err != nil {
+		t.Fatalf("expected the global hook to pass, got %v", err)
+	}
+	if _, err := env.run("hooks", "test", "no.such.event"); err == nil {
+		t.Error("expected testing an event without hooks to fail")
+	}
+
+	stdout, err = env.runJSON("hooks", "history", "--failed")
+	if err != nil {
+		t.Fatalf("hooks history failed: %v", err)
+	}
+	var runs []hookRunJSON
+	if err := json.Unmarshal([]byte(stdout), &runs); err != nil {
+		t.Fatalf("could not parse JSON: %v\n%s", err, stdout)
+	}
+	if len(runs) != 1 || runs[0].ExitCode != 3 || runs[0].Stderr != "broken\n" || runs[0].Repo != "test-repo" {
+		t.Fatalf("expected the failed repo hook in history, got %+v", runs)
+	}
+
+	stdout, _ = env.runJSON("hooks", "history", "--event", "task.failed")
+	if err := json.Unmarshal([]byte(stdout), &runs); err != nil || len(runs) != 3 || runs[0].Stdout != "global\n" {
+		t.Errorf("expected all three runs newest first, got %+v", runs)
+	}
+}
+
+// TestCLIAndMCPEmitSameEvents drives the same workflow through the CLI and
+// through the MCP server and expects hooks to see the same events from both.
+func TestCLIAndMCPEmitSameEvents(t *testing.T) {
+	cliEvents := recordHookEvents(t, func(env *testEnv, repoPath string) {
+		mustRun := func(args ...string) map[string]any {
+			t.Helper()
+			stdout, err := env.runJSON(args...)
+			if err != nil {
+				t.Fatalf("%v failed: %v", args, err)
+			}
+			var out map[string]any
+			json.Unmarshal([]byte(stdout), &out)
+			return out
+		}
+		mustRun("add", repoPath)
+		wtID, _ := spawnWithCommit(t, env, "builder", "built.txt")
+		done := mustRun("tasks", "test-repo", "--create", "build it")["id"].(string)
+		broken := mustRun("tasks", "test-repo", "--create", "break it")["id"].(string)
+		mustRun("tasks", "test-repo", "--claim", done, "--agent", "builder-agent")
+		mustRun("tasks", "test-repo", "--start", done, "--worktree", wtID)
+		mustRun("tasks", "test-repo", "--complete", done, "--result", "built")
+		mustRun("tasks", "test-repo", "--fail", broken, "--result", "broke")
+
+		commitOnMain(t, repoPath, "built.txt", "on main\n")
+		if _, err := env.run("merge", wtID); err == nil {
+			t.Fatal("expected the merge to conflict")
+		}
+		removeOnMain(t, repoPath, "built.txt")
+		mustRun("merge", wtID)
+
+		mustRun("agents", "token", "create", "reviewer")
+		mustRun("lock", "test-repo", "docs/**", "--agent", "reviewer")
+		mustRun("unlock", "test-repo", "docs/**", "--agent", "reviewer")
+
+		syncID, _ := spawnWithCommit(t, env, "syncer", "synced.txt")
+		commitOnMain(t, repoPath, "main.txt", "on main\n")
+		mustRun("sync", syncID)
+		mustRun("repos", "set", "test-repo", "setup", "true")
+		mustRun("setup", syncID)
+		mustRun("queue", "add", syncID)
+
+		mustRun("cleanup")
+		mustRun("agents", "--remove", "reviewer")
+	})
+
+	mcpEvents := recordHookEvents(t, func(env *testEnv, repoPath string) {
+		db, err := openRegistry()
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := config.Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := mcpserver.NewServer(db, cfg)
+		call := func(tool string, args map[string]any) map[string]any {
+			t.Helper()
+			out, err := callMCPTool(s.MCPServer, tool, args)
+			if err != nil {
+				t.Fatalf("%s failed: %v", tool, err)
+			}
+			return out
+		}
+
+		call("agit_add_repo", map[string]any{"path": repoPath})
+		wt := call("agit_spawn_worktree", map[string]any{"repo": "test-repo", "task": "builder", "agent": "builder-agent"})
+		wtID, wtPath := wt["worktree_id"].(string), wt["path"].(string)
+		writeFileInWorktree(t, wtPath, "built.txt", "builder\n")
+		runGit(t, wtPath, "add", "built.txt")
+		runGit(t, wtPath, "commit", "-m", "add built.txt")
+		agent, _ := db.GetAgentByName("builder-agent")
+
+		done := call("agit_create_task", map[string]any{"repo": "test-repo", "description": "build it"})["task_id"].(string)
+		broken := call("agit_create_task", map[string]any{"repo": "test-repo", "description": "break it"})["task_id"].(string)
+		call("agit_claim_task", map[string]any{"task_id": done, "agent_id": agent.ID})
+		call("agit_start_task", map[string]any{"task_id": done, "worktree_id": wtID})
+		call("agit_complete_task", map[string]any{"task_id": done, "result": "built"})
+		call("agit_fail_task", map[string]any{"task_id": broken, "result": "broke"})
+
+		merge := map[string]any{"repo": "test-repo", "worktree_id": wtID}
+		commitOnMain(t, repoPath, "built.txt", "on main\n")
+		if out, err := callMCPTool(s.MCPServer, "agit_merge_worktree", merge); err == nil && out["merged"] == true {
+			t.Fatal("expected the merge to conflict")
+		}
+		removeOnMain(t, repoPath, "built.txt")
+		call("agit_merge_worktree", merge)
+
+		reviewer := call("agit_register_agent", map[string]any{"name": "reviewer", "type": "custom"})["agent_id"].(string)
+		lock := call("agit_acquire_lock", map[string]any{"repo": "test-repo", "path": "docs/**", "agent_id": reviewer})["id"].(string)
+		call("agit_release_lock", map[string]any{"lock_id": lock, "agent_id": reviewer})
+
+		syncer := call("agit_spawn_worktree", map[string]any{"repo": "test-repo", "task": "syncer", "agent": "syncer-agent"})
+		syncID, syncPath := syncer["worktree_id"].(string), syncer["path"].(string)
+		writeFileInWorktree(t, syncPath, "synced.txt", "syncer\n")
+		runGit(t, syncPath, "add", "synced.txt")
+		runGit(t, syncPath, "commit", "-m", "add synced.txt")
+		commitOnMain(t, repoPath, "main.txt", "on main\n")
+		call("agit_sync_worktree", map[string]any{"repo": "test-repo", "worktree_id": syncID})
+		if _, err := env.run("repos", "set", "test-repo", "setup", "true"); err != nil {
+			t.Fatal(err)
+		}
+		call("agit_setup_worktree", map[string]any{"repo": "test-repo", "worktree_id": syncID})
+		// The MCP server leaves the queue to a worker: agit queue run or the
+		// scheduler
+		call("agit_enqueue_merge", map[string]any{"repo": "test-repo", "worktree_id": syncID, "cleanup": false})
+		if _, err := env.run("queue", "run", "test-repo"); err != nil {
+			t.Fatal(err)
+		}
+
+		call("agit_remove_worktree", map[string]any{"repo": "test-repo", "worktree_id": wtID})
+		call("agit_remove_worktree", map[string]any{"repo": "test-repo", "worktree_id": syncID})
+		// No MCP tool removes agents
+		if _, err := env.run("agents", "--remove", "reviewer"); err != nil {
+			t.Fatal(err)
+		}
+		db.Close()
+	})
+
+	want := []string{
+		"agent.registered", "agent.registered", "agent.registered", "agent.removed",
+		"lock.acquired", "lock.released", "merge.failed", "repo.added",
+		"task.claimed", "task.completed", "task.created", "task.created", "task.failed", "task.started", "touches.updated",
+		"worktree.created", "worktree.created", "worktree.merged", "worktree.merged",
+		"worktree.removed", "worktree.removed", "worktree.setup", "worktree.synced",
+	}
+	if strings.Join(cliEvents, " ") != strings.Join(want, " ") {
+		t.Errorf("CLI events:\n got %v\nwant %v", cliEvents, want)
+	}
+	if strings.Join(mcpEvents, " ") != strings.Join(cliEvents, " ") {
+		t.Errorf("MCP events differ from the CLI's:\n mcp %v\n cli %v", mcpEvents, cliEvents)
+	}
+}
+
+// recordHookEvents runs scenario in a fresh environment with a hook on every
+// event, and returns the events the hooks saw, sorted
+func recordHookEvents(t *testing.T, scenario func(env *testEnv, repoPath string)) []string {
+	t.Helper()
+	repoPath := setupTestGitRepo(t)
+	env := newTestEnv(t)
+	env.init()
+
+	log := filepath.Join(env.home, "events.log")
+	for _, event := range []string{
+		registry.EventWorktreeCreated, registry.EventWorktreeRemoved, registry.EventWorktreeMerged,
+		registry.EventWorktreeSynced, registry.EventWorktreeSetup,
+		registry.EventTaskCreated, registry.EventTaskClaimed, registry.EventTaskStarted,
+		registry.EventTaskCompleted, registry.EventTaskFailed, registry.EventTaskRequeued, registry.EventTaskBlocked,
+		registry.EventAgentRegistered, registry.EventAgentDisconnected, registry.EventAgentRemoved,
+		registry.EventRepoAdded, registry.EventMergeFailed, registry.EventConflictDetected,
+		registry.EventLockAcquired, registry.EventLockReleased, registry.EventTouchesUpdated,
+	} {
+		if _, err := env.run("config", "set", "hooks."+event, "echo $AGIT_EVENT >> "+log); err != nil {
+			t.Fatalf("config set hook failed: %v", err)
+		}
+	}
+	scenario(env, repoPath)
+
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatalf("no hook ran: %v", err)
+	}
+	events := strings.Fields(string(data))
+	sort.Strings(events)
+	return events
+}
+
+func commitOnMain(t *testing.T, repoPath, file, content string) {
+	t.Helper()
+	writeFileInWorktree(t, repoPath, file, content)
+	runGit(t, repoPath, "add", file)
+	runGit(t, repoPath, "commit", "-m", "add "+file+" on main")
+}
+
+func removeOnMain(t *testing.T, repoPath, file string) {
+	t.Helper()
+	runGit(t, repoPath, "rm", file)
+	runGit(t, repoPath, "commit", "-m", "remove "+file+" from main")
+}
+
+// callMCPTool calls a tool through the server's JSON-RPC handler and decodes
+// its JSON result
+func callMCPTool(s *server.MCPServer, tool string, args map[string]any) (map[string]any, error) {
+	msg, _ := json.Marshal(map[string]any{
+		"jsonrpc": "2.0", "id": 1, "method": "tools/call",
+		"params": map[string]any{"name": tool, "arguments": args},
+	})
+	switch resp := s.HandleMessage(context.Background(), msg).(type) {
+	case mcp.JSONRPCError:
+		return nil, errors.New(resp.Error.Message)
+	case mcp.JSONRPCResponse:
+		result := resp.Result.(mcp.CallToolResult)
+		var out map[string]any
+		err := json.Unmarshal([]byte(result.Content[0].(mcp.TextContent).Text), &out)
+		return out, err
+	default:
+		return nil, fmt.Errorf("unexpected response %T", resp)
+	}
+}
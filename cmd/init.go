@@ -6,7 +6,6 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/fathindos/agit/internal/config"
-	"github.com/fathindos/agit/internal/registry"
 	"github.com/fathindos/agit/internal/ui"
 )
 
@@ -31,7 +30,7 @@ var initCmd = &cobra.Command{
 		}
 
 		// Initialize database
-		db, err := registry.Open()
+		db, err := openRegistry()
 		if err != nil {
 			return fmt.Errorf("could not initialize database: %w", err)
 		}
@@ -0,0 +1,273 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	apperrors "github.com/fathindos/agit/internal/errors"
+	"github.com/fathindos/agit/internal/registry"
+	"github.com/fathindos/agit/internal/ui"
+)
+
+type lockJSON struct {
+	ID        string `json:"id"`
+	Repo      string `json:"repo"`
+	Pattern   string `json:"pattern"`
+	Agent     string `json:"agent,omitempty"`
+	Worktree  string `json:"worktree,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+	CreatedAt string `json:"created_at"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+func toLockJSON(repoName string, l *registry.Lock) lockJSON {
+	item := lockJSON{
+		ID:        l.ID,
+		Repo:      repoName,
+		Pattern:   l.Pattern,
+		CreatedAt: l.CreatedAt.Format(time.RFC3339),
+		ExpiresAt: l.ExpiresAt.Format(time.RFC3339),
+	}
+	if l.AgentName != nil {
+		item.Agent = *l.AgentName
+	}
+	if l.WorktreeID != nil {
+		item.Worktree = *l.WorktreeID
+	}
+	if l.Reason != nil {
+		item.Reason = *l.Reason
+	}
+	return item
+}
+
+// lockHolderFlags resolves --agent and --worktree to the IDs a lock is held by
+func lockHolderFlags(cmd *cobra.Command, db *registry.DB, repo *registry.Repo) (agentID, worktreeID *string, err error) {
+	agentName, _ := cmd.Flags().GetString("agent")
+	worktreeArg, _ := cmd.Flags().GetString("worktree")
+
+	if agentName != "" {
+		agent, err := db.GetAgentByName(agentName)
+		if err != nil {
+			return nil, nil, err
+		}
+		if agent == nil {
+			return nil, nil, apperrors.NewUserErrorf("agent %q not found", agentName)
+		}
+		agentID = &agent.ID
+	}
+	if worktreeArg != "" {
+		wt, err := db.ResolveWorktree(repo.ID, worktreeArg)
+		if err != nil {
+			return nil, nil, err
+		}
+		worktreeID = &wt.ID
+	}
+	return agentID, worktreeID, nil
+}
+
+var lockCmd = &cobra.Command{
+	Use:   "lock <repo> <path>",
+	Short: "Lock a path so other agents stay away from it",
+	Long: `Takes an advisory lock on a file, directory or glob in a repo, held by an
+agent (--agent), a worktree (--worktree) or both. A path without wildcards
+also covers everything below it; globs use * and ? within a path segment and
+** across segments, e.g. internal/registry/db.go, internal/registry or
+**/*.sql.
+
+Locks are not enforced on disk. agit conflicts and agit_check_conflicts
+report changes in other worktrees that fall under a lock, and agit merge
+and the merge queue refuse to land them.
+
+A lock lapses after --ttl unless renewed: locking a path you already hold
+extends it. Locking a path that overlaps someone else's lock fails.`,
+	Args:              cobra.ExactArgs(2),
+	ValidArgsFunction: completeRepoNames,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ttl, _ := cmd.Flags().GetDuration("ttl")
+		reasonFlag, _ := cmd.Flags().GetString("reason")
+
+		db, err := openRegistry()
+		if err != nil {
+			return fmt.Errorf("could not open registry: %w", err)
+		}
+		defer db.Close()
+
+		repo, err := db.GetRepo(args[0])
+		if err != nil {
+			return err
+		}
+		agentID, worktreeID, err := lockHolderFlags(cmd, db, repo)
+		if err != nil {
+			return err
+		}
+		if agentID == nil && worktreeID == nil {
+			return apperrors.NewUserError("a lock needs a holder: pass --agent or --worktree")
+		}
+		if ttl <= 0 {
+			return apperrors.NewUserError("--ttl must be positive")
+		}
+
+		var reason *string
+		if reasonFlag != "" {
+			reason = &reasonFlag
+		}
+
+		lock, err := db.AcquireLock(repo.ID, args[1], agentID, worktreeID, reason, ttl)
+		var held *registry.LockHeldError
+		if errors.As(err, &held) || errors.Is(err, registry.ErrInvalidPattern) {
+			return apperrors.NewUserError(err.Error())
+		}
+		if err != nil {
+			return err
+		}
+
+		if ui.IsJSON() {
+			return ui.RenderJSON(toLockJSON(repo.Name, lock))
+		}
+		ui.Success("Locked %s in %s for %s (lock %s)", lock.Pattern, repo.Name, lock.Holder(), lock.ID[:8])
+		ui.Bullet("Expires: %s", lock.ExpiresAt.Format(time.RFC3339))
+		return nil
+	},
+}
+
+var unlockCmd = &cobra.Command{
+	Use:   "unlock <repo> <lock-id|path>",
+	Short: "Release a lock",
+	Long: `Releases a lock by ID (or ID prefix) or by the exact path it was taken on.
+When a path is given, --agent or --worktree picks whose lock to release if
+more than one holder has locked it.`,
+	Args:              cobra.ExactArgs(2),
+	ValidArgsFunction: completeRepoNames,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		db, err := openRegistry()
+		if err != nil {
+			return fmt.Errorf("could not open registry: %w", err)
+		}
+		defer db.Close()
+
+		repo, err := db.GetRepo(args[0])
+		if err != nil {
+			return err
+		}
+		agentID, worktreeID, err := lockHolderFlags(cmd, db, repo)
+		if err != nil {
+			return err
+		}
+		locks, err := db.ListLocks(repo.ID)
+		if err != nil {
+			return err
+		}
+
+		target := strings.Trim(strings.TrimPrefix(args[1], "./"), "/")
+		var matched []*registry.Lock
+		for _, l := range locks {
+			if strings.HasPrefix(l.ID, args[1]) || l.Pattern == target {
+				if (agentID == nil && worktreeID == nil) || l.HeldBy(agentID, worktreeID) {
+					matched = append(matched, l)
+				}
+			}
+		}
+		switch len(matched) {
+		case 0:
+			return apperrors.NewUserErrorf("no lock matching %q in %s", args[1], repo.Name)
+		case 1:
+		default:
+			return apperrors.NewUserErrorf("%q matches %d locks; pass a lock ID, --agent or --worktree", args[1], len(matched))
+		}
+
+		lock := matched[0]
+		if err := db.ReleaseLock(lock.ID); err != nil {
+			return err
+		}
+
+		if ui.IsJSON() {
+			return ui.RenderJSON(map[string]interface{}{
+				"status":  "released",
+				"id":      lock.ID,
+				"pattern": lock.Pattern,
+			})
+		}
+		ui.Success("Released lock on %s held by %s", lock.Pattern, lock.Holder())
+		return nil
+	},
+}
+
+var locksCmd = &cobra.Command{
+	Use:               "locks [repo]",
+	Short:             "List unexpired locks",
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: completeRepoNames,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		db, err := openRegistry()
+		if err != nil {
+			return fmt.Errorf("could not open registry: %w", err)
+		}
+		defer db.Close()
+
+		repos, err := reposFromArgs(db, args)
+		if err != nil {
+			return err
+		}
+
+		items := make([]lockJSON, 0)
+		for _, repo := range repos {
+			locks, err := db.ListLocks(repo.ID)
+			if err != nil {
+				return err
+			}
+			for _, l := range locks {
+				items = append(items, toLockJSON(repo.Name, l))
+			}
+		}
+
+		if ui.IsJSON() {
+			return ui.RenderJSON(items)
+		}
+
+		if len(items) == 0 {
+			fmt.Println("No locks held.")
+			return nil
+		}
+
+		table := ui.NewTable("Lock", "Repo", "Path", "Agent", "Worktree", "Expires", "Reason")
+		for _, item := range items {
+			agent := item.Agent
+			if agent == "" {
+				agent = ui.T.Muted("-")
+			}
+			worktree := ui.T.Muted("-")
+			if item.Worktree != "" {
+				worktree = item.Worktree[:12]
+			}
+			expires, _ := time.Parse(time.RFC3339, item.ExpiresAt)
+			table.Append([]string{
+				item.ID[:8],
+				item.Repo,
+				item.Pattern,
+				agent,
+				worktree,
+				"in " + time.Until(expires).Round(time.Second).String(),
+				item.Reason,
+			})
+		}
+		table.Render()
+		return nil
+	},
+}
+
+func init() {
+	for _, c := range []*cobra.Command{lockCmd, unlockCmd} {
+		c.Flags().String("agent", "", "Agent name holding the lock")
+		c.Flags().String("worktree", "", "Worktree ID holding the lock (full or prefix)")
+		_ = c.RegisterFlagCompletionFunc("agent", completeAgentNames)
+	}
+	lockCmd.Flags().Duration("ttl", registry.DefaultLockTTL, "How long to hold the lock")
+	lockCmd.Flags().String("reason", "", "Why the path is locked, shown to other agents")
+	rootCmd.AddCommand(lockCmd)
+	rootCmd.AddCommand(unlockCmd)
+	rootCmd.AddCommand(locksCmd)
+}
@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	apperrors "github.com/fathindos/agit/internal/errors"
+)
+
+func TestLockUnlockAndList(t *testing.T) {
+	repoPath := setupTestGitRepo(t)
+	env := newTestEnv(t)
+	env.init()
+	if _, err := env.run("add", repoPath); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+	if _, err := env.run("spawn", "test-repo", "--task", "schema", "--agent", "alice"); err != nil {
+		t.Fatalf("spawn failed: %v", err)
+	}
+	if _, err := env.run("spawn", "test-repo", "--task", "docs", "--agent", "bob"); err != nil {
+		t.Fatalf("spawn failed: %v", err)
+	}
+
+	stdout, err := env.runJSON("lock", "test-repo", "internal/registry", "--agent", "alice", "--reason", "schema rewrite")
+	if err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	var lock lockJSON
+	if err := json.Unmarshal([]byte(stdout), &lock); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, stdout)
+	}
+	if lock.Agent != "alice" || lock.Pattern != "internal/registry" || lock.Reason != "schema rewrite" {
+		t.Errorf("unexpected lock: %+v", lock)
+	}
+
+	// bob can't lock a file under alice's directory
+	_, err = env.run("lock", "test-repo", "internal/registry/db.go", "--agent", "bob")
+	if err == nil || !strings.Contains(err.Error(), "locked by agent alice") {
+		t.Fatalf("expected clash with alice's lock, got %v", err)
+	}
+
+	if _, err := env.run("lock", "test-repo", "docs/**", "--agent", "bob"); err != nil {
+		t.Fatalf("lock disjoint path failed: %v", err)
+	}
+
+	stdout, err = env.runJSON("locks", "test-repo")
+	if err != nil {
+		t.Fatalf("locks failed: %v", err)
+	}
+	var locks []lockJSON
+	if err := json.Unmarshal([]byte(stdout), &locks); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, stdout)
+	}
+	if len(locks) != 2 {
+		t.Fatalf("expected 2 locks, got %d", len(locks))
+	}
+
+	text, err := env.run("locks")
+	if err != nil {
+		t.Fatalf("locks failed: %v", err)
+	}
+	if !strings.Contains(text, "internal/registry") || !strings.Contains(text, "docs/**") {
+		t.Errorf("expected both locks in table, got: %s", text)
+	}
+
+	if _, err := env.run("unlock", "test-repo", "internal/registry"); err != nil {
+		t.Fatalf("unlock by path failed: %v", err)
+	}
+	if _, err := env.run("unlock", "test-repo", locks[1].ID[:8]); err != nil {
+		t.Fatalf("unlock by ID prefix failed: %v", err)
+	}
+	text, _ = env.run("locks", "test-repo")
+	if !strings.Contains(text, "No locks held") {
+		t.Errorf("expected no locks after unlocking, got: %s", text)
+	}
+}
+
+func TestLockRequiresHolder(t *testing.T) {
+	repoPath := setupTestGitRepo(t)
+	env := newTestEnv(t)
+	env.init()
+	if _, err := env.run("add", repoPath); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+
+	if _, err := env.run("lock", "test-repo", "README.md"); err == nil {
+		t.Error("expected error without --agent or --worktree")
+	}
+	if _, err := env.run("lock", "test-repo", "README.md", "--agent", "ghost"); err == nil {
+		t.Error("expected error for unknown agent")
+	}
+	if _, err := env.run("agents", "token", "create", "alice"); err != nil {
+		t.Fatalf("token create failed: %v", err)
+	}
+	if _, err := env.run("lock", "test-repo", "../outside", "--agent", "alice"); err == nil || !apperrors.IsUserError(err) {
+		t.Errorf("expected a user error for a pattern outside the repo, got %v", err)
+	}
+}
+
+func TestMergeRefusesLockedChanges(t *testing.T) {
+	repoPath := setupTestGitRepo(t)
+	env := newTestEnv(t)
+	env.init()
+	if _, err := env.run("add", repoPath); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+
+	owner, _ := spawnWithCommit(t, env, "owner", "owner.txt")
+	wtID, _ := spawnWithCommit(t, env, "intruder", "locked.txt")
+
+	if _, err := env.run("lock", "test-repo", "locked.txt", "--worktree", owner); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+
+	stdout, err := env.runJSON("conflicts", "test-repo")
+	if err != nil {
+		t.Fatalf("conflicts failed: %v", err)
+	}
+	var out conflictsOutputJSON
+	if err := json.Unmarshal([]byte(stdout), &out); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, stdout)
+	}
+	if len(out.LockViolations) != 1 || out.LockViolations[0].File != "locked.txt" {
+		t.Fatalf("expected a locked.txt violation, got: %s", stdout)
+	}
+
+	_, err = env.run("merge", wtID)
+	if err == nil || !strings.Contains(err.Error(), "locked files") {
+		t.Fatalf("expected merge to be refused, got %v", err)
+	}
+
+	// The lock holder's own merge goes through
+	if _, err := env.run("merge", owner); err != nil {
+		t.Fatalf("merge of lock holder failed: %v", err)
+	}
+	if _, err := env.run("merge", wtID, "--skip-conflict-check"); err != nil {
+		t.Fatalf("merge with --skip-conflict-check failed: %v", err)
+	}
+}
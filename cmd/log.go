@@ -0,0 +1,230 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	apperrors "github.com/fathindos/agit/internal/errors"
+	"github.com/fathindos/agit/internal/registry"
+	"github.com/fathindos/agit/internal/ui"
+)
+
+// logPollInterval is how often agit log --follow checks for new events
+const logPollInterval = time.Second
+
+type eventJSON struct {
+	ID         int64          `json:"id"`
+	Type       string         `json:"type"`
+	Time       string         `json:"time"`
+	Actor      string         `json:"actor,omitempty"`
+	AgentID    string         `json:"agent_id,omitempty"`
+	Repo       string         `json:"repo,omitempty"`
+	EntityType string         `json:"entity_type"`
+	EntityID   string         `json:"entity_id"`
+	Payload    map[string]any `json:"payload,omitempty"`
+}
+
+func toEventJSON(e *registry.Event, repoNames map[string]string) eventJSON {
+	item := eventJSON{
+		ID:         e.ID,
+		Type:       e.Type,
+		Time:       e.CreatedAt.Format(time.RFC3339),
+		EntityType: e.EntityType,
+		EntityID:   e.EntityID,
+		Payload:    e.Payload,
+	}
+	if e.Actor != nil {
+		item.Actor = *e.Actor
+	}
+	if e.AgentID != nil {
+		item.AgentID = *e.AgentID
+	}
+	if e.RepoID != nil {
+		item.Repo = repoNames[*e.RepoID]
+	}
+	return item
+}
+
+// eventDetail renders an event payload as sorted key=value pairs
+func eventDetail(payload map[string]any) string {
+	keys := make([]string, 0, len(payload))
+	for k := range payload {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		v := payload[k]
+		if list, ok := v.([]any); ok {
+			strs := make([]string, 0, len(list))
+			for _, item := range list {
+				strs = append(strs, fmt.Sprint(item))
+			}
+			v = strings.Join(strs, ",")
+		}
+		parts = append(parts, fmt.Sprintf("%s=%v", k, v))
+	}
+	return strings.Join(parts, " ")
+}
+
+func printEvent(e *registry.Event, repoNames map[string]string) {
+	actor := "-"
+	if e.Actor != nil {
+		actor = *e.Actor
+	}
+	entity := e.EntityID
+	if e.EntityType == "worktree" || e.EntityType == "agent" {
+		if len(entity) > 12 {
+			entity = entity[:12]
+		}
+	}
+	repo := ""
+	if e.RepoID != nil {
+		repo = repoNames[*e.RepoID] + " "
+	}
+
+	typ := fmt.Sprintf("%-18s", e.Type)
+	switch {
+	case strings.HasSuffix(e.Type, ".failed") || e.Type == registry.EventConflictDetected:
+		typ = ui.T.Warning(typ)
+	case strings.HasSuffix(e.Type, ".completed") || e.Type == registry.EventWorktreeMerged:
+		typ = ui.T.Success(typ)
+	}
+
+	fmt.Printf("%s  %s %s%s %s  %s\n",
+		ui.T.Muted(e.CreatedAt.Format("2006-01-02 15:04:05")),
+		typ,
+		repo,
+		entity,
+		ui.T.Info(actor),
+		ui.T.Muted(eventDetail(e.Payload)),
+	)
+}
+
+var logCmd = &cobra.Command{
+	Use:   "log [repo]",
+	Short: "Show the event log",
+	Long: `Shows what happened across repos, worktrees, tasks and agents: every state
+transition is appended to a persistent event log in the registry.
+
+--since accepts a duration before now (30m, 2h, 7d) or a date/RFC 3339
+timestamp. --type matches an exact event type (task.claimed) or a whole
+category (task). --agent matches an agent name or ID. --follow keeps
+printing new events as they are recorded; with -o json, each event is
+written as one line of JSON.`,
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: completeRepoNames,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		sinceStr, _ := cmd.Flags().GetString("since")
+		agent, _ := cmd.Flags().GetString("agent")
+		eventType, _ := cmd.Flags().GetString("type")
+		follow, _ := cmd.Flags().GetBool("follow")
+		limit, _ := cmd.Flags().GetInt("limit")
+
+		db, err := openRegistry()
+		if err != nil {
+			return fmt.Errorf("could not open registry: %w", err)
+		}
+		defer db.Close()
+
+		filter := registry.EventFilter{Agent: agent, Type: eventType, Limit: limit}
+		if len(args) > 0 {
+			repo, err := db.GetRepo(args[0])
+			if err != nil {
+				return err
+			}
+			filter.RepoID = repo.ID
+		}
+		if sinceStr != "" {
+			filter.Since, err = registry.ParseSince(sinceStr, time.Now())
+			if err != nil {
+				return apperrors.NewUserError(err.Error())
+			}
+			if !cmd.Flags().Changed("limit") {
+				filter.Limit = 0
+			}
+		}
+
+		repoNames := make(map[string]string)
+		loadRepoNames := func() {
+			if repos, err := db.ListRepos(); err == nil {
+				for _, r := range repos {
+					repoNames[r.ID] = r.Name
+				}
+			}
+		}
+		loadRepoNames()
+
+		events, err := db.ListEvents(filter)
+		if err != nil {
+			return err
+		}
+
+		if !follow {
+			if ui.IsJSON() {
+				items := make([]eventJSON, 0, len(events))
+				for _, e := range events {
+					items = append(items, toEventJSON(e, repoNames))
+				}
+				return ui.RenderJSON(items)
+			}
+			if len(events) == 0 {
+				fmt.Println("No events found.")
+				return nil
+			}
+			for _, e := range events {
+				printEvent(e, repoNames)
+			}
+			return nil
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		// Follow: print the backlog, then poll for newer events
+		filter.Limit = 0
+		for {
+			for _, e := range events {
+				if e.RepoID != nil && repoNames[*e.RepoID] == "" {
+					loadRepoNames()
+				}
+				if ui.IsJSON() {
+					if err := ui.RenderJSONLine(toEventJSON(e, repoNames)); err != nil {
+						return err
+					}
+				} else {
+					printEvent(e, repoNames)
+				}
+				filter.AfterID = e.ID
+			}
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(logPollInterval):
+			}
+
+			if events, err = db.ListEvents(filter); err != nil {
+				return err
+			}
+		}
+	},
+}
+
+func init() {
+	logCmd.Flags().String("since", "", "Only show events after this time (e.g. 2h, 7d, 2006-01-02)")
+	logCmd.Flags().String("agent", "", "Only show events by this agent (name or ID)")
+	logCmd.Flags().String("type", "", "Only show events of this type or category (e.g. task.claimed, worktree)")
+	logCmd.Flags().BoolP("follow", "f", false, "Keep printing new events as they happen")
+	logCmd.Flags().Int("limit", 50, "Show at most this many recent events (0 for all; default all with --since)")
+	rootCmd.AddCommand(logCmd)
+}
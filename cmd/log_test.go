@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestLogRecordsCLITransitions(t *testing.T) {
+	repoPath := setupTestGitRepo(t)
+	env := newTestEnv(t)
+	env.init()
+	if _, err := env.run("add", repoPath); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+
+	if _, err := env.run("tasks", "test-repo", "--create", "log me"); err != nil {
+		t.Fatalf("task create failed: %v", err)
+	}
+	wtID, _ := spawnWithCommit(t, env, "logger", "log.txt")
+	if _, err := env.run("merge", wtID); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+
+	stdout, err := env.runJSON("log", "test-repo")
+	if err != nil {
+		t.Fatalf("log failed: %v", err)
+	}
+	var events []eventJSON
+	if err := json.Unmarshal([]byte(stdout), &events); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, stdout)
+	}
+
+	types := make([]string, 0, len(events))
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	for _, want := range []string{"task.created", "worktree.created", "worktree.merged"} {
+		found := false
+		for _, typ := range types {
+			found = found || typ == want
+		}
+		if !found {
+			t.Errorf("expected %s in log, got %v", want, types)
+		}
+	}
+
+	merged := events[len(events)-1]
+	if merged.Type != "worktree.merged" || merged.Actor != "logger-agent" || merged.Repo != "test-repo" {
+		t.Errorf("expected merge by logger-agent last, got %+v", merged)
+	}
+
+	// Filters
+	stdout, err = env.runJSON("log", "--type", "worktree", "--agent", "logger-agent")
+	if err != nil {
+		t.Fatalf("filtered log failed: %v", err)
+	}
+	events = nil
+	json.Unmarshal([]byte(stdout), &events)
+	for _, e := range events {
+		if !strings.HasPrefix(e.Type, "worktree.") || e.Actor != "logger-agent" {
+			t.Errorf("filter leaked event %+v", e)
+		}
+	}
+	if len(events) != 2 {
+		t.Errorf("expected created and merged worktree events, got %d", len(events))
+	}
+
+	if _, err := env.run("log", "--since", "soon"); err == nil {
+		t.Error("expected error for invalid --since")
+	}
+}
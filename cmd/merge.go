@@ -2,21 +2,59 @@ package cmd
 
 import (
 	"fmt"
+	"os"
+	"os/user"
+	"strings"
 
 	"github.com/spf13/cobra"
 
+	"github.com/fathindos/agit/internal/conflicts"
 	apperrors "github.com/fathindos/agit/internal/errors"
 	gitops "github.com/fathindos/agit/internal/git"
+	"github.com/fathindos/agit/internal/hooks"
+	"github.com/fathindos/agit/internal/mergequeue"
 	"github.com/fathindos/agit/internal/registry"
 	"github.com/fathindos/agit/internal/ui"
 	"github.com/fathindos/agit/internal/ui/interactive"
+	"github.com/fathindos/agit/internal/verify"
 )
 
 var mergeCmd = &cobra.Command{
 	Use:   "merge [worktree-id]",
 	Short: "Merge a worktree branch back into the base branch",
 	Long: `Merges the worktree's branch into the repository's default branch.
-Runs a conflict check first unless --skip-conflict-check is set.
+Runs a conflict check first unless --skip-conflict-check is set. The check
+simulates the merge with git merge-tree, refuses to land changes to files
+that another agent or worktree has locked (see agit lock), and warns about
+other active worktrees that will conflict once this branch lands.
+
+The merge itself never checks anything out in your repository: the result is
+committed with git plumbing and the default branch ref is advanced in place,
+so your current branch, index and uncommitted work are left alone. If the
+default branch is the one you have checked out, its files are fast-forwarded
+to match.
+
+If the repo has verify commands (see agit repos set <repo> verify), they run
+inside the worktree after the conflict check and the merge is refused unless
+every command succeeds. Since only commits are merged, a worktree with
+uncommitted changes to tracked files is refused instead of verified. Results
+are stored against the worktree and shown in agit status. --skip-verify bypasses the gate; the skip is recorded along with
+who requested it.
+
+A pre.merge hook (see agit config) runs before anything else and can refuse
+the merge by exiting non-zero; its stderr is reported as the error. With
+--cleanup, a pre.worktree.remove hook can likewise keep the worktree after
+the merge.
+
+--strategy selects how the branch lands: merge (a --no-ff merge commit),
+squash (one commit whose message is built from the worktree's task), rebase
+(rebase onto the default branch, then fast-forward) or ff-only. Without the
+flag, the repo's merge_strategy setting is used (see agit repos set), falling
+back to merge.
+
+The merge holds the repository's merge slot, like an entry of the merge
+queue (see agit queue), so it never interleaves with a queued merge or
+another agit merge; it is refused while one of those is in progress.
 
 With -i (interactive), presents a selector if no worktree ID is specified.`,
 	Args:              cobra.MaximumNArgs(1),
@@ -25,8 +63,10 @@ With -i (interactive), presents a selector if no worktree ID is specified.`,
 		isInteractive, _ := cmd.Flags().GetBool("interactive")
 		skipCheck, _ := cmd.Flags().GetBool("skip-conflict-check")
 		cleanup, _ := cmd.Flags().GetBool("cleanup")
+		strategyName, _ := cmd.Flags().GetString("strategy")
+		skipVerify, _ := cmd.Flags().GetBool("skip-verify")
 
-		db, err := registry.Open()
+		db, err := openRegistry()
 		if err != nil {
 			return fmt.Errorf("could not open registry: %w", err)
 		}
@@ -35,22 +75,9 @@ With -i (interactive), presents a selector if no worktree ID is specified.`,
 		var wt *registry.Worktree
 
 		if len(args) > 0 {
-			worktreeID := args[0]
-			wt, err = db.GetWorktree(worktreeID)
+			wt, err = resolveWorktreeArg(db, args[0])
 			if err != nil {
-				repos, repoErr := db.ListRepos()
-				if repoErr != nil {
-					return err
-				}
-				for _, r := range repos {
-					if found, findErr := db.FindWorktreeByPrefix(r.ID, worktreeID); findErr == nil {
-						wt = found
-						break
-					}
-				}
-				if wt == nil {
-					return err
-				}
+				return err
 			}
 		} else if isInteractive {
 			worktrees, err := db.ListAllActiveWorktrees()
@@ -90,49 +117,140 @@ With -i (interactive), presents a selector if no worktree ID is specified.`,
 			return err
 		}
 
-		// Pre-merge conflict check
+		opts, err := mergequeue.MergeOptions(db, repo, wt, strategyName)
+		if err != nil {
+			return err
+		}
+
+		// A pre.merge hook can refuse the merge before any checks run
+		cfg, err := loadRepoConfig(repo)
+		if err != nil {
+			return fmt.Errorf("could not load config: %w", err)
+		}
+
+		// Hold the repo's merge slot, so queued merges can't land between
+		// the checks below and this merge
+		slot, err := mergequeue.ClaimSlot(db, repo, wt, strategyName, nil)
+		if err != nil {
+			return err
+		}
+		status, outcome := "failed", "merge did not complete"
+		defer func() { slot.Release(status, outcome) }()
+
+		// From here on, a merge that is refused or fails is logged as merge.failed
+		failed := func(err error) error {
+			outcome = err.Error()
+			mergequeue.RecordMergeFailed(db, repo, wt, opts.Strategy, err.Error(), nil)
+			return err
+		}
+		hookRunner := hooks.NewRunner(cfg, db)
+		if err := mergequeue.CheckMerge(hookRunner, repo, wt, opts.Strategy); err != nil {
+			return failed(err)
+		}
+
+		// Pre-merge conflict check, simulated without touching the checkout
+		base := opts.BaseBranch
+		var peerConflicts []conflicts.PairSimulation
 		if !skipCheck {
-			canMerge, err := gitops.CanMergeCleanly(repo.Path, wt.Branch)
+			sim, err := gitops.SimulateMerge(repo.Path, base, wt.Branch)
 			if err != nil {
 				ui.Warning("Could not check merge compatibility: %v", err)
-			} else if !canMerge {
-				return apperrors.NewUserError("merge would produce conflicts. Use --skip-conflict-check to force, or resolve manually")
+			} else if !sim.Clean {
+				return failed(apperrors.NewUserErrorf("merge would produce conflicts in %s. Use --skip-conflict-check to force, or resolve manually",
+					strings.Join(sim.ConflictedPaths, ", ")))
+			}
+
+			if violations, err := conflicts.MergeLockViolations(db, repo, wt); err != nil {
+				ui.Warning("Could not check locks: %v", err)
+			} else if len(violations) > 0 {
+				return failed(apperrors.NewUserErrorf("merge would change locked files: %s. Use --skip-conflict-check to force, or wait for the locks to be released",
+					conflicts.FormatLockViolations(violations)))
+			}
+
+			activeStatus := "active"
+			if others, err := db.ListWorktrees(repo.ID, &activeStatus); err == nil {
+				peerConflicts = conflicts.ConflictingPeers(repo, wt, others)
 			}
 		}
 
-		// Checkout default branch and merge
-		if err := gitops.CheckoutBranch(repo.Path, repo.DefaultBranch); err != nil {
-			return fmt.Errorf("could not checkout %s: %w", repo.DefaultBranch, err)
+		// Verify gate: build/test commands configured on the repo
+		var run *registry.VerifyRun
+		if skipVerify {
+			if run, err = verify.Skip(db, repo, wt, currentUser()); err != nil {
+				return err
+			}
+		} else {
+			if len(verify.Commands(repo)) > 0 && !ui.IsJSON() {
+				ui.Info("Verifying %s...", wt.Branch)
+			}
+			if run, err = verify.Worktree(db, repo, wt); err != nil {
+				return failed(err)
+			}
+			if step := verify.FailedStep(run); step != nil {
+				if !ui.IsJSON() && step.Output != "" {
+					fmt.Print(step.Output)
+				}
+				return failed(apperrors.NewUserErrorf("verification failed: %s. Fix the worktree, or use --skip-verify to override", verify.Describe(step)))
+			}
 		}
 
-		if err := gitops.MergeBranch(repo.Path, wt.Branch); err != nil {
-			return err
+		// Merge through plumbing; the user's checkout is left alone
+		tip, err := gitops.Merge(repo.Path, wt.Branch, opts)
+		if err != nil {
+			return failed(err)
 		}
 
 		db.UpdateWorktreeStatus(wt.ID, "completed")
+		mergequeue.RecordMerged(db, repo, wt, opts.Strategy, tip, nil)
+		status, outcome = "merged", fmt.Sprintf("merged into %s at %s (%s)", base, gitops.ShortSHA(tip), opts.Strategy)
 
-		if ui.IsJSON() {
-			result := map[string]string{
-				"status":  "ok",
-				"message": "merged",
-				"branch":  wt.Branch,
-				"into":    repo.DefaultBranch,
-			}
-			if cleanup {
+		var cleanupErr error
+		if cleanup {
+			cleanupErr = hookRunner.Check(hooks.PreWorktreeRemove, hooks.WorktreeEnv(repo, wt))
+			if cleanupErr == nil {
 				gitops.RemoveWorktree(repo.Path, wt.Path)
-				gitops.DeleteBranch(repo.Path, wt.Branch)
+				if wt.OwnsBranch() {
+					gitops.DeleteBranch(repo.Path, wt.Branch)
+				}
 				db.DeleteWorktree(wt.ID)
+			}
+		}
+
+		if ui.IsJSON() {
+			result := map[string]interface{}{
+				"status":   "ok",
+				"message":  "merged",
+				"branch":   wt.Branch,
+				"into":     base,
+				"strategy": opts.Strategy,
+			}
+			if len(peerConflicts) > 0 {
+				result["peer_conflicts"] = peerConflicts
+			}
+			if run != nil {
+				result["verify"] = run.Status
+			}
+			if cleanup && cleanupErr != nil {
+				result["cleanup"] = "refused"
+				result["cleanup_error"] = cleanupErr.Error()
+			} else if cleanup {
 				result["cleanup"] = "done"
 			}
 			return ui.RenderJSON(result)
 		}
 
-		ui.Success("Merged %s into %s", wt.Branch, repo.DefaultBranch)
+		if run != nil && run.Status == "skipped" {
+			ui.Warning("Verification skipped by %s", *run.SkippedBy)
+		}
+		ui.Success("Merged %s into %s (%s)", wt.Branch, base, opts.Strategy)
+		for _, p := range peerConflicts {
+			ui.Warning("Worktree %s will conflict with this merge in %s",
+				p.WorktreeB[:12], strings.Join(p.ConflictedPaths, ", "))
+		}
 
-		if cleanup {
-			gitops.RemoveWorktree(repo.Path, wt.Path)
-			gitops.DeleteBranch(repo.Path, wt.Branch)
-			db.DeleteWorktree(wt.ID)
+		if cleanup && cleanupErr != nil {
+			ui.Warning("Kept the worktree: %v", cleanupErr)
+		} else if cleanup {
 			ui.Success("Cleaned up worktree and branch")
 		}
 
@@ -143,5 +261,37 @@ With -i (interactive), presents a selector if no worktree ID is specified.`,
 func init() {
 	mergeCmd.Flags().Bool("skip-conflict-check", false, "Skip pre-merge conflict check")
 	mergeCmd.Flags().Bool("cleanup", false, "Remove worktree and branch after merge")
+	mergeCmd.Flags().Bool("skip-verify", false, "Merge without running the repo's verify commands (the skip is recorded)")
+	mergeCmd.Flags().String("strategy", "", "Merge strategy: merge, squash, rebase, or ff-only (default: repo setting, then merge)")
 	rootCmd.AddCommand(mergeCmd)
 }
+
+// resolveWorktreeArg looks up a worktree by full ID, falling back to a short
+// ID prefix in any registered repo
+func resolveWorktreeArg(db *registry.DB, worktreeID string) (*registry.Worktree, error) {
+	wt, err := db.GetWorktree(worktreeID)
+	if err == nil {
+		return wt, nil
+	}
+	repos, repoErr := db.ListRepos()
+	if repoErr != nil {
+		return nil, err
+	}
+	for _, r := range repos {
+		if found, findErr := db.FindWorktreeByPrefix(r.ID, worktreeID); findErr == nil {
+			return found, nil
+		}
+	}
+	return nil, err
+}
+
+// currentUser names the person running agit, for audit records
+func currentUser() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return "unknown"
+}
@@ -3,9 +3,13 @@ package cmd
 import (
 	"encoding/json"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 	"testing"
+
+	apperrors "github.com/fathindos/agit/internal/errors"
+	"github.com/fathindos/agit/internal/registry"
 )
 
 func TestMergeMissingWorktreeID(t *testing.T) {
@@ -138,3 +142,351 @@ func extractSpawnJSON(t *testing.T, output string) (id, path string) {
 	}
 	return id, path
 }
+
+func TestMergePreCheckReportsConflictedPaths(t *testing.T) {
+	repoPath := setupTestGitRepo(t)
+	env := newTestEnv(t)
+	env.init()
+
+	if _, err := env.run("add", repoPath); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+
+	stdout, err := env.runJSON("spawn", "test-repo", "--task", "diverge", "--agent", "d-agent")
+	if err != nil {
+		t.Fatalf("spawn failed: %v", err)
+	}
+	wtID, wtPath := extractSpawnJSON(t, stdout)
+
+	if err := os.WriteFile(filepath.Join(wtPath, "README.md"), []byte("# Branch\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, wtPath, "commit", "-am", "branch edit")
+	if err := os.WriteFile(filepath.Join(repoPath, "README.md"), []byte("# Main\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, repoPath, "commit", "-am", "main edit")
+
+	_, err = env.run("merge", wtID)
+	if err == nil {
+		t.Fatal("expected merge pre-check to fail")
+	}
+	if !strings.Contains(err.Error(), "README.md") {
+		t.Errorf("expected conflicted path in error, got: %v", err)
+	}
+
+	// The simulated check must not leave a merge in progress
+	if _, statErr := os.Stat(filepath.Join(repoPath, ".git", "MERGE_HEAD")); !os.IsNotExist(statErr) {
+		t.Error("expected no MERGE_HEAD after pre-check")
+	}
+}
+
+// gitOutput runs a git command and returns its trimmed stdout.
+func gitOutput(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).Output()
+	if err != nil {
+		t.Fatalf("git %v failed: %v", args, err)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// spawnWithCommit spawns a worktree and commits a new file in it.
+func spawnWithCommit(t *testing.T, env *testEnv, task, file string) (string, string) {
+	t.Helper()
+	stdout, err := env.runJSON("spawn", "test-repo", "--task", task, "--agent", task+"-agent")
+	if err != nil {
+		t.Fatalf("spawn failed: %v", err)
+	}
+	wtID, wtPath := extractSpawnJSON(t, stdout)
+	writeFileInWorktree(t, wtPath, file, task+"\n")
+	runGit(t, wtPath, "add", file)
+	runGit(t, wtPath, "commit", "-m", "add "+file)
+	return wtID, wtPath
+}
+
+func TestMergeStrategySquash(t *testing.T) {
+	repoPath := setupTestGitRepo(t)
+	env := newTestEnv(t)
+	env.init()
+	if _, err := env.run("add", repoPath); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+
+	wtID, wtPath := spawnWithCommit(t, env, "squash me", "a.txt")
+	writeFileInWorktree(t, wtPath, "b.txt", "second\n")
+	runGit(t, wtPath, "add", "b.txt")
+	runGit(t, wtPath, "commit", "-m", "add b.txt")
+
+	if _, err := env.run("merge", wtID, "--strategy", "squash"); err != nil {
+		t.Fatalf("squash merge failed: %v", err)
+	}
+
+	if parents := strings.Fields(gitOutput(t, repoPath, "log", "-1", "--format=%P")); len(parents) != 1 {
+		t.Errorf("expected a single-parent squash commit, got parents %v", parents)
+	}
+	msg := gitOutput(t, repoPath, "log", "-1", "--format=%B")
+	if !strings.HasPrefix(msg, "squash me") {
+		t.Errorf("expected task description as subject, got: %q", msg)
+	}
+	if got := gitOutput(t, repoPath, "rev-list", "--count", "HEAD"); got != "2" {
+		t.Errorf("expected 2 commits on main after squash, got %s", got)
+	}
+}
+
+func TestMergeStrategyRebaseKeepsHistoryLinear(t *testing.T) {
+	repoPath := setupTestGitRepo(t)
+	env := newTestEnv(t)
+	env.init()
+	if _, err := env.run("add", repoPath); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+
+	wtID, _ := spawnWithCommit(t, env, "rebase me", "r.txt")
+
+	// Move main forward so a fast-forward alone is impossible
+	writeFileInWorktree(t, repoPath, "main.txt", "main\n")
+	runGit(t, repoPath, "add", "main.txt")
+	runGit(t, repoPath, "commit", "-m", "main moves on")
+
+	if _, err := env.run("merge", wtID, "--strategy", "ff-only"); err == nil {
+		t.Fatal("expected ff-only to fail on diverged branches")
+	}
+
+	if _, err := env.run("merge", wtID, "--strategy", "rebase"); err != nil {
+		t.Fatalf("rebase merge failed: %v", err)
+	}
+	if merges := gitOutput(t, repoPath, "rev-list", "--merges", "--count", "HEAD"); merges != "0" {
+		t.Errorf("expected linear history, found %s merge commit(s)", merges)
+	}
+	if _, err := os.Stat(filepath.Join(repoPath, "r.txt")); err != nil {
+		t.Errorf("expected r.txt on main after rebase merge: %v", err)
+	}
+}
+
+func TestMergeStrategyRepoDefault(t *testing.T) {
+	repoPath := setupTestGitRepo(t)
+	env := newTestEnv(t)
+	env.init()
+	if _, err := env.run("add", repoPath); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+
+	if _, err := env.run("repos", "set", "test-repo", "merge_strategy", "bogus"); err == nil {
+		t.Fatal("expected invalid strategy to be rejected")
+	}
+	if _, err := env.run("repos", "set", "test-repo", "merge_strategy", "ff-only"); err != nil {
+		t.Fatalf("repos set failed: %v", err)
+	}
+
+	wtID, _ := spawnWithCommit(t, env, "fast forward", "ff.txt")
+	stdout, err := env.runJSON("merge", wtID)
+	if err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	var result map[string]interface{}
+	if err := json.Unmarshal([]byte(stdout), &result); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, stdout)
+	}
+	if result["strategy"] != "ff-only" {
+		t.Errorf("expected repo default ff-only, got %v", result["strategy"])
+	}
+	if merges := gitOutput(t, repoPath, "rev-list", "--merges", "--count", "HEAD"); merges != "0" {
+		t.Errorf("expected no merge commits, got %s", merges)
+	}
+}
+
+func TestMergeInvalidStrategy(t *testing.T) {
+	repoPath := setupTestGitRepo(t)
+	env := newTestEnv(t)
+	env.init()
+	if _, err := env.run("add", repoPath); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+
+	wtID, _ := spawnWithCommit(t, env, "bad strategy", "x.txt")
+	if _, err := env.run("merge", wtID, "--strategy", "octopus"); err == nil {
+		t.Error("expected error for unknown strategy")
+	}
+}
+
+func TestMergeLeavesUserCheckoutAlone(t *testing.T) {
+	repoPath := setupTestGitRepo(t)
+	env := newTestEnv(t)
+	env.init()
+	if _, err := env.run("add", repoPath); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+
+	wtID, _ := spawnWithCommit(t, env, "background merge", "feature.txt")
+
+	// The user is on another branch with uncommitted work
+	runGit(t, repoPath, "checkout", "-b", "my-work")
+	writeFileInWorktree(t, repoPath, "README.md", "# Work in progress\n")
+	mainBefore := gitOutput(t, repoPath, "rev-parse", "main")
+
+	if _, err := env.run("merge", wtID); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+
+	if head := gitOutput(t, repoPath, "rev-parse", "--abbrev-ref", "HEAD"); head != "my-work" {
+		t.Errorf("expected HEAD to stay on my-work, got %s", head)
+	}
+	data, err := os.ReadFile(filepath.Join(repoPath, "README.md"))
+	if err != nil || string(data) != "# Work in progress\n" {
+		t.Errorf("expected uncommitted changes to survive, got %q (%v)", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(repoPath, "feature.txt")); !os.IsNotExist(err) {
+		t.Error("expected feature.txt not to appear in the user's checkout")
+	}
+
+	mainAfter := gitOutput(t, repoPath, "rev-parse", "main")
+	if mainAfter == mainBefore {
+		t.Fatal("expected main to advance")
+	}
+	if files := gitOutput(t, repoPath, "ls-tree", "--name-only", "main"); !strings.Contains(files, "feature.txt") {
+		t.Errorf("expected feature.txt on main, got %s", files)
+	}
+}
+
+func TestMergeUpdatesCheckedOutDefaultBranch(t *testing.T) {
+	repoPath := setupTestGitRepo(t)
+	env := newTestEnv(t)
+	env.init()
+	if _, err := env.run("add", repoPath); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+
+	wtID, _ := spawnWithCommit(t, env, "sync checkout", "synced.txt")
+
+	// main stays checked out with an unrelated local edit
+	writeFileInWorktree(t, repoPath, "README.md", "# Local edit\n")
+
+	if _, err := env.run("merge", wtID); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(repoPath, "synced.txt")); err != nil {
+		t.Errorf("expected checkout of main to be fast-forwarded: %v", err)
+	}
+	status := gitOutput(t, repoPath, "status", "--porcelain", "--untracked-files=no")
+	if status != "M README.md" {
+		t.Errorf("expected only the local README edit to remain, got %q", status)
+	}
+}
+
+func TestMergeVerifyBlocksFailingWorktree(t *testing.T) {
+	repoPath := setupTestGitRepo(t)
+	env := newTestEnv(t)
+	env.init()
+	if _, err := env.run("add", repoPath); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+	if _, err := env.run("repos", "set", "test-repo", "verify", "test -f ok.txt", "echo verified"); err != nil {
+		t.Fatalf("repos set verify failed: %v", err)
+	}
+
+	wtID, wtPath := spawnWithCommit(t, env, "verify", "a.txt")
+	mainBefore := gitOutput(t, repoPath, "rev-parse", "main")
+
+	_, err := env.run("merge", wtID)
+	if err == nil || !strings.Contains(err.Error(), "test -f ok.txt") {
+		t.Fatalf("expected verification failure naming the command, got: %v", err)
+	}
+	if got := gitOutput(t, repoPath, "rev-parse", "main"); got != mainBefore {
+		t.Error("main moved despite failed verification")
+	}
+
+	stdout, err := env.runJSON("status", "test-repo")
+	if err != nil {
+		t.Fatalf("status failed: %v", err)
+	}
+	var status statusJSON
+	if err := json.Unmarshal([]byte(stdout), &status); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, stdout)
+	}
+	v := status.Repos[0].Worktrees[0].Verify
+	if v == nil || v.Status != "failed" || v.Failed != "test -f ok.txt" {
+		t.Errorf("expected failed verify in status, got %+v", v)
+	}
+
+	// A fix that is only staged would pass in the working tree but not be
+	// merged
+	writeFileInWorktree(t, wtPath, "ok.txt", "ok\n")
+	runGit(t, wtPath, "add", "ok.txt")
+	if _, err := env.run("merge", wtID); err == nil || !strings.Contains(err.Error(), "uncommitted changes to ok.txt") {
+		t.Fatalf("expected the staged fix to be refused, got: %v", err)
+	}
+
+	// Committing the fix lets the gate pass
+	runGit(t, wtPath, "commit", "-m", "add ok.txt")
+	stdout, err = env.runJSON("merge", wtID)
+	if err != nil {
+		t.Fatalf("merge failed after fix: %v\n%s", err, stdout)
+	}
+	if !strings.Contains(stdout, `"verify": "passed"`) {
+		t.Errorf("expected passed verify in merge result, got: %s", stdout)
+	}
+}
+
+func TestMergeSkipVerifyIsRecorded(t *testing.T) {
+	repoPath := setupTestGitRepo(t)
+	env := newTestEnv(t)
+	env.init()
+	if _, err := env.run("add", repoPath); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+	if _, err := env.run("repos", "set", "test-repo", "verify", "false"); err != nil {
+		t.Fatalf("repos set verify failed: %v", err)
+	}
+
+	wtID, _ := spawnWithCommit(t, env, "skip", "s.txt")
+	stdout, err := env.run("merge", wtID, "--skip-verify")
+	if err != nil {
+		t.Fatalf("merge --skip-verify failed: %v", err)
+	}
+	if !strings.Contains(stdout, "Verification skipped by") {
+		t.Errorf("expected skip warning, got: %s", stdout)
+	}
+}
+
+func TestMergeHoldsQueueSlot(t *testing.T) {
+	repoPath := setupTestGitRepo(t)
+	env := newTestEnv(t)
+	env.init()
+	if _, err := env.run("add", repoPath); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+
+	busy, _ := spawnWithCommit(t, env, "busy", "busy.txt")
+	wtID, _ := spawnWithCommit(t, env, "direct", "direct.txt")
+
+	db, err := registry.Open()
+	if err != nil {
+		t.Fatalf("registry.Open: %v", err)
+	}
+	defer db.Close()
+	repo, _ := db.GetRepo("test-repo")
+
+	// As if the queue worker were merging another worktree
+	held, err := db.ClaimMergeSlot(repo.ID, busy, "", nil)
+	if err != nil {
+		t.Fatalf("ClaimMergeSlot: %v", err)
+	}
+	if _, err := env.run("merge", wtID); err == nil || !strings.Contains(err.Error(), "in progress") || !apperrors.IsUserError(err) {
+		t.Fatalf("expected the merge to be refused while the slot is held, got %v", err)
+	}
+
+	result := "merged"
+	db.FinishMerge(held.ID, "merged", &result)
+	if _, err := env.run("merge", wtID); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	entries, _ := db.ListMergeQueue(repo.ID, true)
+	if len(entries) != 2 || entries[1].WorktreeID != wtID || entries[1].Status != "merged" {
+		t.Errorf("expected the direct merge recorded as a merged queue entry, got %+v", entries)
+	}
+	if next, _ := db.ClaimMergeSlot(repo.ID, busy, "", nil); next == nil {
+		t.Error("expected the slot to be free after the merge")
+	}
+}
@@ -0,0 +1,307 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	apperrors "github.com/fathindos/agit/internal/errors"
+	"github.com/fathindos/agit/internal/mergequeue"
+	"github.com/fathindos/agit/internal/registry"
+	"github.com/fathindos/agit/internal/ui"
+)
+
+type queueEntryJSON struct {
+	ID         string `json:"id"`
+	Repo       string `json:"repo"`
+	Worktree   string `json:"worktree"`
+	Branch     string `json:"branch,omitempty"`
+	Strategy   string `json:"strategy,omitempty"`
+	Status     string `json:"status"`
+	Result     string `json:"result,omitempty"`
+	EnqueuedAt string `json:"enqueued_at"`
+}
+
+func toQueueEntryJSON(db *registry.DB, repo *registry.Repo, e *registry.MergeQueueEntry) queueEntryJSON {
+	item := queueEntryJSON{
+		ID:         e.ID,
+		Repo:       repo.Name,
+		Worktree:   e.WorktreeID,
+		Strategy:   e.Strategy,
+		Status:     e.Status,
+		EnqueuedAt: e.EnqueuedAt.Format("2006-01-02 15:04:05"),
+	}
+	if wt, err := db.GetWorktree(e.WorktreeID); err == nil {
+		item.Branch = wt.Branch
+	}
+	if e.Result != nil {
+		item.Result = *e.Result
+	}
+	return item
+}
+
+// reposFromArgs returns the named repo, or every registered repo
+func reposFromArgs(db *registry.DB, args []string) ([]*registry.Repo, error) {
+	if len(args) > 0 {
+		repo, err := db.GetRepo(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return []*registry.Repo{repo}, nil
+	}
+	return db.ListRepos()
+}
+
+// reportProcessed prints the outcome of drained queue entries
+func reportProcessed(db *registry.DB, repo *registry.Repo, processed []*registry.MergeQueueEntry) {
+	for _, e := range processed {
+		result := ""
+		if e.Result != nil {
+			result = *e.Result
+		}
+		switch e.Status {
+		case "merged":
+			ui.Success("%s: %s %s", repo.Name, e.WorktreeID[:12], result)
+		case "conflict":
+			ui.Warning("%s: %s bounced back: %s", repo.Name, e.WorktreeID[:12], result)
+		default:
+			ui.Warning("%s: %s %s: %s", repo.Name, e.WorktreeID[:12], e.Status, result)
+		}
+	}
+}
+
+var queueCmd = &cobra.Command{
+	Use:   "queue [repo]",
+	Short: "Show and process the merge queue",
+	Long: `Worktrees are merged through a persistent, per-repo queue so that merges
+from many agents land one at a time. Each entry is re-checked against the
+default branch tip left by the previous merge; entries that no longer merge
+cleanly are bounced back and their worktree is marked conflict.
+
+Without a subcommand, lists queued and in-progress entries. Use --all to
+include finished entries.`,
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: completeRepoNames,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		all, _ := cmd.Flags().GetBool("all")
+
+		db, err := openRegistry()
+		if err != nil {
+			return fmt.Errorf("could not open registry: %w", err)
+		}
+		defer db.Close()
+
+		repos, err := reposFromArgs(db, args)
+		if err != nil {
+			return err
+		}
+
+		items := make([]queueEntryJSON, 0)
+		for _, repo := range repos {
+			entries, err := db.ListMergeQueue(repo.ID, all)
+			if err != nil {
+				return err
+			}
+			for _, e := range entries {
+				items = append(items, toQueueEntryJSON(db, repo, e))
+			}
+		}
+
+		if ui.IsJSON() {
+			return ui.RenderJSON(items)
+		}
+
+		if len(items) == 0 {
+			fmt.Println("Merge queue is empty.")
+			return nil
+		}
+
+		table := ui.NewTable("#", "Entry", "Repo", "Worktree", "Branch", "Strategy", "Status", "Result")
+		for i, item := range items {
+			strategy := item.Strategy
+			if strategy == "" {
+				strategy = ui.T.Muted("default")
+			}
+			table.Append([]string{
+				fmt.Sprintf("%d", i+1),
+				item.ID[:8],
+				item.Repo,
+				item.Worktree[:12],
+				item.Branch,
+				strategy,
+				ui.StatusColor(item.Status),
+				item.Result,
+			})
+		}
+		table.Render()
+		return nil
+	},
+}
+
+var queueAddCmd = &cobra.Command{
+	Use:   "add <worktree-id>",
+	Short: "Enqueue a worktree for merging",
+	Long: `Adds a worktree to its repo's merge queue, then processes the queue unless
+--no-run is set. A worktree previously bounced with a conflict can be
+re-enqueued once it has been fixed.`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeWorktreeIDs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		strategy, _ := cmd.Flags().GetString("strategy")
+		cleanup, _ := cmd.Flags().GetBool("cleanup")
+		noRun, _ := cmd.Flags().GetBool("no-run")
+
+		db, err := openRegistry()
+		if err != nil {
+			return fmt.Errorf("could not open registry: %w", err)
+		}
+		defer db.Close()
+
+		wt, err := resolveWorktreeArg(db, args[0])
+		if err != nil {
+			return err
+		}
+		repo, err := db.GetRepoByID(wt.RepoID)
+		if err != nil {
+			return err
+		}
+
+		entry, err := mergequeue.Enqueue(db, repo, wt, strategy, cleanup, wt.AgentID)
+		if err != nil {
+			return err
+		}
+
+		var processed []*registry.MergeQueueEntry
+		if !noRun {
+			processed, err = mergequeue.Drain(db, repo)
+			if err != nil {
+				return err
+			}
+		}
+		entry, err = db.GetMergeQueueEntry(entry.ID)
+		if err != nil {
+			return err
+		}
+
+		if ui.IsJSON() {
+			out := map[string]interface{}{"entry": toQueueEntryJSON(db, repo, entry)}
+			if len(processed) > 0 {
+				var items []queueEntryJSON
+				for _, e := range processed {
+					items = append(items, toQueueEntryJSON(db, repo, e))
+				}
+				out["processed"] = items
+			}
+			return ui.RenderJSON(out)
+		}
+
+		ui.Success("Enqueued %s (entry %s)", wt.Branch, entry.ID[:8])
+		reportProcessed(db, repo, processed)
+		if entry.Status == "queued" {
+			ui.Info("Waiting behind another merge; run agit queue run %s to process", repo.Name)
+		}
+		return nil
+	},
+}
+
+var queueRunCmd = &cobra.Command{
+	Use:               "run [repo]",
+	Short:             "Process queued merges in order",
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: completeRepoNames,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		db, err := openRegistry()
+		if err != nil {
+			return fmt.Errorf("could not open registry: %w", err)
+		}
+		defer db.Close()
+
+		repos, err := reposFromArgs(db, args)
+		if err != nil {
+			return err
+		}
+
+		items := make([]queueEntryJSON, 0)
+		total := 0
+		for _, repo := range repos {
+			processed, err := mergequeue.Drain(db, repo)
+			if err != nil {
+				return err
+			}
+			total += len(processed)
+			if ui.IsJSON() {
+				for _, e := range processed {
+					items = append(items, toQueueEntryJSON(db, repo, e))
+				}
+				continue
+			}
+			reportProcessed(db, repo, processed)
+		}
+
+		if ui.IsJSON() {
+			return ui.RenderJSON(map[string]interface{}{"status": "ok", "processed": items})
+		}
+		if total == 0 {
+			fmt.Println("Nothing to merge.")
+		}
+		return nil
+	},
+}
+
+var queueCancelCmd = &cobra.Command{
+	Use:   "cancel <entry-id>",
+	Short: "Remove a queued entry from the merge queue",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		db, err := openRegistry()
+		if err != nil {
+			return fmt.Errorf("could not open registry: %w", err)
+		}
+		defer db.Close()
+
+		repos, err := db.ListRepos()
+		if err != nil {
+			return err
+		}
+		var match *registry.MergeQueueEntry
+		for _, repo := range repos {
+			entries, err := db.ListMergeQueue(repo.ID, false)
+			if err != nil {
+				return err
+			}
+			for _, e := range entries {
+				if strings.HasPrefix(e.ID, args[0]) {
+					if match != nil {
+						return apperrors.NewUserErrorf("entry ID %q is ambiguous", args[0])
+					}
+					match = e
+				}
+			}
+		}
+		if match == nil {
+			return apperrors.NewUserErrorf("no queued entry matches %q", args[0])
+		}
+
+		if err := db.CancelMerge(match.ID); err != nil {
+			return apperrors.NewUserError(err.Error())
+		}
+
+		if ui.IsJSON() {
+			return ui.RenderJSON(map[string]string{"status": "ok", "message": "cancelled", "id": match.ID})
+		}
+		ui.Success("Cancelled queue entry %s", match.ID[:8])
+		return nil
+	},
+}
+
+func init() {
+	queueCmd.Flags().Bool("all", false, "Include merged, bounced and cancelled entries")
+	queueAddCmd.Flags().String("strategy", "", "Merge strategy: merge, squash, rebase, or ff-only (default: repo setting, then merge)")
+	queueAddCmd.Flags().Bool("cleanup", false, "Remove worktree and branch after merge")
+	queueAddCmd.Flags().Bool("no-run", false, "Only enqueue; do not process the queue")
+	queueCmd.AddCommand(queueAddCmd)
+	queueCmd.AddCommand(queueRunCmd)
+	queueCmd.AddCommand(queueCancelCmd)
+	rootCmd.AddCommand(queueCmd)
+}
@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+type queueAddResult struct {
+	Entry     queueEntryJSON   `json:"entry"`
+	Processed []queueEntryJSON `json:"processed"`
+}
+
+func queueAdd(t *testing.T, env *testEnv, args ...string) queueAddResult {
+	t.Helper()
+	stdout, err := env.runJSON(append([]string{"queue", "add"}, args...)...)
+	if err != nil {
+		t.Fatalf("queue add failed: %v\nOutput: %s", err, stdout)
+	}
+	var res queueAddResult
+	if err := json.Unmarshal([]byte(stdout), &res); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, stdout)
+	}
+	return res
+}
+
+func TestQueueMergesInOrderAndBouncesConflicts(t *testing.T) {
+	repoPath := setupTestGitRepo(t)
+	env := newTestEnv(t)
+	env.init()
+	if _, err := env.run("add", repoPath); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+
+	// Both worktrees rewrite README.md; each merges cleanly on its own
+	firstID, firstPath := spawnWithCommit(t, env, "first", "first.txt")
+	writeFileInWorktree(t, firstPath, "README.md", "# First\n")
+	runGit(t, firstPath, "commit", "-am", "first readme")
+	secondID, secondPath := spawnWithCommit(t, env, "second", "second.txt")
+	writeFileInWorktree(t, secondPath, "README.md", "# Second\n")
+	runGit(t, secondPath, "commit", "-am", "second readme")
+
+	queueAdd(t, env, firstID, "--no-run")
+	queueAdd(t, env, secondID, "--no-run")
+
+	stdout, err := env.runJSON("queue", "run", "test-repo")
+	if err != nil {
+		t.Fatalf("queue run failed: %v\nOutput: %s", err, stdout)
+	}
+	var run struct {
+		Processed []queueEntryJSON `json:"processed"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &run); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, stdout)
+	}
+	if len(run.Processed) != 2 {
+		t.Fatalf("expected 2 processed entries, got %d: %s", len(run.Processed), stdout)
+	}
+	if run.Processed[0].Worktree != firstID || run.Processed[0].Status != "merged" {
+		t.Errorf("expected first worktree merged, got %+v", run.Processed[0])
+	}
+	if run.Processed[1].Worktree != secondID || run.Processed[1].Status != "conflict" {
+		t.Errorf("expected second worktree bounced, got %+v", run.Processed[1])
+	}
+	if !strings.Contains(run.Processed[1].Result, "README.md") {
+		t.Errorf("expected conflicted path in result, got %q", run.Processed[1].Result)
+	}
+
+	if got := gitOutput(t, repoPath, "show", "main:README.md"); got != "# First" {
+		t.Errorf("expected first README on main, got %q", got)
+	}
+
+	// The bounced worktree can be fixed and re-enqueued
+	runGit(t, secondPath, "rebase", "-X", "theirs", "main")
+	res := queueAdd(t, env, secondID)
+	if res.Entry.Status != "merged" {
+		t.Fatalf("expected re-enqueued worktree to merge, got %+v", res.Entry)
+	}
+
+	// A merged worktree cannot be queued again
+	if _, err := env.run("queue", "add", firstID); err == nil {
+		t.Error("expected error enqueuing a completed worktree")
+	}
+}
+
+func TestQueueRejectsDuplicateAndCancels(t *testing.T) {
+	repoPath := setupTestGitRepo(t)
+	env := newTestEnv(t)
+	env.init()
+	if _, err := env.run("add", repoPath); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+
+	wtID, _ := spawnWithCommit(t, env, "dup", "dup.txt")
+	res := queueAdd(t, env, wtID, "--no-run")
+	if res.Entry.Status != "queued" {
+		t.Fatalf("expected queued entry, got %+v", res.Entry)
+	}
+
+	if _, err := env.run("queue", "add", wtID, "--no-run"); err == nil {
+		t.Error("expected error enqueuing the same worktree twice")
+	}
+
+	if _, err := env.run("queue", "cancel", res.Entry.ID[:8]); err != nil {
+		t.Fatalf("queue cancel failed: %v", err)
+	}
+
+	stdout, err := env.run("queue")
+	if err != nil {
+		t.Fatalf("queue failed: %v", err)
+	}
+	if !strings.Contains(stdout, "Merge queue is empty") {
+		t.Errorf("expected empty queue after cancel, got: %s", stdout)
+	}
+}
@@ -2,10 +2,15 @@ package cmd
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 
+	apperrors "github.com/fathindos/agit/internal/errors"
+	gitops "github.com/fathindos/agit/internal/git"
 	"github.com/fathindos/agit/internal/registry"
+	"github.com/fathindos/agit/internal/setup"
 	"github.com/fathindos/agit/internal/ui"
 )
 
@@ -22,7 +27,7 @@ var reposCmd = &cobra.Command{
 	Short: "List registered repositories",
 	Long:  `Shows all repositories registered with agit and their current state.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		db, err := registry.Open()
+		db, err := openRegistry()
 		if err != nil {
 			return fmt.Errorf("could not open registry: %w", err)
 		}
@@ -97,7 +102,7 @@ var removeCmd = &cobra.Command{
 	Args:              cobra.ExactArgs(1),
 	ValidArgsFunction: completeRepoNames,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		db, err := registry.Open()
+		db, err := openRegistry()
 		if err != nil {
 			return fmt.Errorf("could not open registry: %w", err)
 		}
@@ -116,7 +121,162 @@ var removeCmd = &cobra.Command{
 	},
 }
 
+// repoSetting describes a per-repo setting accepted by `agit repos set`
+type repoSetting struct {
+	validate func(value string) error
+	list     bool // takes one or more values
+}
+
+// repoSettings lists the per-repo settings accepted by `agit repos set`
+var repoSettings = map[string]repoSetting{
+	"merge_strategy": {validate: func(v string) error {
+		_, err := gitops.ParseMergeStrategy(v)
+		return err
+	}},
+	"verify": {list: true, validate: func(v string) error {
+		if strings.TrimSpace(v) == "" {
+			return fmt.Errorf("verify command cannot be empty")
+		}
+		return nil
+	}},
+	"verify_timeout": {validate: func(v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("verify_timeout must be a positive duration like 5m, got %q", v)
+		}
+		return nil
+	}},
+	"setup": {list: true, validate: func(v string) error {
+		if strings.TrimSpace(v) == "" {
+			return fmt.Errorf("setup command cannot be empty")
+		}
+		return nil
+	}},
+	"setup_copy": {list: true, validate: setup.ValidatePath},
+	"setup_link": {list: true, validate: setup.ValidatePath},
+	"setup_timeout": {validate: func(v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("setup_timeout must be a positive duration like 5m, got %q", v)
+		}
+		return nil
+	}},
+}
+
+var reposSetCmd = &cobra.Command{
+	Use:   "set <repo> <key> [value...]",
+	Short: "Show or change a per-repo setting",
+	Long: `Shows or changes a setting stored on a single repository. With no value,
+prints the current setting. Use --unset to remove it.
+
+Settings:
+  merge_strategy   Default strategy for agit merge (merge, squash, rebase, ff-only)
+  verify           Commands run in the worktree before a merge; pass one value per
+                   command, e.g. agit repos set my-app verify "go build ./..." "go test ./..."
+  verify_timeout   Per-command timeout for verify (default 10m)
+  setup            Commands run in each new worktree by agit spawn, e.g.
+                   agit repos set my-app setup "go mod download" "npm ci"
+  setup_copy       Files or directories copied from the main checkout into each
+                   new worktree, e.g. .env
+  setup_link       Paths symlinked from each new worktree to the main checkout
+  setup_timeout    Per-command timeout for setup (default 10m)
+
+The setup settings replace the matching entries of the [setup] section in the
+repository's .agit.toml.`,
+	Args:              cobra.MinimumNArgs(2),
+	ValidArgsFunction: completeRepoNames,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		unset, _ := cmd.Flags().GetBool("unset")
+		key := args[1]
+
+		setting, ok := repoSettings[key]
+		if !ok {
+			return apperrors.NewUserErrorf("unknown repo setting %q", key)
+		}
+		if !setting.list && len(args) > 3 {
+			return apperrors.NewUserErrorf("%s takes a single value", key)
+		}
+
+		db, err := openRegistry()
+		if err != nil {
+			return fmt.Errorf("could not open registry: %w", err)
+		}
+		defer db.Close()
+
+		repo, err := db.GetRepo(args[0])
+		if err != nil {
+			return err
+		}
+
+		if len(args) == 2 && !unset {
+			if setting.list {
+				values := repo.SettingList(key)
+				if ui.IsJSON() {
+					if values == nil {
+						values = []string{}
+					}
+					return ui.RenderJSON(map[string]interface{}{"repo": repo.Name, "key": key, "value": values})
+				}
+				if len(values) == 0 {
+					fmt.Printf("%s is not set for %s\n", key, repo.Name)
+				}
+				for _, v := range values {
+					fmt.Println(v)
+				}
+				return nil
+			}
+
+			value := repo.Setting(key)
+			if ui.IsJSON() {
+				return ui.RenderJSON(map[string]string{"repo": repo.Name, "key": key, "value": value})
+			}
+			if value == "" {
+				fmt.Printf("%s is not set for %s\n", key, repo.Name)
+			} else {
+				fmt.Println(value)
+			}
+			return nil
+		}
+
+		var values []string
+		if !unset {
+			values = args[2:]
+			for _, v := range values {
+				if err := setting.validate(v); err != nil {
+					return apperrors.NewUserError(err.Error())
+				}
+			}
+		}
+
+		if setting.list {
+			err = db.SetRepoSettingList(repo.ID, key, values)
+		} else {
+			err = db.SetRepoSetting(repo.ID, key, strings.Join(values, ""))
+		}
+		if err != nil {
+			return err
+		}
+
+		value := strings.Join(values, "; ")
+		if ui.IsJSON() {
+			var jsonValue interface{} = value
+			if setting.list {
+				jsonValue = values
+			}
+			return ui.RenderJSON(map[string]interface{}{"status": "ok", "repo": repo.Name, "key": key, "value": jsonValue})
+		}
+		if unset {
+			ui.Success("Unset %s for %s", key, repo.Name)
+		} else {
+			ui.Success("Set %s = %s for %s", key, value, repo.Name)
+		}
+		return nil
+	},
+}
+
 func init() {
+	reposSetCmd.Flags().Bool("unset", false, "Remove the setting")
+	reposCmd.AddCommand(reposSetCmd)
 	rootCmd.AddCommand(reposCmd)
 	rootCmd.AddCommand(removeCmd)
 }
@@ -62,3 +62,35 @@ func TestReposAfterAddJSON(t *testing.T) {
 		t.Errorf("expected repo name in JSON output, got: %s", stdout)
 	}
 }
+
+func TestReposSet(t *testing.T) {
+	repoPath := setupTestGitRepo(t)
+	env := newTestEnv(t)
+	env.init()
+	if _, err := env.run("add", repoPath); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+
+	if _, err := env.run("repos", "set", "test-repo", "no_such_key", "x"); err == nil {
+		t.Error("expected error for unknown setting")
+	}
+
+	if _, err := env.run("repos", "set", "test-repo", "merge_strategy", "squash"); err != nil {
+		t.Fatalf("repos set failed: %v", err)
+	}
+	stdout, err := env.run("repos", "set", "test-repo", "merge_strategy")
+	if err != nil {
+		t.Fatalf("repos set (read) failed: %v", err)
+	}
+	if strings.TrimSpace(stdout) != "squash" {
+		t.Errorf("expected squash, got %q", stdout)
+	}
+
+	if _, err := env.run("repos", "set", "test-repo", "merge_strategy", "--unset"); err != nil {
+		t.Fatalf("repos set --unset failed: %v", err)
+	}
+	stdout, _ = env.run("repos", "set", "test-repo", "merge_strategy")
+	if !strings.Contains(stdout, "not set") {
+		t.Errorf("expected setting to be removed, got %q", stdout)
+	}
+}
@@ -39,6 +39,18 @@ Get started:
   agit serve         Start the MCP server for agent integration`,
 	Version: Version,
 	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		// Relocate ~/.agit and select a profile before anything reads them
+		home, _ := cmd.Flags().GetString("home")
+		config.SetHome(home)
+		profile, _ := cmd.Flags().GetString("profile")
+		config.SetProfile(profile)
+		if config.Profile() != "" && cmd != configSetCmd {
+			// A mistyped profile would otherwise silently fall back to defaults
+			if _, err := config.Load(); err != nil {
+				return apperrors.NewUserError(err.Error())
+			}
+		}
+
 		// Skip UI init for serve (MCP uses raw stdout)
 		if cmd.Name() == "serve" {
 			return nil
@@ -116,6 +128,8 @@ func init() {
 	rootCmd.PersistentFlags().Bool("no-color", false, "Disable colored output")
 	rootCmd.PersistentFlags().BoolP("quiet", "q", false, "Suppress informational messages")
 	rootCmd.PersistentFlags().BoolP("interactive", "i", false, "Enable interactive selection mode")
+	rootCmd.PersistentFlags().String("home", "", "Use this directory instead of ~/.agit for config and registry (env: AGIT_HOME)")
+	rootCmd.PersistentFlags().String("profile", "", "Layer a named settings profile over the config (env: AGIT_PROFILE)")
 
 	// Branded version output
 	rootCmd.SetVersionTemplate(ui.T.Brand(ui.Sym.Zap+" agit v"+Version) + "\n")
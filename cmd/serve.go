@@ -4,8 +4,11 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -14,6 +17,7 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/fathindos/agit/internal/config"
+	"github.com/fathindos/agit/internal/daemon"
 	apperrors "github.com/fathindos/agit/internal/errors"
 	mcpserver "github.com/fathindos/agit/internal/mcp"
 	"github.com/fathindos/agit/internal/registry"
@@ -33,24 +37,57 @@ Configure in your agent's MCP settings:
         "args": ["serve"]
       }
     }
-  }`,
+  }
+
+With --scheduler, the server also runs the daemon's periodic sweeps and
+conflict scans (see agit daemon) for as long as it is up. The scheduler is
+skipped if agit daemon is already running.
+
+Clients can subscribe to resources such as agit://repos/<name>/tasks and are
+sent notifications/resources/updated when they change, including changes
+made by other agit processes.
+
+--transport http serves the streamable HTTP transport at /mcp. Agents
+authenticate with a bearer token from agit agents token create:
+
+  Authorization: Bearer agit_...
+
+An authenticated agent acts as itself: tools that take an agent name or ID
+default to it, and every request counts as its heartbeat. The server listens
+on 127.0.0.1 unless --host (or server.host) says otherwise; off loopback,
+only the http transport is allowed and every request needs a token. Tokens
+are sent with every request, so off loopback serve over TLS with --tls-cert
+and --tls-key, or put the server behind a TLS-terminating proxy.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		transport, _ := cmd.Flags().GetString("transport")
 		port, _ := cmd.Flags().GetInt("port")
+		withScheduler, _ := cmd.Flags().GetBool("scheduler")
+		tlsCert, _ := cmd.Flags().GetString("tls-cert")
+		tlsKey, _ := cmd.Flags().GetString("tls-key")
 
 		if cmd.Flags().Changed("port") && !cmd.Flags().Changed("transport") {
 			transport = "sse"
 		}
 		if cmd.Flags().Changed("port") && cmd.Flags().Changed("transport") && transport == "stdio" {
-			fmt.Fprintln(os.Stderr, "Warning: --port is only used with --transport=sse, ignoring")
+			fmt.Fprintln(os.Stderr, "Warning: --port is only used with --transport=sse or http, ignoring")
+		}
+		if (tlsCert == "") != (tlsKey == "") {
+			return apperrors.NewUserError("--tls-cert and --tls-key must be given together")
+		}
+		if tlsCert != "" && transport != "http" {
+			return apperrors.NewUserError("--tls-cert and --tls-key are only used with --transport http")
 		}
 
 		cfg, err := config.Load()
 		if err != nil {
 			return fmt.Errorf("could not load config: %w", err)
 		}
+		host, _ := cmd.Flags().GetString("host")
+		if host == "" {
+			host = cfg.Server.Host
+		}
 
-		db, err := registry.Open()
+		db, err := openRegistry()
 		if err != nil {
 			return fmt.Errorf("could not open registry: %w", err)
 		}
@@ -58,52 +95,138 @@ Configure in your agent's MCP settings:
 
 		s := mcpserver.NewServer(db, cfg)
 
+		if withScheduler {
+			stopScheduler := startScheduler(db, cfg)
+			defer stopScheduler()
+		}
+
 		switch transport {
 		case "stdio":
-			if err := server.ServeStdio(s); err != nil {
+			if err := s.ServeStdio(); err != nil {
 				return fmt.Errorf("stdio server error: %w", err)
 			}
 		case "sse":
-			addr := fmt.Sprintf("127.0.0.1:%d", port)
-			sseServer := server.NewSSEServer(s)
+			if !isLoopback(host) {
+				return apperrors.NewUserErrorf("the SSE transport has no authentication and only listens on loopback; use --transport http to listen on %s", host)
+			}
+			addr := net.JoinHostPort(host, strconv.Itoa(port))
+			httpServer := &http.Server{Addr: addr, ReadHeaderTimeout: readHeaderTimeout}
+			sseServer := server.NewSSEServer(s.MCPServer, server.WithHTTPServer(httpServer))
+			httpServer.Handler = s.SSEHandler(sseServer)
 			log.Printf("agit MCP server listening on %s (SSE)\n", addr)
-
-			// Handle graceful shutdown on SIGTERM/SIGINT
-			sigCh := make(chan os.Signal, 1)
-			signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT)
-
-			errCh := make(chan error, 1)
-			go func() {
-				errCh <- sseServer.Start(addr)
-			}()
-
-			select {
-			case err := <-errCh:
-				if err != nil {
-					if strings.Contains(err.Error(), "address already in use") {
-						return apperrors.NewUserErrorf("port %d is already in use — try a different port with --port", port)
-					}
-					return fmt.Errorf("SSE server error: %w", err)
-				}
-			case sig := <-sigCh:
-				log.Printf("received %s, shutting down gracefully...", sig)
-				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-				defer cancel()
-				if err := sseServer.Shutdown(ctx); err != nil {
-					log.Printf("shutdown error: %v", err)
-				}
-				log.Println("server stopped")
+			return listenAndServe(httpServer, port, sseServer.Shutdown, "", "")
+		case "http":
+			addr := net.JoinHostPort(host, strconv.Itoa(port))
+			// Off loopback, every request must carry an agent token
+			requireToken := !isLoopback(host)
+			mux := http.NewServeMux()
+			mux.Handle("/mcp", s.HTTPHandler(mcpserver.HTTPOptions{RequireToken: requireToken}))
+			httpServer := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: readHeaderTimeout}
+			// Cancelling the base context ends open event streams on shutdown
+			baseCtx, cancelStreams := context.WithCancel(context.Background())
+			httpServer.BaseContext = func(net.Listener) context.Context { return baseCtx }
+			httpServer.RegisterOnShutdown(cancelStreams)
+			scheme := "http"
+			if tlsCert != "" {
+				scheme = "https"
+			} else if requireToken {
+				log.Printf("WARNING: serving on %s without TLS; agent tokens cross the network in clear text. Use --tls-cert and --tls-key, or a TLS-terminating proxy", host)
 			}
+			if requireToken {
+				log.Printf("agit MCP server listening on %s://%s/mcp (agent token required)\n", scheme, addr)
+			} else {
+				log.Printf("agit MCP server listening on %s://%s/mcp\n", scheme, addr)
+			}
+			return listenAndServe(httpServer, port, httpServer.Shutdown, tlsCert, tlsKey)
 		default:
-			return apperrors.NewUserErrorf("unknown transport %q (use stdio or sse)", transport)
+			return apperrors.NewUserErrorf("unknown transport %q (use stdio, sse or http)", transport)
 		}
 
 		return nil
 	},
 }
 
+// readHeaderTimeout bounds how long a client may take to send request headers
+const readHeaderTimeout = 10 * time.Second
+
+// listenAndServe runs httpServer until it fails or the process is
+// interrupted, then shuts it down gracefully. It serves TLS when given a
+// certificate and key file.
+func listenAndServe(httpServer *http.Server, port int, shutdown func(context.Context) error, certFile, keyFile string) error {
+	// Handle graceful shutdown on SIGTERM/SIGINT
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT)
+
+	errCh := make(chan error, 1)
+	go func() {
+		if certFile != "" {
+			errCh <- httpServer.ListenAndServeTLS(certFile, keyFile)
+		} else {
+			errCh <- httpServer.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			if strings.Contains(err.Error(), "address already in use") {
+				return apperrors.NewUserErrorf("port %d is already in use — try a different port with --port", port)
+			}
+			return fmt.Errorf("HTTP server error: %w", err)
+		}
+	case sig := <-sigCh:
+		log.Printf("received %s, shutting down gracefully...", sig)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdown(ctx); err != nil {
+			log.Printf("shutdown error: %v", err)
+		}
+		log.Println("server stopped")
+	}
+	return nil
+}
+
+// isLoopback reports whether host only accepts local connections
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
 func init() {
-	serveCmd.Flags().String("transport", "stdio", "Transport: stdio or sse")
-	serveCmd.Flags().Int("port", 3847, "Port for SSE transport")
+	serveCmd.Flags().String("transport", "stdio", "Transport: stdio, sse or http")
+	serveCmd.Flags().String("host", "", "Interface to listen on for sse and http (default: server.host, 127.0.0.1)")
+	serveCmd.Flags().Int("port", 3847, "Port for SSE and HTTP transports")
+	serveCmd.Flags().String("tls-cert", "", "Certificate file to serve the http transport over TLS")
+	serveCmd.Flags().String("tls-key", "", "Private key file for --tls-cert")
+	serveCmd.Flags().Bool("scheduler", false, "Run the daemon's sweeps and conflict scans in-process")
 	rootCmd.AddCommand(serveCmd)
 }
+
+// startScheduler runs the daemon scheduler in the background and returns a
+// function that stops it. Output goes to stderr so stdio transport is
+// unaffected.
+func startScheduler(db *registry.DB, cfg *config.Config) func() {
+	if paths, err := daemon.DefaultPaths(); err == nil {
+		if pid, _ := paths.Running(); pid != 0 {
+			log.Printf("agit daemon is already running (pid %d), not starting the scheduler", pid)
+			return func() {}
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sched := daemon.NewScheduler(db, cfg, 0)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sched.Run(ctx)
+	}()
+	log.Printf("scheduler running every %s", sched.Interval())
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
@@ -1,8 +1,17 @@
 package cmd
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
+	"math/big"
 	"net"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -69,3 +78,77 @@ func TestServePortInUse(t *testing.T) {
 		_ = port
 	}
 }
+
+func TestServeSSERefusesNonLoopbackHost(t *testing.T) {
+	_, err := executeCommandWithInit(t, "serve", "--transport", "sse", "--host", "0.0.0.0")
+	if err == nil || !strings.Contains(err.Error(), "--transport http") {
+		t.Errorf("expected SSE off loopback to be refused, got %v", err)
+	}
+}
+
+func TestServeTLSFlags(t *testing.T) {
+	for _, args := range [][]string{
+		{"serve", "--transport", "http", "--tls-cert", "cert.pem"},
+		{"serve", "--transport", "sse", "--tls-cert", "cert.pem", "--tls-key", "key.pem"},
+	} {
+		if _, err := executeCommandWithInit(t, args...); err == nil || !strings.Contains(err.Error(), "--tls-key") {
+			t.Errorf("%v: expected the TLS flags to be refused, got %v", args, err)
+		}
+	}
+}
+
+func TestServeHTTPOverTLS(t *testing.T) {
+	env := newTestEnv(t)
+	env.init()
+
+	certFile, keyFile := writeTestCertificate(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not find free port: %v", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	go env.run("serve", "--transport", "http", "--port", fmt.Sprintf("%d", port), "--tls-cert", certFile, "--tls-key", keyFile)
+	time.Sleep(200 * time.Millisecond)
+
+	conn, err := tls.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port), &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("expected a TLS handshake, got %v", err)
+	}
+	conn.Close()
+}
+
+// writeTestCertificate writes a self-signed certificate for 127.0.0.1 and
+// its key, returning their paths
+func writeTestCertificate(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
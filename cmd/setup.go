@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	apperrors "github.com/fathindos/agit/internal/errors"
+	"github.com/fathindos/agit/internal/setup"
+	"github.com/fathindos/agit/internal/ui"
+)
+
+var setupCmd = &cobra.Command{
+	Use:   "setup <worktree-id>",
+	Short: "Run the repo's setup recipe in a worktree",
+	Long: `Runs the repository's setup recipe in a worktree: the same bootstrap
+agit spawn runs, e.g. after fixing a worktree left in the setup_failed state.
+
+The recipe comes from the [setup] section of .agit.toml in the main checkout:
+
+  [setup]
+  copy = [".env"]                          # copied from the main checkout
+  link = ["node_modules"]                  # symlinked to the main checkout
+  commands = ["go mod download", "npm ci"] # run in the worktree, in order
+  timeout = "10m"                          # per command
+
+The setup, setup_copy, setup_link and setup_timeout repo settings (see agit
+repos set) replace the matching entries from the file. Commands run with
+sh -c and see AGIT_REPO, AGIT_WORKTREE_ID, AGIT_BRANCH, AGIT_BASE_BRANCH and
+AGIT_MAIN_CHECKOUT. A passing run makes the worktree active again; a failing
+one leaves it in setup_failed.`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeWorktreeIDs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		db, err := openRegistry()
+		if err != nil {
+			return fmt.Errorf("could not open registry: %w", err)
+		}
+		defer db.Close()
+
+		wt, err := resolveWorktreeArg(db, args[0])
+		if err != nil {
+			return err
+		}
+		repo, err := db.GetRepoByID(wt.RepoID)
+		if err != nil {
+			return err
+		}
+
+		recipe, err := setup.LoadRecipe(repo)
+		if err == nil && recipe.Empty() {
+			return apperrors.NewUserErrorf("%s has no setup recipe; add a [setup] section to %s or use agit repos set", repo.Name, setup.RepoFile)
+		}
+		if !ui.IsJSON() {
+			ui.Info("Setting up %s...", wt.Branch)
+		}
+		if err := setup.Worktree(db, repo, wt); err != nil {
+			return err
+		}
+		step := setup.FailedStep(wt)
+
+		if ui.IsJSON() {
+			result := map[string]interface{}{
+				"status":   wt.Status,
+				"worktree": wt.ID,
+				"setup":    *wt.SetupStatus,
+				"steps":    wt.SetupSteps,
+			}
+			if step != nil {
+				result["setup_error"] = setup.Describe(step)
+			}
+			return ui.RenderJSON(result)
+		}
+
+		if step != nil {
+			if step.Output != "" {
+				fmt.Println(step.Output)
+			}
+			return apperrors.NewUserErrorf("setup failed: %s. The worktree stays setup_failed", setup.Describe(step))
+		}
+		ui.Success("Setup passed for %s (%d steps)", wt.Branch, len(wt.SetupSteps))
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(setupCmd)
+}
@@ -8,11 +8,11 @@ import (
 	"github.com/google/uuid"
 	"github.com/spf13/cobra"
 
-	"github.com/fathindos/agit/internal/config"
 	apperrors "github.com/fathindos/agit/internal/errors"
 	gitops "github.com/fathindos/agit/internal/git"
 	"github.com/fathindos/agit/internal/hooks"
 	"github.com/fathindos/agit/internal/registry"
+	"github.com/fathindos/agit/internal/setup"
 	"github.com/fathindos/agit/internal/ui"
 	"github.com/fathindos/agit/internal/ui/interactive"
 )
@@ -24,6 +24,28 @@ var spawnCmd = &cobra.Command{
 The worktree provides an isolated workspace where an agent can make changes
 without affecting other agents or the main branch.
 
+With --base, the new branch starts from another local branch instead, such
+as another agent's branch or a release branch. The base is remembered:
+conflict scans diff the worktree against it, agit sync updates from it and
+agit merge merges back into it. A worktree stacked on another agent's branch
+can only be merged once that agent's worktree has been; it then merges into
+the default branch if the base branch was deleted.
+
+With --existing-branch, the worktree checks out a branch that already exists
+rather than creating one. agit never deletes such a branch when the worktree
+is cleaned up.
+
+If the repo has a setup recipe (a [setup] section in its .agit.toml, or the
+setup, setup_copy and setup_link repo settings), it runs in the new worktree
+before agit spawn returns: files are copied or symlinked from the main
+checkout, then the setup commands run. If any step fails, the worktree is
+left in the setup_failed state; fix the problem and re-run it with agit setup.
+--skip-setup leaves the recipe for later.
+
+A pre.spawn hook (see agit config) runs before the worktree is created; if
+it exits non-zero, nothing is created and its stderr is reported as the
+error.
+
 With -i (interactive), presents a selector if no repo is specified.`,
 	Args:              cobra.MaximumNArgs(1),
 	ValidArgsFunction: completeRepoNames,
@@ -32,9 +54,15 @@ With -i (interactive), presents a selector if no repo is specified.`,
 		task, _ := cmd.Flags().GetString("task")
 		branch, _ := cmd.Flags().GetString("branch")
 		agentName, _ := cmd.Flags().GetString("agent")
+		base, _ := cmd.Flags().GetString("base")
+		existingBranch, _ := cmd.Flags().GetString("existing-branch")
+		skipSetup, _ := cmd.Flags().GetBool("skip-setup")
+		if existingBranch != "" && branch != "" {
+			return apperrors.NewUserError("--branch and --existing-branch cannot be used together")
+		}
 
 		// Open registry
-		db, err := registry.Open()
+		db, err := openRegistry()
 		if err != nil {
 			return fmt.Errorf("could not open registry: %w", err)
 		}
@@ -69,21 +97,30 @@ With -i (interactive), presents a selector if no repo is specified.`,
 			return apperrors.NewUserError("requires a repo argument (or use -i for interactive mode)")
 		}
 
-		// Load config
-		cfg, err := config.Load()
-		if err != nil {
-			return fmt.Errorf("could not load config: %w", err)
-		}
-
 		// Get repo
 		repo, err := db.GetRepo(repoName)
 		if err != nil {
 			return err
 		}
 
+		// Load config, with the repo's own settings layered on top
+		cfg, err := loadRepoConfig(repo)
+		if err != nil {
+			return fmt.Errorf("could not load config: %w", err)
+		}
+
+		if base != "" && !gitops.LocalBranchExists(repo.Path, base) {
+			return apperrors.NewUserErrorf("base %q is not a local branch in %s", base, repo.Name)
+		}
+		if existingBranch != "" && !gitops.LocalBranchExists(repo.Path, existingBranch) {
+			return apperrors.NewUserErrorf("branch %q not found in %s", existingBranch, repo.Name)
+		}
+
 		// Generate worktree ID and branch name
 		shortID := uuid.New().String()[:8]
-		if branch == "" {
+		if existingBranch != "" {
+			branch = existingBranch
+		} else if branch == "" {
 			if task != "" {
 				// Slugify the task description
 				slug := strings.ToLower(task)
@@ -106,8 +143,32 @@ With -i (interactive), presents a selector if no repo is specified.`,
 		// Worktree path
 		worktreePath := filepath.Join(repo.Path, cfg.Defaults.WorktreeDir, "agit-"+shortID)
 
+		// Let a pre.spawn hook refuse the worktree before anything is created
+		hookRunner := hooks.NewRunner(cfg, db)
+		baseBranch := repo.DefaultBranch
+		if base != "" {
+			baseBranch = base
+		}
+		if err := hookRunner.Check(hooks.PreSpawn, map[string]string{
+			"AGIT_REPO":          repoName,
+			"AGIT_BRANCH":        branch,
+			"AGIT_BASE_BRANCH":   baseBranch,
+			"AGIT_WORKTREE_PATH": worktreePath,
+			"AGIT_TASK":          task,
+			"AGIT_AGENT":         agentName,
+		}); err != nil {
+			return err
+		}
+
 		// Create the git worktree
-		if err := gitops.CreateWorktree(repo.Path, worktreePath, branch, repo.DefaultBranch); err != nil {
+		if existingBranch != "" {
+			err = gitops.AttachWorktree(repo.Path, worktreePath, branch)
+		} else if base != "" {
+			err = gitops.CreateWorktree(repo.Path, worktreePath, branch, base)
+		} else {
+			err = gitops.CreateWorktree(repo.Path, worktreePath, branch, repo.DefaultBranch)
+		}
+		if err != nil {
 			return fmt.Errorf("could not create worktree: %w", err)
 		}
 
@@ -127,13 +188,16 @@ With -i (interactive), presents a selector if no repo is specified.`,
 			agentID = &agent.ID
 		}
 
-		var taskDesc *string
+		opts := registry.WorktreeOptions{AgentID: agentID, ExistingBranch: existingBranch != ""}
 		if task != "" {
-			taskDesc = &task
+			opts.TaskDescription = &task
+		}
+		if base != "" {
+			opts.BaseRef = &base
 		}
 
 		// Record in registry
-		wt, err := db.CreateWorktree(repo.ID, worktreePath, branch, agentID, taskDesc)
+		wt, err := db.CreateWorktreeWithOptions(repo.ID, worktreePath, branch, opts)
 		if err != nil {
 			gitops.RemoveWorktree(repo.Path, worktreePath)
 			return fmt.Errorf("could not record worktree: %w", err)
@@ -143,13 +207,13 @@ With -i (interactive), presents a selector if no repo is specified.`,
 			db.UpdateAgentWorktree(*agentID, &wt.ID)
 		}
 
-		// Fire worktree.created hook
-		hookRunner := hooks.NewRunner(cfg)
-		defer hookRunner.Wait()
-		hookRunner.Fire("worktree.created", map[string]string{
-			"AGIT_REPO":        repoName,
-			"AGIT_WORKTREE_ID": wt.ID,
-		})
+		// Bootstrap the worktree with the repo's setup recipe
+		if !skipSetup {
+			if err := setup.Worktree(db, repo, wt); err != nil {
+				return err
+			}
+		}
+		failedStep := setup.FailedStep(wt)
 
 		if ui.IsJSON() {
 			result := map[string]string{
@@ -165,17 +229,43 @@ With -i (interactive), presents a selector if no repo is specified.`,
 			if task != "" {
 				result["task"] = task
 			}
+			result["base"] = wt.Base(repo.DefaultBranch)
+			if wt.SetupStatus != nil {
+				result["setup"] = *wt.SetupStatus
+			}
+			if failedStep != nil {
+				result["status"] = wt.Status
+				result["setup_error"] = setup.Describe(failedStep)
+			}
 			return ui.RenderJSON(result)
 		}
 
 		ui.Success("Created worktree: %s", ui.T.Muted(worktreePath))
 		ui.KeyValue("Branch", branch)
+		if base != "" {
+			ui.KeyValue("Base", base)
+		}
 		if agentName != "" {
 			ui.KeyValue("Agent", agentName)
 		}
 		if task != "" {
 			ui.KeyValue("Task", task)
 		}
+		if failedStep != nil {
+			ui.Blank()
+			if failedStep.Output != "" {
+				fmt.Print(failedStep.Output)
+				if !strings.HasSuffix(failedStep.Output, "\n") {
+					fmt.Println()
+				}
+			}
+			ui.Warning("Setup failed: %s", setup.Describe(failedStep))
+			fmt.Printf("The worktree is marked setup_failed. Fix it and re-run: agit setup %s\n", wt.ID[:12])
+			return nil
+		}
+		if wt.SetupStatus != nil {
+			ui.KeyValue("Setup", fmt.Sprintf("passed (%d steps)", len(wt.SetupSteps)))
+		}
 		fmt.Printf("\nAgent can work in: %s\n", ui.T.Bold(worktreePath))
 
 		return nil
@@ -186,6 +276,9 @@ func init() {
 	spawnCmd.Flags().StringP("task", "t", "", "Description of what the agent will do")
 	spawnCmd.Flags().StringP("branch", "b", "", "Custom branch name (auto-generated if omitted)")
 	spawnCmd.Flags().StringP("agent", "a", "", "Agent name to assign this worktree to")
+	spawnCmd.Flags().String("base", "", "Local branch to branch from and merge back into (default: the repo's default branch)")
+	spawnCmd.Flags().String("existing-branch", "", "Check out an existing branch instead of creating a new one")
+	spawnCmd.Flags().Bool("skip-setup", false, "Don't run the repo's setup recipe in the new worktree")
 	_ = spawnCmd.RegisterFlagCompletionFunc("agent", completeAgentNames)
 	rootCmd.AddCommand(spawnCmd)
 }
@@ -1,8 +1,14 @@
 package cmd
 
 import (
+	"encoding/json"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/fathindos/agit/internal/conflicts"
+	"github.com/fathindos/agit/internal/registry"
 )
 
 func TestSpawnSuccess(t *testing.T) {
@@ -56,3 +62,233 @@ func TestSpawnRepoNotFound(t *testing.T) {
 		t.Error("expected error for nonexistent repo")
 	}
 }
+
+func TestSpawnFromBaseRef(t *testing.T) {
+	repoPath := setupTestGitRepo(t)
+	env := newTestEnv(t)
+	env.init()
+	if _, err := env.run("add", repoPath); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+
+	lowerID, lowerPath := spawnWithCommit(t, env, "lower", "lower.txt")
+	lowerBranch := gitOutput(t, lowerPath, "rev-parse", "--abbrev-ref", "HEAD")
+	lowerTip := gitOutput(t, lowerPath, "rev-parse", "HEAD")
+
+	stdout, err := env.runJSON("spawn", "test-repo", "--task", "upper", "--base", lowerBranch)
+	if err != nil {
+		t.Fatalf("spawn --base failed: %v", err)
+	}
+	var spawned map[string]string
+	if err := json.Unmarshal([]byte(stdout), &spawned); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, stdout)
+	}
+	if spawned["base"] != lowerBranch {
+		t.Errorf("expected base %s, got %q", lowerBranch, spawned["base"])
+	}
+	upperPath := spawned["path"]
+	if got := gitOutput(t, upperPath, "rev-parse", "HEAD"); got != lowerTip {
+		t.Fatalf("expected upper to start at %s, got %s", lowerTip, got)
+	}
+
+	writeFileInWorktree(t, upperPath, "upper.txt", "upper\n")
+	runGit(t, upperPath, "add", "upper.txt")
+	runGit(t, upperPath, "commit", "-m", "add upper.txt")
+
+	// Conflict scans diff against the base, so lower.txt is not upper's change
+	db, err := registry.Open()
+	if err != nil {
+		t.Fatalf("registry.Open: %v", err)
+	}
+	defer db.Close()
+	repo, _ := db.GetRepo("test-repo")
+	wt, err := db.GetWorktree(spawned["worktree"])
+	if err != nil {
+		t.Fatalf("GetWorktree: %v", err)
+	}
+	touches, err := conflicts.WorktreeTouches(repo, wt)
+	if err != nil {
+		t.Fatalf("WorktreeTouches: %v", err)
+	}
+	if len(touches) != 1 || touches[0].FilePath != "upper.txt" {
+		t.Errorf("expected only upper.txt touched, got %+v", touches)
+	}
+
+	// Upper can't merge into lower's branch while lower is in progress
+	if _, err := env.run("merge", spawned["worktree"]); err == nil || !strings.Contains(err.Error(), "merge that worktree first") {
+		t.Fatalf("expected merging a stacked worktree to be refused, got %v", err)
+	}
+	if got := gitOutput(t, repoPath, "rev-parse", lowerBranch); got != lowerTip {
+		t.Errorf("expected %s untouched, moved to %s", lowerBranch, got)
+	}
+
+	// Once lower is merged and its branch deleted, upper merges into main
+	if _, err := env.run("merge", lowerID, "--cleanup"); err != nil {
+		t.Fatalf("merge lower failed: %v", err)
+	}
+	if _, err := env.run("merge", spawned["worktree"]); err != nil {
+		t.Fatalf("merge upper failed: %v", err)
+	}
+	runGit(t, repoPath, "cat-file", "-e", "main:upper.txt")
+
+	if _, err := env.run("spawn", "test-repo", "--base", "no-such-ref"); err == nil {
+		t.Error("expected error for unknown base ref")
+	}
+	runGit(t, repoPath, "tag", "v1", "main")
+	if _, err := env.run("spawn", "test-repo", "--base", "v1"); err == nil || !strings.Contains(err.Error(), "not a local branch") {
+		t.Errorf("expected a tag base to be refused, got %v", err)
+	}
+}
+
+func TestSpawnFromReleaseBranchMergesIntoIt(t *testing.T) {
+	repoPath := setupTestGitRepo(t)
+	env := newTestEnv(t)
+	env.init()
+	if _, err := env.run("add", repoPath); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+	runGit(t, repoPath, "branch", "release", "main")
+
+	stdout, err := env.runJSON("spawn", "test-repo", "--task", "hotfix", "--base", "release")
+	if err != nil {
+		t.Fatalf("spawn --base failed: %v", err)
+	}
+	wtID, wtPath := extractSpawnJSON(t, stdout)
+	writeFileInWorktree(t, wtPath, "fix.txt", "fix\n")
+	runGit(t, wtPath, "add", "fix.txt")
+	runGit(t, wtPath, "commit", "-m", "add fix.txt")
+
+	mainTip := gitOutput(t, repoPath, "rev-parse", "main")
+	if _, err := env.run("merge", wtID, "--strategy", "ff-only"); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	if got := gitOutput(t, repoPath, "rev-parse", "release"); got != gitOutput(t, wtPath, "rev-parse", "HEAD") {
+		t.Errorf("expected release fast-forwarded to the worktree, got %s", got)
+	}
+	if got := gitOutput(t, repoPath, "rev-parse", "main"); got != mainTip {
+		t.Errorf("expected main untouched, moved to %s", got)
+	}
+}
+
+func TestSpawnExistingBranch(t *testing.T) {
+	repoPath := setupTestGitRepo(t)
+	env := newTestEnv(t)
+	env.init()
+	if _, err := env.run("add", repoPath); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+	runGit(t, repoPath, "branch", "feature/handoff")
+
+	if _, err := env.run("spawn", "test-repo", "--existing-branch", "feature/handoff", "--branch", "other"); err == nil {
+		t.Error("expected error combining --branch and --existing-branch")
+	}
+	if _, err := env.run("spawn", "test-repo", "--existing-branch", "no-such-branch"); err == nil {
+		t.Error("expected error for a missing branch")
+	}
+
+	stdout, err := env.runJSON("spawn", "test-repo", "--existing-branch", "feature/handoff")
+	if err != nil {
+		t.Fatalf("spawn --existing-branch failed: %v", err)
+	}
+	wtID, wtPath := extractSpawnJSON(t, stdout)
+	if got := gitOutput(t, wtPath, "rev-parse", "--abbrev-ref", "HEAD"); got != "feature/handoff" {
+		t.Fatalf("expected feature/handoff checked out, got %s", got)
+	}
+
+	writeFileInWorktree(t, wtPath, "handoff.txt", "handoff\n")
+	runGit(t, wtPath, "add", "handoff.txt")
+	runGit(t, wtPath, "commit", "-m", "add handoff.txt")
+
+	if _, err := env.run("merge", wtID, "--cleanup"); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	// The branch predates the worktree, so cleanup leaves it alone
+	gitOutput(t, repoPath, "rev-parse", "--verify", "refs/heads/feature/handoff")
+}
+
+func TestSpawnRunsSetupRecipe(t *testing.T) {
+	repoPath := setupTestGitRepo(t)
+	env := newTestEnv(t)
+	env.init()
+	if _, err := env.run("add", repoPath); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+	writeFileInWorktree(t, repoPath, ".env", "TOKEN=abc\n")
+	writeFileInWorktree(t, repoPath, ".agit.toml", "[setup]\ncopy = [\".env\"]\ncommands = [\"test -f .env && touch bootstrapped\"]\n")
+
+	stdout, err := env.runJSON("spawn", "test-repo", "--task", "ready")
+	if err != nil {
+		t.Fatalf("spawn failed: %v", err)
+	}
+	var result map[string]string
+	if err := json.Unmarshal([]byte(stdout), &result); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, stdout)
+	}
+	if result["status"] != "ok" || result["setup"] != "passed" {
+		t.Fatalf("expected setup to pass, got %v", result)
+	}
+	if _, err := os.Stat(filepath.Join(result["path"], "bootstrapped")); err != nil {
+		t.Errorf("expected setup command to run in the worktree: %v", err)
+	}
+}
+
+func TestSpawnSetupFailureAndRetry(t *testing.T) {
+	repoPath := setupTestGitRepo(t)
+	env := newTestEnv(t)
+	env.init()
+	if _, err := env.run("add", repoPath); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+	if _, err := env.run("repos", "set", "test-repo", "setup", "echo missing toolchain >&2; exit 4"); err != nil {
+		t.Fatalf("repos set failed: %v", err)
+	}
+
+	stdout, err := env.runJSON("spawn", "test-repo", "--task", "broken")
+	if err != nil {
+		t.Fatalf("spawn failed: %v", err)
+	}
+	var result map[string]string
+	if err := json.Unmarshal([]byte(stdout), &result); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, stdout)
+	}
+	if result["status"] != "setup_failed" || !strings.Contains(result["setup_error"], "missing toolchain") {
+		t.Fatalf("expected setup_failed with the command's error, got %v", result)
+	}
+
+	db, err := registry.Open()
+	if err != nil {
+		t.Fatalf("registry.Open: %v", err)
+	}
+	wt, err := db.GetWorktree(result["worktree"])
+	db.Close()
+	if err != nil || wt.Status != "setup_failed" {
+		t.Fatalf("expected worktree recorded as setup_failed, got %+v, %v", wt, err)
+	}
+
+	text, err := env.run("status")
+	if err != nil {
+		t.Fatalf("status failed: %v", err)
+	}
+	if !strings.Contains(text, "SETUP FAILED") || !strings.Contains(text, wt.ID[:12]) {
+		t.Errorf("expected status to show the setup_failed worktree, got: %s", text)
+	}
+
+	if _, err := env.run("setup", wt.ID); err == nil {
+		t.Fatal("expected setup to fail again before the recipe is fixed")
+	}
+	if _, err := env.run("repos", "set", "test-repo", "setup", "true"); err != nil {
+		t.Fatalf("repos set failed: %v", err)
+	}
+	if _, err := env.run("setup", wt.ID); err != nil {
+		t.Fatalf("setup failed after fixing the recipe: %v", err)
+	}
+
+	db, err = registry.Open()
+	if err != nil {
+		t.Fatalf("registry.Open: %v", err)
+	}
+	defer db.Close()
+	if wt, _ = db.GetWorktree(wt.ID); wt.Status != "active" {
+		t.Errorf("expected worktree active after a passing setup, got %s", wt.Status)
+	}
+}
@@ -7,7 +7,9 @@ import (
 
 	"github.com/fathindos/agit/internal/conflicts"
 	"github.com/fathindos/agit/internal/registry"
+	"github.com/fathindos/agit/internal/setup"
 	"github.com/fathindos/agit/internal/ui"
+	"github.com/fathindos/agit/internal/verify"
 )
 
 type statusJSON struct {
@@ -18,19 +20,70 @@ type statusRepoJSON struct {
 	Name          string               `json:"name"`
 	DefaultBranch string               `json:"default_branch"`
 	Worktrees     []statusWorktreeJSON `json:"worktrees"`
+	SetupFailed   []statusWorktreeJSON `json:"setup_failed,omitempty"`
 	Conflicts     []statusConflictJSON `json:"conflicts,omitempty"`
 	Tasks         []statusTaskJSON     `json:"tasks,omitempty"`
 }
 
 type statusWorktreeJSON struct {
-	ID     string `json:"id"`
-	Branch string `json:"branch"`
-	Agent  string `json:"agent"`
-	Task   string `json:"task,omitempty"`
+	ID     string            `json:"id"`
+	Branch string            `json:"branch"`
+	Base   string            `json:"base,omitempty"`
+	Agent  string            `json:"agent"`
+	Task   string            `json:"task,omitempty"`
+	Verify *statusVerifyJSON `json:"verify,omitempty"`
+	Setup  string            `json:"setup_error,omitempty"`
+}
+
+type statusVerifyJSON struct {
+	Status    string `json:"status"`
+	Commit    string `json:"commit,omitempty"`
+	Failed    string `json:"failed_command,omitempty"`
+	SkippedBy string `json:"skipped_by,omitempty"`
+	At        string `json:"at"`
+}
+
+func toStatusVerifyJSON(run *registry.VerifyRun) *statusVerifyJSON {
+	if run == nil {
+		return nil
+	}
+	v := &statusVerifyJSON{
+		Status: run.Status,
+		At:     run.StartedAt.Format("2006-01-02 15:04:05"),
+	}
+	if len(run.CommitSHA) >= 12 {
+		v.Commit = run.CommitSHA[:12]
+	}
+	if step := verify.FailedStep(run); step != nil {
+		v.Failed = step.Command
+	}
+	if run.SkippedBy != nil {
+		v.SkippedBy = *run.SkippedBy
+	}
+	return v
+}
+
+// verifySummary renders a worktree's latest verify run for the status view
+func verifySummary(run *registry.VerifyRun) string {
+	switch {
+	case run == nil:
+		return ""
+	case run.Status == "passed":
+		return ui.T.Success("passed")
+	case run.Status == "skipped":
+		return ui.T.Warning("skipped by " + *run.SkippedBy)
+	default:
+		msg := "failed"
+		if step := verify.FailedStep(run); step != nil {
+			msg += ": " + step.Command
+		}
+		return ui.T.Error(msg)
+	}
 }
 
 type statusConflictJSON struct {
 	File      string `json:"file"`
+	Kind      string `json:"kind"`
 	Worktrees int    `json:"worktrees"`
 }
 
@@ -47,7 +100,7 @@ var statusCmd = &cobra.Command{
 	Args:              cobra.MaximumNArgs(1),
 	ValidArgsFunction: completeRepoNames,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		db, err := registry.Open()
+		db, err := openRegistry()
 		if err != nil {
 			return fmt.Errorf("could not open registry: %w", err)
 		}
@@ -121,6 +174,12 @@ var statusCmd = &cobra.Command{
 					if wt.TaskDescription != nil {
 						wtJSON.Task = *wt.TaskDescription
 					}
+					if wt.BaseRef != nil {
+						wtJSON.Base = *wt.BaseRef
+					}
+					if run, err := db.LatestVerifyRun(wt.ID); err == nil {
+						wtJSON.Verify = toStatusVerifyJSON(run)
+					}
 					repoData.Worktrees = append(repoData.Worktrees, wtJSON)
 				}
 			} else if len(worktrees) > 0 {
@@ -137,35 +196,71 @@ var statusCmd = &cobra.Command{
 					if wt.TaskDescription != nil {
 						taskStr = *wt.TaskDescription
 					}
-					fmt.Printf("  %s  branch:%s  agent:%s  task:%s\n",
+					fmt.Printf("  %s  branch:%s  agent:%s  task:%s",
 						ui.T.Muted(wt.ID[:12]),
 						ui.T.Muted(wt.Branch),
 						ui.T.Muted(agentStr),
 						ui.T.Muted(taskStr),
 					)
+					if wt.BaseRef != nil {
+						fmt.Printf("  base:%s", ui.T.Muted(*wt.BaseRef))
+					}
+					if run, err := db.LatestVerifyRun(wt.ID); err == nil && run != nil {
+						fmt.Printf("  verify:%s", verifySummary(run))
+					}
+					fmt.Println()
 				}
 				ui.Blank()
 			} else if !ui.IsJSON() {
 				fmt.Println("  No active worktrees")
 			}
 
+			// Worktrees whose setup recipe failed are not usable yet
+			setupFailedStatus := "setup_failed"
+			if failed, err := db.ListWorktrees(repo.ID, &setupFailedStatus); err == nil && len(failed) > 0 {
+				if !ui.IsJSON() {
+					ui.Section("Setup Failed")
+				}
+				for _, wt := range failed {
+					reason := "setup failed"
+					if step := setup.FailedStep(wt); step != nil {
+						reason = setup.Describe(step)
+					}
+					if ui.IsJSON() {
+						repoData.SetupFailed = append(repoData.SetupFailed, statusWorktreeJSON{
+							ID: wt.ID[:12], Branch: wt.Branch, Agent: "-", Setup: reason,
+						})
+						continue
+					}
+					ui.Warning("%s  branch:%s  %s", wt.ID[:12], wt.Branch, reason)
+				}
+				if !ui.IsJSON() {
+					fmt.Println("  Fix and re-run with: agit setup <worktree-id>")
+					ui.Blank()
+				}
+			}
+
 			// Refresh file touches for live conflict scanning
 			conflicts.ScanAndUpdate(db, repo)
 
 			// Conflicts
 			conflictList, err := db.FindConflicts(repo.ID)
+			if err == nil {
+				conflicts.RecordDetected(db, repo, conflictList)
+			}
 			if err == nil && len(conflictList) > 0 {
 				if ui.IsJSON() {
 					for _, c := range conflictList {
 						repoData.Conflicts = append(repoData.Conflicts, statusConflictJSON{
 							File:      c.FilePath,
+							Kind:      conflicts.Kind(c),
 							Worktrees: len(c.Worktrees),
 						})
 					}
 				} else {
 					ui.Section("Conflicts")
 					for _, c := range conflictList {
-						ui.Warning("%s modified in %d worktrees", c.FilePath, len(c.Worktrees))
+						ui.Warning("%s modified in %d worktrees (%s)", c.FilePath, len(c.Worktrees), conflicts.KindLabel(c))
 					}
 					ui.Blank()
 				}
@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fathindos/agit/internal/conflicts"
+	gitops "github.com/fathindos/agit/internal/git"
+	"github.com/fathindos/agit/internal/ui"
+)
+
+var syncCmd = &cobra.Command{
+	Use:   "sync <worktree-id>",
+	Short: "Bring a worktree up to date with its base branch",
+	Long: `Updates a worktree's branch from its base: the ref it was spawned from with
+agit spawn --base, or the repository's default branch. By default the
+branch's commits are rebased onto the base (--rebase); --merge merges the
+base in instead. The worktree must have no uncommitted changes to tracked
+files.
+
+If git stops on conflicts, the conflicted paths are reported and the rebase
+or merge is left in progress inside the worktree: resolve the files and run
+git rebase --continue (or git commit for a merge), or undo the whole sync
+with agit sync <worktree-id> --abort.
+
+Afterwards the worktree's file touches are refreshed, so overlaps with other
+worktrees that the base has already absorbed no longer show up in agit
+conflicts. A worktree the merge queue bounced with the conflict status is
+active again once it syncs cleanly.`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeWorktreeIDs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		useMerge, _ := cmd.Flags().GetBool("merge")
+		abort, _ := cmd.Flags().GetBool("abort")
+
+		db, err := openRegistry()
+		if err != nil {
+			return fmt.Errorf("could not open registry: %w", err)
+		}
+		defer db.Close()
+
+		wt, err := resolveWorktreeArg(db, args[0])
+		if err != nil {
+			return err
+		}
+		repo, err := db.GetRepoByID(wt.RepoID)
+		if err != nil {
+			return err
+		}
+
+		if abort {
+			mode, err := conflicts.AbortSync(db, repo, wt)
+			if err != nil {
+				return err
+			}
+			if ui.IsJSON() {
+				return ui.RenderJSON(map[string]interface{}{
+					"status":   "aborted",
+					"worktree": wt.ID,
+					"mode":     mode,
+				})
+			}
+			ui.Success("Aborted %s of %s; the branch is back where it was", mode, wt.Branch)
+			return nil
+		}
+
+		mode := gitops.SyncRebase
+		if useMerge {
+			mode = gitops.SyncMerge
+		}
+		result, err := conflicts.Sync(db, repo, wt, mode)
+		if err != nil {
+			return err
+		}
+		base := wt.Base(repo.DefaultBranch)
+
+		if ui.IsJSON() {
+			return ui.RenderJSON(map[string]interface{}{
+				"status":   conflicts.SyncStatus(result),
+				"worktree": wt.ID,
+				"branch":   wt.Branch,
+				"onto":     base,
+				"result":   result,
+			})
+		}
+
+		switch {
+		case result.UpToDate:
+			ui.Success("%s is already up to date with %s", wt.Branch, base)
+		case result.Conflicted():
+			ui.Warning("%s of %s onto %s stopped on conflicts:", mode, wt.Branch, base)
+			for _, p := range result.ConflictedPaths {
+				ui.Bullet("%s", p)
+			}
+			ui.Blank()
+			if mode == gitops.SyncRebase {
+				fmt.Printf("Resolve them in %s and run git rebase --continue,\n", wt.Path)
+			} else {
+				fmt.Printf("Resolve them in %s and run git commit,\n", wt.Path)
+			}
+			fmt.Printf("or undo the sync with: agit sync %s --abort\n", wt.ID[:12])
+		default:
+			ui.Success("Synced %s with %s (%s)", wt.Branch, base, mode)
+			ui.Bullet("%s -> %s", gitops.ShortSHA(result.OldHead), gitops.ShortSHA(result.NewHead))
+		}
+		return nil
+	},
+}
+
+func init() {
+	syncCmd.Flags().Bool("rebase", false, "Rebase the branch onto its base branch (default)")
+	syncCmd.Flags().Bool("merge", false, "Merge the base branch into the branch instead of rebasing")
+	syncCmd.Flags().Bool("abort", false, "Abort a sync that stopped on conflicts")
+	syncCmd.MarkFlagsMutuallyExclusive("rebase", "merge")
+	syncCmd.MarkFlagsMutuallyExclusive("abort", "rebase")
+	syncCmd.MarkFlagsMutuallyExclusive("abort", "merge")
+	rootCmd.AddCommand(syncCmd)
+}
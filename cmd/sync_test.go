@@ -0,0 +1,240 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	apperrors "github.com/fathindos/agit/internal/errors"
+	"github.com/fathindos/agit/internal/registry"
+)
+
+type syncOutputJSON struct {
+	Status string `json:"status"`
+	Result struct {
+		Mode            string   `json:"mode"`
+		Base            string   `json:"base"`
+		OldHead         string   `json:"old_head"`
+		NewHead         string   `json:"new_head"`
+		UpToDate        bool     `json:"up_to_date"`
+		ConflictedPaths []string `json:"conflicted_paths"`
+	} `json:"result"`
+}
+
+func runSync(t *testing.T, env *testEnv, args ...string) syncOutputJSON {
+	t.Helper()
+	stdout, err := env.runJSON(append([]string{"sync"}, args...)...)
+	if err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	var out syncOutputJSON
+	if err := json.Unmarshal([]byte(stdout), &out); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, stdout)
+	}
+	return out
+}
+
+func TestSyncRebaseAndMerge(t *testing.T) {
+	repoPath := setupTestGitRepo(t)
+	env := newTestEnv(t)
+	env.init()
+	if _, err := env.run("add", repoPath); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+
+	landed, _ := spawnWithCommit(t, env, "landed", "landed.txt")
+	rebased, rebasedPath := spawnWithCommit(t, env, "rebased", "rebased.txt")
+	merged, mergedPath := spawnWithCommit(t, env, "merged", "merged.txt")
+
+	if out := runSync(t, env, rebased); out.Status != "up_to_date" {
+		t.Fatalf("expected up_to_date before main moves, got %q", out.Status)
+	}
+
+	if _, err := env.run("merge", landed); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	mainTip := gitOutput(t, repoPath, "rev-parse", "main")
+
+	out := runSync(t, env, rebased)
+	if out.Status != "synced" || out.Result.Mode != "rebase" || out.Result.Base != mainTip {
+		t.Fatalf("unexpected rebase result: %+v", out)
+	}
+	if parent := gitOutput(t, rebasedPath, "rev-parse", "HEAD~1"); parent != mainTip {
+		t.Errorf("expected branch rebased onto %s, parent is %s", mainTip, parent)
+	}
+
+	out = runSync(t, env, merged, "--merge")
+	if out.Status != "synced" || out.Result.Mode != "merge" {
+		t.Fatalf("unexpected merge result: %+v", out)
+	}
+	if parents := strings.Fields(gitOutput(t, mergedPath, "log", "-1", "--format=%P")); len(parents) != 2 {
+		t.Errorf("expected a merge commit, got parents %v", parents)
+	}
+	if _, err := os.Stat(filepath.Join(mergedPath, "landed.txt")); err != nil {
+		t.Errorf("expected landed.txt in synced worktree: %v", err)
+	}
+
+	text, err := env.run("log", "--type", "worktree.synced")
+	if err != nil {
+		t.Fatalf("log failed: %v", err)
+	}
+	if strings.Count(text, "worktree.synced") != 2 {
+		t.Errorf("expected two worktree.synced events, got: %s", text)
+	}
+}
+
+func TestSyncConflictAndAbort(t *testing.T) {
+	repoPath := setupTestGitRepo(t)
+	env := newTestEnv(t)
+	env.init()
+	if _, err := env.run("add", repoPath); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+
+	landed, _ := spawnWithCommit(t, env, "landed", "shared.txt")
+	wtID, wtPath := spawnWithCommit(t, env, "behind", "shared.txt")
+	if _, err := env.run("merge", landed); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	before := gitOutput(t, wtPath, "rev-parse", "HEAD")
+
+	out := runSync(t, env, wtID)
+	if out.Status != "conflict" || len(out.Result.ConflictedPaths) != 1 || out.Result.ConflictedPaths[0] != "shared.txt" {
+		t.Fatalf("expected a conflict on shared.txt, got %+v", out)
+	}
+
+	// The rebase is left in progress, so a second sync is refused
+	if _, err := env.run("sync", wtID); err == nil || !strings.Contains(err.Error(), "already in progress") || !apperrors.IsUserError(err) {
+		t.Fatalf("expected sync in progress user error, got %v", err)
+	}
+
+	if _, err := env.run("sync", wtID, "--abort", "--merge"); err == nil || !strings.Contains(err.Error(), "[abort merge]") {
+		t.Fatalf("expected --abort with --merge to be rejected, got %v", err)
+	}
+	if _, err := env.run("sync", wtID, "--abort"); err != nil {
+		t.Fatalf("abort failed: %v", err)
+	}
+	if after := gitOutput(t, wtPath, "rev-parse", "HEAD"); after != before {
+		t.Errorf("expected HEAD restored to %s after abort, got %s", before, after)
+	}
+	if _, err := env.run("sync", wtID, "--abort"); err == nil || !apperrors.IsUserError(err) {
+		t.Errorf("expected user error aborting with no sync in progress, got %v", err)
+	}
+}
+
+func TestSyncReactivatesBouncedWorktree(t *testing.T) {
+	repoPath := setupTestGitRepo(t)
+	env := newTestEnv(t)
+	env.init()
+	if _, err := env.run("add", repoPath); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+
+	landed, _ := spawnWithCommit(t, env, "landed", "landed.txt")
+	bounced, _ := spawnWithCommit(t, env, "bounced", "bounced.txt")
+	if _, err := env.run("merge", landed); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+
+	// As left by a queued merge that no longer applied cleanly
+	db, err := registry.Open()
+	if err != nil {
+		t.Fatalf("registry.Open: %v", err)
+	}
+	defer db.Close()
+	if err := db.UpdateWorktreeStatus(bounced, "conflict"); err != nil {
+		t.Fatal(err)
+	}
+
+	if out := runSync(t, env, bounced); out.Status != "synced" {
+		t.Fatalf("expected synced, got %+v", out)
+	}
+	if wt, _ := db.GetWorktree(bounced); wt.Status != "active" {
+		t.Errorf("expected a cleanly synced worktree to be active again, got %s", wt.Status)
+	}
+}
+
+func TestSyncRefusesUncommittedChanges(t *testing.T) {
+	repoPath := setupTestGitRepo(t)
+	env := newTestEnv(t)
+	env.init()
+	if _, err := env.run("add", repoPath); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+
+	wtID, wtPath := spawnWithCommit(t, env, "dirty", "a.txt")
+	writeFileInWorktree(t, wtPath, "README.md", "edited\n")
+
+	if _, err := env.run("sync", wtID); err == nil || !strings.Contains(err.Error(), "uncommitted changes") || !apperrors.IsUserError(err) {
+		t.Fatalf("expected uncommitted changes user error, got %v", err)
+	}
+}
+
+func TestSyncRefreshesFileTouches(t *testing.T) {
+	repoPath := setupTestGitRepo(t)
+	env := newTestEnv(t)
+	env.init()
+	if _, err := env.run("add", repoPath); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+
+	// landed and stale make the same change; once landed is merged, syncing
+	// stale drops its now-empty commit and its overlap with other goes away
+	commitShared := func(task, content string) (string, string) {
+		stdout, err := env.runJSON("spawn", "test-repo", "--task", task, "--agent", task+"-agent")
+		if err != nil {
+			t.Fatalf("spawn failed: %v", err)
+		}
+		wtID, wtPath := extractSpawnJSON(t, stdout)
+		writeFileInWorktree(t, wtPath, "shared.txt", content)
+		runGit(t, wtPath, "add", "shared.txt")
+		runGit(t, wtPath, "commit", "-m", task+": add shared.txt")
+		return wtID, wtPath
+	}
+	landed, _ := commitShared("landed", "same\n")
+	stale, _ := commitShared("stale", "same\n")
+	commitShared("other", "different\n")
+
+	if _, err := env.run("merge", landed); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	if _, err := env.run("conflicts", "test-repo"); err != nil {
+		t.Fatalf("conflicts failed: %v", err)
+	}
+
+	db, err := registry.Open()
+	if err != nil {
+		t.Fatalf("registry.Open: %v", err)
+	}
+	defer db.Close()
+	repo, err := db.GetRepo("test-repo")
+	if err != nil {
+		t.Fatalf("GetRepo: %v", err)
+	}
+	involves := func(wtID string) bool {
+		found, err := db.FindConflicts(repo.ID)
+		if err != nil {
+			t.Fatalf("FindConflicts: %v", err)
+		}
+		for _, c := range found {
+			for _, id := range c.Worktrees {
+				if id == wtID {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	if !involves(stale) {
+		t.Fatal("expected stale worktree to overlap before syncing")
+	}
+
+	if out := runSync(t, env, stale); out.Status != "synced" {
+		t.Fatalf("expected synced, got %+v", out)
+	}
+	if involves(stale) {
+		t.Error("expected stale overlap to disappear after syncing")
+	}
+}
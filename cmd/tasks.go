@@ -2,10 +2,12 @@ package cmd
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/spf13/cobra"
 
-	"github.com/fathindos/agit/internal/config"
+	"github.com/fathindos/agit/internal/conflicts"
 	apperrors "github.com/fathindos/agit/internal/errors"
 	"github.com/fathindos/agit/internal/hooks"
 	"github.com/fathindos/agit/internal/registry"
@@ -14,45 +16,67 @@ import (
 )
 
 type taskJSON struct {
-	ID          string `json:"id"`
-	Priority    string `json:"priority"`
-	Status      string `json:"status"`
-	Agent       string `json:"agent"`
-	Description string `json:"description"`
+	ID          string   `json:"id"`
+	Priority    string   `json:"priority"`
+	Status      string   `json:"status"`
+	Agent       string   `json:"agent"`
+	Description string   `json:"description"`
+	Scope       []string `json:"scope,omitempty"`
 }
 
 var tasksCmd = &cobra.Command{
-	Use:               "tasks <repo>",
-	Short:             "Manage tasks for a repository",
-	Long:              `Create, list, claim, and complete tasks for a repository.`,
+	Use:   "tasks <repo>",
+	Short: "Manage tasks for a repository",
+	Long: `Create, list, claim, start and complete tasks for a repository.
+
+Tasks can depend on other tasks with --depends-on; a task is only handed out
+by "agit tasks next" once all of its dependencies are completed. Use --graph
+to view the dependency graph.
+
+--scope declares the paths or globs a new task is expected to touch, e.g.
+--scope 'internal/registry/**'. "agit tasks next" hands out tasks whose scope
+overlaps work already in flight only when nothing else is available, and
+--check-overlap reports the scheduling risks ahead of time: pending tasks that
+collide with claimed or in-progress tasks, with files active worktrees have
+changed, or with each other. It also flags worktrees that changed files
+outside their task's scope.
+
+A pre.task.claim hook (see agit config) runs before --claim takes a task and
+can refuse it by exiting non-zero; its stderr is reported as the error.`,
 	Args:              cobra.ExactArgs(1),
 	ValidArgsFunction: completeRepoNames,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		repoName := args[0]
 		create, _ := cmd.Flags().GetString("create")
 		claim, _ := cmd.Flags().GetString("claim")
+		start, _ := cmd.Flags().GetString("start")
+		worktreeID, _ := cmd.Flags().GetString("worktree")
 		complete, _ := cmd.Flags().GetString("complete")
 		fail, _ := cmd.Flags().GetString("fail")
 		result, _ := cmd.Flags().GetString("result")
 		agent, _ := cmd.Flags().GetString("agent")
 		priority, _ := cmd.Flags().GetInt("priority")
+		dependsOn, _ := cmd.Flags().GetStringSlice("depends-on")
+		cascade, _ := cmd.Flags().GetBool("cascade")
+		graph, _ := cmd.Flags().GetBool("graph")
+		scope, _ := cmd.Flags().GetStringSlice("scope")
+		checkOverlap, _ := cmd.Flags().GetBool("check-overlap")
 		isInteractive, _ := cmd.Flags().GetBool("interactive")
 
-		db, err := registry.Open()
+		db, err := openRegistry()
 		if err != nil {
 			return fmt.Errorf("could not open registry: %w", err)
 		}
 		defer db.Close()
 
-		cfg, _ := config.Load()
-		hookRunner := hooks.NewRunner(cfg)
-		defer hookRunner.Wait()
-
 		repo, err := db.GetRepo(repoName)
 		if err != nil {
 			return err
 		}
 
+		cfg, _ := loadRepoConfig(repo)
+		hookRunner := hooks.NewRunner(cfg, db)
+
 		var resultPtr *string
 		if result != "" {
 			resultPtr = &result
@@ -60,14 +84,48 @@ var tasksCmd = &cobra.Command{
 
 		// Create task
 		if create != "" {
-			task, err := db.CreateTask(repo.ID, create, priority)
+			for _, depID := range dependsOn {
+				dep, err := db.GetTask(depID)
+				if err != nil {
+					return apperrors.NewUserErrorf("invalid --depends-on: %v", err)
+				}
+				if dep.RepoID != repo.ID {
+					return apperrors.NewUserErrorf("invalid --depends-on: task %q belongs to a different repository", depID)
+				}
+			}
+			for _, pattern := range scope {
+				if err := registry.ValidatePattern(pattern); err != nil {
+					return apperrors.NewUserErrorf("invalid --scope: %v", err)
+				}
+			}
+			task, err := db.CreateTaskWithDeps(repo.ID, create, priority, dependsOn, scope...)
 			if err != nil {
 				return err
 			}
+			var overlaps []registry.ScopeOverlap
+			if len(task.Scope) > 0 {
+				if overlaps, err = db.ScopeOverlapsFor(task); err != nil {
+					return err
+				}
+			}
 			if ui.IsJSON() {
-				return ui.RenderJSON(map[string]string{"status": "ok", "message": "created", "id": task.ID, "description": task.Description})
+				result := map[string]interface{}{"status": "ok", "message": "created", "id": task.ID, "description": task.Description, "depends_on": dependsOn}
+				if len(task.Scope) > 0 {
+					result["scope"] = task.Scope
+					result["overlaps"] = toScopeOverlapsJSON(overlaps)
+				}
+				return ui.RenderJSON(result)
 			}
 			ui.Success("Created task: %s - %s", task.ID, task.Description)
+			if len(dependsOn) > 0 {
+				ui.KeyValue("Depends on", strings.Join(dependsOn, ", "))
+			}
+			if len(task.Scope) > 0 {
+				ui.KeyValue("Scope", strings.Join(task.Scope, ", "))
+			}
+			for _, o := range overlaps {
+				ui.Warning("%s", describeScopeOverlap(o))
+			}
 			return nil
 		}
 
@@ -86,14 +144,16 @@ var tasksCmd = &cobra.Command{
 					return err
 				}
 			}
+			task, err := db.GetTask(claim)
+			if err != nil {
+				return err
+			}
+			if err := hookRunner.Check(hooks.PreTaskClaim, hooks.TaskEnv(repo, task, agentObj.ID, agent)); err != nil {
+				return err
+			}
 			if err := db.ClaimTask(claim, agentObj.ID); err != nil {
 				return err
 			}
-			hookRunner.Fire("task.claimed", map[string]string{
-				"AGIT_REPO":     repoName,
-				"AGIT_TASK_ID":  claim,
-				"AGIT_AGENT_ID": agent,
-			})
 			if ui.IsJSON() {
 				return ui.RenderJSON(map[string]string{"status": "ok", "message": "claimed", "task": claim, "agent": agent})
 			}
@@ -101,15 +161,30 @@ var tasksCmd = &cobra.Command{
 			return nil
 		}
 
+		// Start task
+		if start != "" {
+			if worktreeID == "" {
+				return apperrors.NewUserError("--worktree is required when starting a task")
+			}
+			wt, err := db.ResolveWorktree(repo.ID, worktreeID)
+			if err != nil {
+				return err
+			}
+			if err := db.StartTask(start, wt.ID); err != nil {
+				return err
+			}
+			if ui.IsJSON() {
+				return ui.RenderJSON(map[string]string{"status": "ok", "message": "started", "task": start, "worktree": wt.ID})
+			}
+			ui.Success("Task %s started in worktree %s", start, wt.ID[:12])
+			return nil
+		}
+
 		// Complete task
 		if complete != "" {
 			if err := db.CompleteTask(complete, resultPtr); err != nil {
 				return err
 			}
-			hookRunner.Fire("task.completed", map[string]string{
-				"AGIT_REPO":    repoName,
-				"AGIT_TASK_ID": complete,
-			})
 			if ui.IsJSON() {
 				return ui.RenderJSON(map[string]string{"status": "ok", "message": "completed", "task": complete})
 			}
@@ -122,14 +197,20 @@ var tasksCmd = &cobra.Command{
 			if err := db.FailTask(fail, resultPtr); err != nil {
 				return err
 			}
-			hookRunner.Fire("task.failed", map[string]string{
-				"AGIT_REPO":    repoName,
-				"AGIT_TASK_ID": fail,
-			})
+			var blocked []string
+			if cascade {
+				blocked, err = db.BlockDependents(fail)
+				if err != nil {
+					return err
+				}
+			}
 			if ui.IsJSON() {
-				return ui.RenderJSON(map[string]string{"status": "ok", "message": "failed", "task": fail})
+				return ui.RenderJSON(map[string]interface{}{"status": "ok", "message": "failed", "task": fail, "blocked": blocked})
 			}
 			ui.Success("Task %s marked as failed", fail)
+			if len(blocked) > 0 {
+				ui.Warning("Blocked %d dependent task(s): %s", len(blocked), strings.Join(blocked, ", "))
+			}
 			return nil
 		}
 
@@ -138,6 +219,16 @@ var tasksCmd = &cobra.Command{
 			return tasksInteractive(db, repo)
 		}
 
+		// Dependency graph
+		if graph {
+			return renderTaskGraph(db, repo)
+		}
+
+		// Scheduling risks from declared scopes
+		if checkOverlap {
+			return renderScopeCheck(db, repo)
+		}
+
 		// List tasks
 		tasks, err := db.ListTasks(repo.ID, nil)
 		if err != nil {
@@ -179,6 +270,7 @@ var tasksCmd = &cobra.Command{
 					Status:      t.Status,
 					Agent:       agentStr,
 					Description: t.Description,
+					Scope:       t.Scope,
 				})
 			}
 			return ui.RenderJSON(items)
@@ -260,11 +352,214 @@ func tasksInteractive(db *registry.DB, repo *registry.Repo) error {
 	return nil
 }
 
+type taskGraphNodeJSON struct {
+	ID          string   `json:"id"`
+	Description string   `json:"description"`
+	Status      string   `json:"status"`
+	Priority    int      `json:"priority"`
+	DependsOn   []string `json:"depends_on"`
+	Ready       bool     `json:"ready"`
+	Depth       int      `json:"depth"`
+}
+
+// renderTaskGraph prints a repo's tasks in dependency order. Each task is
+// indented by its depth (the longest chain of prerequisites below it) and
+// lists the tasks it waits on.
+func renderTaskGraph(db *registry.DB, repo *registry.Repo) error {
+	tasks, err := db.ListTasks(repo.ID, nil)
+	if err != nil {
+		return err
+	}
+	edges, err := db.ListTaskDependencies(repo.ID)
+	if err != nil {
+		return err
+	}
+
+	byID := make(map[string]*registry.Task)
+	for _, t := range tasks {
+		byID[t.ID] = t
+	}
+	deps := make(map[string][]string)
+	for _, e := range edges {
+		deps[e.TaskID] = append(deps[e.TaskID], e.DependsOnID)
+	}
+
+	// Depth is computed recursively; the registry rejects cycles, so the
+	// recursion always terminates.
+	depth := make(map[string]int)
+	var depthOf func(id string) int
+	depthOf = func(id string) int {
+		if d, ok := depth[id]; ok {
+			return d
+		}
+		d := 0
+		for _, dep := range deps[id] {
+			if dd := depthOf(dep) + 1; dd > d {
+				d = dd
+			}
+		}
+		depth[id] = d
+		return d
+	}
+
+	nodes := make([]taskGraphNodeJSON, 0, len(tasks))
+	for _, t := range tasks {
+		ready := t.Status == "pending"
+		for _, dep := range deps[t.ID] {
+			if d, ok := byID[dep]; !ok || d.Status != "completed" {
+				ready = false
+			}
+		}
+		dependsOn := deps[t.ID]
+		if dependsOn == nil {
+			dependsOn = []string{}
+		}
+		nodes = append(nodes, taskGraphNodeJSON{
+			ID:          t.ID,
+			Description: t.Description,
+			Status:      t.Status,
+			Priority:    t.Priority,
+			DependsOn:   dependsOn,
+			Ready:       ready,
+			Depth:       depthOf(t.ID),
+		})
+	}
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return nodes[i].Depth < nodes[j].Depth
+	})
+
+	if ui.IsJSON() {
+		return ui.RenderJSON(map[string]interface{}{"repo": repo.Name, "tasks": nodes})
+	}
+
+	if len(nodes) == 0 {
+		fmt.Printf("No tasks for %s.\n", repo.Name)
+		return nil
+	}
+
+	for _, n := range nodes {
+		line := fmt.Sprintf("%s%s  [%s]  %s", strings.Repeat("  ", n.Depth), n.ID, ui.StatusColor(n.Status), n.Description)
+		if len(n.DependsOn) > 0 {
+			line += ui.T.Muted("  " + ui.Sym.Arrow + " after " + strings.Join(n.DependsOn, ", "))
+		}
+		if n.Ready {
+			line += "  " + ui.T.Success("ready")
+		}
+		fmt.Println(line)
+	}
+	return nil
+}
+
+type scopeOverlapJSON struct {
+	Task        string `json:"task"`
+	Pattern     string `json:"pattern"`
+	OtherTask   string `json:"other_task,omitempty"`
+	OtherStatus string `json:"other_status,omitempty"`
+	Worktree    string `json:"worktree,omitempty"`
+	Path        string `json:"path"`
+	Changed     bool   `json:"changed,omitempty"`
+	InFlight    bool   `json:"in_flight"`
+}
+
+type scopeDriftJSON struct {
+	Task     string `json:"task"`
+	Worktree string `json:"worktree"`
+	Path     string `json:"path"`
+}
+
+func toScopeOverlapsJSON(overlaps []registry.ScopeOverlap) []scopeOverlapJSON {
+	items := make([]scopeOverlapJSON, 0, len(overlaps))
+	for _, o := range overlaps {
+		items = append(items, scopeOverlapJSON{
+			Task:        o.TaskID,
+			Pattern:     o.Pattern,
+			OtherTask:   o.OtherTaskID,
+			OtherStatus: o.OtherStatus,
+			Worktree:    o.WorktreeID,
+			Path:        o.Path,
+			Changed:     o.Changed,
+			InFlight:    o.InFlight(),
+		})
+	}
+	return items
+}
+
+// describeScopeOverlap renders an overlap as a sentence, e.g.
+// "t-1a2b3c4d (internal/**) overlaps in_progress task t-5e6f7a8b (internal/registry)"
+func describeScopeOverlap(o registry.ScopeOverlap) string {
+	subject := fmt.Sprintf("%s (%s) overlaps", o.TaskID, o.Pattern)
+	if o.Changed {
+		line := fmt.Sprintf("%s %s, changed in worktree %s", subject, o.Path, o.WorktreeID[:12])
+		if o.OtherTaskID != "" {
+			line += fmt.Sprintf(" (%s task %s)", o.OtherStatus, o.OtherTaskID)
+		}
+		return line
+	}
+	return fmt.Sprintf("%s %s task %s (%s)", subject, o.OtherStatus, o.OtherTaskID, o.Path)
+}
+
+// renderScopeCheck refreshes file touches and reports scope overlaps and
+// out-of-scope changes for a repo
+func renderScopeCheck(db *registry.DB, repo *registry.Repo) error {
+	if err := conflicts.ScanAndUpdate(db, repo); err != nil {
+		return fmt.Errorf("could not scan worktrees: %w", err)
+	}
+	overlaps, err := db.FindScopeOverlaps(repo.ID)
+	if err != nil {
+		return err
+	}
+	drift, err := db.FindScopeDrift(repo.ID)
+	if err != nil {
+		return err
+	}
+
+	if ui.IsJSON() {
+		driftItems := make([]scopeDriftJSON, 0, len(drift))
+		for _, d := range drift {
+			driftItems = append(driftItems, scopeDriftJSON{Task: d.TaskID, Worktree: d.WorktreeID, Path: d.Path})
+		}
+		return ui.RenderJSON(map[string]interface{}{
+			"repo":         repo.Name,
+			"overlaps":     toScopeOverlapsJSON(overlaps),
+			"out_of_scope": driftItems,
+		})
+	}
+
+	if len(overlaps) == 0 && len(drift) == 0 {
+		ui.Success("No scheduling risks in %s", repo.Name)
+		return nil
+	}
+	if len(overlaps) > 0 {
+		ui.Section("Scheduling risks")
+		for _, o := range overlaps {
+			line := describeScopeOverlap(o)
+			if o.InFlight() {
+				fmt.Printf("  %s %s\n", ui.T.Warning("in flight:"), line)
+			} else {
+				fmt.Printf("  %s %s\n", ui.T.Muted("pending:"), line)
+			}
+		}
+	}
+	if len(drift) > 0 {
+		ui.Section("Out of scope")
+		for _, d := range drift {
+			fmt.Printf("  %s changed %s in worktree %s\n", d.TaskID, d.Path, ui.T.Muted(d.WorktreeID[:12]))
+		}
+	}
+	return nil
+}
+
 var tasksNextCmd = &cobra.Command{
 	Use:   "next <repo>",
 	Short: "Claim the highest-priority pending task",
 	Long: `Atomically claims and returns the highest-priority pending task for the given
 repository. If multiple tasks share the highest priority, the oldest (FIFO) is chosen.
+Tasks whose dependencies are not yet completed are skipped, and tasks whose
+--scope overlaps work already in flight are passed over while any other task
+is available.
+A pre.task.claim hook (see agit config) runs with the chosen task before it is
+claimed; if it exits non-zero, the task stays pending and the hook's stderr
+is reported as the error.
 Returns nothing if no pending tasks exist.`,
 	Args:              cobra.ExactArgs(1),
 	ValidArgsFunction: completeRepoNames,
@@ -276,7 +571,7 @@ Returns nothing if no pending tasks exist.`,
 			return apperrors.NewUserError("--agent is required for tasks next")
 		}
 
-		db, err := registry.Open()
+		db, err := openRegistry()
 		if err != nil {
 			return fmt.Errorf("could not open registry: %w", err)
 		}
@@ -299,7 +594,12 @@ Returns nothing if no pending tasks exist.`,
 			}
 		}
 
-		task, err := db.NextTask(repo.ID, agentObj.ID)
+		// A pre.task.claim hook sees the chosen task and can refuse it
+		cfg, _ := loadRepoConfig(repo)
+		hookRunner := hooks.NewRunner(cfg, db)
+		task, err := db.NextTaskChecked(repo.ID, agentObj.ID, func(t *registry.Task) error {
+			return hookRunner.Check(hooks.PreTaskClaim, hooks.TaskEnv(repo, t, agentObj.ID, agent))
+		})
 		if err != nil {
 			return err
 		}
@@ -312,16 +612,6 @@ Returns nothing if no pending tasks exist.`,
 			return nil
 		}
 
-		// Fire task.claimed hook
-		cfg, _ := config.Load()
-		hookRunner := hooks.NewRunner(cfg)
-		defer hookRunner.Wait()
-		hookRunner.Fire("task.claimed", map[string]string{
-			"AGIT_REPO":    repoName,
-			"AGIT_TASK_ID": task.ID,
-			"AGIT_AGENT":   agent,
-		})
-
 		if ui.IsJSON() {
 			return ui.RenderJSON(map[string]interface{}{
 				"status":      "ok",
@@ -330,6 +620,7 @@ Returns nothing if no pending tasks exist.`,
 				"description": task.Description,
 				"priority":    task.Priority,
 				"agent":       agent,
+				"scope":       task.Scope,
 			})
 		}
 
@@ -337,6 +628,9 @@ Returns nothing if no pending tasks exist.`,
 		ui.KeyValue("Description", task.Description)
 		ui.KeyValue("Priority", fmt.Sprintf("%d", task.Priority))
 		ui.KeyValue("Agent", agent)
+		if len(task.Scope) > 0 {
+			ui.KeyValue("Scope", strings.Join(task.Scope, ", "))
+		}
 		return nil
 	},
 }
@@ -345,10 +639,17 @@ func init() {
 	tasksCmd.Flags().String("create", "", "Create a new task with this description")
 	tasksCmd.Flags().Int("priority", 0, "Task priority (0=normal, 1=high, 2=critical)")
 	tasksCmd.Flags().String("claim", "", "Claim a task by ID")
+	tasksCmd.Flags().String("start", "", "Mark a claimed task as in progress by ID")
+	tasksCmd.Flags().String("worktree", "", "Worktree the task is worked on in (required for --start)")
 	tasksCmd.Flags().String("complete", "", "Complete a task by ID")
 	tasksCmd.Flags().String("fail", "", "Fail a task by ID")
 	tasksCmd.Flags().String("result", "", "Result message (used with --complete or --fail)")
 	tasksCmd.Flags().StringP("agent", "a", "", "Agent name (required for --claim)")
+	tasksCmd.Flags().StringSlice("depends-on", nil, "Task IDs the new task depends on (used with --create)")
+	tasksCmd.Flags().Bool("cascade", false, "Mark pending dependents as blocked (used with --fail)")
+	tasksCmd.Flags().Bool("graph", false, "Show the task dependency graph")
+	tasksCmd.Flags().StringSlice("scope", nil, "Paths or globs the new task is expected to touch (used with --create)")
+	tasksCmd.Flags().Bool("check-overlap", false, "Report pending tasks whose scope overlaps other work, and changes outside a task's scope")
 
 	tasksNextCmd.Flags().StringP("agent", "a", "", "Agent name (required)")
 	tasksCmd.AddCommand(tasksNextCmd)
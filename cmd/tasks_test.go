@@ -322,6 +322,129 @@ func TestTasksFailJSON(t *testing.T) {
 	}
 }
 
+func TestTasksDependsOnAndGraph(t *testing.T) {
+	repoPath := setupTestGitRepo(t)
+	env := newTestEnv(t)
+	env.init()
+
+	_, err := env.run("add", repoPath)
+	if err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+
+	stdout, err := env.run("tasks", "test-repo", "--create", "build schema")
+	if err != nil {
+		t.Fatalf("create base failed: %v", err)
+	}
+	baseID := extractTaskID(t, stdout)
+
+	stdout, err = env.run("tasks", "test-repo", "--create", "wire api", "--priority", "2", "--depends-on", baseID)
+	if err != nil {
+		t.Fatalf("create dependent failed: %v", err)
+	}
+	depID := extractTaskID(t, stdout)
+
+	// Next must skip the higher-priority dependent
+	stdout, err = env.run("tasks", "next", "test-repo", "--agent", "graph-agent")
+	if err != nil {
+		t.Fatalf("tasks next failed: %v", err)
+	}
+	if !strings.Contains(stdout, "build schema") {
+		t.Errorf("expected dependency-free task to be claimed, got: %s", stdout)
+	}
+
+	stdout, err = env.run("tasks", "test-repo", "--graph")
+	if err != nil {
+		t.Fatalf("tasks --graph failed: %v", err)
+	}
+	if !strings.Contains(stdout, "after "+baseID) {
+		t.Errorf("expected graph to show %s waiting on %s, got: %s", depID, baseID, stdout)
+	}
+
+	stdout, err = env.runJSON("tasks", "test-repo", "--graph")
+	if err != nil {
+		t.Fatalf("tasks --graph --output json failed: %v", err)
+	}
+	if !strings.Contains(stdout, `"depends_on"`) {
+		t.Errorf("expected depends_on in JSON graph, got: %s", stdout)
+	}
+
+	// Failing the base with --cascade blocks the dependent
+	stdout, err = env.run("tasks", "test-repo", "--fail", baseID, "--cascade")
+	if err != nil {
+		t.Fatalf("fail --cascade failed: %v", err)
+	}
+	if !strings.Contains(stdout, depID) {
+		t.Errorf("expected %s to be reported as blocked, got: %s", depID, stdout)
+	}
+}
+
+func TestTasksDependsOnUnknownTask(t *testing.T) {
+	repoPath := setupTestGitRepo(t)
+	env := newTestEnv(t)
+	env.init()
+
+	_, err := env.run("add", repoPath)
+	if err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+
+	_, err = env.run("tasks", "test-repo", "--create", "orphan", "--depends-on", "t-missing0")
+	if err == nil {
+		t.Error("expected error for unknown dependency")
+	}
+}
+
+func TestTasksScopeCheckOverlap(t *testing.T) {
+	repoPath := setupTestGitRepo(t)
+	env := newTestEnv(t)
+	env.init()
+
+	_, err := env.run("add", repoPath)
+	if err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+
+	stdout, err := env.run("tasks", "test-repo", "--create", "rework api", "--scope", "api/**")
+	if err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+	runningID := extractTaskID(t, stdout)
+	if _, err := env.run("tasks", "test-repo", "--claim", runningID, "--agent", "scope-agent"); err != nil {
+		t.Fatalf("claim failed: %v", err)
+	}
+
+	// Creating an overlapping task warns straight away
+	stdout, err = env.run("tasks", "test-repo", "--create", "fix handler", "--scope", "api/handler.go")
+	if err != nil {
+		t.Fatalf("create overlapping failed: %v", err)
+	}
+	riskyID := extractTaskID(t, stdout)
+	if !strings.Contains(stdout, "claimed task "+runningID) {
+		t.Errorf("expected overlap warning against %s, got: %s", runningID, stdout)
+	}
+
+	stdout, err = env.runJSON("tasks", "test-repo", "--check-overlap")
+	if err != nil {
+		t.Fatalf("tasks --check-overlap failed: %v", err)
+	}
+	if !strings.Contains(stdout, `"task": "`+riskyID+`"`) || !strings.Contains(stdout, `"in_flight": true`) {
+		t.Errorf("expected in-flight overlap for %s, got: %s", riskyID, stdout)
+	}
+
+	stdout, err = env.run("tasks", "test-repo", "--check-overlap")
+	if err != nil {
+		t.Fatalf("tasks --check-overlap failed: %v", err)
+	}
+	if !strings.Contains(stdout, "SCHEDULING RISKS") || !strings.Contains(stdout, riskyID) {
+		t.Errorf("expected scheduling risks, got: %s", stdout)
+	}
+
+	if _, err := env.run("tasks", "test-repo", "--create", "bad", "--scope", "../outside"); err == nil {
+		t.Error("expected error for a scope outside the repo")
+	}
+}
+
 // extractTaskID parses the task ID from "Created task: t-xxxxxxxx - description" output.
 func extractTaskID(t *testing.T, output string) string {
 	t.Helper()
@@ -55,7 +55,13 @@ func captureStdout(fn func()) string {
 // resetAllFlags resets all flags on a command and its subcommands to their defaults.
 func resetAllFlags(cmd *cobra.Command) {
 	cmd.Flags().VisitAll(func(f *pflag.Flag) {
-		f.Value.Set(f.DefValue)
+		// Slice flags render their default as "[]", which Set would
+		// parse as a literal element; clear them instead.
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			sv.Replace(nil)
+		} else {
+			f.Value.Set(f.DefValue)
+		}
 		f.Changed = false
 	})
 	for _, sub := range cmd.Commands() {
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -13,13 +14,102 @@ import (
 
 // Config represents the agit configuration file (~/.agit/config.toml)
 type Config struct {
-	Server      ServerConfig      `toml:"server"`
-	Defaults    DefaultsConfig    `toml:"defaults"`
-	Agent       AgentConfig       `toml:"agent"`
-	UI          UIConfig          `toml:"ui"`
-	Updates     UpdatesConfig     `toml:"updates"`
-	Hooks       map[string]string `toml:"hooks,omitempty"`
-	HookTimeout string            `toml:"hook_timeout,omitempty"`
+	Server      ServerConfig        `toml:"server"`
+	Defaults    DefaultsConfig      `toml:"defaults"`
+	Agent       AgentConfig         `toml:"agent"`
+	UI          UIConfig            `toml:"ui"`
+	Updates     UpdatesConfig       `toml:"updates"`
+	Hooks       map[string][]string `toml:"hooks,omitempty"` // commands per event, run in order
+	HookTimeout string              `toml:"hook_timeout,omitempty"`
+	Webhooks    map[string]Webhook  `toml:"webhooks,omitempty"`
+}
+
+// configFile is a config file as written on disk, where an event's hook is a
+// single command string or an array of commands
+type configFile struct {
+	*Config
+	Hooks map[string]any `toml:"hooks,omitempty"`
+}
+
+// hookCommands reads a hook entry from a config file: a command or an array
+// of commands. Empty commands are dropped.
+func hookCommands(event string, value any) ([]string, error) {
+	var commands []string
+	switch v := value.(type) {
+	case string:
+		commands = []string{v}
+	case []any:
+		for _, item := range v {
+			command, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("hooks.%s: commands must be strings", event)
+			}
+			commands = append(commands, command)
+		}
+	default:
+		return nil, fmt.Errorf("hooks.%s must be a command or an array of commands", event)
+	}
+	kept := commands[:0]
+	for _, command := range commands {
+		if strings.TrimSpace(command) != "" {
+			kept = append(kept, command)
+		}
+	}
+	return kept, nil
+}
+
+// setHook replaces the commands run for an event; none removes the hook
+func (c *Config) setHook(event string, commands []string) {
+	if len(commands) == 0 {
+		delete(c.Hooks, event)
+		return
+	}
+	if c.Hooks == nil {
+		c.Hooks = make(map[string][]string)
+	}
+	c.Hooks[event] = commands
+}
+
+// Webhook posts hook events to an HTTP endpoint as JSON, configured as
+// [webhooks.<name>]. Deliveries that fail are retried with exponential
+// backoff, starting at Backoff, until Attempts tries have been made.
+type Webhook struct {
+	URL      string            `toml:"url"`
+	Events   []string          `toml:"events,omitempty"`   // event names; empty or "*" for every event
+	Headers  map[string]string `toml:"headers,omitempty"`  // extra request headers
+	Secret   string            `toml:"secret,omitempty"`   // signs each body with HMAC-SHA256
+	Attempts int               `toml:"attempts,omitempty"` // default: 3
+	Backoff  string            `toml:"backoff,omitempty"`  // default: "1s"
+}
+
+// Wants reports whether the webhook subscribes to an event
+func (w Webhook) Wants(event string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == event || e == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// MaxAttempts returns the number of delivery attempts, defaulting to 3
+func (w Webhook) MaxAttempts() int {
+	if w.Attempts > 0 {
+		return w.Attempts
+	}
+	return 3
+}
+
+// InitialBackoff returns the wait before the first retry, defaulting to 1s.
+// Each later retry waits twice as long as the one before.
+func (w Webhook) InitialBackoff() time.Duration {
+	if d, err := time.ParseDuration(w.Backoff); err == nil && d > 0 {
+		return d
+	}
+	return time.Second
 }
 
 // UpdatesConfig controls automatic update checking.
@@ -36,20 +126,25 @@ type UIConfig struct {
 }
 
 type ServerConfig struct {
-	Transport string `toml:"transport"` // "stdio" or "sse"
+	Transport string `toml:"transport"` // "stdio", "sse" or "http"
+	Host      string `toml:"host"`      // interface to listen on; http allows non-loopback
 	Port      int    `toml:"port"`
 }
 
 type DefaultsConfig struct {
-	BranchPrefix      string `toml:"branch_prefix"`
-	WorktreeDir       string `toml:"worktree_dir"`
-	CleanupStaleAfter string `toml:"cleanup_stale_after"`
-	AutoConflictCheck bool   `toml:"auto_conflict_check"`
+	BranchPrefix         string `toml:"branch_prefix"`
+	WorktreeDir          string `toml:"worktree_dir"`
+	CleanupStaleAfter    string `toml:"cleanup_stale_after"`
+	AutoConflictCheck    bool   `toml:"auto_conflict_check"`
+	ConflictContextLines int    `toml:"conflict_context_lines"`
+	Watch                bool   `toml:"watch"`          // watch worktrees for edits while the daemon runs
+	WatchDebounce        string `toml:"watch_debounce"` // quiet period before a changed worktree is rescanned
 }
 
 type AgentConfig struct {
 	HeartbeatInterval string `toml:"heartbeat_interval"`
 	StaleAfter        string `toml:"stale_after"`
+	MaxTaskAttempts   int    `toml:"max_task_attempts"`
 }
 
 // DefaultConfig returns the default configuration
@@ -57,17 +152,22 @@ func DefaultConfig() *Config {
 	return &Config{
 		Server: ServerConfig{
 			Transport: "stdio",
+			Host:      "127.0.0.1",
 			Port:      3847,
 		},
 		Defaults: DefaultsConfig{
-			BranchPrefix:      "agit/",
-			WorktreeDir:       ".worktrees",
-			CleanupStaleAfter: "24h",
-			AutoConflictCheck: true,
+			BranchPrefix:         "agit/",
+			WorktreeDir:          ".worktrees",
+			CleanupStaleAfter:    "24h",
+			AutoConflictCheck:    true,
+			ConflictContextLines: 3,
+			Watch:                true,
+			WatchDebounce:        "500ms",
 		},
 		Agent: AgentConfig{
 			HeartbeatInterval: "30s",
 			StaleAfter:        "5m",
+			MaxTaskAttempts:   3,
 		},
 		Updates: UpdatesConfig{
 			Enabled:       true,
@@ -77,13 +177,44 @@ func DefaultConfig() *Config {
 	}
 }
 
-// AgitDir returns the path to the agit config directory (~/.agit/)
+// home and profile are set from the --home and --profile flags; when empty,
+// AGIT_HOME and AGIT_PROFILE are consulted instead
+var home, profile string
+
+// SetHome relocates the agit directory for this process, overriding
+// AGIT_HOME. An empty path restores the default.
+func SetHome(path string) {
+	home = path
+}
+
+// SetProfile selects a named profile for this process, overriding
+// AGIT_PROFILE. An empty name restores the default.
+func SetProfile(name string) {
+	profile = name
+}
+
+// Profile returns the name of the active profile, or "" if none is selected
+func Profile() string {
+	if profile != "" {
+		return profile
+	}
+	return os.Getenv("AGIT_PROFILE")
+}
+
+// AgitDir returns the path to the agit directory: --home, then AGIT_HOME,
+// then ~/.agit/
 func AgitDir() (string, error) {
-	home, err := os.UserHomeDir()
+	if home != "" {
+		return home, nil
+	}
+	if dir := os.Getenv("AGIT_HOME"); dir != "" {
+		return dir, nil
+	}
+	userHome, err := os.UserHomeDir()
 	if err != nil {
 		return "", fmt.Errorf("could not determine home directory: %w", err)
 	}
-	return filepath.Join(home, ".agit"), nil
+	return filepath.Join(userHome, ".agit"), nil
 }
 
 // ConfigPath returns the path to the config file
@@ -95,6 +226,18 @@ func ConfigPath() (string, error) {
 	return filepath.Join(dir, "config.toml"), nil
 }
 
+// ProfilePath returns the path to a named profile's settings file
+func ProfilePath(name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("invalid profile name %q", name)
+	}
+	dir, err := AgitDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "profiles", name+".toml"), nil
+}
+
 // DBPath returns the path to the SQLite database
 func DBPath() (string, error) {
 	dir, err := AgitDir()
@@ -104,8 +247,23 @@ func DBPath() (string, error) {
 	return filepath.Join(dir, "agit.db"), nil
 }
 
-// Load reads the config from disk. Returns default config if file doesn't exist.
+// EnvVar returns the environment variable that overrides a config key, e.g.
+// AGIT_DEFAULTS_BRANCH_PREFIX for defaults.branch_prefix
+func EnvVar(key string) string {
+	return "AGIT_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// Load reads the config in effect outside any repository: the defaults,
+// then the config file, then the active profile, then AGIT_* environment
+// variables. A missing config file leaves the defaults in place.
 func Load() (*Config, error) {
+	cfg, _, err := load()
+	return cfg, err
+}
+
+// LoadGlobal reads the config file alone, over the defaults, ignoring
+// profiles and environment variables. It is what Save should be given.
+func LoadGlobal() (*Config, error) {
 	path, err := ConfigPath()
 	if err != nil {
 		return nil, err
@@ -121,13 +279,277 @@ func Load() (*Config, error) {
 		return nil, fmt.Errorf("could not read config: %w", err)
 	}
 
-	if err := toml.Unmarshal(data, cfg); err != nil {
+	file := configFile{Config: cfg}
+	if err := toml.Unmarshal(data, &file); err != nil {
 		return nil, fmt.Errorf("could not parse config: %w", err)
 	}
+	for event, value := range file.Hooks {
+		commands, err := hookCommands(event, value)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse config: %w", err)
+		}
+		cfg.setHook(event, commands)
+	}
+
+	return cfg, nil
+}
+
+func load() (*Config, map[string]string, error) {
+	cfg, err := LoadGlobal()
+	if err != nil {
+		return nil, nil, err
+	}
+	sources := make(map[string]string)
+	for _, key := range AllKeys() {
+		sources[key] = SourceDefault
+	}
+
+	path, err := ConfigPath()
+	if err != nil {
+		return nil, nil, err
+	}
+	global, err := readLayer(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	for key := range global {
+		if isKey(key) {
+			sources[key] = SourceGlobal
+		}
+	}
+
+	if name := Profile(); name != "" {
+		path, err := ProfilePath(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return nil, nil, fmt.Errorf("profile %q not found; create it with agit --profile %s config set <key> <value>", name, name)
+		}
+		layer, err := readLayer(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := cfg.apply(layer, SourceProfile+" "+name, sources); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	if err := cfg.applyEnv(sources); err != nil {
+		return nil, nil, err
+	}
+	return cfg, sources, nil
+}
+
+// applyEnv sets every config key that has an AGIT_* environment variable
+func (c *Config) applyEnv(sources map[string]string) error {
+	for _, key := range AllKeys() {
+		name := EnvVar(key)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := c.SetByDotKey(key, value); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		sources[key] = SourceEnv + " " + name
+	}
+	return nil
+}
+
+// RepoFile is the repo-local config file at the root of a repository's main
+// checkout. Its settings are layered over the global config for that repo.
+const RepoFile = ".agit.toml"
+
+// Config layers, lowest precedence first, as reported by LoadLayers. Profile
+// and environment sources are followed by the profile or variable name.
+const (
+	SourceDefault  = "default"
+	SourceGlobal   = "global"
+	SourceProfile  = "profile"
+	SourceRepoFile = "repo file"
+	SourceRepo     = "repo setting"
+	SourceEnv      = "env"
+)
+
+// LoadForRepo returns the config in effect for a repository: Load's layers,
+// with the repo's .agit.toml and then overrides stored on the repo in the
+// registry applied before the environment variables.
+func LoadForRepo(repoPath string, overrides map[string]string) (*Config, error) {
+	cfg, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	return cfg.ForRepo(repoPath, overrides)
+}
 
+// ForRepo returns a copy of c with a repository's .agit.toml and registry
+// overrides layered on top. AGIT_* environment variables still take
+// precedence.
+func (c *Config) ForRepo(repoPath string, overrides map[string]string) (*Config, error) {
+	cfg := c.clone()
+	if err := cfg.layerRepo(repoPath, overrides, make(map[string]string)); err != nil {
+		return nil, err
+	}
 	return cfg, nil
 }
 
+// LoadLayers is LoadForRepo that also reports which layer each key's value
+// came from. Every key in AllKeys is present in the sources, as are any
+// hooks.<event> keys that are set. An empty repoPath and no overrides give
+// the layers of Load.
+func LoadLayers(repoPath string, overrides map[string]string) (*Config, map[string]string, error) {
+	cfg, sources, err := load()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := cfg.layerRepo(repoPath, overrides, sources); err != nil {
+		return nil, nil, err
+	}
+	return cfg, sources, nil
+}
+
+func (c *Config) layerRepo(repoPath string, overrides map[string]string, sources map[string]string) error {
+	if repoPath != "" {
+		path := filepath.Join(repoPath, RepoFile)
+		repoFile, err := readLayer(path)
+		if err != nil {
+			return err
+		}
+		if err := c.apply(repoFile, SourceRepoFile, sources); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	layer := make(map[string]any, len(overrides))
+	for key, value := range overrides {
+		layer[key] = value
+	}
+	if err := c.apply(layer, SourceRepo, sources); err != nil {
+		return fmt.Errorf("repo setting: %w", err)
+	}
+	if err := c.applyEnv(sources); err != nil {
+		return err
+	}
+	return c.Validate()
+}
+
+func (c *Config) clone() *Config {
+	cfg := *c
+	if c.Hooks != nil {
+		cfg.Hooks = make(map[string][]string, len(c.Hooks))
+		for event, commands := range c.Hooks {
+			cfg.Hooks[event] = append([]string(nil), commands...)
+		}
+	}
+	if c.Webhooks != nil {
+		cfg.Webhooks = make(map[string]Webhook, len(c.Webhooks))
+		for name, w := range c.Webhooks {
+			w.Events = append([]string(nil), w.Events...)
+			if w.Headers != nil {
+				headers := make(map[string]string, len(w.Headers))
+				for k, v := range w.Headers {
+					headers[k] = v
+				}
+				w.Headers = headers
+			}
+			cfg.Webhooks[name] = w
+		}
+	}
+	return &cfg
+}
+
+// readLayer reads a TOML file as flattened dot-notation keys. A missing file
+// is an empty layer.
+func readLayer(path string) (map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not read config: %w", err)
+	}
+	var tree map[string]any
+	if err := toml.Unmarshal(data, &tree); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", path, err)
+	}
+	flat := make(map[string]any)
+	flatten("", tree, flat)
+	return flat, nil
+}
+
+func flatten(prefix string, tree map[string]any, out map[string]any) {
+	for k, v := range tree {
+		if sub, ok := v.(map[string]any); ok {
+			flatten(prefix+k+".", sub, out)
+			continue
+		}
+		out[prefix+k] = v
+	}
+}
+
+// apply sets the config keys in a layer, ignoring keys that are not config
+// settings (a repo's .agit.toml also holds its setup recipe)
+func (c *Config) apply(layer map[string]any, source string, sources map[string]string) error {
+	keys := make([]string, 0, len(layer))
+	for key := range layer {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !isKey(key) {
+			continue
+		}
+		if event, ok := strings.CutPrefix(key, "hooks."); ok {
+			commands, err := hookCommands(event, layer[key])
+			if err != nil {
+				return err
+			}
+			c.setHook(event, commands)
+			sources[key] = source
+			continue
+		}
+		var value string
+		switch v := layer[key].(type) {
+		case string:
+			value = v
+		case int64:
+			value = strconv.FormatInt(v, 10)
+		case bool:
+			value = strconv.FormatBool(v)
+		case []any:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			value = strings.Join(items, ",")
+		default:
+			value = fmt.Sprint(v)
+		}
+		if err := c.SetByDotKey(key, value); err != nil {
+			return err
+		}
+		sources[key] = source
+	}
+	return nil
+}
+
+// isKey reports whether key is a dot-notation config key
+func isKey(key string) bool {
+	if strings.HasPrefix(key, "hooks.") {
+		return len(key) > len("hooks.")
+	}
+	if _, _, ok := webhookKey(key); ok {
+		return true
+	}
+	for _, k := range AllKeys() {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
 // Save writes the config to disk
 func Save(cfg *Config) error {
 	path, err := ConfigPath()
@@ -135,7 +557,21 @@ func Save(cfg *Config) error {
 		return err
 	}
 
-	data, err := toml.Marshal(cfg)
+	// Events with a single command keep the plain string form
+	plain := *cfg
+	plain.Hooks = nil
+	file := configFile{Config: &plain}
+	if len(cfg.Hooks) > 0 {
+		file.Hooks = make(map[string]any, len(cfg.Hooks))
+		for event, commands := range cfg.Hooks {
+			if len(commands) == 1 {
+				file.Hooks[event] = commands[0]
+			} else {
+				file.Hooks[event] = commands
+			}
+		}
+	}
+	data, err := toml.Marshal(file)
 	if err != nil {
 		return fmt.Errorf("could not marshal config: %w", err)
 	}
@@ -143,13 +579,58 @@ func Save(cfg *Config) error {
 	return os.WriteFile(path, data, 0644)
 }
 
+// SetProfileValue stores one dot-notation key in a profile's settings file,
+// creating the profile if needed. Only keys set this way are written, so the
+// profile keeps overriding just what it names. An empty value removes the key.
+func SetProfileValue(name, key, value string) error {
+	path, err := ProfilePath(name)
+	if err != nil {
+		return err
+	}
+	tree := make(map[string]any)
+	if data, err := os.ReadFile(path); err == nil {
+		if err := toml.Unmarshal(data, &tree); err != nil {
+			return fmt.Errorf("could not parse %s: %w", path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("could not read profile: %w", err)
+	}
+
+	table, field := tree, key
+	if section, rest, ok := strings.Cut(key, "."); ok {
+		sub, _ := tree[section].(map[string]any)
+		if sub == nil {
+			sub = make(map[string]any)
+			tree[section] = sub
+		}
+		table, field = sub, rest
+	}
+	if value == "" {
+		delete(table, field)
+	} else {
+		table[field] = value
+	}
+
+	data, err := toml.Marshal(tree)
+	if err != nil {
+		return fmt.Errorf("could not marshal profile: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("could not create profiles directory: %w", err)
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
 // Validate checks that all configuration values are within valid ranges.
 func (c *Config) Validate() error {
 	// Server
 	switch c.Server.Transport {
-	case "stdio", "sse":
+	case "stdio", "sse", "http":
 	default:
-		return fmt.Errorf("invalid server.transport %q: must be stdio or sse", c.Server.Transport)
+		return fmt.Errorf("invalid server.transport %q: must be stdio, sse or http", c.Server.Transport)
+	}
+	if c.Server.Host == "" {
+		return fmt.Errorf("invalid server.host: must not be empty")
 	}
 	if c.Server.Port < 1 || c.Server.Port > 65535 {
 		return fmt.Errorf("invalid server.port %d: must be 1-65535", c.Server.Port)
@@ -160,6 +641,7 @@ func (c *Config) Validate() error {
 		key, val string
 	}{
 		{"defaults.cleanup_stale_after", c.Defaults.CleanupStaleAfter},
+		{"defaults.watch_debounce", c.Defaults.WatchDebounce},
 		{"agent.heartbeat_interval", c.Agent.HeartbeatInterval},
 		{"agent.stale_after", c.Agent.StaleAfter},
 		{"updates.check_interval", c.Updates.CheckInterval},
@@ -170,6 +652,27 @@ func (c *Config) Validate() error {
 		}
 	}
 
+	for name, w := range c.Webhooks {
+		if !strings.HasPrefix(w.URL, "http://") && !strings.HasPrefix(w.URL, "https://") {
+			return fmt.Errorf("invalid webhooks.%s.url %q: must be an http or https URL", name, w.URL)
+		}
+		if w.Attempts < 0 {
+			return fmt.Errorf("invalid webhooks.%s.attempts %d: must be 1 or greater", name, w.Attempts)
+		}
+		if w.Backoff != "" {
+			if _, err := time.ParseDuration(w.Backoff); err != nil {
+				return fmt.Errorf("invalid webhooks.%s.backoff %q: %w", name, w.Backoff, err)
+			}
+		}
+	}
+
+	if c.Defaults.ConflictContextLines < 0 {
+		return fmt.Errorf("invalid defaults.conflict_context_lines %d: must be 0 or greater", c.Defaults.ConflictContextLines)
+	}
+	if c.Agent.MaxTaskAttempts < 0 {
+		return fmt.Errorf("invalid agent.max_task_attempts %d: must be 0 (unlimited) or greater", c.Agent.MaxTaskAttempts)
+	}
+
 	// UI
 	switch c.UI.Color {
 	case "", "auto", "always", "never":
@@ -189,13 +692,18 @@ func (c *Config) Validate() error {
 func AllKeys() []string {
 	return []string{
 		"server.transport",
+		"server.host",
 		"server.port",
 		"defaults.branch_prefix",
 		"defaults.worktree_dir",
 		"defaults.cleanup_stale_after",
 		"defaults.auto_conflict_check",
+		"defaults.conflict_context_lines",
+		"defaults.watch",
+		"defaults.watch_debounce",
 		"agent.heartbeat_interval",
 		"agent.stale_after",
+		"agent.max_task_attempts",
 		"ui.color",
 		"ui.output_format",
 		"ui.compact",
@@ -205,11 +713,14 @@ func AllKeys() []string {
 	}
 }
 
-// SetByDotKey sets a configuration value by dot-notation key.
+// SetByDotKey sets a configuration value by dot-notation key. A hooks.<event>
+// key takes a single command; an empty value removes the hook.
 func (c *Config) SetByDotKey(key, value string) error {
 	switch key {
 	case "server.transport":
 		c.Server.Transport = value
+	case "server.host":
+		c.Server.Host = value
 	case "server.port":
 		v, err := strconv.Atoi(value)
 		if err != nil {
@@ -228,10 +739,30 @@ func (c *Config) SetByDotKey(key, value string) error {
 			return fmt.Errorf("invalid value for defaults.auto_conflict_check: %w", err)
 		}
 		c.Defaults.AutoConflictCheck = v
+	case "defaults.conflict_context_lines":
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid value for defaults.conflict_context_lines: %w", err)
+		}
+		c.Defaults.ConflictContextLines = v
+	case "defaults.watch":
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid value for defaults.watch: %w", err)
+		}
+		c.Defaults.Watch = v
+	case "defaults.watch_debounce":
+		c.Defaults.WatchDebounce = value
 	case "agent.heartbeat_interval":
 		c.Agent.HeartbeatInterval = value
 	case "agent.stale_after":
 		c.Agent.StaleAfter = value
+	case "agent.max_task_attempts":
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid value for agent.max_task_attempts: %w", err)
+		}
+		c.Agent.MaxTaskAttempts = v
 	case "ui.color":
 		c.UI.Color = value
 	case "ui.output_format":
@@ -253,19 +784,20 @@ func (c *Config) SetByDotKey(key, value string) error {
 	case "hook_timeout":
 		c.HookTimeout = value
 	default:
+		if name, field, ok := webhookKey(key); ok {
+			return c.setWebhook(name, field, value)
+		}
 		if strings.HasPrefix(key, "hooks.") {
 			event := strings.TrimPrefix(key, "hooks.")
 			if event == "" {
 				return fmt.Errorf("invalid hooks key: event name required")
 			}
-			if c.Hooks == nil {
-				c.Hooks = make(map[string]string)
-			}
-			if value == "" {
-				delete(c.Hooks, event)
-			} else {
-				c.Hooks[event] = value
+			// A single command replaces any list from the config file
+			var commands []string
+			if value != "" {
+				commands = []string{value}
 			}
+			c.setHook(event, commands)
 			return nil
 		}
 		return fmt.Errorf("unknown config key %q", key)
@@ -274,10 +806,13 @@ func (c *Config) SetByDotKey(key, value string) error {
 }
 
 // GetByDotKey returns the current value for a dot-notation key as a string.
+// A hook with several commands is returned one command per line.
 func (c *Config) GetByDotKey(key string) (string, error) {
 	switch key {
 	case "server.transport":
 		return c.Server.Transport, nil
+	case "server.host":
+		return c.Server.Host, nil
 	case "server.port":
 		return strconv.Itoa(c.Server.Port), nil
 	case "defaults.branch_prefix":
@@ -288,10 +823,18 @@ func (c *Config) GetByDotKey(key string) (string, error) {
 		return c.Defaults.CleanupStaleAfter, nil
 	case "defaults.auto_conflict_check":
 		return strconv.FormatBool(c.Defaults.AutoConflictCheck), nil
+	case "defaults.conflict_context_lines":
+		return strconv.Itoa(c.Defaults.ConflictContextLines), nil
+	case "defaults.watch":
+		return strconv.FormatBool(c.Defaults.Watch), nil
+	case "defaults.watch_debounce":
+		return c.Defaults.WatchDebounce, nil
 	case "agent.heartbeat_interval":
 		return c.Agent.HeartbeatInterval, nil
 	case "agent.stale_after":
 		return c.Agent.StaleAfter, nil
+	case "agent.max_task_attempts":
+		return strconv.Itoa(c.Agent.MaxTaskAttempts), nil
 	case "ui.color":
 		return c.UI.Color, nil
 	case "ui.output_format":
@@ -305,17 +848,189 @@ func (c *Config) GetByDotKey(key string) (string, error) {
 	case "hook_timeout":
 		return c.HookTimeout, nil
 	default:
+		if name, field, ok := webhookKey(key); ok {
+			return c.webhookValue(name, field), nil
+		}
 		if strings.HasPrefix(key, "hooks.") {
 			event := strings.TrimPrefix(key, "hooks.")
-			if c.Hooks != nil {
-				return c.Hooks[event], nil
-			}
-			return "", nil
+			return strings.Join(c.Hooks[event], "\n"), nil
 		}
 		return "", fmt.Errorf("unknown config key %q", key)
 	}
 }
 
+// WebhookKeys returns the dot-notation keys of every webhook setting that is
+// set, sorted
+func (c *Config) WebhookKeys() []string {
+	var keys []string
+	for name, w := range c.Webhooks {
+		prefix := "webhooks." + name + "."
+		for _, field := range []string{"url", "events", "secret", "attempts", "backoff"} {
+			if c.webhookValue(name, field) != "" {
+				keys = append(keys, prefix+field)
+			}
+		}
+		for header := range w.Headers {
+			keys = append(keys, prefix+"headers."+header)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// MaskedValue stands in for a credential when the config is displayed
+const MaskedValue = "********"
+
+// IsSecretKey reports whether a key holds a credential that should not be
+// displayed: a webhook's secret, or a header such as Authorization or one
+// named *-Token or *-Key
+func IsSecretKey(key string) bool {
+	_, field, ok := webhookKey(key)
+	if !ok {
+		return false
+	}
+	if field == "secret" {
+		return true
+	}
+	header, ok := strings.CutPrefix(field, "headers.")
+	return ok && isSecretHeader(header)
+}
+
+func isSecretHeader(name string) bool {
+	name = strings.ToLower(name)
+	switch name {
+	case "authorization", "proxy-authorization", "cookie":
+		return true
+	}
+	for _, suffix := range []string{"-token", "-key", "-secret"} {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redacted returns a copy of the config for display, with webhook secrets
+// and credential headers replaced by MaskedValue
+func (c *Config) Redacted() *Config {
+	r := *c
+	if c.Webhooks == nil {
+		return &r
+	}
+	r.Webhooks = make(map[string]Webhook, len(c.Webhooks))
+	for name, w := range c.Webhooks {
+		if w.Secret != "" {
+			w.Secret = MaskedValue
+		}
+		if w.Headers != nil {
+			headers := make(map[string]string, len(w.Headers))
+			for header, value := range w.Headers {
+				if isSecretHeader(header) {
+					value = MaskedValue
+				}
+				headers[header] = value
+			}
+			w.Headers = headers
+		}
+		r.Webhooks[name] = w
+	}
+	return &r
+}
+
+// webhookKey splits a webhooks.<name>.<field> key. Headers are set with
+// webhooks.<name>.headers.<Header-Name>.
+func webhookKey(key string) (name, field string, ok bool) {
+	rest, found := strings.CutPrefix(key, "webhooks.")
+	if !found {
+		return "", "", false
+	}
+	name, field, found = strings.Cut(rest, ".")
+	if !found || name == "" {
+		return "", "", false
+	}
+	switch field {
+	case "url", "events", "secret", "attempts", "backoff":
+		return name, field, true
+	}
+	if header, found := strings.CutPrefix(field, "headers."); found && header != "" {
+		return name, field, true
+	}
+	return "", "", false
+}
+
+// setWebhook sets one field of a webhook, creating it if needed. Events are
+// comma-separated. Clearing the url removes the whole webhook.
+func (c *Config) setWebhook(name, field, value string) error {
+	if c.Webhooks == nil {
+		c.Webhooks = make(map[string]Webhook)
+	}
+	w := c.Webhooks[name]
+	switch field {
+	case "url":
+		if value == "" {
+			delete(c.Webhooks, name)
+			return nil
+		}
+		w.URL = value
+	case "events":
+		w.Events = nil
+		for _, e := range strings.Split(value, ",") {
+			if e = strings.TrimSpace(e); e != "" {
+				w.Events = append(w.Events, e)
+			}
+		}
+	case "secret":
+		w.Secret = value
+	case "attempts":
+		w.Attempts = 0
+		if value != "" {
+			v, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid value for webhooks.%s.attempts: %w", name, err)
+			}
+			w.Attempts = v
+		}
+	case "backoff":
+		w.Backoff = value
+	default:
+		header := strings.TrimPrefix(field, "headers.")
+		if value == "" {
+			delete(w.Headers, header)
+		} else {
+			if w.Headers == nil {
+				w.Headers = make(map[string]string)
+			}
+			w.Headers[header] = value
+		}
+	}
+	c.Webhooks[name] = w
+	return nil
+}
+
+func (c *Config) webhookValue(name, field string) string {
+	w, ok := c.Webhooks[name]
+	if !ok {
+		return ""
+	}
+	switch field {
+	case "url":
+		return w.URL
+	case "events":
+		return strings.Join(w.Events, ",")
+	case "secret":
+		return w.Secret
+	case "attempts":
+		if w.Attempts == 0 {
+			return ""
+		}
+		return strconv.Itoa(w.Attempts)
+	case "backoff":
+		return w.Backoff
+	default:
+		return w.Headers[strings.TrimPrefix(field, "headers.")]
+	}
+}
+
 // EnsureDir creates the agit directory if it doesn't exist
 func EnsureDir() error {
 	dir, err := AgitDir()
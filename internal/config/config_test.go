@@ -3,7 +3,9 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestDefaultConfig(t *testing.T) {
@@ -32,6 +34,9 @@ func TestDefaultConfig(t *testing.T) {
 	if cfg.Agent.StaleAfter != "5m" {
 		t.Errorf("expected stale_after 5m, got %s", cfg.Agent.StaleAfter)
 	}
+	if cfg.Agent.MaxTaskAttempts != 3 {
+		t.Errorf("expected max_task_attempts 3, got %d", cfg.Agent.MaxTaskAttempts)
+	}
 	if !cfg.Updates.Enabled {
 		t.Error("expected updates.enabled true")
 	}
@@ -80,6 +85,62 @@ func TestLoadExistingFile(t *testing.T) {
 	}
 }
 
+func TestLoadLayers(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	dir := filepath.Join(home, ".agit")
+	os.MkdirAll(dir, 0755)
+	global := "[defaults]\nbranch_prefix = \"team/\"\nconflict_context_lines = 5\n[hooks]\n\"task.claimed\" = \"echo global\"\n"
+	os.WriteFile(filepath.Join(dir, "config.toml"), []byte(global), 0644)
+
+	repo := t.TempDir()
+	local := "[defaults]\nworktree_dir = \".agents\"\nconflict_context_lines = 1\n[hooks]\n\"worktree.created\" = \"make bootstrap\"\n[setup]\ncommands = [\"npm ci\"]\n"
+	os.WriteFile(filepath.Join(repo, RepoFile), []byte(local), 0644)
+
+	cfg, sources, err := LoadLayers(repo, map[string]string{"defaults.conflict_context_lines": "0"})
+	if err != nil {
+		t.Fatalf("LoadLayers: %v", err)
+	}
+	if cfg.Defaults.BranchPrefix != "team/" || sources["defaults.branch_prefix"] != SourceGlobal {
+		t.Errorf("expected branch_prefix from global, got %q (%s)", cfg.Defaults.BranchPrefix, sources["defaults.branch_prefix"])
+	}
+	if cfg.Defaults.WorktreeDir != ".agents" || sources["defaults.worktree_dir"] != SourceRepoFile {
+		t.Errorf("expected worktree_dir from repo file, got %q (%s)", cfg.Defaults.WorktreeDir, sources["defaults.worktree_dir"])
+	}
+	if cfg.Defaults.ConflictContextLines != 0 || sources["defaults.conflict_context_lines"] != SourceRepo {
+		t.Errorf("expected repo setting to win, got %d (%s)", cfg.Defaults.ConflictContextLines, sources["defaults.conflict_context_lines"])
+	}
+	if sources["server.port"] != SourceDefault {
+		t.Errorf("expected server.port from default, got %s", sources["server.port"])
+	}
+	if cfg.Hooks["task.claimed"][0] != "echo global" || cfg.Hooks["worktree.created"][0] != "make bootstrap" {
+		t.Errorf("expected hooks from both layers, got %v", cfg.Hooks)
+	}
+
+	// The global config itself is untouched by a repo's layers
+	base, _ := Load()
+	if _, err := base.ForRepo(repo, nil); err != nil {
+		t.Fatalf("ForRepo: %v", err)
+	}
+	if base.Defaults.WorktreeDir != ".worktrees" || len(base.Hooks) != 1 {
+		t.Errorf("ForRepo modified the base config: %+v", base)
+	}
+}
+
+func TestLoadLayersInvalidRepoValue(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	repo := t.TempDir()
+	os.WriteFile(filepath.Join(repo, RepoFile), []byte("[server]\nport = 0\n"), 0644)
+	if _, err := LoadForRepo(repo, nil); err == nil {
+		t.Error("expected invalid repo file value to be rejected")
+	}
+	if _, err := LoadForRepo(t.TempDir(), map[string]string{"defaults.watch": "maybe"}); err == nil {
+		t.Error("expected invalid repo setting to be rejected")
+	}
+}
+
 func TestLoadMalformed(t *testing.T) {
 	home := t.TempDir()
 	t.Setenv("HOME", home)
@@ -183,7 +244,7 @@ func TestValidate(t *testing.T) {
 
 	// Invalid transport
 	bad := DefaultConfig()
-	bad.Server.Transport = "http"
+	bad.Server.Transport = "websocket"
 	if err := bad.Validate(); err == nil {
 		t.Error("expected error for invalid transport")
 	}
@@ -202,6 +263,13 @@ func TestValidate(t *testing.T) {
 		t.Error("expected error for invalid duration")
 	}
 
+	// Negative max attempts
+	bad = DefaultConfig()
+	bad.Agent.MaxTaskAttempts = -1
+	if err := bad.Validate(); err == nil {
+		t.Error("expected error for negative max_task_attempts")
+	}
+
 	// Invalid color
 	bad = DefaultConfig()
 	bad.UI.Color = "rainbow"
@@ -225,13 +293,18 @@ func TestSetByDotKey(t *testing.T) {
 		check      func() bool
 	}{
 		{"server.transport", "sse", func() bool { return cfg.Server.Transport == "sse" }},
+		{"server.host", "0.0.0.0", func() bool { return cfg.Server.Host == "0.0.0.0" }},
 		{"server.port", "8080", func() bool { return cfg.Server.Port == 8080 }},
 		{"defaults.branch_prefix", "feat/", func() bool { return cfg.Defaults.BranchPrefix == "feat/" }},
 		{"defaults.worktree_dir", ".wt", func() bool { return cfg.Defaults.WorktreeDir == ".wt" }},
 		{"defaults.cleanup_stale_after", "48h", func() bool { return cfg.Defaults.CleanupStaleAfter == "48h" }},
 		{"defaults.auto_conflict_check", "false", func() bool { return !cfg.Defaults.AutoConflictCheck }},
+		{"defaults.conflict_context_lines", "0", func() bool { return cfg.Defaults.ConflictContextLines == 0 }},
+		{"defaults.watch", "false", func() bool { return !cfg.Defaults.Watch }},
+		{"defaults.watch_debounce", "2s", func() bool { return cfg.Defaults.WatchDebounce == "2s" }},
 		{"agent.heartbeat_interval", "1m", func() bool { return cfg.Agent.HeartbeatInterval == "1m" }},
 		{"agent.stale_after", "10m", func() bool { return cfg.Agent.StaleAfter == "10m" }},
+		{"agent.max_task_attempts", "5", func() bool { return cfg.Agent.MaxTaskAttempts == 5 }},
 		{"ui.color", "never", func() bool { return cfg.UI.Color == "never" }},
 		{"ui.output_format", "json", func() bool { return cfg.UI.OutputFormat == "json" }},
 		{"ui.compact", "true", func() bool { return cfg.UI.Compact }},
@@ -273,3 +346,218 @@ func TestGetByDotKey(t *testing.T) {
 		t.Error("expected error for unknown key")
 	}
 }
+
+func TestEnvOverrides(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("AGIT_DEFAULTS_BRANCH_PREFIX", "ci/")
+	t.Setenv("AGIT_AGENT_MAX_TASK_ATTEMPTS", "7")
+
+	if got := EnvVar("defaults.branch_prefix"); got != "AGIT_DEFAULTS_BRANCH_PREFIX" {
+		t.Errorf("unexpected env var name %s", got)
+	}
+
+	repo := t.TempDir()
+	os.WriteFile(filepath.Join(repo, RepoFile), []byte("[defaults]\nbranch_prefix = \"fe/\"\n"), 0644)
+	cfg, sources, err := LoadLayers(repo, nil)
+	if err != nil {
+		t.Fatalf("LoadLayers: %v", err)
+	}
+	if cfg.Defaults.BranchPrefix != "ci/" || sources["defaults.branch_prefix"] != "env AGIT_DEFAULTS_BRANCH_PREFIX" {
+		t.Errorf("expected env to beat the repo file, got %q (%s)", cfg.Defaults.BranchPrefix, sources["defaults.branch_prefix"])
+	}
+	if cfg.Agent.MaxTaskAttempts != 7 {
+		t.Errorf("expected max_task_attempts 7, got %d", cfg.Agent.MaxTaskAttempts)
+	}
+
+	t.Setenv("AGIT_SERVER_PORT", "not-a-port")
+	if _, err := Load(); err == nil {
+		t.Error("expected invalid env value to be rejected")
+	}
+}
+
+func TestAgitHome(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	dir := t.TempDir()
+	t.Setenv("AGIT_HOME", dir)
+
+	if got, _ := DBPath(); got != filepath.Join(dir, "agit.db") {
+		t.Errorf("expected database under AGIT_HOME, got %s", got)
+	}
+
+	flag := t.TempDir()
+	SetHome(flag)
+	defer SetHome("")
+	if got, _ := ConfigPath(); got != filepath.Join(flag, "config.toml") {
+		t.Errorf("expected --home to beat AGIT_HOME, got %s", got)
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	defer SetProfile("")
+
+	SetProfile("ci")
+	if _, err := Load(); err == nil {
+		t.Fatal("expected error loading a missing profile")
+	}
+
+	if err := SetProfileValue("ci", "defaults.branch_prefix", "ci/"); err != nil {
+		t.Fatalf("SetProfileValue: %v", err)
+	}
+	if err := SetProfileValue("ci", "hooks.task.claimed", "echo ci"); err != nil {
+		t.Fatalf("SetProfileValue: %v", err)
+	}
+	cfg, sources, err := LoadLayers("", nil)
+	if err != nil {
+		t.Fatalf("LoadLayers: %v", err)
+	}
+	if cfg.Defaults.BranchPrefix != "ci/" || sources["defaults.branch_prefix"] != "profile ci" {
+		t.Errorf("expected branch_prefix from profile, got %q (%s)", cfg.Defaults.BranchPrefix, sources["defaults.branch_prefix"])
+	}
+	if v, _ := cfg.GetByDotKey("hooks.task.claimed"); v != "echo ci" {
+		t.Errorf("expected hook from profile, got %v", cfg.Hooks)
+	}
+	if sources["defaults.worktree_dir"] != SourceDefault {
+		t.Errorf("expected keys the profile leaves alone to keep their source, got %s", sources["defaults.worktree_dir"])
+	}
+
+	SetProfile("")
+	if cfg, _ := Load(); cfg.Defaults.BranchPrefix != "agit/" {
+		t.Errorf("expected profile ignored when not selected, got %s", cfg.Defaults.BranchPrefix)
+	}
+
+	if _, err := ProfilePath("../escape"); err == nil {
+		t.Error("expected invalid profile name to be rejected")
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	dir := filepath.Join(home, ".agit")
+	os.MkdirAll(dir, 0755)
+	content := "[webhooks.ci]\nurl = \"https://ci.example.com/agit\"\nevents = [\"task.completed\", \"task.failed\"]\nsecret = \"s3cret\"\n[webhooks.ci.headers]\nAuthorization = \"Bearer abc\"\n"
+	os.WriteFile(filepath.Join(dir, "config.toml"), []byte(content), 0644)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	w, ok := cfg.Webhooks["ci"]
+	if !ok || w.URL != "https://ci.example.com/agit" || w.Headers["Authorization"] != "Bearer abc" {
+		t.Fatalf("unexpected webhook: %+v", cfg.Webhooks)
+	}
+	if !w.Wants("task.failed") || w.Wants("worktree.created") {
+		t.Errorf("unexpected event filter: %v", w.Events)
+	}
+	if w.MaxAttempts() != 3 || w.InitialBackoff() != time.Second {
+		t.Errorf("expected default retries, got %d, %v", w.MaxAttempts(), w.InitialBackoff())
+	}
+
+	if err := cfg.SetByDotKey("webhooks.slack.url", "https://hooks.example.com/x"); err != nil {
+		t.Fatalf("SetByDotKey: %v", err)
+	}
+	if err := cfg.SetByDotKey("webhooks.slack.attempts", "5"); err != nil {
+		t.Fatalf("SetByDotKey: %v", err)
+	}
+	if v, _ := cfg.GetByDotKey("webhooks.slack.attempts"); v != "5" {
+		t.Errorf("expected attempts 5, got %q", v)
+	}
+	if !cfg.Webhooks["slack"].Wants("anything") {
+		t.Error("expected a webhook without events to get every event")
+	}
+	cfg.SetByDotKey("webhooks.slack.url", "ftp://nope")
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected a non-http URL to be rejected")
+	}
+	if err := cfg.SetByDotKey("webhooks.slack.url", ""); err != nil || cfg.Webhooks["slack"].URL != "" {
+		t.Errorf("expected an empty url to remove the webhook, got %v", cfg.Webhooks)
+	}
+}
+
+func TestRedactedMasksCredentials(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Webhooks = map[string]Webhook{"ci": {
+		URL:    "https://ci.example.com/agit",
+		Secret: "s3cret",
+		Headers: map[string]string{
+			"Authorization": "Bearer abc", "X-Api-Key": "k", "X-Auth-Token": "t", "X-Team": "core",
+		},
+	}}
+
+	w := cfg.Redacted().Webhooks["ci"]
+	if w.Secret != MaskedValue || w.Headers["Authorization"] != MaskedValue ||
+		w.Headers["X-Api-Key"] != MaskedValue || w.Headers["X-Auth-Token"] != MaskedValue {
+		t.Errorf("expected credentials masked, got %+v", w)
+	}
+	if w.URL != "https://ci.example.com/agit" || w.Headers["X-Team"] != "core" {
+		t.Errorf("expected other values kept, got %+v", w)
+	}
+	if cfg.Webhooks["ci"].Headers["Authorization"] != "Bearer abc" {
+		t.Error("Redacted changed the original config")
+	}
+
+	for key, want := range map[string]bool{
+		"webhooks.ci.secret":                true,
+		"webhooks.ci.headers.authorization": true,
+		"webhooks.ci.headers.X-Api-Key":     true,
+		"webhooks.ci.headers.X-Team":        false,
+		"webhooks.ci.url":                   false,
+		"server.token":                      false,
+	} {
+		if got := IsSecretKey(key); got != want {
+			t.Errorf("IsSecretKey(%q) = %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestHookCommandLists(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	dir := filepath.Join(home, ".agit")
+	os.MkdirAll(dir, 0755)
+	global := "[hooks]\n\"task.claimed\" = \"echo one\"\n\"task.failed\" = [\"./notify.sh\", \"echo logged\"]\n"
+	os.WriteFile(filepath.Join(dir, "config.toml"), []byte(global), 0644)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(cfg.Hooks["task.claimed"]) != 1 || len(cfg.Hooks["task.failed"]) != 2 {
+		t.Fatalf("expected a string and an array of commands, got %v", cfg.Hooks)
+	}
+	if v, _ := cfg.GetByDotKey("hooks.task.failed"); v != "./notify.sh\necho logged" {
+		t.Errorf("expected one command per line, got %q", v)
+	}
+
+	// Saving keeps single commands as plain strings
+	if err := Save(cfg); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, "config.toml"))
+	if !strings.Contains(string(data), "'task.claimed' = 'echo one'") {
+		t.Errorf("expected a single command to be saved as a string:\n%s", data)
+	}
+	reloaded, _ := Load()
+	if len(reloaded.Hooks["task.failed"]) != 2 {
+		t.Errorf("expected command list to survive a save, got %v", reloaded.Hooks)
+	}
+
+	// A repo's .agit.toml replaces an event's commands
+	repo := t.TempDir()
+	os.WriteFile(filepath.Join(repo, RepoFile), []byte("[hooks]\n\"task.failed\" = [\"make report\"]\n"), 0644)
+	repoCfg, err := cfg.ForRepo(repo, nil)
+	if err != nil {
+		t.Fatalf("ForRepo: %v", err)
+	}
+	if got := repoCfg.Hooks["task.failed"]; len(got) != 1 || got[0] != "make report" {
+		t.Errorf("expected repo hooks to replace the event's commands, got %v", got)
+	}
+
+	os.WriteFile(filepath.Join(dir, "config.toml"), []byte("[hooks]\n\"task.failed\" = 3\n"), 0644)
+	if _, err := Load(); err == nil {
+		t.Error("expected a non-command hook to be rejected")
+	}
+}
@@ -1,6 +1,9 @@
 package conflicts
 
 import (
+	"sort"
+	"strings"
+
 	gitops "github.com/fathindos/agit/internal/git"
 	"github.com/fathindos/agit/internal/registry"
 )
@@ -14,19 +17,11 @@ func ScanAndUpdate(db *registry.DB, repo *registry.Repo) error {
 	}
 
 	for _, wt := range worktrees {
-		files, err := gitops.ModifiedFilesWithStatus(repo.Path, repo.DefaultBranch, wt.Branch)
+		touches, err := LiveTouches(repo, wt)
 		if err != nil {
 			continue // skip worktrees we can't diff
 		}
 
-		var touches []registry.FileTouch
-		for path, changeType := range files {
-			touches = append(touches, registry.FileTouch{
-				FilePath:   path,
-				ChangeType: changeType,
-			})
-		}
-
 		if err := db.RecordFileTouches(repo.ID, wt.ID, touches); err != nil {
 			return err
 		}
@@ -35,10 +30,176 @@ func ScanAndUpdate(db *registry.DB, repo *registry.Repo) error {
 	return nil
 }
 
+// ScanWorktree refreshes the file touches of a single worktree
+func ScanWorktree(db *registry.DB, repo *registry.Repo, wt *registry.Worktree) error {
+	touches, err := LiveTouches(repo, wt)
+	if err != nil {
+		return err
+	}
+	return db.RecordFileTouches(repo.ID, wt.ID, touches)
+}
+
+// LiveTouches returns a worktree's committed touches together with the edits
+// still in its working tree. Files with uncommitted edits are flagged, and
+// their ranges are taken from the working tree so they include both kinds of
+// change. If the worktree directory is unavailable, only committed touches
+// are returned.
+func LiveTouches(repo *registry.Repo, wt *registry.Worktree) ([]registry.FileTouch, error) {
+	touches, err := WorktreeTouches(repo, wt)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := gitops.UncommittedFiles(wt.Path)
+	if err != nil || len(files) == 0 {
+		return touches, nil
+	}
+	hunks, err := gitops.UncommittedLineRanges(wt.Path, wt.Base(repo.DefaultBranch))
+	if err != nil {
+		return touches, nil
+	}
+
+	byPath := make(map[string]int, len(touches))
+	for i, t := range touches {
+		byPath[t.FilePath] = i
+	}
+	for path, changeType := range files {
+		t := registry.FileTouch{
+			FilePath:    path,
+			ChangeType:  changeType,
+			Uncommitted: true,
+		}
+		if i, ok := byPath[path]; ok && changeType == "modified" {
+			// Edited again after being committed: keep the branch's change type
+			t.ChangeType = touches[i].ChangeType
+		}
+		if t.ChangeType == "modified" && len(hunks[path]) > 0 {
+			t.Ranges = hunks[path]
+		}
+		if i, ok := byPath[path]; ok {
+			touches[i] = t
+		} else {
+			touches = append(touches, t)
+		}
+	}
+	return touches, nil
+}
+
+// WorktreeTouches diffs a worktree's branch against its base (the ref it was
+// spawned from, or the repo's default branch) and returns its file touches,
+// including the changed line ranges of modified files. Added, deleted and
+// binary files carry no ranges and count as touching the whole file.
+func WorktreeTouches(repo *registry.Repo, wt *registry.Worktree) ([]registry.FileTouch, error) {
+	base := wt.Base(repo.DefaultBranch)
+	files, err := gitops.ModifiedFilesWithStatus(repo.Path, base, wt.Branch)
+	if err != nil {
+		return nil, err
+	}
+	hunks, err := gitops.ChangedLineRanges(repo.Path, base, wt.Branch)
+	if err != nil {
+		return nil, err
+	}
+
+	var touches []registry.FileTouch
+	for path, changeType := range files {
+		t := registry.FileTouch{
+			FilePath:   path,
+			ChangeType: changeType,
+		}
+		if changeType == "modified" && len(hunks[path]) > 0 {
+			t.Ranges = hunks[path]
+		}
+		touches = append(touches, t)
+	}
+	return touches, nil
+}
+
 // Detect scans and returns conflicts for a repo
 func Detect(db *registry.DB, repo *registry.Repo) ([]registry.Conflict, error) {
 	if err := ScanAndUpdate(db, repo); err != nil {
 		return nil, err
 	}
-	return db.FindConflicts(repo.ID)
+	list, err := db.FindConflicts(repo.ID)
+	if err != nil {
+		return nil, err
+	}
+	_, err = RecordDetected(db, repo, list)
+	return list, err
+}
+
+// RecordDetected logs a conflict.detected event for each conflict, unless the
+// last event for that file already names the same set of worktrees. It
+// returns the conflicts that were new.
+func RecordDetected(db *registry.DB, repo *registry.Repo, list []registry.Conflict) ([]registry.Conflict, error) {
+	var fresh []registry.Conflict
+	for _, c := range list {
+		worktrees := append([]string(nil), c.Worktrees...)
+		sort.Strings(worktrees)
+		key := strings.Join(worktrees, ",")
+
+		last, err := db.ListEvents(registry.EventFilter{
+			RepoID: repo.ID, Type: registry.EventConflictDetected, EntityID: c.FilePath, Limit: 1,
+		})
+		if err != nil {
+			return fresh, err
+		}
+		if len(last) == 1 && payloadKey(last[0].Payload["worktrees"]) == key {
+			continue
+		}
+
+		repoID := repo.ID
+		if err := db.RecordEvent(&registry.Event{
+			Type:       registry.EventConflictDetected,
+			RepoID:     &repoID,
+			EntityType: "file",
+			EntityID:   c.FilePath,
+			Payload:    map[string]any{"worktrees": worktrees, "conflict": Kind(c)},
+		}); err != nil {
+			return fresh, err
+		}
+		fresh = append(fresh, c)
+	}
+	return fresh, nil
+}
+
+// payloadKey joins a decoded JSON string list the way RecordDetected keys
+// worktree sets
+func payloadKey(v any) string {
+	list, _ := v.([]any)
+	strs := make([]string, 0, len(list))
+	for _, item := range list {
+		if s, ok := item.(string); ok {
+			strs = append(strs, s)
+		}
+	}
+	return strings.Join(strs, ",")
+}
+
+// LockViolations returns the files a worktree has changed, committed or not,
+// that fall under a lock held by another agent or worktree
+func LockViolations(db *registry.DB, repo *registry.Repo, wt *registry.Worktree) ([]registry.LockViolation, error) {
+	touches, err := LiveTouches(repo, wt)
+	if err != nil {
+		return nil, err
+	}
+	return db.LockViolations(wt, touchedPaths(touches))
+}
+
+// MergeLockViolations is LockViolations restricted to the worktree's commits,
+// which is what a merge would land
+func MergeLockViolations(db *registry.DB, repo *registry.Repo, wt *registry.Worktree) ([]registry.LockViolation, error) {
+	touches, err := WorktreeTouches(repo, wt)
+	if err != nil {
+		return nil, err
+	}
+	return db.LockViolations(wt, touchedPaths(touches))
+}
+
+func touchedPaths(touches []registry.FileTouch) []string {
+	paths := make([]string, len(touches))
+	for i, t := range touches {
+		paths[i] = t.FilePath
+	}
+	sort.Strings(paths)
+	return paths
 }
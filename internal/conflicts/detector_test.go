@@ -135,3 +135,30 @@ func TestFindConflictsMultipleFiles(t *testing.T) {
 		t.Errorf("expected 2 conflicts, got %d", len(conflicts))
 	}
 }
+
+func TestRecordDetectedDeduplicates(t *testing.T) {
+	db := mustDB(t)
+
+	repo, _ := db.AddRepo("dedupe-repo", "/tmp/dd", "", "main")
+	wt1, _ := db.CreateWorktree(repo.ID, "/tmp/dd1", "b1", nil, nil)
+	wt2, _ := db.CreateWorktree(repo.ID, "/tmp/dd2", "b2", nil, nil)
+	wt3, _ := db.CreateWorktree(repo.ID, "/tmp/dd3", "b3", nil, nil)
+
+	list := []registry.Conflict{{FilePath: "shared.go", Worktrees: []string{wt1.ID, wt2.ID}}}
+	RecordDetected(db, repo, list)
+	RecordDetected(db, repo, list)
+
+	filter := registry.EventFilter{RepoID: repo.ID, Type: registry.EventConflictDetected}
+	events, _ := db.ListEvents(filter)
+	if len(events) != 1 {
+		t.Fatalf("expected 1 event for an unchanged conflict, got %d", len(events))
+	}
+
+	// A third worktree joining the conflict is a new event
+	list[0].Worktrees = append(list[0].Worktrees, wt3.ID)
+	RecordDetected(db, repo, list)
+	events, _ = db.ListEvents(filter)
+	if len(events) != 2 {
+		t.Errorf("expected a new event when the conflict changes, got %d", len(events))
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/fathindos/agit/internal/lines"
 	"github.com/fathindos/agit/internal/registry"
 )
 
@@ -15,7 +16,7 @@ func FormatReport(conflicts []registry.Conflict) string {
 
 	var b strings.Builder
 	for _, c := range conflicts {
-		fmt.Fprintf(&b, "CONFLICT: %s\n", c.FilePath)
+		fmt.Fprintf(&b, "CONFLICT: %s (%s)\n", c.FilePath, KindLabel(c))
 		for i, wtID := range c.Worktrees {
 			shortID := wtID
 			if len(shortID) > 12 {
@@ -30,10 +31,18 @@ func FormatReport(conflicts []registry.Conflict) string {
 				task = c.TaskDescs[i]
 			}
 
+			detail := ""
+			if i < len(c.Ranges) {
+				detail = " " + FormatRanges(c.Ranges[i])
+			}
+			if i < len(c.Uncommitted) && c.Uncommitted[i] {
+				detail += " [uncommitted]"
+			}
+
 			if agent != "" {
-				fmt.Fprintf(&b, "  Modified in: %s (%s: %s)\n", shortID, agent, task)
+				fmt.Fprintf(&b, "  Modified in: %s%s (%s: %s)\n", shortID, detail, agent, task)
 			} else {
-				fmt.Fprintf(&b, "  Modified in: %s\n", shortID)
+				fmt.Fprintf(&b, "  Modified in: %s%s\n", shortID, detail)
 			}
 		}
 		b.WriteString("\n")
@@ -41,3 +50,48 @@ func FormatReport(conflicts []registry.Conflict) string {
 	fmt.Fprintf(&b, "%d conflict(s) across %d file(s).\n", len(conflicts), len(conflicts))
 	return b.String()
 }
+
+// Kind classifies a conflict as "committed" when every worktree has committed
+// its change, or "in_flight" when at least one side is an uncommitted edit
+func Kind(c registry.Conflict) string {
+	if c.InFlight() {
+		return "in_flight"
+	}
+	return "committed"
+}
+
+// KindLabel is the human-readable form of Kind
+func KindLabel(c registry.Conflict) string {
+	if c.InFlight() {
+		return "in-flight edit"
+	}
+	return "both committed"
+}
+
+// FormatRanges renders a worktree's overlapping hunks, e.g. "lines 10-14, 30"
+func FormatRanges(ranges []lines.Range) string {
+	if ranges == nil {
+		return "whole file"
+	}
+	parts := RangeStrings(ranges)
+	return "lines " + strings.Join(parts, ", ")
+}
+
+// RangeStrings converts line ranges to their "start-end" string form
+func RangeStrings(ranges []lines.Range) []string {
+	parts := make([]string, len(ranges))
+	for i, r := range ranges {
+		parts[i] = r.String()
+	}
+	return parts
+}
+
+// FormatLockViolations summarises lock violations for an error message, e.g.
+// "db.go (locked by agent claude-1 on internal/registry/**)"
+func FormatLockViolations(violations []registry.LockViolation) string {
+	parts := make([]string, len(violations))
+	for i, v := range violations {
+		parts[i] = fmt.Sprintf("%s (locked by %s on %s)", v.Path, v.Lock.Holder(), v.Lock.Pattern)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,60 @@
+package conflicts
+
+import (
+	gitops "github.com/fathindos/agit/internal/git"
+	"github.com/fathindos/agit/internal/registry"
+)
+
+// PairSimulation is the simulated merge of two worktree branches
+type PairSimulation struct {
+	WorktreeA       string   `json:"worktree_a"`
+	WorktreeB       string   `json:"worktree_b"`
+	Clean           bool     `json:"clean"`
+	ConflictedPaths []string `json:"conflicted_paths,omitempty"`
+	Error           string   `json:"error,omitempty"`
+}
+
+// SimulatePairs merges every pair of worktree branches in memory using
+// git merge-tree and reports which pairs would conflict. Neither the user's
+// checkout nor any worktree is modified.
+func SimulatePairs(repo *registry.Repo, worktrees []*registry.Worktree) []PairSimulation {
+	var results []PairSimulation
+	for i := 0; i < len(worktrees); i++ {
+		for j := i + 1; j < len(worktrees); j++ {
+			a, b := worktrees[i], worktrees[j]
+			res := PairSimulation{WorktreeA: a.ID, WorktreeB: b.ID}
+
+			sim, err := gitops.SimulateMerge(repo.Path, a.Branch, b.Branch)
+			if err != nil {
+				res.Error = err.Error()
+			} else {
+				res.Clean = sim.Clean
+				res.ConflictedPaths = sim.ConflictedPaths
+			}
+			results = append(results, res)
+		}
+	}
+	return results
+}
+
+// ConflictingPeers simulates merging wt against every other worktree and
+// returns only the pairs that would conflict. It is used before a merge to
+// warn about worktrees that will need rework once wt lands.
+func ConflictingPeers(repo *registry.Repo, wt *registry.Worktree, others []*registry.Worktree) []PairSimulation {
+	var results []PairSimulation
+	for _, other := range others {
+		if other.ID == wt.ID {
+			continue
+		}
+		sim, err := gitops.SimulateMerge(repo.Path, wt.Branch, other.Branch)
+		if err != nil || sim.Clean {
+			continue
+		}
+		results = append(results, PairSimulation{
+			WorktreeA:       wt.ID,
+			WorktreeB:       other.ID,
+			ConflictedPaths: sim.ConflictedPaths,
+		})
+	}
+	return results
+}
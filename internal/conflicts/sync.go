@@ -0,0 +1,95 @@
+package conflicts
+
+import (
+	"errors"
+
+	apperrors "github.com/fathindos/agit/internal/errors"
+	gitops "github.com/fathindos/agit/internal/git"
+	"github.com/fathindos/agit/internal/registry"
+)
+
+// SyncStatus names the outcome of a sync: up_to_date, synced or conflict
+func SyncStatus(r *gitops.SyncResult) string {
+	switch {
+	case r.UpToDate:
+		return "up_to_date"
+	case r.Conflicted():
+		return "conflict"
+	default:
+		return "synced"
+	}
+}
+
+// Sync brings a worktree up to date with its base (the ref it was spawned
+// from, or the repo's default branch), records a worktree.synced event and
+// refreshes the worktree's file touches so overlaps the base has already
+// absorbed drop out. A sync that stops on conflicts is reported in the
+// result, not as an error. A worktree the merge queue bounced with the
+// conflict status is made active again once it syncs cleanly, so that
+// conflict scans, the watcher and lock checks see it again.
+func Sync(db *registry.DB, repo *registry.Repo, wt *registry.Worktree, mode gitops.SyncMode) (*gitops.SyncResult, error) {
+	result, err := gitops.Sync(wt.Path, wt.Base(repo.DefaultBranch), mode)
+	if err != nil {
+		return nil, syncError(err)
+	}
+	if !result.UpToDate {
+		payload := map[string]any{"mode": string(result.Mode), "result": SyncStatus(result), "base": result.Base}
+		if result.Conflicted() {
+			payload["conflicted_paths"] = result.ConflictedPaths
+		}
+		if err := recordSync(db, repo, wt, payload); err != nil {
+			return nil, err
+		}
+	}
+	if wt.Status == "conflict" && !result.Conflicted() {
+		if err := db.UpdateWorktreeStatus(wt.ID, "active"); err != nil {
+			return nil, err
+		}
+		wt.Status = "active"
+	}
+	if err := ScanWorktree(db, repo, wt); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// AbortSync undoes a sync left in progress on conflicts and refreshes the
+// worktree's file touches. It returns the mode that was aborted.
+func AbortSync(db *registry.DB, repo *registry.Repo, wt *registry.Worktree) (gitops.SyncMode, error) {
+	mode, err := gitops.AbortSync(wt.Path)
+	if err != nil {
+		return "", syncError(err)
+	}
+	if err := recordSync(db, repo, wt, map[string]any{"mode": string(mode), "result": "aborted"}); err != nil {
+		return "", err
+	}
+	if err := ScanWorktree(db, repo, wt); err != nil {
+		return "", err
+	}
+	return mode, nil
+}
+
+// syncError marks the sync refusals the user can resolve as user errors,
+// leaving git failures to be reported as bugs
+func syncError(err error) error {
+	for _, refusal := range []error{gitops.ErrUnknownSyncMode, gitops.ErrSyncInProgress, gitops.ErrUncommittedChanges, gitops.ErrNoSyncInProgress} {
+		if errors.Is(err, refusal) {
+			return apperrors.NewUserError(err.Error())
+		}
+	}
+	return err
+}
+
+func recordSync(db *registry.DB, repo *registry.Repo, wt *registry.Worktree, payload map[string]any) error {
+	repoID := repo.ID
+	payload["branch"] = wt.Branch
+	payload["onto"] = wt.Base(repo.DefaultBranch)
+	return db.RecordEvent(&registry.Event{
+		Type:       registry.EventWorktreeSynced,
+		AgentID:    wt.AgentID,
+		RepoID:     &repoID,
+		EntityType: "worktree",
+		EntityID:   wt.ID,
+		Payload:    payload,
+	})
+}
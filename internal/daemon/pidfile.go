@@ -0,0 +1,172 @@
+package daemon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fathindos/agit/internal/config"
+)
+
+// Paths locates the daemon's files in the agit directory
+type Paths struct {
+	PID   string // daemon.pid, holding the running daemon's process ID
+	State string // daemon.json, the most recent pass
+	Log   string // daemon.log, stdout and stderr of a detached daemon
+}
+
+// DefaultPaths returns the daemon file locations under ~/.agit
+func DefaultPaths() (Paths, error) {
+	dir, err := config.AgitDir()
+	if err != nil {
+		return Paths{}, err
+	}
+	return PathsIn(dir), nil
+}
+
+// PathsIn returns the daemon file locations under dir
+func PathsIn(dir string) Paths {
+	return Paths{
+		PID:   filepath.Join(dir, "daemon.pid"),
+		State: filepath.Join(dir, "daemon.json"),
+		Log:   filepath.Join(dir, "daemon.log"),
+	}
+}
+
+// State is what a running daemon reports about itself
+type State struct {
+	PID       int         `json:"pid"`
+	StartedAt time.Time   `json:"started_at"`
+	Interval  string      `json:"interval"`
+	LastTick  *TickResult `json:"last_tick,omitempty"`
+}
+
+// ReadPID returns the PID recorded in the pidfile, or 0 if there is none
+func (p Paths) ReadPID() (int, error) {
+	data, err := os.ReadFile(p.PID)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("could not read pidfile: %w", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid pidfile %s: %w", p.PID, err)
+	}
+	return pid, nil
+}
+
+// Running returns the PID of the running daemon, or 0 if it is not running.
+// A pidfile left behind by a process that no longer exists is removed.
+func (p Paths) Running() (int, error) {
+	pid, err := p.ReadPID()
+	if err != nil || pid == 0 {
+		return 0, err
+	}
+	if !processAlive(pid) {
+		p.Remove()
+		return 0, nil
+	}
+	return pid, nil
+}
+
+// Acquire writes the current process's PID to the pidfile. It fails if
+// another live daemon already holds it.
+func (p Paths) Acquire() error {
+	if err := os.MkdirAll(filepath.Dir(p.PID), 0755); err != nil {
+		return fmt.Errorf("could not create directory: %w", err)
+	}
+	for attempt := 0; attempt < 2; attempt++ {
+		f, err := os.OpenFile(p.PID, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if errors.Is(err, os.ErrExist) {
+			pid, err := p.Running()
+			if err != nil {
+				return err
+			}
+			if pid != 0 && pid != os.Getpid() {
+				return fmt.Errorf("daemon already running (pid %d)", pid)
+			}
+			// Stale pidfile was removed; try again
+			os.Remove(p.PID)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("could not write pidfile: %w", err)
+		}
+		_, err = fmt.Fprintf(f, "%d\n", os.Getpid())
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("could not write pidfile: %w", err)
+		}
+		return nil
+	}
+	return fmt.Errorf("could not acquire pidfile %s", p.PID)
+}
+
+// Remove deletes the pidfile and state file
+func (p Paths) Remove() {
+	os.Remove(p.PID)
+	os.Remove(p.State)
+}
+
+// WriteState records the daemon's state for agit daemon status
+func (p Paths) WriteState(s *State) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode daemon state: %w", err)
+	}
+	// Write then rename so readers never see a partial file
+	tmp := p.State + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("could not write daemon state: %w", err)
+	}
+	if err := os.Rename(tmp, p.State); err != nil {
+		return fmt.Errorf("could not write daemon state: %w", err)
+	}
+	return nil
+}
+
+// ReadState returns the last recorded daemon state, or nil if there is none
+func (p Paths) ReadState() (*State, error) {
+	data, err := os.ReadFile(p.State)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read daemon state: %w", err)
+	}
+	s := &State{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("could not parse daemon state: %w", err)
+	}
+	return s, nil
+}
+
+// Stop asks the daemon to exit and waits up to timeout for it to do so. It
+// returns the PID that was stopped, or 0 if no daemon was running.
+func (p Paths) Stop(timeout time.Duration) (int, error) {
+	pid, err := p.Running()
+	if err != nil || pid == 0 {
+		return 0, err
+	}
+	if err := terminate(pid); err != nil {
+		return 0, fmt.Errorf("could not stop daemon (pid %d): %w", pid, err)
+	}
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if !processAlive(pid) {
+			p.Remove()
+			return pid, nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return 0, fmt.Errorf("daemon (pid %d) did not exit within %s", pid, timeout)
+}
@@ -0,0 +1,85 @@
+package daemon
+
+import (
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestAcquireWritesPID(t *testing.T) {
+	paths := PathsIn(t.TempDir())
+
+	if err := paths.Acquire(); err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	pid, err := paths.Running()
+	if err != nil {
+		t.Fatalf("Running: %v", err)
+	}
+	if pid != os.Getpid() {
+		t.Errorf("expected pid %d, got %d", os.Getpid(), pid)
+	}
+
+	paths.Remove()
+	if pid, _ := paths.Running(); pid != 0 {
+		t.Errorf("expected no daemon after Remove, got pid %d", pid)
+	}
+}
+
+func TestRunningRemovesStalePidfile(t *testing.T) {
+	paths := PathsIn(t.TempDir())
+
+	// PIDs this large are never handed out
+	if err := os.WriteFile(paths.PID, []byte(strconv.Itoa(1<<30)+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	pid, err := paths.Running()
+	if err != nil {
+		t.Fatalf("Running: %v", err)
+	}
+	if pid != 0 {
+		t.Errorf("expected stale pidfile to be ignored, got pid %d", pid)
+	}
+	if _, err := os.Stat(paths.PID); !os.IsNotExist(err) {
+		t.Error("expected stale pidfile to be removed")
+	}
+
+	// Acquire succeeds over a stale pidfile
+	os.WriteFile(paths.PID, []byte(strconv.Itoa(1<<30)+"\n"), 0644)
+	if err := paths.Acquire(); err != nil {
+		t.Fatalf("Acquire over stale pidfile: %v", err)
+	}
+}
+
+func TestStateRoundTrip(t *testing.T) {
+	paths := PathsIn(t.TempDir())
+
+	if s, err := paths.ReadState(); err != nil || s != nil {
+		t.Fatalf("expected no state, got %v, %v", s, err)
+	}
+	want := &State{
+		PID:       42,
+		StartedAt: time.Now().Truncate(time.Second),
+		Interval:  "30s",
+		LastTick:  &TickResult{AgentsSwept: 2, NewConflicts: 1},
+	}
+	if err := paths.WriteState(want); err != nil {
+		t.Fatalf("WriteState: %v", err)
+	}
+	got, err := paths.ReadState()
+	if err != nil {
+		t.Fatalf("ReadState: %v", err)
+	}
+	if got.PID != 42 || !got.StartedAt.Equal(want.StartedAt) || got.LastTick.AgentsSwept != 2 {
+		t.Errorf("unexpected state: %+v", got)
+	}
+}
+
+func TestStopNotRunning(t *testing.T) {
+	paths := PathsIn(t.TempDir())
+	pid, err := paths.Stop(time.Second)
+	if err != nil || pid != 0 {
+		t.Errorf("expected (0, nil), got (%d, %v)", pid, err)
+	}
+}
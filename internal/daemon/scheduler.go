@@ -0,0 +1,275 @@
+// Package daemon runs agit's background maintenance: sweeping agents that
+// stopped sending heartbeats, reclaiming expired task leases, marking idle
+// worktrees stale, watching worktrees for edits, scanning repos for new
+// conflicts and merging queued worktrees. The scheduler can be hosted by
+// `agit daemon` or in-process by `agit serve --scheduler`.
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/fathindos/agit/internal/config"
+	"github.com/fathindos/agit/internal/conflicts"
+	"github.com/fathindos/agit/internal/mergequeue"
+	"github.com/fathindos/agit/internal/registry"
+	"github.com/fathindos/agit/internal/watch"
+)
+
+// DefaultInterval is used when agent.heartbeat_interval is unset or invalid
+const DefaultInterval = 30 * time.Second
+
+// TickResult summarizes one scheduler pass
+type TickResult struct {
+	At              time.Time `json:"at"`
+	AgentsSwept     int       `json:"agents_swept"`
+	TasksRequeued   []string  `json:"tasks_requeued"`
+	TasksFailed     []string  `json:"tasks_failed"`
+	WorktreesStaled int       `json:"worktrees_staled"`
+	LocksExpired    int       `json:"locks_expired"`
+	NewConflicts    int       `json:"new_conflicts"`
+	Watching        int       `json:"watching"`
+	Errors          []string  `json:"errors,omitempty"`
+}
+
+// Scheduler periodically applies the sweep and scan policies from the config
+type Scheduler struct {
+	db       *registry.DB
+	cfg      *config.Config
+	interval time.Duration
+	watcher  *watch.Watcher
+
+	// conflictMu keeps the watcher and the periodic scan from reporting the
+	// same new conflict twice
+	conflictMu sync.Mutex
+
+	// OnTick, if set, is called after every pass
+	OnTick func(*TickResult)
+}
+
+// NewScheduler creates a Scheduler. A zero interval runs one pass per
+// agent.heartbeat_interval.
+func NewScheduler(db *registry.DB, cfg *config.Config, interval time.Duration) *Scheduler {
+	if interval <= 0 {
+		interval = DefaultInterval
+		if d, err := time.ParseDuration(cfg.Agent.HeartbeatInterval); err == nil && d > 0 {
+			interval = d
+		}
+	}
+	return &Scheduler{
+		db:       db,
+		cfg:      cfg,
+		interval: interval,
+	}
+}
+
+// Interval returns the time between passes
+func (s *Scheduler) Interval() time.Duration {
+	return s.interval
+}
+
+// Run executes a pass immediately and then once per interval until ctx is
+// cancelled. If defaults.watch is set, active worktrees are also watched and
+// rescanned as they are edited. Merge queues are drained in the background,
+// so slow verify commands don't hold up the sweeps.
+func (s *Scheduler) Run(ctx context.Context) {
+	mergesDone := s.startMergeWorker(ctx)
+	defer func() { <-mergesDone }()
+
+	if s.cfg.Defaults.Watch {
+		if done := s.startWatcher(ctx); done != nil {
+			defer func() { <-done }()
+		}
+	}
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		result := s.Tick()
+		for _, e := range result.Errors {
+			log.Printf("scheduler: %s", e)
+		}
+		if s.OnTick != nil {
+			s.OnTick(result)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Tick runs one pass. Failures in one step are recorded in the result and do
+// not stop the remaining steps.
+func (s *Scheduler) Tick() *TickResult {
+	result := &TickResult{At: time.Now(), TasksRequeued: []string{}, TasksFailed: []string{}}
+	fail := func(step string, err error) {
+		result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", step, err))
+	}
+
+	// Agents that stopped sending heartbeats
+	if staleAfter, err := time.ParseDuration(s.cfg.Agent.StaleAfter); err != nil {
+		fail("sweep agents", fmt.Errorf("invalid stale_after duration %q", s.cfg.Agent.StaleAfter))
+	} else if n, err := s.db.SweepStaleAgents(staleAfter); err != nil {
+		fail("sweep agents", err)
+	} else {
+		result.AgentsSwept = n
+	}
+
+	if reclaimed, err := s.db.ReclaimExpiredTasks(); err != nil {
+		fail("reclaim tasks", err)
+	} else {
+		result.TasksRequeued = reclaimed.Requeued
+		result.TasksFailed = reclaimed.Failed
+	}
+
+	if n, err := s.db.PurgeExpiredLocks(); err != nil {
+		fail("purge locks", err)
+	} else {
+		result.LocksExpired = n
+	}
+
+	repos, err := s.db.ListRepos()
+	if err != nil {
+		fail("list repos", err)
+		return result
+	}
+
+	// Worktrees whose directory is gone, or that nobody has touched lately
+	for _, repo := range repos {
+		n, err := s.db.PruneOrphanedWorktrees(repo.ID)
+		if err != nil {
+			fail("prune "+repo.Name, err)
+		}
+		result.WorktreesStaled += n
+	}
+	if s.cfg.Defaults.CleanupStaleAfter != "" && s.cfg.Defaults.CleanupStaleAfter != "0" {
+		if idleAfter, err := time.ParseDuration(s.cfg.Defaults.CleanupStaleAfter); err != nil {
+			fail("mark idle worktrees", fmt.Errorf("invalid cleanup_stale_after duration %q", s.cfg.Defaults.CleanupStaleAfter))
+		} else if ids, err := s.db.MarkIdleWorktreesStale(idleAfter); err != nil {
+			fail("mark idle worktrees", err)
+		} else {
+			result.WorktreesStaled += len(ids)
+		}
+	}
+
+	if s.watcher != nil {
+		result.Watching = s.watcher.Len()
+	}
+
+	for _, repo := range repos {
+		if !s.repoConfig(repo).Defaults.AutoConflictCheck {
+			continue
+		}
+		if err := conflicts.ScanAndUpdate(s.db, repo); err != nil {
+			fail("scan "+repo.Name, err)
+			continue
+		}
+		n, err := s.detectConflicts(repo)
+		if err != nil {
+			fail("conflicts "+repo.Name, err)
+		}
+		result.NewConflicts += n
+	}
+	return result
+}
+
+// startMergeWorker drains every repo's merge queue once per interval until
+// ctx is cancelled. A merge in progress is finished before it stops.
+func (s *Scheduler) startMergeWorker(ctx context.Context) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			s.DrainMergeQueues()
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return done
+}
+
+// DrainMergeQueues merges the queued entries of every repo in order and
+// returns how many it processed. Queues another worker holds are skipped.
+func (s *Scheduler) DrainMergeQueues() int {
+	repos, err := s.db.ListRepos()
+	if err != nil {
+		log.Printf("scheduler: list repos: %v", err)
+		return 0
+	}
+	n := 0
+	for _, repo := range repos {
+		processed, err := mergequeue.Drain(s.db, repo)
+		if err != nil {
+			log.Printf("scheduler: merge queue %s: %v", repo.Name, err)
+		}
+		n += len(processed)
+	}
+	return n
+}
+
+// repoConfig returns the scheduler's config with a repo's own settings
+// layered on top, falling back to the scheduler's config if they are invalid
+func (s *Scheduler) repoConfig(repo *registry.Repo) *config.Config {
+	cfg, err := s.cfg.ForRepo(repo.Path, repo.ConfigOverrides())
+	if err != nil {
+		log.Printf("scheduler: config for %s: %v", repo.Name, err)
+		return s.cfg
+	}
+	return cfg
+}
+
+// detectConflicts records a conflict.detected event for each of the repo's
+// conflicts not reported before, which fires the repo's hooks when the
+// registry has them attached. It returns how many were new.
+func (s *Scheduler) detectConflicts(repo *registry.Repo) (int, error) {
+	s.conflictMu.Lock()
+	defer s.conflictMu.Unlock()
+
+	list, err := s.db.FindConflicts(repo.ID)
+	if err != nil {
+		return 0, err
+	}
+	fresh, err := conflicts.RecordDetected(s.db, repo, list)
+	return len(fresh), err
+}
+
+// startWatcher runs a file watcher over active worktrees until ctx is
+// cancelled. Each debounced rescan is followed by a conflict check of its
+// repo. It returns a channel closed once the watcher has stopped, or nil if
+// the watcher could not be started.
+func (s *Scheduler) startWatcher(ctx context.Context) <-chan struct{} {
+	debounce, _ := time.ParseDuration(s.cfg.Defaults.WatchDebounce)
+	w, err := watch.New(s.db, debounce)
+	if err != nil {
+		log.Printf("scheduler: could not start file watcher: %v", err)
+		return nil
+	}
+	w.OnScan = func(repo *registry.Repo, wt *registry.Worktree) {
+		if !s.repoConfig(repo).Defaults.AutoConflictCheck {
+			return
+		}
+		if _, err := s.detectConflicts(repo); err != nil {
+			log.Printf("scheduler: conflicts %s: %v", repo.Name, err)
+		}
+	}
+	s.watcher = w
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w.Run(ctx, s.interval)
+	}()
+	return done
+}
@@ -0,0 +1,97 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fathindos/agit/internal/config"
+	"github.com/fathindos/agit/internal/registry"
+)
+
+func mustDB(t *testing.T) *registry.DB {
+	t.Helper()
+	db, err := registry.OpenMemory()
+	if err != nil {
+		t.Fatalf("OpenMemory: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestNewSchedulerInterval(t *testing.T) {
+	db := mustDB(t)
+	cfg := config.DefaultConfig()
+
+	if got := NewScheduler(db, cfg, 0).Interval(); got != 30*time.Second {
+		t.Errorf("expected heartbeat interval 30s, got %s", got)
+	}
+	if got := NewScheduler(db, cfg, time.Minute).Interval(); got != time.Minute {
+		t.Errorf("expected explicit interval 1m, got %s", got)
+	}
+	cfg.Agent.HeartbeatInterval = "bogus"
+	if got := NewScheduler(db, cfg, 0).Interval(); got != DefaultInterval {
+		t.Errorf("expected default interval, got %s", got)
+	}
+}
+
+func TestTickSweepsAgentsAndWorktrees(t *testing.T) {
+	db := mustDB(t)
+	cfg := config.DefaultConfig()
+	cfg.Agent.StaleAfter = "1ms"
+	cfg.Defaults.AutoConflictCheck = false
+
+	repo, _ := db.AddRepo("tick-repo", t.TempDir(), "", "main")
+	db.RegisterAgent("sleepy", "test")
+	// The worktree directory does not exist, so it is orphaned
+	wt, _ := db.CreateWorktree(repo.ID, "/nonexistent/agit-tick", "b1", nil, nil)
+
+	time.Sleep(5 * time.Millisecond)
+	result := NewScheduler(db, cfg, 0).Tick()
+	if len(result.Errors) > 0 {
+		t.Fatalf("unexpected errors: %v", result.Errors)
+	}
+	if result.AgentsSwept != 1 {
+		t.Errorf("expected 1 agent swept, got %d", result.AgentsSwept)
+	}
+	if result.WorktreesStaled != 1 {
+		t.Errorf("expected 1 worktree marked stale, got %d", result.WorktreesStaled)
+	}
+
+	got, _ := db.GetWorktree(wt.ID)
+	if got.Status != "stale" {
+		t.Errorf("expected worktree stale, got %s", got.Status)
+	}
+
+	// A second pass has nothing left to do
+	result = NewScheduler(db, cfg, 0).Tick()
+	if result.AgentsSwept != 0 || result.WorktreesStaled != 0 {
+		t.Errorf("expected an idle second pass, got %+v", result)
+	}
+}
+
+func TestTickReportsInvalidDurations(t *testing.T) {
+	db := mustDB(t)
+	cfg := config.DefaultConfig()
+	cfg.Agent.StaleAfter = "soon"
+	cfg.Defaults.AutoConflictCheck = false
+
+	result := NewScheduler(db, cfg, 0).Tick()
+	if len(result.Errors) != 1 {
+		t.Fatalf("expected one error, got %v", result.Errors)
+	}
+}
+
+func TestDrainMergeQueues(t *testing.T) {
+	db := mustDB(t)
+	repo, _ := db.AddRepo("queue-repo", "/nonexistent/agit-queue", "", "main")
+	wt, _ := db.CreateWorktree(repo.ID, "/nonexistent/agit-queue-wt", "b1", nil, nil)
+	entry, _ := db.EnqueueMerge(repo.ID, wt.ID, "", false, nil)
+
+	if n := NewScheduler(db, config.DefaultConfig(), 0).DrainMergeQueues(); n != 1 {
+		t.Fatalf("expected 1 entry processed, got %d", n)
+	}
+	// The merge fails, since the repo doesn't exist, but the entry is settled
+	if got, _ := db.GetMergeQueueEntry(entry.ID); got.Status == "queued" || got.Status == "merging" {
+		t.Errorf("expected the entry to be processed, got %s", got.Status)
+	}
+}
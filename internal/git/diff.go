@@ -2,7 +2,10 @@ package git
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
+
+	"github.com/fathindos/agit/internal/lines"
 )
 
 // ModifiedFiles returns the list of files modified in a branch compared to a base branch
@@ -64,3 +67,142 @@ func parseModifiedFilesWithStatus(output string) map[string]string {
 	}
 	return files
 }
+
+// ChangedLineRanges returns, per file, the line ranges of the base branch that
+// a branch rewrites. Ranges are expressed in base-side coordinates so that the
+// hunks of two branches forked from the same base can be compared directly.
+// Pure insertions are reported as the single line they follow.
+func ChangedLineRanges(repoPath, baseBranch, branch string) (map[string][]lines.Range, error) {
+	out, err := runGit(repoPath, "diff", "--no-ext-diff", "--no-color", "-U0", baseBranch+"..."+branch)
+	if err != nil {
+		return nil, fmt.Errorf("could not get diff hunks: %w", err)
+	}
+
+	return parseHunkRanges(out), nil
+}
+
+// parseHunkRanges parses a zero-context unified diff into the base-side line
+// ranges of each hunk, keyed by file path. File headers are only read between
+// a "diff --git" line and the first hunk of its section, so removed or added
+// lines that start with "-- " or "++ " are not mistaken for them.
+func parseHunkRanges(output string) map[string][]lines.Range {
+	ranges := make(map[string][]lines.Range)
+	var oldPath, path string
+	inHeader := false
+
+	for _, line := range strings.Split(output, "\n") {
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			oldPath, path = "", ""
+			inHeader = true
+		case inHeader && strings.HasPrefix(line, "--- "):
+			oldPath = strings.TrimPrefix(strings.TrimPrefix(line, "--- "), "a/")
+		case inHeader && strings.HasPrefix(line, "+++ "):
+			path = strings.TrimPrefix(strings.TrimPrefix(line, "+++ "), "b/")
+			if path == "/dev/null" {
+				path = oldPath
+			}
+		case strings.HasPrefix(line, "@@ "):
+			inHeader = false
+			if path == "" {
+				continue
+			}
+			if r, ok := parseHunkHeader(line); ok {
+				ranges[path] = append(ranges[path], r)
+			}
+		}
+	}
+	return ranges
+}
+
+// parseHunkHeader extracts the base-side range from "@@ -a,b +c,d @@"
+func parseHunkHeader(line string) (lines.Range, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 3 || !strings.HasPrefix(fields[1], "-") {
+		return lines.Range{}, false
+	}
+
+	startStr, countStr, hasCount := strings.Cut(strings.TrimPrefix(fields[1], "-"), ",")
+	start, err := strconv.Atoi(startStr)
+	if err != nil {
+		return lines.Range{}, false
+	}
+	count := 1
+	if hasCount {
+		if count, err = strconv.Atoi(countStr); err != nil {
+			return lines.Range{}, false
+		}
+	}
+
+	if count == 0 {
+		// Insertion after line start (0 = top of file)
+		return lines.Range{Start: start, End: start}, true
+	}
+	return lines.Range{Start: start, End: start + count - 1}, true
+}
+
+// UncommittedFiles returns the files with uncommitted changes in a worktree,
+// staged or not, including untracked files, mapped to their change type
+func UncommittedFiles(worktreePath string) (map[string]string, error) {
+	// --no-optional-locks keeps status from rewriting the index, which would
+	// wake anything watching the worktree's git directory
+	out, err := runGit(worktreePath, "--no-optional-locks", "status", "--porcelain=v1", "-z", "--untracked-files=all")
+	if err != nil {
+		return nil, fmt.Errorf("could not get uncommitted files: %w", err)
+	}
+
+	return parseStatusPorcelain(out), nil
+}
+
+// UncommittedTrackedFiles is UncommittedFiles without untracked files
+func UncommittedTrackedFiles(worktreePath string) (map[string]string, error) {
+	out, err := runGit(worktreePath, "--no-optional-locks", "status", "--porcelain=v1", "-z", "--untracked-files=no")
+	if err != nil {
+		return nil, fmt.Errorf("could not get uncommitted files: %w", err)
+	}
+
+	return parseStatusPorcelain(out), nil
+}
+
+// parseStatusPorcelain parses NUL-separated git status --porcelain=v1 output
+// into a map of file path to change type
+func parseStatusPorcelain(output string) map[string]string {
+	files := make(map[string]string)
+	entries := strings.Split(output, "\x00")
+	for i := 0; i < len(entries); i++ {
+		entry := entries[i]
+		if len(entry) < 4 {
+			continue
+		}
+		xy, path := entry[:2], entry[3:]
+
+		switch {
+		case xy == "??" || strings.Contains(xy, "A"):
+			files[path] = "added"
+		case strings.Contains(xy, "D"):
+			files[path] = "deleted"
+		case strings.Contains(xy, "R"), strings.Contains(xy, "C"):
+			files[path] = "renamed"
+			i++ // the next entry is the source path
+		default:
+			files[path] = "modified"
+		}
+	}
+	return files
+}
+
+// UncommittedLineRanges returns, per file, the line ranges of the base branch
+// that a worktree's working tree rewrites, including edits that are not yet
+// committed. Ranges use the same base-side coordinates as ChangedLineRanges.
+func UncommittedLineRanges(worktreePath, baseBranch string) (map[string][]lines.Range, error) {
+	base, err := runGit(worktreePath, "merge-base", baseBranch, "HEAD")
+	if err != nil {
+		return nil, fmt.Errorf("could not find merge base: %w", err)
+	}
+	out, err := runGit(worktreePath, "--no-optional-locks", "diff", "--no-ext-diff", "--no-color", "-U0", strings.TrimSpace(base))
+	if err != nil {
+		return nil, fmt.Errorf("could not get diff hunks: %w", err)
+	}
+
+	return parseHunkRanges(out), nil
+}
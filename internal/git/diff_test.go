@@ -2,6 +2,8 @@ package git
 
 import (
 	"testing"
+
+	"github.com/fathindos/agit/internal/lines"
 )
 
 func TestParseModifiedFiles(t *testing.T) {
@@ -106,3 +108,118 @@ func TestParseModifiedFilesWithStatus(t *testing.T) {
 		})
 	}
 }
+
+func TestParseStatusPorcelain(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   map[string]string
+	}{
+		{
+			name:   "mixed statuses",
+			output: " M edited.go\x00M  staged.go\x00?? new file.go\x00A  added.go\x00 D gone.go\x00R  renamed.go\x00old.go\x00",
+			want: map[string]string{
+				"edited.go":   "modified",
+				"staged.go":   "modified",
+				"new file.go": "added",
+				"added.go":    "added",
+				"gone.go":     "deleted",
+				"renamed.go":  "renamed",
+			},
+		},
+		{
+			name:   "empty output",
+			output: "",
+			want:   map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseStatusPorcelain(tt.output)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d files, want %d: %v", len(got), len(tt.want), got)
+			}
+			for path, wantStatus := range tt.want {
+				if gotStatus := got[path]; gotStatus != wantStatus {
+					t.Errorf("file %q: got %q, want %q", path, gotStatus, wantStatus)
+				}
+			}
+		})
+	}
+}
+
+func TestParseHunkRanges(t *testing.T) {
+	output := `diff --git a/main.go b/main.go
+index 1111111..2222222 100644
+--- a/main.go
++++ b/main.go
+@@ -3 +3 @@ import (
+-	"fmt"
++	"log"
+@@ -10,4 +10,0 @@ func main() {
+-	a
+-	b
+-	c
+-	d
+@@ -20,0 +17,2 @@ func helper() {
++	x
++	y
+diff --git a/gone.go b/gone.go
+deleted file mode 100644
+index 3333333..0000000
+--- a/gone.go
++++ /dev/null
+@@ -1,2 +0,0 @@
+-package main
+-
+`
+	got := parseHunkRanges(output)
+
+	want := map[string][]lines.Range{
+		"main.go": {{Start: 3, End: 3}, {Start: 10, End: 13}, {Start: 20, End: 20}},
+		"gone.go": {{Start: 1, End: 2}},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d files, want %d: %v", len(got), len(want), got)
+	}
+	for path, wantRanges := range want {
+		gotRanges := got[path]
+		if len(gotRanges) != len(wantRanges) {
+			t.Fatalf("%s: got %v, want %v", path, gotRanges, wantRanges)
+		}
+		for i := range wantRanges {
+			if gotRanges[i] != wantRanges[i] {
+				t.Errorf("%s[%d] = %v, want %v", path, i, gotRanges[i], wantRanges[i])
+			}
+		}
+	}
+}
+
+func TestParseHunkRangesIgnoresHeaderLikeContent(t *testing.T) {
+	// A removed "-- comment" and an added "++ counter" show up as "--- " and
+	// "+++ " lines inside the hunk
+	output := `diff --git a/schema.sql b/schema.sql
+index 1111111..2222222 100644
+--- a/schema.sql
++++ b/schema.sql
+@@ -4 +4 @@
+--- drop this comment
++++ counter
+@@ -9,2 +9 @@
+-a
+-b
++c
+`
+	got := parseHunkRanges(output)
+
+	want := []lines.Range{{Start: 4, End: 4}, {Start: 9, End: 10}}
+	if len(got) != 1 || len(got["schema.sql"]) != len(want) {
+		t.Fatalf("got %v, want schema.sql: %v", got, want)
+	}
+	for i, r := range want {
+		if got["schema.sql"][i] != r {
+			t.Errorf("schema.sql[%d] = %v, want %v", i, got["schema.sql"][i], r)
+		}
+	}
+}
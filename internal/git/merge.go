@@ -2,28 +2,202 @@ package git
 
 import (
 	"fmt"
+	"os/exec"
+	"sort"
 	"strings"
 )
 
-// MergeBranch merges a branch into the current branch
-func MergeBranch(repoPath, branchName string) error {
-	_, err := runGit(repoPath, "merge", branchName, "--no-ff", "-m",
-		fmt.Sprintf("Merge %s via agit", branchName))
+// MergeStrategy selects how a worktree branch is integrated into the base branch
+type MergeStrategy string
+
+const (
+	StrategyMerge  MergeStrategy = "merge"   // merge commit (--no-ff)
+	StrategySquash MergeStrategy = "squash"  // single squashed commit
+	StrategyRebase MergeStrategy = "rebase"  // rebase onto base, then fast-forward
+	StrategyFFOnly MergeStrategy = "ff-only" // fast-forward or fail
+)
+
+// MergeStrategies lists the supported strategies in display order
+var MergeStrategies = []MergeStrategy{StrategyMerge, StrategySquash, StrategyRebase, StrategyFFOnly}
+
+// ParseMergeStrategy validates a strategy name. An empty name yields StrategyMerge.
+func ParseMergeStrategy(name string) (MergeStrategy, error) {
+	if name == "" {
+		return StrategyMerge, nil
+	}
+	for _, s := range MergeStrategies {
+		if string(s) == name {
+			return s, nil
+		}
+	}
+	return "", fmt.Errorf("unknown merge strategy %q: must be merge, squash, rebase, or ff-only", name)
+}
+
+// MergeOptions controls Merge
+type MergeOptions struct {
+	Strategy     MergeStrategy
+	Message      string // commit message for merge and squash; defaults to "Merge <branch> via agit"
+	BaseBranch   string // branch being merged into
+	WorktreePath string // checkout of the branch; required for rebase
+}
+
+// Merge integrates branchName into opts.BaseBranch without checking anything
+// out in the main repository. The result commit is built with merge-tree and
+// commit-tree, and the base branch ref is advanced with a compare-and-swap
+// update-ref, so the user's HEAD never moves. It returns the new tip of the
+// base branch.
+//
+// If the base branch happens to be checked out somewhere, that checkout is
+// fast-forwarded with read-tree so its index and files match the new ref;
+// local changes that would be overwritten abort the merge before the ref moves.
+func Merge(repoPath, branchName string, opts MergeOptions) (string, error) {
+	if opts.BaseBranch == "" {
+		return "", fmt.Errorf("merge requires a base branch")
+	}
+	strategy := opts.Strategy
+	if strategy == "" {
+		strategy = StrategyMerge
+	}
+	message := opts.Message
+	if message == "" {
+		message = fmt.Sprintf("Merge %s via agit", branchName)
+	}
+
+	if strategy == StrategyRebase {
+		if opts.WorktreePath == "" {
+			return "", fmt.Errorf("rebase strategy requires the worktree path")
+		}
+		// The branch is checked out in its own worktree, so rebase it there
+		if _, err := runGit(opts.WorktreePath, "rebase", opts.BaseBranch); err != nil {
+			runGitNoOutput(opts.WorktreePath, "rebase", "--abort")
+			return "", fmt.Errorf("rebase onto %s failed: %w", opts.BaseBranch, err)
+		}
+	}
+
+	oldTip, err := revParse(repoPath, "refs/heads/"+opts.BaseBranch)
 	if err != nil {
-		return fmt.Errorf("merge failed: %w", err)
+		return "", err
 	}
-	return nil
+	branchTip, err := revParse(repoPath, branchName)
+	if err != nil {
+		return "", err
+	}
+
+	// Nothing to integrate
+	if isAncestor(repoPath, branchTip, oldTip) {
+		return oldTip, nil
+	}
+
+	var newTip string
+	switch strategy {
+	case StrategyRebase, StrategyFFOnly:
+		if !isAncestor(repoPath, oldTip, branchTip) {
+			return "", fmt.Errorf("cannot fast-forward %s to %s: branches have diverged", opts.BaseBranch, branchName)
+		}
+		newTip = branchTip
+
+	case StrategyMerge, StrategySquash:
+		sim, err := SimulateMerge(repoPath, oldTip, branchTip)
+		if err != nil {
+			return "", err
+		}
+		if !sim.Clean {
+			return "", fmt.Errorf("merge failed: conflicts in %s", strings.Join(sim.ConflictedPaths, ", "))
+		}
+		args := []string{"commit-tree", sim.Tree, "-p", oldTip}
+		if strategy == StrategyMerge {
+			args = append(args, "-p", branchTip)
+		}
+		out, err := runGit(repoPath, append(args, "-m", message)...)
+		if err != nil {
+			return "", fmt.Errorf("could not create merge commit: %w", err)
+		}
+		newTip = strings.TrimSpace(out)
+
+	default:
+		return "", fmt.Errorf("unknown merge strategy %q", strategy)
+	}
+
+	reason := fmt.Sprintf("agit: %s %s", strategy, branchName)
+	if err := advanceBranch(repoPath, opts.BaseBranch, oldTip, newTip, reason); err != nil {
+		return "", err
+	}
+	return newTip, nil
 }
 
-// CheckoutBranch switches to a branch
-func CheckoutBranch(repoPath, branchName string) error {
-	_, err := runGit(repoPath, "checkout", branchName)
+// advanceBranch moves refs/heads/<branch> from oldTip to newTip. A checkout of
+// the branch, if any, is updated first so it never sees the ref move under it.
+func advanceBranch(repoPath, branch, oldTip, newTip, reason string) error {
+	checkout, err := branchCheckout(repoPath, branch)
 	if err != nil {
-		return fmt.Errorf("could not checkout branch: %w", err)
+		return err
+	}
+
+	if checkout != "" {
+		if _, err := runGit(checkout, "read-tree", "-m", "-u", oldTip, newTip); err != nil {
+			return fmt.Errorf("%s is checked out at %s and its local changes would be overwritten: %w", branch, checkout, err)
+		}
+	}
+
+	if _, err := runGit(repoPath, "update-ref", "-m", reason, "refs/heads/"+branch, newTip, oldTip); err != nil {
+		if checkout != "" {
+			runGitNoOutput(checkout, "read-tree", "-m", "-u", newTip, oldTip)
+		}
+		return fmt.Errorf("could not update %s (was it moved concurrently?): %w", branch, err)
 	}
 	return nil
 }
 
+// branchCheckout returns the path of the worktree that has branch checked
+// out, or "" if it is not checked out anywhere
+func branchCheckout(repoPath, branch string) (string, error) {
+	out, err := runGit(repoPath, "worktree", "list", "--porcelain")
+	if err != nil {
+		return "", fmt.Errorf("could not list worktrees: %w", err)
+	}
+
+	var current string
+	for _, line := range strings.Split(out, "\n") {
+		switch {
+		case strings.HasPrefix(line, "worktree "):
+			current = strings.TrimPrefix(line, "worktree ")
+		case line == "branch refs/heads/"+branch:
+			return current, nil
+		}
+	}
+	return "", nil
+}
+
+func revParse(repoPath, ref string) (string, error) {
+	out, err := runGit(repoPath, "rev-parse", "--verify", ref+"^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("could not resolve %s: %w", ref, err)
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// isAncestor reports whether ancestor is reachable from descendant
+func isAncestor(repoPath, ancestor, descendant string) bool {
+	return runGitNoOutput(repoPath, "merge-base", "--is-ancestor", ancestor, descendant) == nil
+}
+
+// SquashMessage builds the commit message for a squash merge from the
+// worktree's task. The task description becomes the subject line.
+func SquashMessage(branchName, taskDescription, taskID string) string {
+	subject := strings.TrimSpace(taskDescription)
+	if subject == "" {
+		subject = fmt.Sprintf("Merge %s via agit", branchName)
+	}
+
+	var b strings.Builder
+	b.WriteString(subject)
+	fmt.Fprintf(&b, "\n\nSquashed from %s via agit.", branchName)
+	if taskID != "" {
+		fmt.Fprintf(&b, "\nTask: %s", taskID)
+	}
+	return b.String()
+}
+
 // HasUnmergedChanges checks if a branch has changes not in the base branch
 func HasUnmergedChanges(repoPath, baseBranch, branch string) (bool, error) {
 	out, err := runGit(repoPath, "log", "--oneline", baseBranch+".."+branch)
@@ -33,15 +207,68 @@ func HasUnmergedChanges(repoPath, baseBranch, branch string) (bool, error) {
 	return strings.TrimSpace(out) != "", nil
 }
 
-// CanMergeCleanly does a dry-run merge to check for conflicts
-func CanMergeCleanly(repoPath, branchName string) (bool, error) {
-	// Try a merge with --no-commit --no-ff
-	err := runGitNoOutput(repoPath, "merge", "--no-commit", "--no-ff", branchName)
-	// Abort the merge attempt regardless of outcome
-	runGitNoOutput(repoPath, "merge", "--abort")
+// MergeSimulation is the outcome of merging two refs in memory
+type MergeSimulation struct {
+	Clean           bool
+	Tree            string   // OID of the merged tree (written even when conflicted)
+	ConflictedPaths []string // sorted, empty when Clean
+}
+
+// SimulateMerge merges theirs into ours with git merge-tree --write-tree.
+// It writes objects to the repository's object store but never touches the
+// index, the working tree or any ref, so it is safe to run against a checkout
+// the user is working in. Requires git 2.38 or newer.
+func SimulateMerge(repoPath, ours, theirs string) (*MergeSimulation, error) {
+	cmd := exec.Command("git", "merge-tree", "--write-tree", "--name-only", "--no-messages", ours, theirs)
+	cmd.Dir = repoPath
+	out, err := cmd.Output()
+
+	clean := true
+	if err != nil {
+		// Exit status 1 means the merge has conflicts; anything else is a failure
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok || exitErr.ExitCode() != 1 {
+			if ok {
+				return nil, fmt.Errorf("git merge-tree %s %s: %s", ours, theirs, strings.TrimSpace(string(exitErr.Stderr)))
+			}
+			return nil, fmt.Errorf("git merge-tree %s %s: %w", ours, theirs, err)
+		}
+		clean = false
+	}
+
+	return parseMergeTree(string(out), clean), nil
+}
+
+// parseMergeTree parses the output of git merge-tree --write-tree --name-only:
+// the tree OID on the first line followed by one conflicted path per line.
+func parseMergeTree(output string, clean bool) *MergeSimulation {
+	sim := &MergeSimulation{Clean: clean}
+	lines := strings.Split(strings.TrimRight(output, "\n"), "\n")
+	if len(lines) == 0 {
+		return sim
+	}
+	sim.Tree = strings.TrimSpace(lines[0])
+
+	seen := make(map[string]bool)
+	for _, line := range lines[1:] {
+		if line == "" {
+			break // informational messages follow a blank line
+		}
+		if !seen[line] {
+			seen[line] = true
+			sim.ConflictedPaths = append(sim.ConflictedPaths, line)
+		}
+	}
+	sort.Strings(sim.ConflictedPaths)
+	return sim
+}
 
+// CanMergeCleanly reports whether branchName merges into baseBranch without
+// conflicts. It simulates the merge and leaves the working tree untouched.
+func CanMergeCleanly(repoPath, baseBranch, branchName string) (bool, error) {
+	sim, err := SimulateMerge(repoPath, baseBranch, branchName)
 	if err != nil {
-		return false, nil // merge would conflict
+		return false, err
 	}
-	return true, nil
+	return sim.Clean, nil
 }
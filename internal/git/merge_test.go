@@ -0,0 +1,82 @@
+package git
+
+import (
+	"testing"
+)
+
+func TestParseMergeTree(t *testing.T) {
+	tests := []struct {
+		name      string
+		output    string
+		clean     bool
+		wantTree  string
+		wantPaths []string
+	}{
+		{
+			name:     "clean merge",
+			output:   "4b825dc642cb6eb9a060e54bf8d69288fbee4904\n",
+			clean:    true,
+			wantTree: "4b825dc642cb6eb9a060e54bf8d69288fbee4904",
+		},
+		{
+			name:      "conflicts are sorted and deduplicated",
+			output:    "abc123\nz.go\na.go\nz.go\n",
+			wantTree:  "abc123",
+			wantPaths: []string{"a.go", "z.go"},
+		},
+		{
+			name:      "messages after blank line ignored",
+			output:    "abc123\nshared.go\n\nAuto-merging shared.go\nCONFLICT (content): Merge conflict in shared.go\n",
+			wantTree:  "abc123",
+			wantPaths: []string{"shared.go"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseMergeTree(tt.output, tt.clean)
+			if got.Clean != tt.clean {
+				t.Errorf("Clean = %v, want %v", got.Clean, tt.clean)
+			}
+			if got.Tree != tt.wantTree {
+				t.Errorf("Tree = %q, want %q", got.Tree, tt.wantTree)
+			}
+			if len(got.ConflictedPaths) != len(tt.wantPaths) {
+				t.Fatalf("got paths %v, want %v", got.ConflictedPaths, tt.wantPaths)
+			}
+			for i, p := range tt.wantPaths {
+				if got.ConflictedPaths[i] != p {
+					t.Errorf("path[%d] = %q, want %q", i, got.ConflictedPaths[i], p)
+				}
+			}
+		})
+	}
+}
+
+func TestSquashMessage(t *testing.T) {
+	got := SquashMessage("agit/fix-login", "Fix login redirect", "task-123")
+	want := "Fix login redirect\n\nSquashed from agit/fix-login via agit.\nTask: task-123"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	got = SquashMessage("agit/misc", "", "")
+	want = "Merge agit/misc via agit\n\nSquashed from agit/misc via agit."
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestParseMergeStrategy(t *testing.T) {
+	if s, err := ParseMergeStrategy(""); err != nil || s != StrategyMerge {
+		t.Errorf("empty name: got %q, %v", s, err)
+	}
+	for _, name := range []string{"merge", "squash", "rebase", "ff-only"} {
+		if s, err := ParseMergeStrategy(name); err != nil || string(s) != name {
+			t.Errorf("%s: got %q, %v", name, s, err)
+		}
+	}
+	if _, err := ParseMergeStrategy("octopus"); err == nil {
+		t.Error("expected error for unknown strategy")
+	}
+}
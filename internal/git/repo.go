@@ -67,6 +67,12 @@ func BranchExists(repoPath, branch string) bool {
 	return runGitNoOutput(repoPath, "rev-parse", "--verify", branch) == nil
 }
 
+// LocalBranchExists checks if refs/heads/<branch> exists, ignoring tags,
+// remote-tracking branches and other refs of the same name
+func LocalBranchExists(repoPath, branch string) bool {
+	return runGitNoOutput(repoPath, "show-ref", "--verify", "--quiet", "refs/heads/"+branch) == nil
+}
+
 // GetCurrentBranch returns the current branch name
 func GetCurrentBranch(repoPath string) (string, error) {
 	out, err := runGit(repoPath, "rev-parse", "--abbrev-ref", "HEAD")
@@ -76,6 +82,23 @@ func GetCurrentBranch(repoPath string) (string, error) {
 	return strings.TrimSpace(out), nil
 }
 
+// GetHeadCommit returns the commit SHA checked out at path
+func GetHeadCommit(path string) (string, error) {
+	out, err := runGit(path, "rev-parse", "HEAD")
+	if err != nil {
+		return "", fmt.Errorf("could not resolve HEAD: %w", err)
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// ShortSHA abbreviates a commit SHA for display
+func ShortSHA(sha string) string {
+	if len(sha) > 12 {
+		return sha[:12]
+	}
+	return sha
+}
+
 // runGit executes a git command in the given directory and returns stdout
 func runGit(dir string, args ...string) (string, error) {
 	cmd := exec.Command("git", args...)
@@ -0,0 +1,156 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SyncMode selects how a worktree branch picks up its base branch
+type SyncMode string
+
+const (
+	SyncRebase SyncMode = "rebase" // replay the branch's commits onto the base
+	SyncMerge  SyncMode = "merge"  // merge the base into the branch
+)
+
+// Sync refusals the user can resolve, as opposed to git failures
+var (
+	ErrUnknownSyncMode    = errors.New("unknown sync mode")
+	ErrSyncInProgress     = errors.New("a rebase or merge is already in progress")
+	ErrUncommittedChanges = errors.New("worktree has uncommitted changes")
+	ErrNoSyncInProgress   = errors.New("no rebase or merge in progress")
+)
+
+// SyncResult describes the outcome of Sync
+type SyncResult struct {
+	Mode            SyncMode `json:"mode"`
+	Base            string   `json:"base"`     // base branch commit synced onto
+	OldHead         string   `json:"old_head"` // branch tip before syncing
+	NewHead         string   `json:"new_head"` // branch tip after syncing; empty while conflicted
+	UpToDate        bool     `json:"up_to_date"`
+	ConflictedPaths []string `json:"conflicted_paths,omitempty"`
+}
+
+// Conflicted reports whether the sync stopped on conflicts
+func (r *SyncResult) Conflicted() bool {
+	return len(r.ConflictedPaths) > 0
+}
+
+// SyncInProgress returns the mode of a rebase or merge that is stopped in a
+// worktree waiting for conflicts to be resolved, or "" if there is none
+func SyncInProgress(worktreePath string) (SyncMode, error) {
+	gitDir, err := GitDir(worktreePath)
+	if err != nil {
+		return "", err
+	}
+	for _, name := range []string{"rebase-merge", "rebase-apply"} {
+		if _, err := os.Stat(filepath.Join(gitDir, name)); err == nil {
+			return SyncRebase, nil
+		}
+	}
+	if _, err := os.Stat(filepath.Join(gitDir, "MERGE_HEAD")); err == nil {
+		return SyncMerge, nil
+	}
+	return "", nil
+}
+
+// Sync brings a worktree's branch up to date with baseBranch, which may be
+// any ref, by rebasing onto it or merging it in. If git stops on conflicts,
+// the rebase or merge is left in progress so it can be resolved and
+// continued, or undone with AbortSync, and the conflicted paths are returned
+// in the result rather than as an error. Uncommitted changes in the worktree
+// refuse the sync.
+func Sync(worktreePath, baseBranch string, mode SyncMode) (*SyncResult, error) {
+	if mode == "" {
+		mode = SyncRebase
+	}
+	if mode != SyncRebase && mode != SyncMerge {
+		return nil, fmt.Errorf("%w %q: must be rebase or merge", ErrUnknownSyncMode, mode)
+	}
+
+	if inProgress, err := SyncInProgress(worktreePath); err != nil {
+		return nil, err
+	} else if inProgress != "" {
+		return nil, fmt.Errorf("%w; resolve the %s and run git %s --continue, or abort it", ErrSyncInProgress, inProgress, inProgress)
+	}
+	status, err := runGit(worktreePath, "status", "--porcelain", "--untracked-files=no")
+	if err != nil {
+		return nil, fmt.Errorf("could not check worktree status: %w", err)
+	}
+	if strings.TrimSpace(status) != "" {
+		return nil, fmt.Errorf("%w; commit or stash them before syncing", ErrUncommittedChanges)
+	}
+
+	base, err := revParse(worktreePath, baseBranch)
+	if err != nil {
+		return nil, err
+	}
+	head, err := revParse(worktreePath, "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	result := &SyncResult{Mode: mode, Base: base, OldHead: head, NewHead: head}
+	if isAncestor(worktreePath, base, head) {
+		result.UpToDate = true
+		return result, nil
+	}
+
+	var runErr error
+	if mode == SyncRebase {
+		_, runErr = runGit(worktreePath, "rebase", base)
+	} else {
+		_, runErr = runGit(worktreePath, "merge", "--no-edit", "-m", fmt.Sprintf("Sync %s via agit", baseBranch), base)
+	}
+	if runErr != nil {
+		inProgress, err := SyncInProgress(worktreePath)
+		if err != nil || inProgress == "" {
+			return nil, fmt.Errorf("%s onto %s failed: %w", mode, baseBranch, runErr)
+		}
+		paths, err := ConflictedFiles(worktreePath)
+		if err != nil {
+			return nil, err
+		}
+		result.NewHead = ""
+		result.ConflictedPaths = paths
+		return result, nil
+	}
+
+	if result.NewHead, err = revParse(worktreePath, "HEAD"); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// AbortSync undoes a rebase or merge left in progress by Sync, restoring the
+// branch to where it was. It returns the mode that was aborted.
+func AbortSync(worktreePath string) (SyncMode, error) {
+	mode, err := SyncInProgress(worktreePath)
+	if err != nil {
+		return "", err
+	}
+	if mode == "" {
+		return "", ErrNoSyncInProgress
+	}
+	if _, err := runGit(worktreePath, string(mode), "--abort"); err != nil {
+		return "", fmt.Errorf("could not abort %s: %w", mode, err)
+	}
+	return mode, nil
+}
+
+// ConflictedFiles lists the unmerged paths in a worktree
+func ConflictedFiles(worktreePath string) ([]string, error) {
+	out, err := runGit(worktreePath, "diff", "--name-only", "--diff-filter=U", "-z")
+	if err != nil {
+		return nil, fmt.Errorf("could not list conflicted files: %w", err)
+	}
+	var paths []string
+	for _, p := range strings.Split(out, "\x00") {
+		if p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths, nil
+}
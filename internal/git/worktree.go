@@ -37,6 +37,21 @@ func CreateWorktree(repoPath, worktreePath, branchName, baseBranch string) error
 	return nil
 }
 
+// AttachWorktree creates a git worktree at the specified path that checks
+// out an existing local branch instead of creating a new one
+func AttachWorktree(repoPath, worktreePath, branchName string) error {
+	if !LocalBranchExists(repoPath, branchName) {
+		return fmt.Errorf("branch %q does not exist", branchName)
+	}
+	if err := os.MkdirAll(filepath.Dir(worktreePath), 0755); err != nil {
+		return fmt.Errorf("could not create worktree parent directory: %w", err)
+	}
+	if _, err := runGit(repoPath, "worktree", "add", worktreePath, branchName); err != nil {
+		return fmt.Errorf("could not create worktree: %w", err)
+	}
+	return nil
+}
+
 // RemoveWorktree removes a git worktree
 func RemoveWorktree(repoPath, worktreePath string) error {
 	_, err := runGit(repoPath, "worktree", "remove", worktreePath, "--force")
@@ -72,3 +87,31 @@ func DeleteBranch(repoPath, branchName string) error {
 	_, err := runGit(repoPath, "branch", "-D", branchName)
 	return err
 }
+
+// GitDir returns the absolute path of a worktree's private git directory,
+// where its HEAD and index live
+func GitDir(worktreePath string) (string, error) {
+	out, err := runGit(worktreePath, "rev-parse", "--absolute-git-dir")
+	if err != nil {
+		return "", fmt.Errorf("could not find git directory: %w", err)
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// IgnoredDirs returns the directories in a worktree that are entirely
+// ignored by git (such as build output or dependency caches), relative to
+// the worktree root
+func IgnoredDirs(worktreePath string) ([]string, error) {
+	out, err := runGit(worktreePath, "ls-files", "--others", "--ignored", "--exclude-standard", "--directory", "-z")
+	if err != nil {
+		return nil, fmt.Errorf("could not list ignored files: %w", err)
+	}
+
+	var dirs []string
+	for _, entry := range strings.Split(out, "\x00") {
+		if strings.HasSuffix(entry, "/") {
+			dirs = append(dirs, strings.TrimSuffix(entry, "/"))
+		}
+	}
+	return dirs, nil
+}
@@ -0,0 +1,351 @@
+package hooks
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/fathindos/agit/internal/config"
+	"github.com/fathindos/agit/internal/registry"
+)
+
+// Every event in the registry's event log reaches hooks with a typed
+// payload, one type per kind of entity. A hook command gets the payload
+// twice: as AGIT_* environment variables, one per field, and as the data of
+// the JSON document on its stdin, which is also the body sent to webhooks.
+// JSON keys are the variable names without the AGIT_ prefix, lowercased, so
+// AGIT_TASK_ID is task_id. Lists are comma-separated in the environment.
+
+// EventInfo identifies an event, its repo and the agent that caused it. It
+// is part of every payload.
+type EventInfo struct {
+	EventID int64  `json:"event_id"`
+	Repo    string `json:"repo,omitempty"`
+	AgentID string `json:"agent_id,omitempty"`
+	Agent   string `json:"agent,omitempty"`
+}
+
+// WorktreePayload is the payload of worktree.created, worktree.setup,
+// worktree.synced and worktree.removed
+type WorktreePayload struct {
+	EventInfo
+	WorktreeID      string   `json:"worktree_id"`
+	WorktreePath    string   `json:"worktree_path,omitempty"`
+	Branch          string   `json:"branch,omitempty"`
+	BaseBranch      string   `json:"base_branch,omitempty"`
+	Task            string   `json:"task,omitempty"`
+	Status          string   `json:"status,omitempty"`
+	SetupStatus     string   `json:"setup_status,omitempty"`
+	FailedStep      string   `json:"failed_step,omitempty"`
+	SyncMode        string   `json:"sync_mode,omitempty"`
+	SyncResult      string   `json:"sync_result,omitempty"`
+	ConflictedPaths []string `json:"conflicted_paths,omitempty"`
+}
+
+// MergePayload is the payload of worktree.merged and merge.failed. Commit
+// is set once merged, Reason when the merge was refused or failed.
+type MergePayload struct {
+	WorktreePayload
+	Strategy string `json:"strategy,omitempty"`
+	Commit   string `json:"commit,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// TaskPayload is the payload of task.* events
+type TaskPayload struct {
+	EventInfo
+	TaskID     string   `json:"task_id"`
+	Task       string   `json:"task,omitempty"`
+	TaskStatus string   `json:"task_status,omitempty"`
+	Priority   int      `json:"priority"`
+	Scope      []string `json:"scope,omitempty"`
+	WorktreeID string   `json:"worktree_id,omitempty"`
+	Result     string   `json:"result,omitempty"`
+	Reason     string   `json:"reason,omitempty"`
+	Attempts   int      `json:"attempts,omitempty"`
+	BlockedBy  string   `json:"blocked_by,omitempty"`
+}
+
+// AgentPayload is the payload of agent.* events
+type AgentPayload struct {
+	EventInfo
+	AgentType   string `json:"agent_type,omitempty"`
+	AgentStatus string `json:"agent_status,omitempty"`
+	Reason      string `json:"reason,omitempty"`
+}
+
+// RepoPayload is the payload of repo.added
+type RepoPayload struct {
+	EventInfo
+	RepoPath      string `json:"repo_path"`
+	DefaultBranch string `json:"default_branch"`
+	RemoteURL     string `json:"remote_url,omitempty"`
+}
+
+// ConflictPayload is the payload of conflict.detected. A conflict between
+// worktrees over a file sets File, Worktrees and Conflict (in_flight or
+// committed); one found by the merge queue sets WorktreeID and Paths.
+type ConflictPayload struct {
+	EventInfo
+	File       string   `json:"file,omitempty"`
+	Worktrees  []string `json:"worktrees,omitempty"`
+	Conflict   string   `json:"conflict,omitempty"`
+	WorktreeID string   `json:"worktree_id,omitempty"`
+	Paths      []string `json:"paths,omitempty"`
+	Source     string   `json:"source,omitempty"`
+}
+
+// LockPayload is the payload of lock.acquired and lock.released
+type LockPayload struct {
+	EventInfo
+	LockID    string `json:"lock_id"`
+	Pattern   string `json:"pattern"`
+	Reason    string `json:"reason,omitempty"`
+	ExpiresAt string `json:"expires_at,omitempty"`
+}
+
+// PayloadFor builds the typed payload of an event. The entity it is about is
+// read from db where it still exists, so the payload reflects the state
+// right after the event; otherwise, as after worktree.removed, the payload
+// is filled from what the event log recorded.
+func PayloadFor(db *registry.DB, e *registry.Event) any {
+	info := EventInfo{EventID: e.ID}
+	if e.RepoID != nil {
+		if repo, err := db.GetRepoByID(*e.RepoID); err == nil {
+			info.Repo = repo.Name
+		}
+	}
+	if e.AgentID != nil {
+		info.AgentID = *e.AgentID
+	}
+	if e.Actor != nil {
+		info.Agent = *e.Actor
+	}
+	data := e.Payload
+
+	switch e.EntityType {
+	case "worktree":
+		switch e.Type {
+		case registry.EventConflictDetected:
+			return ConflictPayload{
+				EventInfo: info, WorktreeID: e.EntityID,
+				Paths: payloadStrings(data, "paths"), Source: payloadString(data, "source"),
+			}
+		case registry.EventWorktreeMerged, registry.EventMergeFailed:
+			return MergePayload{
+				WorktreePayload: worktreePayload(db, info, e.EntityID, data),
+				Strategy:        payloadString(data, "strategy"),
+				Commit:          payloadString(data, "commit"),
+				Reason:          payloadString(data, "reason"),
+			}
+		}
+		return worktreePayload(db, info, e.EntityID, data)
+
+	case "task":
+		p := TaskPayload{
+			EventInfo:  info,
+			TaskID:     e.EntityID,
+			Task:       payloadString(data, "description"),
+			WorktreeID: payloadString(data, "worktree_id"),
+			Result:     payloadString(data, "result"),
+			Reason:     payloadString(data, "reason"),
+			Attempts:   payloadInt(data, "attempts"),
+			BlockedBy:  payloadString(data, "blocked_by"),
+		}
+		if t, err := db.GetTask(e.EntityID); err == nil {
+			p.Task, p.TaskStatus, p.Priority, p.Scope = t.Description, t.Status, t.Priority, t.Scope
+			if p.WorktreeID == "" && t.WorktreeID != nil {
+				p.WorktreeID = *t.WorktreeID
+			}
+			if p.Result == "" && t.Result != nil {
+				p.Result = *t.Result
+			}
+		}
+		return p
+
+	case "agent":
+		p := AgentPayload{EventInfo: info, AgentType: payloadString(data, "type"), Reason: payloadString(data, "reason")}
+		if a, err := db.GetAgent(e.EntityID); err == nil {
+			p.Agent, p.AgentType, p.AgentStatus = a.Name, a.Type, a.Status
+		}
+		return p
+
+	case "repo":
+		p := RepoPayload{
+			EventInfo:     info,
+			RepoPath:      payloadString(data, "path"),
+			DefaultBranch: payloadString(data, "default_branch"),
+		}
+		if repo, err := db.GetRepoByID(e.EntityID); err == nil {
+			p.RepoPath, p.DefaultBranch, p.RemoteURL = repo.Path, repo.DefaultBranch, repo.RemoteURL
+		}
+		return p
+
+	case "file":
+		return ConflictPayload{
+			EventInfo: info, File: e.EntityID,
+			Worktrees: payloadStrings(data, "worktrees"), Conflict: payloadString(data, "conflict"),
+		}
+
+	case "lock":
+		return LockPayload{
+			EventInfo: info, LockID: e.EntityID,
+			Pattern:   payloadString(data, "pattern"),
+			Reason:    payloadString(data, "reason"),
+			ExpiresAt: payloadString(data, "expires_at"),
+		}
+	}
+	return info
+}
+
+func worktreePayload(db *registry.DB, info EventInfo, id string, data map[string]any) WorktreePayload {
+	p := WorktreePayload{
+		EventInfo:       info,
+		WorktreeID:      id,
+		WorktreePath:    payloadString(data, "path"),
+		Branch:          payloadString(data, "branch"),
+		BaseBranch:      payloadString(data, "into"),
+		Task:            payloadString(data, "task"),
+		SetupStatus:     payloadString(data, "status"),
+		FailedStep:      payloadString(data, "failed_step"),
+		SyncMode:        payloadString(data, "mode"),
+		SyncResult:      payloadString(data, "result"),
+		ConflictedPaths: payloadStrings(data, "conflicted_paths"),
+	}
+	if base := payloadString(data, "base"); base != "" {
+		p.BaseBranch = base
+	}
+	wt, err := db.GetWorktree(id)
+	if err != nil {
+		return p
+	}
+	p.WorktreePath, p.Branch, p.Status = wt.Path, wt.Branch, wt.Status
+	if wt.TaskDescription != nil {
+		p.Task = *wt.TaskDescription
+	}
+	if repo, err := db.GetRepoByID(wt.RepoID); err == nil {
+		p.BaseBranch = wt.Base(repo.DefaultBranch)
+	}
+	return p
+}
+
+func payloadString(data map[string]any, key string) string {
+	if s, ok := data[key].(string); ok {
+		return s
+	}
+	return ""
+}
+
+func payloadInt(data map[string]any, key string) int {
+	if n, ok := data[key].(float64); ok {
+		return int(n)
+	}
+	return 0
+}
+
+func payloadStrings(data map[string]any, key string) []string {
+	items, _ := data[key].([]any)
+	var out []string
+	for _, item := range items {
+		if s, ok := item.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// payloadData flattens a typed payload into the data of a JSON document
+func payloadData(payload any) map[string]any {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var data map[string]any
+	if err := dec.Decode(&data); err != nil {
+		return nil
+	}
+	return data
+}
+
+// payloadEnv turns payload data into AGIT_* environment variables. Lists
+// are joined with commas; nested objects are left out.
+func payloadEnv(data map[string]any) map[string]string {
+	env := make(map[string]string, len(data))
+	for k, v := range data {
+		name := "AGIT_" + strings.ToUpper(k)
+		switch v := v.(type) {
+		case string:
+			env[name] = v
+		case json.Number, bool:
+			env[name] = fmt.Sprint(v)
+		case []any:
+			items := make([]string, 0, len(v))
+			for _, item := range v {
+				items = append(items, fmt.Sprint(item))
+			}
+			env[name] = strings.Join(items, ",")
+		}
+	}
+	return env
+}
+
+// Dispatcher fires the hooks and webhooks configured for every event a
+// registry publishes, with the event's typed payload. Hooks for an event in
+// a repo follow that repo's config.
+type Dispatcher struct {
+	db  *registry.DB
+	cfg *config.Config
+	wg  sync.WaitGroup
+}
+
+// Attach subscribes a Dispatcher to the events recorded through db. Closing
+// db waits for the hooks and webhooks it fired.
+func Attach(db *registry.DB, cfg *config.Config) *Dispatcher {
+	d := &Dispatcher{db: db, cfg: cfg}
+	db.Subscribe(d.dispatch)
+	db.OnClose(d.Wait)
+	return d
+}
+
+func (d *Dispatcher) dispatch(e *registry.Event) {
+	r := NewRunner(d.configFor(e.RepoID), d.db)
+	if !r.Wants(e.Type) {
+		return
+	}
+
+	data := payloadData(PayloadFor(d.db, e))
+	r.Fire(e.Type, payloadEnv(data), data)
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		r.Wait()
+	}()
+}
+
+// configFor returns the config in effect for a repo, read afresh so that a
+// long-running process sees changes to the repo's hooks
+func (d *Dispatcher) configFor(repoID *string) *config.Config {
+	if repoID == nil {
+		return d.cfg
+	}
+	repo, err := d.db.GetRepoByID(*repoID)
+	if err != nil {
+		return d.cfg
+	}
+	cfg, err := d.cfg.ForRepo(repo.Path, repo.ConfigOverrides())
+	if err != nil {
+		log.Printf("hooks: config for %s: %v", repo.Name, err)
+		return d.cfg
+	}
+	return cfg
+}
+
+// Wait blocks until every hook and webhook fired so far has finished
+func (d *Dispatcher) Wait() {
+	d.wg.Wait()
+}
@@ -0,0 +1,112 @@
+package hooks
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fathindos/agit/internal/config"
+	"github.com/fathindos/agit/internal/registry"
+)
+
+func TestDispatcherSendsTypedPayload(t *testing.T) {
+	dir := t.TempDir()
+	stdinFile := filepath.Join(dir, "stdin.json")
+	envFile := filepath.Join(dir, "env.txt")
+
+	cfg := config.DefaultConfig()
+	cfg.Hooks = map[string][]string{
+		"task.created": {
+			"cat > " + stdinFile,
+			`echo "$AGIT_EVENT $AGIT_REPO $AGIT_TASK_ID $AGIT_PRIORITY $AGIT_SCOPE" > ` + envFile,
+		},
+	}
+
+	db, err := registry.OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	Attach(db, cfg)
+	repo, _ := db.AddRepo("demo", dir, "", "main")
+	task, err := db.CreateTask(repo.ID, "write docs", 3, "docs/**", "README.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Closing waits for the hooks
+	db.Close()
+
+	env, err := os.ReadFile(envFile)
+	if err != nil {
+		t.Fatalf("expected the task.created hook to run: %v", err)
+	}
+	if want := "task.created demo " + task.ID + " 3 docs/**,README.md\n"; string(env) != want {
+		t.Errorf("env: expected %q, got %q", want, env)
+	}
+
+	raw, err := os.ReadFile(stdinFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc Payload
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		t.Fatalf("invalid JSON on stdin: %v\n%s", err, raw)
+	}
+	if doc.Event != "task.created" || doc.ID == "" {
+		t.Errorf("unexpected document: %+v", doc)
+	}
+	if doc.Data["task_id"] != task.ID || doc.Data["task"] != "write docs" || doc.Data["task_status"] != "pending" {
+		t.Errorf("unexpected task data: %v", doc.Data)
+	}
+	if scope, _ := doc.Data["scope"].([]any); len(scope) != 2 {
+		t.Errorf("expected the scope as a JSON list, got %v", doc.Data["scope"])
+	}
+	if _, ok := doc.Data["event_id"].(float64); !ok {
+		t.Errorf("expected the event ID in the data, got %v", doc.Data["event_id"])
+	}
+}
+
+func TestPayloadForRemovedWorktree(t *testing.T) {
+	db, err := registry.OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	repo, _ := db.AddRepo("demo", "/tmp/demo", "", "main")
+	agent, _ := db.RegisterAgent("builder", "claude")
+	task := "fix login"
+	wt, _ := db.CreateWorktreeWithOptions(repo.ID, "/tmp/demo/wt", "agit/fix", registry.WorktreeOptions{
+		AgentID: &agent.ID, TaskDescription: &task,
+	})
+
+	created := lastEvent(t, db)
+	p, ok := PayloadFor(db, created).(WorktreePayload)
+	if !ok {
+		t.Fatalf("expected a WorktreePayload, got %T", PayloadFor(db, created))
+	}
+	if p.Repo != "demo" || p.Agent != "builder" || p.BaseBranch != "main" || p.Status != "active" {
+		t.Errorf("unexpected created payload: %+v", p)
+	}
+
+	db.DeleteWorktree(wt.ID)
+	removed := lastEvent(t, db)
+	p = PayloadFor(db, removed).(WorktreePayload)
+	if p.WorktreeID != wt.ID || p.Branch != "agit/fix" || p.WorktreePath != "/tmp/demo/wt" || p.Task != task {
+		t.Errorf("expected the removed worktree from the event log, got %+v", p)
+	}
+
+	env := payloadEnv(payloadData(p))
+	if env["AGIT_WORKTREE_PATH"] != "/tmp/demo/wt" || env["AGIT_AGENT"] != "builder" || env["AGIT_EVENT_ID"] == "" {
+		t.Errorf("unexpected env: %v", env)
+	}
+}
+
+func lastEvent(t *testing.T, db *registry.DB) *registry.Event {
+	t.Helper()
+	events, err := db.ListEvents(registry.EventFilter{Limit: 1})
+	if err != nil || len(events) != 1 {
+		t.Fatalf("ListEvents: %v", err)
+	}
+	return events[0]
+}
@@ -1,79 +1,273 @@
 package hooks
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"os/exec"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/fathindos/agit/internal/config"
+	apperrors "github.com/fathindos/agit/internal/errors"
+	"github.com/fathindos/agit/internal/registry"
 )
 
-// Runner executes hook commands configured in the agit config.
+// Blocking events, run by Check before the operation they guard. A hook for
+// one of them that exits non-zero vetoes the operation.
+const (
+	PreSpawn          = "pre.spawn"
+	PreMerge          = "pre.merge"
+	PreTaskClaim      = "pre.task.claim"
+	PreWorktreeRemove = "pre.worktree.remove"
+)
+
+// VetoError reports a pre.* hook that refused an operation. Stderr holds
+// the hook's error output, which is shown to the user as the reason.
+type VetoError struct {
+	Event    string
+	ExitCode int
+	Stderr   string
+}
+
+func (e *VetoError) Error() string {
+	if e.Stderr != "" {
+		return fmt.Sprintf("%s hook refused: %s", e.Event, e.Stderr)
+	}
+	if e.ExitCode < 0 {
+		return fmt.Sprintf("%s hook refused: timed out", e.Event)
+	}
+	return fmt.Sprintf("%s hook refused (exit %d)", e.Event, e.ExitCode)
+}
+
+// Unwrap marks a veto as a user error, so it is reported as is rather than
+// as a bug in agit
+func (e *VetoError) Unwrap() error {
+	return apperrors.NewUserError(e.Error())
+}
+
+// MaxOutput is how many trailing bytes of a hook's stdout and stderr are
+// kept in its run history
+const MaxOutput = 4 * 1024
+
+// Blocking reports whether an event is a pre.* event, run by Check
+func Blocking(event string) bool {
+	return strings.HasPrefix(event, "pre.")
+}
+
+// Runner executes hook commands and delivers webhooks configured in the
+// agit config. Every hook run, and every webhook delivery that runs out of
+// attempts, is recorded in the registry.
 type Runner struct {
-	hooks   map[string]string
-	timeout time.Duration
-	wg      sync.WaitGroup
+	hooks    map[string][]string
+	webhooks map[string]config.Webhook
+	timeout  time.Duration
+	wg       sync.WaitGroup
+
+	// record stores hook runs for agit hooks history
+	record func(*registry.HookRun) error
+	// failed stores webhook deliveries that ran out of attempts
+	failed func(*registry.WebhookDelivery) error
 }
 
-// NewRunner creates a Runner from the config. Returns nil if no hooks are configured.
-func NewRunner(cfg *config.Config) *Runner {
-	if len(cfg.Hooks) == 0 {
+// NewRunner creates a Runner from the config that records into db, which
+// must stay open until Wait returns. A nil db records nothing. Returns nil if
+// no hooks or webhooks are configured.
+func NewRunner(cfg *config.Config, db *registry.DB) *Runner {
+	if cfg == nil || (len(cfg.Hooks) == 0 && len(cfg.Webhooks) == 0) {
 		return nil
 	}
 
-	timeout := 30 * time.Second
-	if cfg.HookTimeout != "" {
-		if d, err := time.ParseDuration(cfg.HookTimeout); err == nil {
-			timeout = d
-		}
+	r := &Runner{
+		hooks:    cfg.Hooks,
+		webhooks: cfg.Webhooks,
+		timeout:  hookTimeout(cfg),
+		record:   func(*registry.HookRun) error { return nil },
+		failed:   func(*registry.WebhookDelivery) error { return nil },
 	}
+	if db != nil {
+		r.record, r.failed = db.RecordHookRun, db.RecordFailedDelivery
+	}
+	return r
+}
 
-	return &Runner{
-		hooks:   cfg.Hooks,
-		timeout: timeout,
+// hookTimeout is the time a hook command or a single webhook request may
+// take, from hook_timeout
+func hookTimeout(cfg *config.Config) time.Duration {
+	if d, err := time.ParseDuration(cfg.HookTimeout); err == nil && d > 0 {
+		return d
 	}
+	return 30 * time.Second
 }
 
-// Fire spawns a goroutine to run the hook command for the given event.
-// The env map provides environment variables (AGIT_EVENT, AGIT_REPO, etc.).
-// Fire returns immediately; the hook runs asynchronously.
-// If no hook is configured for the event, this is a no-op.
-func (r *Runner) Fire(event string, env map[string]string) {
+// Wants reports whether any hook command or webhook is configured for an
+// event. Safe to call on a nil Runner.
+func (r *Runner) Wants(event string) bool {
 	if r == nil {
-		return
+		return false
 	}
+	if len(r.hooks[event]) > 0 {
+		return true
+	}
+	for _, w := range r.webhooks {
+		if w.Wants(event) {
+			return true
+		}
+	}
+	return false
+}
 
-	command, ok := r.hooks[event]
-	if !ok || command == "" {
+// Fire runs the hook commands and delivers the webhooks for the given event
+// in the background. The env map provides environment variables (AGIT_REPO,
+// etc.) for the commands; AGIT_EVENT is added. Commands get a JSON document
+// on stdin, and webhooks receive it as their body: its data holds the env
+// values, keyed without the AGIT_ prefix, plus anything in data. Fire
+// returns immediately. If nothing is configured for the event, this is a
+// no-op.
+func (r *Runner) Fire(event string, env map[string]string, data map[string]any) {
+	if r == nil {
 		return
 	}
 
-	// Build environment
-	envSlice := make([]string, 0, len(env)+1)
-	envSlice = append(envSlice, "AGIT_EVENT="+event)
-	for k, v := range env {
-		envSlice = append(envSlice, k+"="+v)
+	p := NewPayload(event, env, data)
+	for name, w := range r.webhooks {
+		if w.Wants(event) {
+			r.wg.Add(1)
+			go r.deliver(name, w, p)
+		}
 	}
 
+	if len(r.hooks[event]) == 0 {
+		return
+	}
 	r.wg.Add(1)
 	go func() {
 		defer r.wg.Done()
+		for _, run := range r.run(event, env, p) {
+			if run.ExitCode != 0 {
+				log.Printf("hook %q failed: %s", event, describeRun(run))
+			}
+		}
+	}()
+}
+
+// Check runs the hook commands for a pre.* event and waits for them. A
+// command that exits non-zero or times out vetoes the operation: Check
+// returns a *VetoError carrying its stderr, and later commands are skipped.
+// Check returns nil if no hook is configured for the event, and is safe to
+// call on a nil Runner.
+func (r *Runner) Check(event string, env map[string]string) error {
+	if r == nil {
+		return nil
+	}
+
+	runs := r.Run(event, env, nil)
+	if len(runs) == 0 {
+		return nil
+	}
+	last := runs[len(runs)-1]
+	if last.ExitCode == 0 {
+		return nil
+	}
+	return &VetoError{Event: event, ExitCode: last.ExitCode, Stderr: strings.TrimSpace(last.Stderr)}
+}
 
-		ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
-		defer cancel()
+// Run runs the hook commands for an event in order, waits for them and
+// records each run. The commands get env and the JSON document Fire would
+// send. For a pre.* event it stops at the first command that fails, as
+// Check does. Safe to call on a nil Runner.
+func (r *Runner) Run(event string, env map[string]string, data map[string]any) []*registry.HookRun {
+	if r == nil {
+		return nil
+	}
+	return r.run(event, env, NewPayload(event, env, data))
+}
 
-		cmd := exec.CommandContext(ctx, "sh", "-c", command)
-		cmd.Env = append(cmd.Environ(), envSlice...)
+func (r *Runner) run(event string, env map[string]string, p *Payload) []*registry.HookRun {
+	stdin, err := json.Marshal(p)
+	if err != nil {
+		log.Printf("hook %q: could not encode payload: %v", event, err)
+	}
 
-		if err := cmd.Run(); err != nil {
-			log.Printf("hook %q failed: %v", event, err)
+	var runs []*registry.HookRun
+	for _, command := range r.hooks[event] {
+		run := r.runCommand(event, command, env, stdin)
+		if err := r.record(run); err != nil {
+			log.Printf("hook %q: %v", event, err)
 		}
-	}()
+		runs = append(runs, run)
+		if run.ExitCode != 0 && Blocking(event) {
+			break
+		}
+	}
+	return runs
+}
+
+func (r *Runner) runCommand(event, command string, env map[string]string, stdin []byte) *registry.HookRun {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Env = append(cmd.Environ(), hookEnv(event, env)...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// Don't wait on pipes held open by grandchildren once the shell is killed
+	cmd.WaitDelay = time.Second
+
+	start := time.Now()
+	err := cmd.Run()
+	run := &registry.HookRun{
+		Event:      event,
+		Command:    command,
+		Repo:       env["AGIT_REPO"],
+		DurationMS: time.Since(start).Milliseconds(),
+		Stdout:     tail(stdout.Bytes(), MaxOutput),
+		Stderr:     tail(stderr.Bytes(), MaxOutput),
+		CreatedAt:  start,
+	}
+
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+	case ctx.Err() == context.DeadlineExceeded:
+		run.ExitCode = -1
+		run.TimedOut = true
+	case errors.As(err, &exitErr):
+		run.ExitCode = exitErr.ExitCode()
+	default:
+		run.ExitCode = -1
+		run.Stderr = err.Error()
+	}
+	return run
 }
 
-// Wait blocks until all fired hooks have completed or timed out.
+// describeRun summarizes a failed run for the log
+func describeRun(run *registry.HookRun) string {
+	if run.TimedOut {
+		return fmt.Sprintf("%s: timed out after %dms", run.Command, run.DurationMS)
+	}
+	desc := fmt.Sprintf("%s: exit %d", run.Command, run.ExitCode)
+	if msg := strings.TrimSpace(run.Stderr); msg != "" {
+		desc += ": " + msg
+	}
+	return desc
+}
+
+func tail(b []byte, max int) string {
+	if len(b) <= max {
+		return string(b)
+	}
+	return "...\n" + string(b[len(b)-max:])
+}
+
+// Wait blocks until all fired hooks have completed or timed out, and every
+// webhook has been delivered or has run out of attempts.
 // Safe to call on a nil Runner.
 func (r *Runner) Wait() {
 	if r == nil {
@@ -81,3 +275,37 @@ func (r *Runner) Wait() {
 	}
 	r.wg.Wait()
 }
+
+// WorktreeEnv describes a worktree to a hook with AGIT_REPO,
+// AGIT_WORKTREE_ID, AGIT_WORKTREE_PATH, AGIT_BRANCH and AGIT_BASE_BRANCH
+func WorktreeEnv(repo *registry.Repo, wt *registry.Worktree) map[string]string {
+	return map[string]string{
+		"AGIT_REPO":          repo.Name,
+		"AGIT_WORKTREE_ID":   wt.ID,
+		"AGIT_WORKTREE_PATH": wt.Path,
+		"AGIT_BRANCH":        wt.Branch,
+		"AGIT_BASE_BRANCH":   wt.Base(repo.DefaultBranch),
+	}
+}
+
+// TaskEnv describes a task and the agent claiming it to a hook with
+// AGIT_REPO, AGIT_TASK_ID, AGIT_TASK, AGIT_AGENT_ID and AGIT_AGENT
+func TaskEnv(repo *registry.Repo, task *registry.Task, agentID, agentName string) map[string]string {
+	return map[string]string{
+		"AGIT_REPO":     repo.Name,
+		"AGIT_TASK_ID":  task.ID,
+		"AGIT_TASK":     task.Description,
+		"AGIT_AGENT_ID": agentID,
+		"AGIT_AGENT":    agentName,
+	}
+}
+
+// hookEnv builds the environment passed to a hook, AGIT_EVENT first
+func hookEnv(event string, env map[string]string) []string {
+	envSlice := make([]string, 0, len(env)+1)
+	envSlice = append(envSlice, "AGIT_EVENT="+event)
+	for k, v := range env {
+		envSlice = append(envSlice, k+"="+v)
+	}
+	return envSlice
+}
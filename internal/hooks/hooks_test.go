@@ -1,12 +1,14 @@
 package hooks
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/fathindos/agit/internal/config"
+	"github.com/fathindos/agit/internal/registry"
 )
 
 func TestFireWritesFile(t *testing.T) {
@@ -14,15 +16,15 @@ func TestFireWritesFile(t *testing.T) {
 	outFile := filepath.Join(dir, "hook_output.txt")
 
 	cfg := config.DefaultConfig()
-	cfg.Hooks = map[string]string{
-		"worktree.created": "echo $AGIT_EVENT > " + outFile,
+	cfg.Hooks = map[string][]string{
+		"worktree.created": {"echo $AGIT_EVENT > " + outFile},
 	}
 	cfg.HookTimeout = "5s"
 
-	r := NewRunner(cfg)
+	r := NewRunner(cfg, nil)
 	r.Fire("worktree.created", map[string]string{
 		"AGIT_REPO": "test-repo",
-	})
+	}, nil)
 
 	// Wait for async hook to complete
 	deadline := time.After(3 * time.Second)
@@ -50,14 +52,14 @@ func TestFireWritesFile(t *testing.T) {
 
 func TestFireTimeout(t *testing.T) {
 	cfg := config.DefaultConfig()
-	cfg.Hooks = map[string]string{
-		"slow.event": "sleep 10",
+	cfg.Hooks = map[string][]string{
+		"slow.event": {"sleep 10"},
 	}
 	cfg.HookTimeout = "100ms"
 
-	r := NewRunner(cfg)
+	r := NewRunner(cfg, nil)
 	start := time.Now()
-	r.Fire("slow.event", nil)
+	r.Fire("slow.event", nil, nil)
 
 	// Fire returns immediately (async)
 	if time.Since(start) > 50*time.Millisecond {
@@ -70,24 +72,24 @@ func TestFireTimeout(t *testing.T) {
 
 func TestFireNoHookConfigured(t *testing.T) {
 	cfg := config.DefaultConfig()
-	cfg.Hooks = map[string]string{
-		"other.event": "echo hello",
+	cfg.Hooks = map[string][]string{
+		"other.event": {"echo hello"},
 	}
 
-	r := NewRunner(cfg)
+	r := NewRunner(cfg, nil)
 	// Fire for an event with no hook — should be a no-op
-	r.Fire("nonexistent.event", nil)
+	r.Fire("nonexistent.event", nil, nil)
 }
 
 func TestFireNilRunner(t *testing.T) {
 	// Fire on nil runner should not panic
 	var r *Runner
-	r.Fire("any.event", nil)
+	r.Fire("any.event", nil, nil)
 }
 
 func TestNewRunnerNoHooks(t *testing.T) {
 	cfg := config.DefaultConfig()
-	r := NewRunner(cfg)
+	r := NewRunner(cfg, nil)
 	if r != nil {
 		t.Error("expected nil runner when no hooks configured")
 	}
@@ -98,16 +100,110 @@ func TestFireReturnsQuickly(t *testing.T) {
 	outFile := filepath.Join(dir, "async_test.txt")
 
 	cfg := config.DefaultConfig()
-	cfg.Hooks = map[string]string{
-		"test.event": "sleep 0.5 && echo done > " + outFile,
+	cfg.Hooks = map[string][]string{
+		"test.event": {"sleep 0.5 && echo done > " + outFile},
 	}
 
-	r := NewRunner(cfg)
+	r := NewRunner(cfg, nil)
 	start := time.Now()
-	r.Fire("test.event", map[string]string{"AGIT_REPO": "test"})
+	r.Fire("test.event", map[string]string{"AGIT_REPO": "test"}, nil)
 	elapsed := time.Since(start)
 
 	if elapsed > 50*time.Millisecond {
 		t.Errorf("Fire should return in <50ms (async), took %v", elapsed)
 	}
 }
+
+func TestCheckVetoReturnsStderr(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Hooks = map[string][]string{
+		PreMerge: {`test "$AGIT_REPO" = open || { echo "merges to $AGIT_REPO are frozen" >&2; exit 3; }`},
+	}
+	r := NewRunner(cfg, nil)
+
+	if err := r.Check(PreMerge, map[string]string{"AGIT_REPO": "open"}); err != nil {
+		t.Fatalf("expected hook to allow the merge, got %v", err)
+	}
+
+	err := r.Check(PreMerge, map[string]string{"AGIT_REPO": "prod"})
+	var veto *VetoError
+	if !errors.As(err, &veto) {
+		t.Fatalf("expected a VetoError, got %v", err)
+	}
+	if veto.ExitCode != 3 || veto.Stderr != "merges to prod are frozen" {
+		t.Errorf("unexpected veto: %+v", veto)
+	}
+	if err.Error() != "pre.merge hook refused: merges to prod are frozen" {
+		t.Errorf("unexpected message: %q", err.Error())
+	}
+}
+
+func TestCheckTimeoutVetoes(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Hooks = map[string][]string{PreSpawn: {"sleep 10"}}
+	cfg.HookTimeout = "100ms"
+
+	err := NewRunner(cfg, nil).Check(PreSpawn, nil)
+	var veto *VetoError
+	if !errors.As(err, &veto) || veto.ExitCode != -1 {
+		t.Fatalf("expected a timeout veto, got %v", err)
+	}
+}
+
+func TestCheckWithoutHook(t *testing.T) {
+	var r *Runner
+	if err := r.Check(PreSpawn, nil); err != nil {
+		t.Errorf("expected nil runner to allow, got %v", err)
+	}
+
+	cfg := config.DefaultConfig()
+	cfg.Hooks = map[string][]string{"worktree.created": {"exit 1"}}
+	if err := NewRunner(cfg, nil).Check(PreSpawn, nil); err != nil {
+		t.Errorf("expected unconfigured event to allow, got %v", err)
+	}
+}
+
+func TestRunRecordsEachCommand(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Hooks = map[string][]string{
+		"task.failed": {`echo "first $AGIT_REPO"`, `echo oops >&2; exit 4`, `echo third`},
+	}
+	r := NewRunner(cfg, nil)
+	var recorded []*registry.HookRun
+	r.record = func(run *registry.HookRun) error {
+		recorded = append(recorded, run)
+		return nil
+	}
+
+	runs := r.Run("task.failed", map[string]string{"AGIT_REPO": "demo"}, nil)
+	if len(runs) != 3 || len(recorded) != 3 {
+		t.Fatalf("expected every command to run and be recorded, got %d runs, %d recorded", len(runs), len(recorded))
+	}
+	if runs[0].Stdout != "first demo\n" || runs[0].Repo != "demo" || runs[0].ExitCode != 0 {
+		t.Errorf("unexpected first run: %+v", runs[0])
+	}
+	if runs[1].ExitCode != 4 || runs[1].Stderr != "oops\n" {
+		t.Errorf("unexpected failed run: %+v", runs[1])
+	}
+	if runs[2].Stdout != "third\n" {
+		t.Errorf("expected commands after a failure to still run, got %+v", runs[2])
+	}
+}
+
+func TestCheckStopsAtFirstRefusal(t *testing.T) {
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "ran")
+
+	cfg := config.DefaultConfig()
+	cfg.Hooks = map[string][]string{
+		PreSpawn: {"true", "echo second says no >&2; exit 1", "touch " + marker},
+	}
+	err := NewRunner(cfg, nil).Check(PreSpawn, nil)
+	var veto *VetoError
+	if !errors.As(err, &veto) || veto.Stderr != "second says no" {
+		t.Fatalf("expected the second command to veto, got %v", err)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("expected commands after the refusal to be skipped")
+	}
+}
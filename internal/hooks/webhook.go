@@ -0,0 +1,178 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/fathindos/agit/internal/config"
+	"github.com/fathindos/agit/internal/registry"
+)
+
+// Headers sent with every webhook request. The signature is only sent when
+// the webhook has a secret: it is "sha256=" followed by the hex HMAC-SHA256
+// of the request body, keyed with the secret.
+const (
+	HeaderEvent     = "X-Agit-Event"
+	HeaderDelivery  = "X-Agit-Delivery"
+	HeaderSignature = "X-Agit-Signature-256"
+)
+
+// Payload is the JSON body POSTed to a webhook. ID identifies the delivery
+// and is kept when it is redelivered.
+type Payload struct {
+	ID        string         `json:"id"`
+	Event     string         `json:"event"`
+	Timestamp time.Time      `json:"timestamp"`
+	Data      map[string]any `json:"data"`
+}
+
+// NewPayload builds the webhook payload for an event. Its data holds the
+// hook env values keyed without the AGIT_ prefix, e.g. AGIT_WORKTREE_ID
+// becomes worktree_id, overlaid with data.
+func NewPayload(event string, env map[string]string, data map[string]any) *Payload {
+	p := &Payload{
+		ID:        uuid.New().String(),
+		Event:     event,
+		Timestamp: time.Now().UTC(),
+		Data:      make(map[string]any, len(env)+len(data)),
+	}
+	for k, v := range env {
+		p.Data[strings.ToLower(strings.TrimPrefix(k, "AGIT_"))] = v
+	}
+	for k, v := range data {
+		p.Data[k] = v
+	}
+	return p
+}
+
+// Sign returns the signature header value for a body
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Send makes a single delivery attempt. Any response other than 2xx is an
+// error.
+func Send(w config.Webhook, event, deliveryID string, body []byte, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("could not build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "agit-webhook")
+	for k, v := range w.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set(HeaderEvent, event)
+	req.Header.Set(HeaderDelivery, deliveryID)
+	if w.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(w.Secret, body))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 200))
+		if msg := strings.TrimSpace(string(snippet)); msg != "" {
+			return fmt.Errorf("HTTP %d: %s", resp.StatusCode, msg)
+		}
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// deliver posts a payload to a webhook, retrying with exponential backoff.
+// A delivery that runs out of attempts is stored for agit hooks redeliver.
+func (r *Runner) deliver(name string, w config.Webhook, p *Payload) {
+	defer r.wg.Done()
+
+	body, err := json.Marshal(p)
+	if err != nil {
+		log.Printf("webhook %q: could not encode %q payload: %v", name, p.Event, err)
+		return
+	}
+
+	backoff := w.InitialBackoff()
+	attempts := w.MaxAttempts()
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = Send(w, p.Event, p.ID, body, r.timeout); err == nil {
+			return
+		}
+		if attempt < attempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+
+	log.Printf("webhook %q failed for %q after %d attempts: %v", name, p.Event, attempts, err)
+	msg := err.Error()
+	if err := r.failed(&registry.WebhookDelivery{
+		ID:        p.ID,
+		Webhook:   name,
+		Event:     p.Event,
+		URL:       w.URL,
+		Body:      string(body),
+		Attempts:  attempts,
+		LastError: &msg,
+	}); err != nil {
+		log.Printf("webhook %q: %v", name, err)
+	}
+}
+
+// Redeliver makes one more attempt at a failed delivery, using the
+// webhook's current URL, headers and secret, and records the outcome. The
+// body and delivery ID are the ones first sent.
+func Redeliver(db *registry.DB, cfg *config.Config, d *registry.WebhookDelivery) error {
+	w, ok := cfg.Webhooks[d.Webhook]
+	if !ok {
+		return fmt.Errorf("webhook %q is no longer configured", d.Webhook)
+	}
+	sendErr := Send(w, d.Event, d.ID, []byte(d.Body), hookTimeout(cfg))
+	if err := db.RecordRedelivery(d.ID, sendErr); err != nil {
+		return err
+	}
+	return sendErr
+}
+
+// Ping makes a single delivery attempt of an event to every webhook that
+// wants it, without retries and without storing failures, and returns each
+// webhook's error, nil for a successful delivery. Safe to call on a nil
+// Runner.
+func (r *Runner) Ping(event string, env map[string]string, data map[string]any) map[string]error {
+	if r == nil {
+		return nil
+	}
+	results := make(map[string]error)
+	for name, w := range r.webhooks {
+		if !w.Wants(event) {
+			continue
+		}
+		p := NewPayload(event, env, data)
+		body, err := json.Marshal(p)
+		if err == nil {
+			err = Send(w, event, p.ID, body, r.timeout)
+		}
+		results[name] = err
+	}
+	return results
+}
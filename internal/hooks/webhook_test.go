@@ -0,0 +1,164 @@
+package hooks
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/fathindos/agit/internal/config"
+	"github.com/fathindos/agit/internal/registry"
+)
+
+type receivedRequest struct {
+	header http.Header
+	body   []byte
+}
+
+// newReceiver starts a webhook receiver that answers the first failures
+// requests with a 500 and records every request it gets
+func newReceiver(t *testing.T, failures int) (*httptest.Server, func() []receivedRequest) {
+	t.Helper()
+	var mu sync.Mutex
+	var got []receivedRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		got = append(got, receivedRequest{header: r.Header.Clone(), body: body})
+		n := len(got)
+		mu.Unlock()
+		if n <= failures {
+			http.Error(w, "receiver down", http.StatusInternalServerError)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []receivedRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]receivedRequest(nil), got...)
+	}
+}
+
+func TestWebhookSignedPayload(t *testing.T) {
+	srv, received := newReceiver(t, 0)
+
+	cfg := config.DefaultConfig()
+	cfg.Webhooks = map[string]config.Webhook{
+		"ci": {
+			URL:     srv.URL,
+			Events:  []string{"worktree.created"},
+			Headers: map[string]string{"Authorization": "Bearer abc"},
+			Secret:  "s3cret",
+		},
+	}
+
+	r := NewRunner(cfg, nil)
+	r.Fire("worktree.created", map[string]string{"AGIT_REPO": "demo", "AGIT_WORKTREE_ID": "wt-1"}, map[string]any{"branch": "agit/feature"})
+	r.Fire("task.completed", map[string]string{"AGIT_REPO": "demo"}, nil)
+	r.Wait()
+
+	got := received()
+	if len(got) != 1 {
+		t.Fatalf("expected 1 request for the subscribed event, got %d", len(got))
+	}
+	req := got[0]
+	if req.header.Get(HeaderEvent) != "worktree.created" || req.header.Get("Authorization") != "Bearer abc" {
+		t.Errorf("unexpected headers: %v", req.header)
+	}
+	if sig := req.header.Get(HeaderSignature); sig != Sign("s3cret", req.body) {
+		t.Errorf("signature %q does not match body", sig)
+	}
+
+	var p Payload
+	if err := json.Unmarshal(req.body, &p); err != nil {
+		t.Fatalf("invalid payload: %v", err)
+	}
+	if p.ID != req.header.Get(HeaderDelivery) || p.Event != "worktree.created" {
+		t.Errorf("unexpected payload: %+v", p)
+	}
+	if p.Data["repo"] != "demo" || p.Data["worktree_id"] != "wt-1" || p.Data["branch"] != "agit/feature" {
+		t.Errorf("unexpected payload data: %v", p.Data)
+	}
+}
+
+func TestWebhookRetriesThenSucceeds(t *testing.T) {
+	srv, received := newReceiver(t, 2)
+
+	cfg := config.DefaultConfig()
+	cfg.Webhooks = map[string]config.Webhook{"ci": {URL: srv.URL, Attempts: 3, Backoff: "10ms"}}
+
+	r := NewRunner(cfg, nil)
+	var failed []*registry.WebhookDelivery
+	r.failed = func(d *registry.WebhookDelivery) error {
+		failed = append(failed, d)
+		return nil
+	}
+	r.Fire("task.completed", nil, nil)
+	r.Wait()
+
+	got := received()
+	if len(got) != 3 {
+		t.Fatalf("expected 3 attempts, got %d", len(got))
+	}
+	if got[0].header.Get(HeaderDelivery) != got[2].header.Get(HeaderDelivery) {
+		t.Error("expected retries to keep the delivery ID")
+	}
+	if len(failed) != 0 {
+		t.Errorf("expected no failed delivery, got %+v", failed)
+	}
+}
+
+func TestWebhookFailureIsRecorded(t *testing.T) {
+	srv, received := newReceiver(t, 10)
+
+	cfg := config.DefaultConfig()
+	cfg.Webhooks = map[string]config.Webhook{"ci": {URL: srv.URL, Attempts: 2, Backoff: "10ms"}}
+
+	r := NewRunner(cfg, nil)
+	var failed []*registry.WebhookDelivery
+	r.failed = func(d *registry.WebhookDelivery) error {
+		failed = append(failed, d)
+		return nil
+	}
+	r.Fire("task.failed", map[string]string{"AGIT_TASK_ID": "t-1"}, nil)
+	r.Wait()
+
+	if len(received()) != 2 {
+		t.Fatalf("expected 2 attempts, got %d", len(received()))
+	}
+	if len(failed) != 1 {
+		t.Fatalf("expected 1 failed delivery, got %d", len(failed))
+	}
+	d := failed[0]
+	if d.Webhook != "ci" || d.Event != "task.failed" || d.Attempts != 2 || d.LastError == nil {
+		t.Errorf("unexpected failed delivery: %+v", d)
+	}
+
+	// Redelivery sends the stored body once and records the outcome
+	db, err := registry.OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.RecordFailedDelivery(d); err != nil {
+		t.Fatal(err)
+	}
+	if err := Redeliver(db, cfg, d); err == nil {
+		t.Error("expected redelivery to a failing receiver to fail")
+	}
+
+	ok, _ := newReceiver(t, 0)
+	cfg.Webhooks["ci"] = config.Webhook{URL: ok.URL}
+	if err := Redeliver(db, cfg, d); err != nil {
+		t.Fatalf("Redeliver: %v", err)
+	}
+	stored, err := db.FindDelivery(d.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != "delivered" || stored.Attempts != 4 {
+		t.Errorf("expected delivered after 4 attempts, got %+v", stored)
+	}
+}
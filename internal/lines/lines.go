@@ -0,0 +1,24 @@
+// Package lines holds the line ranges that diffs, file touches and conflict
+// reports have in common.
+package lines
+
+import "fmt"
+
+// Range is an inclusive range of line numbers in the base version of a file
+type Range struct {
+	Start int
+	End   int
+}
+
+// String formats the range as "start-end", or "start" for a single line
+func (r Range) String() string {
+	if r.Start == r.End {
+		return fmt.Sprintf("%d", r.Start)
+	}
+	return fmt.Sprintf("%d-%d", r.Start, r.End)
+}
+
+// Overlaps reports whether two ranges intersect once widened by context lines
+func (r Range) Overlaps(other Range, context int) bool {
+	return r.Start <= other.End+context && other.Start <= r.End+context
+}
@@ -0,0 +1,447 @@
+package mcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+
+	apperrors "github.com/fathindos/agit/internal/errors"
+	"github.com/fathindos/agit/internal/registry"
+)
+
+// maxRequestBytes caps the body of a request to the HTTP transport
+const maxRequestBytes = 4 << 20
+
+// DefaultSessionIdleTimeout is how long an HTTP session may go without a
+// request before it is dropped
+const DefaultSessionIdleTimeout = 30 * time.Minute
+
+// maxSessionsPerAgent caps the open sessions of one agent, or of all
+// unauthenticated clients together; opening another ends the least recently
+// used one
+const maxSessionsPerAgent = 32
+
+const sessionHeader = "Mcp-Session-Id"
+
+type callerKey struct{}
+
+// withCaller records the agent a request authenticated as
+func withCaller(ctx context.Context, agent *registry.Agent) context.Context {
+	return context.WithValue(ctx, callerKey{}, agent)
+}
+
+// callerFromContext returns the agent a request authenticated as, or nil
+func callerFromContext(ctx context.Context) *registry.Agent {
+	agent, _ := ctx.Value(callerKey{}).(*registry.Agent)
+	return agent
+}
+
+// callerArg is the argument naming the agent a tool acts for
+type callerArg struct {
+	name   string
+	byName bool   // the argument takes the agent's name rather than its ID
+	unless string // another argument that names the actor instead; the tool checks it belongs to the caller
+}
+
+var callerArgs = map[string]callerArg{
+	"agit_spawn_worktree": {name: "agent", byName: true},
+	"agit_claim_task":     {name: "agent_id"},
+	"agit_next_task":      {name: "agent_id"},
+	"agit_heartbeat":      {name: "agent_id"},
+	"agit_merge_worktree": {name: "agent_id"},
+	"agit_enqueue_merge":  {name: "agent_id"},
+	"agit_acquire_lock":   {name: "agent_id", unless: "worktree_id"},
+	"agit_release_lock":   {name: "agent_id", unless: "worktree_id"},
+}
+
+// withCallerArgs fills in the agent a tool acts for from the request's agent
+// token, so authenticated agents need not pass their own name or ID. Acting
+// for another agent is refused.
+func withCallerArgs(handler server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		agent := callerFromContext(ctx)
+		arg, ok := callerArgs[request.Params.Name]
+		if agent == nil || !ok {
+			return handler(ctx, request)
+		}
+
+		want := agent.ID
+		if arg.byName {
+			want = agent.Name
+		}
+		given, _ := request.Params.Arguments[arg.name].(string)
+		switch {
+		case given == want:
+		case given != "":
+			return nil, apperrors.NewUserErrorf("authenticated as agent %s; cannot act as %s", agent.Name, given)
+		case arg.unless != "" && request.Params.Arguments[arg.unless] != nil:
+		default:
+			if request.Params.Arguments == nil {
+				request.Params.Arguments = make(map[string]any)
+			}
+			request.Params.Arguments[arg.name] = want
+		}
+		return handler(ctx, request)
+	}
+}
+
+// HTTPOptions configures HTTPHandler
+type HTTPOptions struct {
+	// RequireToken refuses requests without an agent token. Without it,
+	// requests may still authenticate with one.
+	RequireToken bool
+	// SessionIdleTimeout ends sessions that have had no request and no open
+	// event stream for this long. Defaults to DefaultSessionIdleTimeout.
+	SessionIdleTimeout time.Duration
+}
+
+// HTTPHandler serves s over the streamable HTTP transport: clients POST
+// JSON-RPC messages and get the replies as JSON, GET an event stream for
+// notifications and DELETE their session when done. A request carrying an
+// agent token in an "Authorization: Bearer" header acts as that agent: tools
+// default to it, and every request counts as its heartbeat. Requests from web
+// pages on other origins, and bodies that are not JSON, are refused. Idle
+// sessions are dropped when new ones open.
+func (s *Server) HTTPHandler(opts HTTPOptions) http.Handler {
+	if opts.SessionIdleTimeout <= 0 {
+		opts.SessionIdleTimeout = DefaultSessionIdleTimeout
+	}
+	return &httpTransport{s: s, opts: opts, sessions: make(map[string]*httpSession)}
+}
+
+type httpTransport struct {
+	s    *Server
+	opts HTTPOptions
+
+	mu       sync.Mutex
+	sessions map[string]*httpSession
+}
+
+// httpSession is a client session of the HTTP transport. It belongs to the
+// agent that opened it, if any.
+type httpSession struct {
+	id            string
+	agentID       string
+	notifications chan mcp.JSONRPCNotification
+	initialized   atomic.Bool
+	streaming     atomic.Bool
+	lastUsed      atomic.Int64 // unix nanoseconds of the latest request
+	ctx           context.Context
+	cancel        context.CancelFunc
+}
+
+func (s *httpSession) SessionID() string { return s.id }
+func (s *httpSession) Initialize()       { s.initialized.Store(true) }
+func (s *httpSession) Initialized() bool { return s.initialized.Load() }
+func (s *httpSession) NotificationChannel() chan<- mcp.JSONRPCNotification {
+	return s.notifications
+}
+
+func (s *httpSession) touch() { s.lastUsed.Store(time.Now().UnixNano()) }
+
+func (t *httpTransport) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Browsers send Origin with cross-site requests; refusing foreign ones
+	// keeps web pages from driving a server listening on loopback
+	if origin := r.Header.Get("Origin"); origin != "" && !isLoopbackOrigin(origin) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	agent, err := t.authenticate(r)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="agit"`)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if agent != nil {
+		if err := t.s.db.Heartbeat(agent.ID); err != nil {
+			log.Printf("mcp: could not record heartbeat for %s: %v", agent.Name, err)
+		}
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		t.handlePost(w, r, agent)
+	case http.MethodGet:
+		t.handleStream(w, r, agent)
+	case http.MethodDelete:
+		session, ok := t.session(w, r, agent)
+		if !ok {
+			return
+		}
+		t.close(session)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// authenticate returns the agent whose token the request carries, or nil for
+// a request without one when tokens are optional
+func (t *httpTransport) authenticate(r *http.Request) (*registry.Agent, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		if t.opts.RequireToken {
+			return nil, errors.New("missing bearer token; create one with agit agents token create")
+		}
+		return nil, nil
+	}
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok {
+		return nil, errors.New("expected a bearer token")
+	}
+	agent, err := t.s.db.AgentForToken(strings.TrimSpace(token))
+	if err != nil {
+		if !errors.Is(err, registry.ErrInvalidToken) {
+			log.Printf("mcp: %v", err)
+		}
+		return nil, registry.ErrInvalidToken
+	}
+	return agent, nil
+}
+
+// session looks up the session a request names, writing the error response
+// if there is none
+func (t *httpTransport) session(w http.ResponseWriter, r *http.Request, agent *registry.Agent) (*httpSession, bool) {
+	id := r.Header.Get(sessionHeader)
+	if id == "" {
+		http.Error(w, "missing "+sessionHeader+" header", http.StatusBadRequest)
+		return nil, false
+	}
+	t.mu.Lock()
+	session, ok := t.sessions[id]
+	t.mu.Unlock()
+	// A session opened by one agent is not found by anyone else
+	if !ok || (agent == nil && session.agentID != "") || (agent != nil && session.agentID != agent.ID) {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return nil, false
+	}
+	session.touch()
+	return session, true
+}
+
+func (t *httpTransport) open(agent *registry.Agent) (*httpSession, error) {
+	session := &httpSession{
+		id:            uuid.New().String(),
+		notifications: make(chan mcp.JSONRPCNotification, 100),
+	}
+	if agent != nil {
+		session.agentID = agent.ID
+	}
+	session.touch()
+	session.ctx, session.cancel = context.WithCancel(context.Background())
+	if err := t.s.RegisterSession(session.ctx, session); err != nil {
+		session.cancel()
+		return nil, err
+	}
+	t.mu.Lock()
+	ended := t.reapLocked(session.agentID)
+	t.sessions[session.id] = session
+	t.mu.Unlock()
+	for _, old := range ended {
+		t.end(old)
+	}
+	return session, nil
+}
+
+// reapLocked removes the sessions that have been idle too long, and the least
+// recently used sessions of agentID beyond maxSessionsPerAgent-1 to make room
+// for a new one. The caller must hold t.mu and end the returned sessions.
+func (t *httpTransport) reapLocked(agentID string) []*httpSession {
+	var ended, own []*httpSession
+	cutoff := time.Now().Add(-t.opts.SessionIdleTimeout).UnixNano()
+	for id, session := range t.sessions {
+		if !session.streaming.Load() && session.lastUsed.Load() < cutoff {
+			delete(t.sessions, id)
+			ended = append(ended, session)
+		} else if session.agentID == agentID {
+			own = append(own, session)
+		}
+	}
+	if excess := len(own) - (maxSessionsPerAgent - 1); excess > 0 {
+		sort.Slice(own, func(i, j int) bool { return own[i].lastUsed.Load() < own[j].lastUsed.Load() })
+		for _, session := range own[:excess] {
+			delete(t.sessions, session.id)
+			ended = append(ended, session)
+		}
+	}
+	return ended
+}
+
+func (t *httpTransport) close(session *httpSession) {
+	t.mu.Lock()
+	delete(t.sessions, session.id)
+	t.mu.Unlock()
+	t.end(session)
+}
+
+// end releases a session already removed from t.sessions
+func (t *httpTransport) end(session *httpSession) {
+	t.s.UnregisterSession(session.id)
+	session.cancel()
+}
+
+// handlePost handles a JSON-RPC message or batch. An initialize request
+// opens a new session; anything else must name an open one.
+func (t *httpTransport) handlePost(w http.ResponseWriter, r *http.Request, agent *registry.Agent) {
+	// Only JSON: form and text/plain bodies can be posted cross-site without
+	// a CORS preflight
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+		http.Error(w, "expected Content-Type: application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBytes))
+	if err != nil {
+		http.Error(w, "could not read request", http.StatusBadRequest)
+		return
+	}
+	body = bytes.TrimSpace(body)
+	batch := len(body) > 0 && body[0] == '['
+	var messages []json.RawMessage
+	if batch {
+		err = json.Unmarshal(body, &messages)
+	} else {
+		messages = []json.RawMessage{body}
+		err = json.Unmarshal(body, new(json.RawMessage))
+	}
+	if err != nil || len(messages) == 0 {
+		http.Error(w, "invalid JSON-RPC message", http.StatusBadRequest)
+		return
+	}
+
+	var session *httpSession
+	if isInitialize(messages) {
+		if len(messages) > 1 {
+			http.Error(w, "initialize must be sent on its own", http.StatusBadRequest)
+			return
+		}
+		if session, err = t.open(agent); err != nil {
+			http.Error(w, fmt.Sprintf("could not open session: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set(sessionHeader, session.id)
+	} else {
+		var ok bool
+		if session, ok = t.session(w, r, agent); !ok {
+			return
+		}
+	}
+	t.reply(w, r, session, agent, messages, batch)
+}
+
+// reply passes each message to mcp-go and writes the responses. A POST of
+// only notifications and responses gets 202 Accepted and no body.
+func (t *httpTransport) reply(w http.ResponseWriter, r *http.Request, session *httpSession, agent *registry.Agent, messages []json.RawMessage, batch bool) {
+	ctx := t.s.WithContext(r.Context(), session)
+	if agent != nil {
+		ctx = withCaller(ctx, agent)
+	}
+	var responses []mcp.JSONRPCMessage
+	for _, message := range messages {
+		if resp := t.s.HandleMessage(ctx, t.s.intercept(session.id, message)); resp != nil {
+			responses = append(responses, resp)
+		}
+	}
+	if len(responses) == 0 {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if batch {
+		json.NewEncoder(w).Encode(responses)
+	} else {
+		json.NewEncoder(w).Encode(responses[0])
+	}
+}
+
+// handleStream sends a session's notifications as server-sent events until
+// the client goes away or the session ends
+func (t *httpTransport) handleStream(w http.ResponseWriter, r *http.Request, agent *registry.Agent) {
+	if !strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		http.Error(w, "expected Accept: text/event-stream", http.StatusNotAcceptable)
+		return
+	}
+	session, ok := t.session(w, r, agent)
+	if !ok {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	// Notifications are not duplicated, so a second stream would only take
+	// some of them
+	if !session.streaming.CompareAndSwap(false, true) {
+		http.Error(w, "session already has an event stream", http.StatusConflict)
+		return
+	}
+	defer session.streaming.Store(false)
+	defer session.touch()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case notification := <-session.notifications:
+			data, err := json.Marshal(notification)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: message\ndata: %s\n\n", data)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-session.ctx.Done():
+			return
+		}
+	}
+}
+
+// isLoopbackOrigin reports whether an Origin header names a page served from
+// this machine
+func isLoopbackOrigin(origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+	if host := u.Hostname(); host == "localhost" {
+		return true
+	} else if ip := net.ParseIP(host); ip != nil {
+		return ip.IsLoopback()
+	}
+	return false
+}
+
+func isInitialize(messages []json.RawMessage) bool {
+	for _, message := range messages {
+		var req struct {
+			Method string `json:"method"`
+		}
+		if json.Unmarshal(message, &req) == nil && req.Method == string(mcp.MethodInitialize) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,230 @@
+package mcp
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fathindos/agit/internal/config"
+	"github.com/fathindos/agit/internal/registry"
+)
+
+func TestHTTPTransportAuthenticatesAgents(t *testing.T) {
+	t.Setenv("AGIT_HOME", t.TempDir())
+	db, err := registry.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(db, config.DefaultConfig())
+	defer db.Close()
+
+	repo, _ := db.AddRepo("demo", t.TempDir(), "", "main")
+	task, _ := db.CreateTask(repo.ID, "fix login", 0)
+	builder, _ := db.RegisterAgent("builder", "claude")
+	other, _ := db.RegisterAgent("other", "claude")
+	token, _, _ := db.CreateAgentToken(builder.ID)
+	otherToken, _, _ := db.CreateAgentToken(other.ID)
+	otherWt, _ := db.CreateWorktree(repo.ID, t.TempDir(), "other-work", &other.ID, nil)
+	db.SweepStaleAgents(0)
+
+	srv := httptest.NewServer(s.HTTPHandler(HTTPOptions{RequireToken: true}))
+	defer srv.Close()
+
+	post := func(token, session string, message map[string]any) (*http.Response, map[string]any) {
+		t.Helper()
+		body, _ := json.Marshal(message)
+		req, _ := http.NewRequest(http.MethodPost, srv.URL, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		if session != "" {
+			req.Header.Set(sessionHeader, session)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var reply map[string]any
+		json.NewDecoder(resp.Body).Decode(&reply)
+		return resp, reply
+	}
+	initialize := map[string]any{
+		"jsonrpc": "2.0", "id": 1, "method": "initialize",
+		"params": map[string]any{"protocolVersion": "2025-03-26", "clientInfo": map[string]any{"name": "test", "version": "1"}},
+	}
+	callTool := func(id int, name string, args map[string]any) map[string]any {
+		return map[string]any{
+			"jsonrpc": "2.0", "id": id, "method": "tools/call",
+			"params": map[string]any{"name": name, "arguments": args},
+		}
+	}
+
+	if resp, _ := post("", "", initialize); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected 401 without a token, got %d", resp.StatusCode)
+	}
+	if resp, _ := post(registry.TokenPrefix+"nope", "", initialize); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected 401 for an unknown token, got %d", resp.StatusCode)
+	}
+
+	resp, reply := post(token, "", initialize)
+	session := resp.Header.Get(sessionHeader)
+	if resp.StatusCode != http.StatusOK || session == "" || reply["result"] == nil {
+		t.Fatalf("expected initialize to open a session, got %d %v", resp.StatusCode, reply)
+	}
+	if agent, _ := db.GetAgent(builder.ID); agent.Status != "active" {
+		t.Errorf("expected the request to count as a heartbeat, got status %s", agent.Status)
+	}
+	if resp, _ := post(token, session, map[string]any{"jsonrpc": "2.0", "method": "notifications/initialized"}); resp.StatusCode != http.StatusAccepted {
+		t.Errorf("expected 202 for a notification, got %d", resp.StatusCode)
+	}
+
+	// The session belongs to builder
+	if resp, _ := post(token, "", callTool(2, "agit_list_repos", nil)); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 without a session, got %d", resp.StatusCode)
+	}
+	if resp, _ := post(otherToken, session, callTool(2, "agit_list_repos", nil)); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected another agent not to find the session, got %d", resp.StatusCode)
+	}
+
+	// Tools act for the authenticated agent
+	if _, reply := post(token, session, callTool(3, "agit_claim_task", map[string]any{"task_id": task.ID})); reply["error"] != nil {
+		t.Fatalf("expected claim without agent_id to succeed, got %v", reply["error"])
+	}
+	if got, _ := db.GetTask(task.ID); got.AssignedAgentID == nil || *got.AssignedAgentID != builder.ID {
+		t.Errorf("expected the task to be claimed by builder, got %v", got.AssignedAgentID)
+	}
+	_, reply = post(token, session, callTool(4, "agit_heartbeat", map[string]any{"agent_id": other.ID}))
+	if e, _ := reply["error"].(map[string]any); e == nil || !strings.Contains(e["message"].(string), "cannot act as") {
+		t.Errorf("expected acting as another agent to be refused, got %v", reply)
+	}
+	_, reply = post(token, session, callTool(5, "agit_acquire_lock", map[string]any{"repo": "demo", "path": "src", "worktree_id": otherWt.ID}))
+	if e, _ := reply["error"].(map[string]any); e == nil || !strings.Contains(e["message"].(string), "not assigned to it") {
+		t.Errorf("expected locking as another agent's worktree to be refused, got %v", reply)
+	}
+	if locks, _ := db.ListLocks(repo.ID); len(locks) != 0 {
+		t.Errorf("expected no lock to be taken, got %d", len(locks))
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, srv.URL, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set(sessionHeader, session)
+	if resp, err := http.DefaultClient.Do(req); err != nil || resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected DELETE to end the session, got %v, %v", resp, err)
+	}
+	if resp, _ := post(token, session, callTool(6, "agit_list_repos", nil)); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected an ended session to be gone, got %d", resp.StatusCode)
+	}
+}
+
+func TestHTTPTransportRefusesCrossSiteRequests(t *testing.T) {
+	t.Setenv("AGIT_HOME", t.TempDir())
+	db, err := registry.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(db, config.DefaultConfig())
+	defer db.Close()
+	repo, _ := db.AddRepo("demo", t.TempDir(), "", "main")
+
+	// Without RequireToken, as when listening on loopback
+	srv := httptest.NewServer(s.HTTPHandler(HTTPOptions{}))
+	defer srv.Close()
+
+	batch := `[{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26","clientInfo":{"name":"page","version":"1"}}},` +
+		`{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"agit_create_task","arguments":{"repo":"demo","description":"injected"}}}]`
+	send := func(contentType, origin, body string) int {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	for _, tc := range []struct {
+		name, contentType, origin string
+		want                      int
+	}{
+		{"foreign origin", "application/json", "https://evil.example", http.StatusForbidden},
+		{"text/plain body", "text/plain", "", http.StatusUnsupportedMediaType},
+		{"initialize batched with a tool call", "application/json", "http://localhost:5173", http.StatusBadRequest},
+	} {
+		if got := send(tc.contentType, tc.origin, batch); got != tc.want {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.want, got)
+		}
+	}
+	if tasks, _ := db.ListTasks(repo.ID, nil); len(tasks) != 0 {
+		t.Errorf("expected no task to be created, got %d", len(tasks))
+	}
+
+	if isLoopbackOrigin("http://127.0.0.1.evil.example") || !isLoopbackOrigin("http://[::1]:8080") {
+		t.Error("isLoopbackOrigin misjudged a host")
+	}
+}
+
+func TestHTTPTransportReapsSessions(t *testing.T) {
+	t.Setenv("AGIT_HOME", t.TempDir())
+	db, err := registry.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(db, config.DefaultConfig())
+	defer db.Close()
+
+	transport := s.HTTPHandler(HTTPOptions{SessionIdleTimeout: 200 * time.Millisecond}).(*httpTransport)
+	srv := httptest.NewServer(transport)
+	defer srv.Close()
+
+	post := func(session, body string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if session != "" {
+			req.Header.Set(sessionHeader, session)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	open := func() string {
+		t.Helper()
+		return post("", `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26","clientInfo":{"name":"test","version":"1"}}}`).Header.Get(sessionHeader)
+	}
+	ping := `{"jsonrpc":"2.0","id":2,"method":"ping"}`
+
+	idle := open()
+	time.Sleep(300 * time.Millisecond)
+	open()
+	if resp := post(idle, ping); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected an idle session to be dropped, got %d", resp.StatusCode)
+	}
+
+	first := open()
+	for i := 0; i < maxSessionsPerAgent; i++ {
+		open()
+	}
+	if resp := post(first, ping); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected the least recently used session to make room, got %d", resp.StatusCode)
+	}
+	transport.mu.Lock()
+	n := len(transport.sessions)
+	transport.mu.Unlock()
+	if n > maxSessionsPerAgent {
+		t.Errorf("expected at most %d sessions, got %d", maxSessionsPerAgent, n)
+	}
+}
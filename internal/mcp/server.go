@@ -8,19 +8,35 @@ import (
 	"github.com/fathindos/agit/internal/registry"
 )
 
+// Server is the agit MCP server: an mcp-go server with all tools and
+// resources registered, plus the resource subscriptions mcp-go leaves out.
+// Serve it with ServeStdio, SSEHandler or HTTPHandler so subscriptions are
+// tracked.
+type Server struct {
+	*server.MCPServer
+	db   *registry.DB
+	subs *subscriptions
+}
+
 // NewServer creates a configured MCP server with all tools and resources registered.
-func NewServer(db *registry.DB, cfg *config.Config) *server.MCPServer {
+func NewServer(db *registry.DB, cfg *config.Config) *Server {
+	subs := newSubscriptions(db)
+	hooks := &server.Hooks{}
+	hooks.AddOnRegisterSession(subs.register)
+
 	s := server.NewMCPServer(
 		"agit",
 		"0.1.0",
 		server.WithResourceCapabilities(true, true),
+		server.WithHooks(hooks),
+		server.WithToolHandlerMiddleware(withCallerArgs),
 	)
 
 	registerTools(s, db, cfg)
 	registerResources(s, db)
 	// Note: withIssueLink wraps each tool handler in registerTools
 
-	return s
+	return &Server{MCPServer: s, db: db, subs: subs}
 }
 
 func registerTools(s *server.MCPServer, db *registry.DB, cfg *config.Config) {
@@ -41,27 +57,39 @@ func registerTools(s *server.MCPServer, db *registry.DB, cfg *config.Config) {
 
 	s.AddTool(
 		mcp.NewTool("agit_spawn_worktree",
-			mcp.WithDescription("Create an isolated worktree for an agent"),
+			mcp.WithDescription("Create an isolated worktree for an agent and run the repo's setup recipe in it. If setup fails, the worktree is returned with status setup_failed and a setup_error; fix it and call agit_setup_worktree before working in it. A pre.spawn hook can refuse the spawn, in which case the error is the hook's stderr."),
 			mcp.WithString("repo", mcp.Required(), mcp.Description("Repository name")),
 			mcp.WithString("task", mcp.Description("Task description")),
 			mcp.WithString("branch", mcp.Description("Custom branch name (auto-generated if omitted)")),
-			mcp.WithString("agent", mcp.Description("Agent name to assign")),
+			mcp.WithString("agent", mcp.Description("Agent name to assign (default: the agent whose token authenticated the request)")),
+			mcp.WithString("base", mcp.Description("Local branch to branch from, e.g. another agent's branch or a release branch; conflict checks, sync and merge use it as the worktree's base (default: the repo's default branch). A worktree stacked on another agent's branch can only be merged after that agent's worktree")),
+			mcp.WithString("existing_branch", mcp.Description("Check out this existing branch instead of creating a new one; it is never deleted on cleanup")),
+			mcp.WithBoolean("skip_setup", mcp.Description("Don't run the repo's setup recipe")),
 		),
 		withIssueLink(handleSpawnWorktree(db, cfg)),
 	)
 
+	s.AddTool(
+		mcp.NewTool("agit_setup_worktree",
+			mcp.WithDescription("Re-run the repo's setup recipe (files copied or linked from the main checkout, then bootstrap commands) in a worktree. A pass makes a setup_failed worktree active again."),
+			mcp.WithString("repo", mcp.Required(), mcp.Description("Repository name")),
+			mcp.WithString("worktree_id", mcp.Required(), mcp.Description("Worktree ID to set up")),
+		),
+		withIssueLink(handleSetupWorktree(db)),
+	)
+
 	s.AddTool(
 		mcp.NewTool("agit_remove_worktree",
-			mcp.WithDescription("Remove a worktree from disk and registry"),
+			mcp.WithDescription("Remove a worktree from disk and registry. A pre.worktree.remove hook can refuse the removal, in which case the error is the hook's stderr."),
 			mcp.WithString("repo", mcp.Required(), mcp.Description("Repository name")),
 			mcp.WithString("worktree_id", mcp.Required(), mcp.Description("Worktree ID to remove")),
 		),
-		withIssueLink(handleRemoveWorktree(db)),
+		withIssueLink(handleRemoveWorktree(db, cfg)),
 	)
 
 	s.AddTool(
 		mcp.NewTool("agit_check_conflicts",
-			mcp.WithDescription("Scan for file conflicts across active worktrees"),
+			mcp.WithDescription("Scan for overlapping changes across active worktrees. Conflicts are reported per file with the overlapping line ranges of each worktree, and as committed or in_flight depending on whether any side is still an uncommitted edit. lock_violations lists changed files that fall under a lock held by another agent or worktree."),
 			mcp.WithString("repo", mcp.Required(), mcp.Description("Repository name")),
 		),
 		withIssueLink(handleCheckConflicts(db)),
@@ -71,18 +99,18 @@ func registerTools(s *server.MCPServer, db *registry.DB, cfg *config.Config) {
 		mcp.NewTool("agit_list_tasks",
 			mcp.WithDescription("List tasks for a repository"),
 			mcp.WithString("repo", mcp.Required(), mcp.Description("Repository name")),
-			mcp.WithString("status", mcp.Description("Filter by status (pending/claimed/in_progress/completed/failed)")),
+			mcp.WithString("status", mcp.Description("Filter by status (pending/claimed/in_progress/completed/failed/blocked)")),
 		),
 		withIssueLink(handleListTasks(db)),
 	)
 
 	s.AddTool(
 		mcp.NewTool("agit_claim_task",
-			mcp.WithDescription("Atomically claim a pending task for an agent"),
+			mcp.WithDescription("Atomically claim a pending task for an agent. The claim is leased; renew it with agit_heartbeat. A pre.task.claim hook can refuse the claim, in which case the error is the hook's stderr."),
 			mcp.WithString("task_id", mcp.Required(), mcp.Description("Task ID to claim")),
-			mcp.WithString("agent_id", mcp.Required(), mcp.Description("Agent ID claiming the task")),
+			mcp.WithString("agent_id", mcp.Description("Agent ID claiming the task (default: the agent whose token authenticated the request)")),
 		),
-		withIssueLink(handleClaimTask(db)),
+		withIssueLink(handleClaimTask(db, cfg)),
 	)
 
 	s.AddTool(
@@ -96,11 +124,56 @@ func registerTools(s *server.MCPServer, db *registry.DB, cfg *config.Config) {
 
 	s.AddTool(
 		mcp.NewTool("agit_merge_worktree",
-			mcp.WithDescription("Merge a worktree branch into the default branch, then auto-cleanup"),
+			mcp.WithDescription("Merge a worktree branch into its base (the default branch unless it was spawned with a base), then auto-cleanup. The repo's verify commands (build/test) run in the worktree first and a failure blocks the merge. A pre.merge hook can refuse the merge, in which case the error is the hook's stderr; a pre.worktree.remove hook can keep the worktree, reported as cleanup_error. The merge takes the repo's merge-queue slot and is refused while a queued merge is in progress; use agit_enqueue_merge to wait your turn."),
+			mcp.WithString("repo", mcp.Required(), mcp.Description("Repository name")),
+			mcp.WithString("worktree_id", mcp.Required(), mcp.Description("Worktree ID to merge")),
+			mcp.WithString("strategy",
+				mcp.Description("Merge strategy (defaults to the repo's merge_strategy setting, then merge)"),
+				mcp.Enum("merge", "squash", "rebase", "ff-only"),
+			),
+			mcp.WithBoolean("skip_verify", mcp.Description("Merge without running verify commands; the skip is recorded against agent_id")),
+			mcp.WithString("agent_id", mcp.Description("ID of the agent requesting the merge (default: the agent whose token authenticated the request)")),
+		),
+		withIssueLink(handleMergeWorktree(db, cfg)),
+	)
+
+	s.AddTool(
+		mcp.NewTool("agit_sync_worktree",
+			mcp.WithDescription("Bring a worktree branch up to date with its base (the repo's default branch unless it was spawned with a base) by rebasing or merging, then refresh its file touches. Conflicts are returned as conflicted_paths with the rebase or merge left in progress in the worktree; resolve and continue with git, or call again with abort=true to undo."),
+			mcp.WithString("repo", mcp.Required(), mcp.Description("Repository name")),
+			mcp.WithString("worktree_id", mcp.Required(), mcp.Description("Worktree ID to sync")),
+			mcp.WithString("mode",
+				mcp.Description("How to pick up the base (default rebase)"),
+				mcp.Enum("rebase", "merge"),
+			),
+			mcp.WithBoolean("abort", mcp.Description("Abort a sync that stopped on conflicts instead of starting one")),
+		),
+		withIssueLink(handleSyncWorktree(db)),
+	)
+
+	s.AddTool(
+		mcp.NewTool("agit_enqueue_merge",
+			mcp.WithDescription("Add a worktree to the repo's merge queue and return its entry and position at once. The queue is processed by agit queue run or the scheduler (agit daemon, or agit serve --scheduler); poll agit_queue_status for the outcome. Merges land one at a time; an entry that conflicts with the new tip is bounced back and its worktree marked conflict. Re-enqueue once the conflict is fixed."),
 			mcp.WithString("repo", mcp.Required(), mcp.Description("Repository name")),
 			mcp.WithString("worktree_id", mcp.Required(), mcp.Description("Worktree ID to merge")),
+			mcp.WithString("strategy",
+				mcp.Description("Merge strategy (defaults to the repo's merge_strategy setting, then merge)"),
+				mcp.Enum("merge", "squash", "rebase", "ff-only"),
+			),
+			mcp.WithBoolean("cleanup", mcp.Description("Remove the worktree and branch after a successful merge (default true)")),
+			mcp.WithString("agent_id", mcp.Description("ID of the agent enqueuing the merge (default: the agent whose token authenticated the request)")),
 		),
-		withIssueLink(handleMergeWorktree(db)),
+		withIssueLink(handleEnqueueMerge(db)),
+	)
+
+	s.AddTool(
+		mcp.NewTool("agit_queue_status",
+			mcp.WithDescription("List the merge queue for a repository, or look up a single entry"),
+			mcp.WithString("repo", mcp.Required(), mcp.Description("Repository name")),
+			mcp.WithString("entry_id", mcp.Description("Queue entry ID to look up")),
+			mcp.WithBoolean("all", mcp.Description("Include merged, bounced and cancelled entries")),
+		),
+		withIssueLink(handleQueueStatus(db)),
 	)
 
 	s.AddTool(
@@ -114,18 +187,20 @@ func registerTools(s *server.MCPServer, db *registry.DB, cfg *config.Config) {
 
 	s.AddTool(
 		mcp.NewTool("agit_heartbeat",
-			mcp.WithDescription("Update agent heartbeat timestamp"),
-			mcp.WithString("agent_id", mcp.Required(), mcp.Description("Agent ID")),
+			mcp.WithDescription("Update agent heartbeat timestamp and renew the leases on tasks the agent holds. Tasks whose lease expires without a heartbeat are returned to pending."),
+			mcp.WithString("agent_id", mcp.Description("Agent ID (default: the agent whose token authenticated the request)")),
 		),
 		withIssueLink(handleHeartbeat(db)),
 	)
 
 	s.AddTool(
 		mcp.NewTool("agit_create_task",
-			mcp.WithDescription("Create a new task for a repository"),
+			mcp.WithDescription("Create a new task for a repository. If a scope is given, scope_overlaps lists in-flight or pending work it is likely to collide with."),
 			mcp.WithString("repo", mcp.Required(), mcp.Description("Repository name")),
 			mcp.WithString("description", mcp.Required(), mcp.Description("Task description")),
 			mcp.WithNumber("priority", mcp.Description("Task priority (higher = more urgent, default 0)")),
+			mcp.WithArray("depends_on", mcp.Description("IDs of tasks that must be completed before this one can be claimed"), stringItems()),
+			mcp.WithArray("scope", mcp.Description("Path globs the task is expected to touch, e.g. internal/registry/** (lets agit_next_task avoid handing out tasks that collide with work in flight)"), stringItems()),
 		),
 		withIssueLink(handleCreateTask(db)),
 	)
@@ -135,6 +210,7 @@ func registerTools(s *server.MCPServer, db *registry.DB, cfg *config.Config) {
 			mcp.WithDescription("Mark a task as failed with optional reason"),
 			mcp.WithString("task_id", mcp.Required(), mcp.Description("Task ID to fail")),
 			mcp.WithString("result", mcp.Description("Failure reason")),
+			mcp.WithBoolean("cascade", mcp.Description("Mark pending tasks that depend on this one as blocked")),
 		),
 		withIssueLink(handleFailTask(db)),
 	)
@@ -191,11 +267,55 @@ func registerTools(s *server.MCPServer, db *registry.DB, cfg *config.Config) {
 
 	s.AddTool(
 		mcp.NewTool("agit_next_task",
-			mcp.WithDescription("Atomically claim the highest-priority pending task. Returns the claimed task or null if no pending tasks exist."),
+			mcp.WithDescription("Atomically claim the highest-priority pending task whose dependencies are all completed. Tasks whose declared scope overlaps a claimed or in-progress task, or files an active worktree has changed, are only handed out when nothing else is available. Returns the claimed task or null if no pending tasks exist. A pre.task.claim hook can refuse the chosen task, in which case it stays pending and the error is the hook's stderr."),
+			mcp.WithString("repo", mcp.Required(), mcp.Description("Repository name")),
+			mcp.WithString("agent_id", mcp.Description("Agent ID claiming the task (default: the agent whose token authenticated the request)")),
+		),
+		withIssueLink(handleNextTask(db, cfg)),
+	)
+
+	s.AddTool(
+		mcp.NewTool("agit_get_events",
+			mcp.WithDescription("Read the event log to catch up on what other agents did: worktrees created, merged or removed, tasks created, claimed, completed or failed, agents registered or swept, and conflicts detected. Pass the returned last_id as after_id on the next call to receive only newer events."),
+			mcp.WithString("repo", mcp.Description("Only events for this repository")),
+			mcp.WithNumber("after_id", mcp.Description("Only events with an ID greater than this (the last_id from a previous call)")),
+			mcp.WithString("since", mcp.Description("Only events after this time: a duration such as 2h or 7d, or an RFC 3339 timestamp")),
+			mcp.WithString("agent", mcp.Description("Only events by this agent (name or ID)")),
+			mcp.WithString("type", mcp.Description("Event type (e.g. task.claimed) or category (e.g. task)")),
+			mcp.WithNumber("limit", mcp.Description("Maximum number of events, most recent kept (default 100)")),
+		),
+		withIssueLink(handleGetEvents(db)),
+	)
+
+	s.AddTool(
+		mcp.NewTool("agit_acquire_lock",
+			mcp.WithDescription("Lock a file, directory or glob in a repository before rewriting it, so other agents know to stay away. Locks are advisory: they are reported by agit_check_conflicts and refuse merges that change locked files. Acquiring a path you already hold renews its TTL. If another agent holds an overlapping lock, acquired is false and held_by describes it."),
 			mcp.WithString("repo", mcp.Required(), mcp.Description("Repository name")),
-			mcp.WithString("agent_id", mcp.Required(), mcp.Description("Agent ID claiming the task")),
+			mcp.WithString("path", mcp.Required(), mcp.Description("Repo-relative path or glob, e.g. internal/registry/db.go, internal/registry/ or **/*.sql")),
+			mcp.WithString("agent_id", mcp.Description("Agent ID holding the lock (default, without worktree_id: the agent whose token authenticated the request)")),
+			mcp.WithString("worktree_id", mcp.Description("Worktree ID holding the lock (full or prefix)")),
+			mcp.WithString("ttl", mcp.Description("How long to hold the lock, e.g. 15m or 2h (default 30m)")),
+			mcp.WithString("reason", mcp.Description("Why the path is locked, shown to other agents")),
 		),
-		withIssueLink(handleNextTask(db)),
+		withIssueLink(handleAcquireLock(db)),
+	)
+
+	s.AddTool(
+		mcp.NewTool("agit_release_lock",
+			mcp.WithDescription("Release a lock you hold"),
+			mcp.WithString("lock_id", mcp.Required(), mcp.Description("Lock ID")),
+			mcp.WithString("agent_id", mcp.Description("Agent ID holding the lock (default, without worktree_id: the agent whose token authenticated the request)")),
+			mcp.WithString("worktree_id", mcp.Description("Worktree ID holding the lock (full or prefix)")),
+		),
+		withIssueLink(handleReleaseLock(db)),
+	)
+
+	s.AddTool(
+		mcp.NewTool("agit_list_locks",
+			mcp.WithDescription("List unexpired locks and who holds them"),
+			mcp.WithString("repo", mcp.Description("Only locks in this repository")),
+		),
+		withIssueLink(handleListLocks(db)),
 	)
 }
 
@@ -237,3 +357,10 @@ func registerResources(s *server.MCPServer, db *registry.DB) {
 		handleRepoTasksResource(db),
 	)
 }
+
+// stringItems declares an array property whose items are strings.
+func stringItems() mcp.PropertyOption {
+	return func(schema map[string]interface{}) {
+		schema["items"] = map[string]interface{}{"type": "string"}
+	}
+}
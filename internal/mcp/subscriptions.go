@@ -0,0 +1,344 @@
+package mcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+
+	"github.com/fathindos/agit/internal/registry"
+)
+
+// resourcePollInterval is how often subscribed resources are checked for
+// changes made by other processes sharing the registry
+const resourcePollInterval = time.Second
+
+const (
+	methodSubscribe       = "resources/subscribe"
+	methodUnsubscribe     = "resources/unsubscribe"
+	methodResourceUpdated = "notifications/resources/updated"
+)
+
+// eventResources lists, per entity type in the event log, the resources an
+// event about such an entity can change. {name} stands for the event's repo.
+var eventResources = map[string][]string{
+	"repo":     {"agit://repos", "agit://repos/{name}"},
+	"worktree": {"agit://repos", "agit://repos/{name}", "agit://repos/{name}/conflicts", "agit://repos/{name}/tasks", "agit://agents"},
+	"task":     {"agit://repos", "agit://repos/{name}", "agit://repos/{name}/tasks"},
+	"agent":    {"agit://agents"},
+	"file":     {"agit://repos/{name}/conflicts"},
+}
+
+// typeResources overrides eventResources for event types that change fewer
+// resources than their entity type implies
+var typeResources = map[string][]string{
+	registry.EventTouchesUpdated: {"agit://repos/{name}/conflicts"},
+}
+
+// subscriptions tracks the resources each client session subscribed to and
+// sends notifications/resources/updated when the event log shows they
+// changed. Events recorded through db wake it at once; events recorded by
+// other processes are picked up by polling the log.
+type subscriptions struct {
+	db *registry.DB
+
+	mu       sync.Mutex
+	sessions map[string]server.ClientSession
+	uris     map[string]map[string]bool // session ID -> subscribed URIs
+
+	wake   chan struct{}
+	start  sync.Once
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newSubscriptions(db *registry.DB) *subscriptions {
+	s := &subscriptions{
+		db:       db,
+		sessions: make(map[string]server.ClientSession),
+		uris:     make(map[string]map[string]bool),
+		wake:     make(chan struct{}, 1),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	db.Subscribe(func(*registry.Event) {
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	})
+	db.OnClose(func() {
+		s.cancel()
+		s.wg.Wait()
+	})
+	return s
+}
+
+// register tracks a client session until its context ends
+func (s *subscriptions) register(ctx context.Context, session server.ClientSession) {
+	id := session.SessionID()
+	s.mu.Lock()
+	s.sessions[id] = session
+	s.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.sessions[id] == session {
+			delete(s.sessions, id)
+			delete(s.uris, id)
+		}
+	}()
+}
+
+// subscribe adds uri to a session's subscriptions and starts watching the
+// event log. It reports false for an unknown session.
+func (s *subscriptions) subscribe(sessionID, uri string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.sessions[sessionID]; !ok {
+		return false
+	}
+	if s.uris[sessionID] == nil {
+		s.uris[sessionID] = make(map[string]bool)
+	}
+	s.uris[sessionID][uri] = true
+
+	s.start.Do(func() {
+		// Read the end of the log now, so that no change after the first
+		// subscription goes unnoticed
+		var last int64
+		if events, err := s.db.ListEvents(registry.EventFilter{Limit: 1}); err == nil && len(events) == 1 {
+			last = events[0].ID
+		}
+		s.wg.Add(1)
+		go s.watch(last)
+	})
+	return true
+}
+
+func (s *subscriptions) unsubscribe(sessionID, uri string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.sessions[sessionID]; !ok {
+		return false
+	}
+	delete(s.uris[sessionID], uri)
+	return true
+}
+
+// watch follows the event log after event last and notifies subscribers of
+// the resources each new event changed
+func (s *subscriptions) watch(last int64) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(resourcePollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+
+		events, err := s.db.ListEvents(registry.EventFilter{AfterID: last})
+		if err != nil {
+			log.Printf("mcp: could not read the event log: %v", err)
+			continue
+		}
+		updated := make(map[string]bool)
+		repoNames := make(map[string]string)
+		for _, e := range events {
+			last = e.ID
+			for _, uri := range s.resourcesFor(e, repoNames) {
+				updated[uri] = true
+			}
+		}
+		s.notify(updated)
+	}
+}
+
+// resourcesFor returns the URIs of the resources an event changed.
+// repoNames caches repo names by ID.
+func (s *subscriptions) resourcesFor(e *registry.Event, repoNames map[string]string) []string {
+	var name string
+	if e.RepoID != nil {
+		if _, ok := repoNames[*e.RepoID]; !ok {
+			if repo, err := s.db.GetRepoByID(*e.RepoID); err == nil {
+				repoNames[*e.RepoID] = repo.Name
+			}
+		}
+		name = repoNames[*e.RepoID]
+	}
+
+	resources, ok := typeResources[e.Type]
+	if !ok {
+		resources = eventResources[e.EntityType]
+	}
+	var uris []string
+	for _, uri := range resources {
+		if strings.Contains(uri, "{name}") {
+			if name == "" {
+				continue
+			}
+			uri = strings.ReplaceAll(uri, "{name}", name)
+		}
+		uris = append(uris, uri)
+	}
+	return uris
+}
+
+// notify sends notifications/resources/updated to every session subscribed
+// to one of the updated URIs. A session whose notification queue is full
+// misses the notification rather than holding up the others.
+func (s *subscriptions) notify(updated map[string]bool) {
+	if len(updated) == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, uris := range s.uris {
+		session := s.sessions[id]
+		if !session.Initialized() {
+			continue
+		}
+		for uri := range uris {
+			if !updated[uri] {
+				continue
+			}
+			notification := mcp.JSONRPCNotification{
+				JSONRPC: mcp.JSONRPC_VERSION,
+				Notification: mcp.Notification{
+					Method: methodResourceUpdated,
+					Params: mcp.NotificationParams{AdditionalFields: map[string]any{"uri": uri}},
+				},
+			}
+			select {
+			case session.NotificationChannel() <- notification:
+			default:
+			}
+		}
+	}
+}
+
+// intercept handles resources/subscribe and resources/unsubscribe requests
+// before they reach mcp-go, which advertises subscriptions but answers both
+// with method not found. A handled request is swapped for a ping with the
+// same ID, since the reply to all three is an empty result. A request for a
+// resource agit does not serve, or from an unknown session, is swapped for
+// resources/read so that the client gets mcp-go's error for it.
+func (s *Server) intercept(sessionID string, message []byte) []byte {
+	var req struct {
+		JSONRPC string          `json:"jsonrpc"`
+		ID      json.RawMessage `json:"id"`
+		Method  string          `json:"method"`
+		Params  struct {
+			URI string `json:"uri"`
+		} `json:"params"`
+	}
+	if err := json.Unmarshal(message, &req); err != nil || len(req.ID) == 0 || string(req.ID) == "null" {
+		return message
+	}
+
+	var ok bool
+	switch req.Method {
+	case methodSubscribe:
+		ok = isResourceURI(req.Params.URI) && s.subs.subscribe(sessionID, req.Params.URI)
+	case methodUnsubscribe:
+		ok = s.subs.unsubscribe(sessionID, req.Params.URI)
+	default:
+		return message
+	}
+
+	rewritten := map[string]any{"jsonrpc": req.JSONRPC, "id": req.ID, "method": mcp.MethodPing}
+	if !ok {
+		rewritten["method"] = mcp.MethodResourcesRead
+		rewritten["params"] = map[string]any{"uri": req.Params.URI}
+	}
+	out, err := json.Marshal(rewritten)
+	if err != nil {
+		return message
+	}
+	return out
+}
+
+// isResourceURI reports whether uri names one of the resources in
+// registerResources
+func isResourceURI(uri string) bool {
+	if uri == "agit://repos" || uri == "agit://agents" {
+		return true
+	}
+	rest, ok := strings.CutPrefix(uri, "agit://repos/")
+	if !ok {
+		return false
+	}
+	name, sub, _ := strings.Cut(rest, "/")
+	return name != "" && (sub == "" || sub == "conflicts" || sub == "tasks")
+}
+
+// ServeStdio serves s over stdin and stdout until stdin is closed or the
+// process is interrupted
+func (s *Server) ServeStdio() error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	stdio := server.NewStdioServer(s.MCPServer)
+	stdio.SetErrorLogger(log.New(os.Stderr, "", log.LstdFlags))
+	// The stdio transport has a single session, always called "stdio"
+	return stdio.Listen(ctx, &interceptReader{s: s, session: "stdio", in: bufio.NewReader(os.Stdin)}, os.Stdout)
+}
+
+// interceptReader passes each line of the stdio transport through intercept
+type interceptReader struct {
+	s       *Server
+	session string
+	in      *bufio.Reader
+	buf     []byte
+	err     error
+}
+
+func (r *interceptReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		var line []byte
+		line, r.err = r.in.ReadBytes('\n')
+		if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 {
+			r.buf = append(r.s.intercept(r.session, trimmed), '\n')
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// SSEHandler serves sse, passing the requests posted to its message
+// endpoint through intercept
+func (s *Server) SSEHandler(sse *server.SSEServer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && r.URL.Path == sse.CompleteMessagePath() {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, "could not read request", http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(s.intercept(r.URL.Query().Get("sessionId"), body)))
+		}
+		sse.ServeHTTP(w, r)
+	})
+}
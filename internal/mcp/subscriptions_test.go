@@ -0,0 +1,108 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+
+	"github.com/fathindos/agit/internal/config"
+	"github.com/fathindos/agit/internal/registry"
+)
+
+type testSession struct {
+	notifications chan mcp.JSONRPCNotification
+}
+
+func (s *testSession) Initialize()       {}
+func (s *testSession) Initialized() bool { return true }
+func (s *testSession) SessionID() string { return "test" }
+func (s *testSession) NotificationChannel() chan<- mcp.JSONRPCNotification {
+	return s.notifications
+}
+
+func TestResourceSubscriptions(t *testing.T) {
+	t.Setenv("AGIT_HOME", t.TempDir())
+	db, err := registry.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A second connection stands in for another agit process
+	other, err := registry.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	s := NewServer(db, config.DefaultConfig())
+	defer db.Close()
+	repo, _ := db.AddRepo("demo", "/tmp/demo", "", "main")
+
+	session := &testSession{notifications: make(chan mcp.JSONRPCNotification, 10)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := s.RegisterSession(ctx, session); err != nil {
+		t.Fatal(err)
+	}
+	ctx = s.WithContext(ctx, session)
+	call := func(method, uri string) mcp.JSONRPCMessage {
+		msg, _ := json.Marshal(map[string]any{
+			"jsonrpc": "2.0", "id": 1, "method": method, "params": map[string]any{"uri": uri},
+		})
+		return s.HandleMessage(ctx, s.intercept("test", msg))
+	}
+	expectUpdate := func(want string) {
+		t.Helper()
+		select {
+		case n := <-session.notifications:
+			if n.Method != "notifications/resources/updated" || n.Params.AdditionalFields["uri"] != want {
+				t.Fatalf("expected an update for %s, got %+v", want, n)
+			}
+		case <-time.After(3 * resourcePollInterval):
+			t.Fatalf("no update for %s", want)
+		}
+	}
+
+	if resp, ok := call("resources/subscribe", "agit://repos/demo/tasks").(mcp.JSONRPCResponse); !ok {
+		t.Fatalf("expected subscribe to succeed, got %+v", resp)
+	}
+	if _, ok := call("resources/subscribe", "agit://nope").(mcp.JSONRPCError); !ok {
+		t.Error("expected subscribing to an unknown resource to fail")
+	}
+
+	db.CreateTask(repo.ID, "in this process", 0)
+	expectUpdate("agit://repos/demo/tasks")
+	other.CreateTask(repo.ID, "in another process", 0)
+	expectUpdate("agit://repos/demo/tasks")
+
+	call("resources/unsubscribe", "agit://repos/demo/tasks")
+	call("resources/subscribe", "agit://agents")
+	db.CreateTask(repo.ID, "unwatched", 0)
+	db.RegisterAgent("builder", "claude")
+	expectUpdate("agit://agents")
+
+	// A rescan that changes a worktree's touches can resolve a conflict
+	call("resources/unsubscribe", "agit://agents")
+	wt, _ := db.CreateWorktree(repo.ID, "/tmp/demo-wt", "agit/demo", nil, nil)
+	call("resources/subscribe", "agit://repos/demo/conflicts")
+	other.RecordFileTouches(repo.ID, wt.ID, []registry.FileTouch{{FilePath: "main.go"}})
+	expectUpdate("agit://repos/demo/conflicts")
+}
+
+func TestIsResourceURI(t *testing.T) {
+	for uri, want := range map[string]bool{
+		"agit://repos":           true,
+		"agit://agents":          true,
+		"agit://repos/web":       true,
+		"agit://repos/web/tasks": true,
+		"agit://repos/web/locks": false,
+		"agit://repos/":          false,
+		"file:///etc/passwd":     false,
+	} {
+		if got := isResourceURI(uri); got != want {
+			t.Errorf("isResourceURI(%q) = %v, want %v", uri, got, want)
+		}
+	}
+}
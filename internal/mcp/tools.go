@@ -3,9 +3,11 @@ package mcp
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/mark3labs/mcp-go/mcp"
@@ -15,8 +17,12 @@ import (
 	"github.com/fathindos/agit/internal/conflicts"
 	apperrors "github.com/fathindos/agit/internal/errors"
 	gitops "github.com/fathindos/agit/internal/git"
+	"github.com/fathindos/agit/internal/hooks"
 	"github.com/fathindos/agit/internal/issuelink"
+	"github.com/fathindos/agit/internal/mergequeue"
 	"github.com/fathindos/agit/internal/registry"
+	"github.com/fathindos/agit/internal/setup"
+	"github.com/fathindos/agit/internal/verify"
 )
 
 func jsonResult(v any) (*mcp.CallToolResult, error) {
@@ -27,6 +33,22 @@ func jsonResult(v any) (*mcp.CallToolResult, error) {
 	return mcp.NewToolResultText(string(data)), nil
 }
 
+// stringSliceArg extracts a list of strings from an array argument.
+// Non-string and empty items are ignored.
+func stringSliceArg(args map[string]any, key string) []string {
+	raw, ok := args[key].([]any)
+	if !ok {
+		return nil
+	}
+	var out []string
+	for _, v := range raw {
+		if s, ok := v.(string); ok && s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
 // wrapInternalError appends an issue link to internal (non-user) errors
 // so that AI agents or their operators can easily report bugs.
 // withIssueLink wraps an MCP tool handler to append issue links to internal errors.
@@ -41,6 +63,24 @@ func withIssueLink(handler mcpserver.ToolHandlerFunc) mcpserver.ToolHandlerFunc
 	}
 }
 
+// repoHooks returns the hook runner for the config in effect for a repo,
+// recording into db
+func repoHooks(db *registry.DB, cfg *config.Config, repo *registry.Repo) (*hooks.Runner, error) {
+	cfg, err := cfg.ForRepo(repo.Path, repo.ConfigOverrides())
+	if err != nil {
+		return nil, fmt.Errorf("could not load config for %s: %w", repo.Name, err)
+	}
+	return hooks.NewRunner(cfg, db), nil
+}
+
+// agentName looks up an agent's name for hooks, falling back to its ID
+func agentName(db *registry.DB, agentID string) string {
+	if agent, err := db.GetAgent(agentID); err == nil {
+		return agent.Name
+	}
+	return agentID
+}
+
 func handleListRepos(db *registry.DB) mcpserver.ToolHandlerFunc {
 	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		repos, err := db.ListRepos()
@@ -111,6 +151,7 @@ func handleRepoStatus(db *registry.DB) mcpserver.ToolHandlerFunc {
 		}
 		type conflictItem struct {
 			File      string   `json:"file"`
+			Kind      string   `json:"kind"`
 			Worktrees []string `json:"worktrees"`
 		}
 
@@ -144,7 +185,7 @@ func handleRepoStatus(db *registry.DB) mcpserver.ToolHandlerFunc {
 
 		var cls []conflictItem
 		for _, c := range conflictList {
-			cls = append(cls, conflictItem{c.FilePath, c.Worktrees})
+			cls = append(cls, conflictItem{c.FilePath, conflicts.Kind(c), c.Worktrees})
 		}
 
 		result := map[string]any{
@@ -170,14 +211,31 @@ func handleSpawnWorktree(db *registry.DB, cfg *config.Config) mcpserver.ToolHand
 		task, _ := request.Params.Arguments["task"].(string)
 		branch, _ := request.Params.Arguments["branch"].(string)
 		agentName, _ := request.Params.Arguments["agent"].(string)
+		base, _ := request.Params.Arguments["base"].(string)
+		existingBranch, _ := request.Params.Arguments["existing_branch"].(string)
+		if existingBranch != "" && branch != "" {
+			return nil, apperrors.NewUserError("branch and existing_branch cannot be used together")
+		}
 
 		repo, err := db.GetRepo(repoName)
 		if err != nil {
 			return nil, err
 		}
+		cfg, err := cfg.ForRepo(repo.Path, repo.ConfigOverrides())
+		if err != nil {
+			return nil, fmt.Errorf("could not load config for %s: %w", repo.Name, err)
+		}
+		if base != "" && !gitops.LocalBranchExists(repo.Path, base) {
+			return nil, apperrors.NewUserErrorf("base %q is not a local branch in %s", base, repo.Name)
+		}
+		if existingBranch != "" && !gitops.LocalBranchExists(repo.Path, existingBranch) {
+			return nil, apperrors.NewUserErrorf("branch %q not found in %s", existingBranch, repo.Name)
+		}
 
 		shortID := uuid.New().String()[:8]
-		if branch == "" {
+		if existingBranch != "" {
+			branch = existingBranch
+		} else if branch == "" {
 			if task != "" {
 				slug := strings.ToLower(task)
 				slug = strings.ReplaceAll(slug, " ", "-")
@@ -198,7 +256,29 @@ func handleSpawnWorktree(db *registry.DB, cfg *config.Config) mcpserver.ToolHand
 
 		worktreePath := filepath.Join(repo.Path, cfg.Defaults.WorktreeDir, "agit-"+shortID)
 
-		if err := gitops.CreateWorktree(repo.Path, worktreePath, branch, repo.DefaultBranch); err != nil {
+		baseBranch := repo.DefaultBranch
+		if base != "" {
+			baseBranch = base
+		}
+		if err := hooks.NewRunner(cfg, db).Check(hooks.PreSpawn, map[string]string{
+			"AGIT_REPO":          repo.Name,
+			"AGIT_BRANCH":        branch,
+			"AGIT_BASE_BRANCH":   baseBranch,
+			"AGIT_WORKTREE_PATH": worktreePath,
+			"AGIT_TASK":          task,
+			"AGIT_AGENT":         agentName,
+		}); err != nil {
+			return nil, err
+		}
+
+		if existingBranch != "" {
+			err = gitops.AttachWorktree(repo.Path, worktreePath, branch)
+		} else if base != "" {
+			err = gitops.CreateWorktree(repo.Path, worktreePath, branch, base)
+		} else {
+			err = gitops.CreateWorktree(repo.Path, worktreePath, branch, repo.DefaultBranch)
+		}
+		if err != nil {
 			return nil, fmt.Errorf("could not create worktree: %w", err)
 		}
 
@@ -217,12 +297,15 @@ func handleSpawnWorktree(db *registry.DB, cfg *config.Config) mcpserver.ToolHand
 			agentID = &agent.ID
 		}
 
-		var taskDesc *string
+		opts := registry.WorktreeOptions{AgentID: agentID, ExistingBranch: existingBranch != ""}
 		if task != "" {
-			taskDesc = &task
+			opts.TaskDescription = &task
+		}
+		if base != "" {
+			opts.BaseRef = &base
 		}
 
-		wt, err := db.CreateWorktree(repo.ID, worktreePath, branch, agentID, taskDesc)
+		wt, err := db.CreateWorktreeWithOptions(repo.ID, worktreePath, branch, opts)
 		if err != nil {
 			gitops.RemoveWorktree(repo.Path, worktreePath)
 			return nil, fmt.Errorf("could not record worktree: %w", err)
@@ -232,15 +315,69 @@ func handleSpawnWorktree(db *registry.DB, cfg *config.Config) mcpserver.ToolHand
 			db.UpdateAgentWorktree(*agentID, &wt.ID)
 		}
 
-		return jsonResult(map[string]string{
+		if skip, _ := request.Params.Arguments["skip_setup"].(bool); !skip {
+			if err := setup.Worktree(db, repo, wt); err != nil {
+				return nil, err
+			}
+		}
+
+		result := map[string]string{
 			"worktree_id": wt.ID,
 			"path":        worktreePath,
 			"branch":      branch,
-		})
+			"base":        wt.Base(repo.DefaultBranch),
+			"status":      wt.Status,
+		}
+		if wt.SetupStatus != nil {
+			result["setup"] = *wt.SetupStatus
+		}
+		if step := setup.FailedStep(wt); step != nil {
+			result["setup_error"] = setup.Describe(step)
+		}
+		return jsonResult(result)
 	}
 }
 
-func handleRemoveWorktree(db *registry.DB) mcpserver.ToolHandlerFunc {
+func handleSetupWorktree(db *registry.DB) mcpserver.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repoName, _ := request.Params.Arguments["repo"].(string)
+		if repoName == "" {
+			return nil, apperrors.NewUserError("repo parameter is required")
+		}
+		worktreeID, _ := request.Params.Arguments["worktree_id"].(string)
+		if worktreeID == "" {
+			return nil, apperrors.NewUserError("worktree_id parameter is required")
+		}
+
+		repo, err := db.GetRepo(repoName)
+		if err != nil {
+			return nil, err
+		}
+		wt, err := db.ResolveWorktree(repo.ID, worktreeID)
+		if err != nil {
+			return nil, err
+		}
+
+		if recipe, err := setup.LoadRecipe(repo); err == nil && recipe.Empty() {
+			return nil, apperrors.NewUserErrorf("%s has no setup recipe", repo.Name)
+		}
+		if err := setup.Worktree(db, repo, wt); err != nil {
+			return nil, err
+		}
+		result := map[string]any{
+			"worktree_id": wt.ID,
+			"status":      wt.Status,
+			"setup":       *wt.SetupStatus,
+			"steps":       wt.SetupSteps,
+		}
+		if step := setup.FailedStep(wt); step != nil {
+			result["setup_error"] = setup.Describe(step)
+		}
+		return jsonResult(result)
+	}
+}
+
+func handleRemoveWorktree(db *registry.DB, cfg *config.Config) mcpserver.ToolHandlerFunc {
 	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		repoName, _ := request.Params.Arguments["repo"].(string)
 		if repoName == "" {
@@ -261,6 +398,14 @@ func handleRemoveWorktree(db *registry.DB) mcpserver.ToolHandlerFunc {
 			return nil, err
 		}
 
+		runner, err := repoHooks(db, cfg, repo)
+		if err != nil {
+			return nil, err
+		}
+		if err := runner.Check(hooks.PreWorktreeRemove, hooks.WorktreeEnv(repo, wt)); err != nil {
+			return nil, err
+		}
+
 		gitops.RemoveWorktree(repo.Path, wt.Path)
 		db.DeleteWorktree(wt.ID)
 
@@ -291,20 +436,47 @@ func handleCheckConflicts(db *registry.DB) mcpserver.ToolHandlerFunc {
 		activeStatus := "active"
 		worktrees, _ := db.ListWorktrees(repo.ID, &activeStatus)
 
+		type hunkItem struct {
+			Worktree    string   `json:"worktree"`
+			Lines       []string `json:"lines,omitempty"`
+			WholeFile   bool     `json:"whole_file,omitempty"`
+			Uncommitted bool     `json:"uncommitted,omitempty"`
+		}
 		type conflictItem struct {
-			File      string   `json:"file"`
-			Worktrees []string `json:"worktrees"`
+			File      string     `json:"file"`
+			Kind      string     `json:"kind"`
+			Worktrees []string   `json:"worktrees"`
+			Overlaps  []hunkItem `json:"overlaps"`
 		}
 
 		var items []conflictItem
 		for _, c := range conflictList {
-			items = append(items, conflictItem{c.FilePath, c.Worktrees})
+			item := conflictItem{File: c.FilePath, Kind: conflicts.Kind(c), Worktrees: c.Worktrees}
+			for i, wtID := range c.Worktrees {
+				h := hunkItem{Worktree: wtID}
+				if i < len(c.Ranges) && c.Ranges[i] != nil {
+					h.Lines = conflicts.RangeStrings(c.Ranges[i])
+				} else {
+					h.WholeFile = true
+				}
+				if i < len(c.Uncommitted) {
+					h.Uncommitted = c.Uncommitted[i]
+				}
+				item.Overlaps = append(item.Overlaps, h)
+			}
+			items = append(items, item)
 		}
 
 		suggestions := conflicts.SuggestResolutionOrder(conflictList, worktrees)
 
+		violations, err := db.FindLockViolations(repo.ID)
+		if err != nil {
+			return nil, err
+		}
+
 		return jsonResult(map[string]any{
 			"conflicts":         items,
+			"lock_violations":   lockViolationsJSON(violations),
 			"scanned_worktrees": len(worktrees),
 			"suggestions":       suggestions,
 		})
@@ -334,12 +506,13 @@ func handleListTasks(db *registry.DB) mcpserver.ToolHandlerFunc {
 		}
 
 		type taskItem struct {
-			ID          string  `json:"id"`
-			Description string  `json:"description"`
-			Priority    int     `json:"priority"`
-			Status      string  `json:"status"`
-			Agent       *string `json:"agent"`
-			CreatedAt   string  `json:"created_at"`
+			ID          string   `json:"id"`
+			Description string   `json:"description"`
+			Priority    int      `json:"priority"`
+			Status      string   `json:"status"`
+			Agent       *string  `json:"agent"`
+			CreatedAt   string   `json:"created_at"`
+			Scope       []string `json:"scope,omitempty"`
 		}
 
 		var items []taskItem
@@ -358,6 +531,7 @@ func handleListTasks(db *registry.DB) mcpserver.ToolHandlerFunc {
 				Status:      t.Status,
 				Agent:       agentName,
 				CreatedAt:   t.CreatedAt.Format("2006-01-02T15:04:05Z"),
+				Scope:       t.Scope,
 			})
 		}
 
@@ -365,7 +539,7 @@ func handleListTasks(db *registry.DB) mcpserver.ToolHandlerFunc {
 	}
 }
 
-func handleClaimTask(db *registry.DB) mcpserver.ToolHandlerFunc {
+func handleClaimTask(db *registry.DB, cfg *config.Config) mcpserver.ToolHandlerFunc {
 	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		taskID, _ := request.Params.Arguments["task_id"].(string)
 		if taskID == "" {
@@ -376,6 +550,22 @@ func handleClaimTask(db *registry.DB) mcpserver.ToolHandlerFunc {
 			return nil, apperrors.NewUserError("agent_id parameter is required")
 		}
 
+		task, err := db.GetTask(taskID)
+		if err != nil {
+			return nil, err
+		}
+		repo, err := db.GetRepoByID(task.RepoID)
+		if err != nil {
+			return nil, err
+		}
+		runner, err := repoHooks(db, cfg, repo)
+		if err != nil {
+			return nil, err
+		}
+		if err := runner.Check(hooks.PreTaskClaim, hooks.TaskEnv(repo, task, agentID, agentName(db, agentID))); err != nil {
+			return nil, err
+		}
+
 		if err := db.ClaimTask(taskID, agentID); err != nil {
 			return nil, err
 		}
@@ -411,7 +601,7 @@ func handleCompleteTask(db *registry.DB) mcpserver.ToolHandlerFunc {
 	}
 }
 
-func handleMergeWorktree(db *registry.DB) mcpserver.ToolHandlerFunc {
+func handleMergeWorktree(db *registry.DB, cfg *config.Config) mcpserver.ToolHandlerFunc {
 	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		repoName, _ := request.Params.Arguments["repo"].(string)
 		if repoName == "" {
@@ -432,36 +622,288 @@ func handleMergeWorktree(db *registry.DB) mcpserver.ToolHandlerFunc {
 			return nil, err
 		}
 
+		strategyName, _ := request.Params.Arguments["strategy"].(string)
+		opts, err := mergequeue.MergeOptions(db, repo, wt, strategyName)
+		if err != nil {
+			return nil, err
+		}
+		runner, err := repoHooks(db, cfg, repo)
+		if err != nil {
+			return nil, err
+		}
+		var agentID *string
+		if id, _ := request.Params.Arguments["agent_id"].(string); id != "" {
+			agentID = &id
+		}
+
+		// Hold the repo's merge slot, so queued merges can't land between
+		// the checks below and this merge
+		slot, err := mergequeue.ClaimSlot(db, repo, wt, strategyName, agentID)
+		if err != nil {
+			return nil, err
+		}
+		status, outcome := "failed", "merge did not complete"
+		defer func() { slot.Release(status, outcome) }()
+
+		// From here on, a merge that is refused or fails is logged as merge.failed
+		failed := func(reason string) {
+			outcome = reason
+			mergequeue.RecordMergeFailed(db, repo, wt, opts.Strategy, reason, agentID)
+		}
+		if err := mergequeue.CheckMerge(runner, repo, wt, opts.Strategy); err != nil {
+			failed(err.Error())
+			return nil, err
+		}
+
 		// Pre-merge conflict check
-		canMerge, err := gitops.CanMergeCleanly(repo.Path, wt.Branch)
+		sim, err := gitops.SimulateMerge(repo.Path, opts.BaseBranch, wt.Branch)
 		if err != nil {
 			return nil, fmt.Errorf("could not check merge compatibility: %w", err)
 		}
-		if !canMerge {
+		if !sim.Clean {
+			failed("merge would result in conflicts in " + strings.Join(sim.ConflictedPaths, ", "))
 			return jsonResult(map[string]any{
-				"error": "merge would result in conflicts",
+				"error":            "merge would result in conflicts",
+				"conflicted_paths": sim.ConflictedPaths,
+			})
+		}
+		violations, err := conflicts.MergeLockViolations(db, repo, wt)
+		if err != nil {
+			return nil, fmt.Errorf("could not check locks: %w", err)
+		}
+		if len(violations) > 0 {
+			failed("merge would change locked files: " + conflicts.FormatLockViolations(violations))
+			return jsonResult(map[string]any{
+				"error":           "merge would change files locked by another agent",
+				"lock_violations": lockViolationsJSON(violations),
 			})
 		}
 
-		// Checkout default branch and merge
-		if err := gitops.CheckoutBranch(repo.Path, repo.DefaultBranch); err != nil {
-			return nil, fmt.Errorf("could not checkout %s: %w", repo.DefaultBranch, err)
+		// Verify gate
+		var run *registry.VerifyRun
+		if skip, _ := request.Params.Arguments["skip_verify"].(bool); skip {
+			by := "mcp"
+			if agentID, _ := request.Params.Arguments["agent_id"].(string); agentID != "" {
+				by = agentID
+				if agent, err := db.GetAgent(agentID); err == nil {
+					by = agent.Name
+				}
+			}
+			if run, err = verify.Skip(db, repo, wt, by); err != nil {
+				return nil, err
+			}
+		} else {
+			if run, err = verify.Worktree(db, repo, wt); err != nil {
+				failed(err.Error())
+				return nil, err
+			}
+			if step := verify.FailedStep(run); step != nil {
+				failed("verification failed: " + verify.Describe(step))
+				return jsonResult(map[string]any{
+					"error":  "verification failed: " + verify.Describe(step),
+					"verify": run.Steps,
+				})
+			}
 		}
 
-		if err := gitops.MergeBranch(repo.Path, wt.Branch); err != nil {
+		// Merge through plumbing; the user's checkout is left alone
+		tip, err := gitops.Merge(repo.Path, wt.Branch, opts)
+		if err != nil {
+			failed(err.Error())
 			return nil, err
 		}
 
-		// Auto-cleanup: remove worktree from disk and mark completed
-		gitops.RemoveWorktree(repo.Path, wt.Path)
-		gitops.DeleteBranch(repo.Path, wt.Branch)
+		// Auto-cleanup: remove worktree from disk and mark completed, unless a
+		// pre.worktree.remove hook wants it kept
+		cleanupErr := runner.Check(hooks.PreWorktreeRemove, hooks.WorktreeEnv(repo, wt))
+		if cleanupErr == nil {
+			gitops.RemoveWorktree(repo.Path, wt.Path)
+			if wt.OwnsBranch() {
+				gitops.DeleteBranch(repo.Path, wt.Branch)
+			}
+		}
 		db.UpdateWorktreeStatus(wt.ID, "completed")
+		mergequeue.RecordMerged(db, repo, wt, opts.Strategy, tip, agentID)
+		status, outcome = "merged", fmt.Sprintf("merged into %s at %s (%s)", opts.BaseBranch, gitops.ShortSHA(tip), opts.Strategy)
 
-		return jsonResult(map[string]any{
+		result := map[string]any{
 			"merged":           true,
 			"branch":           wt.Branch,
-			"into":             repo.DefaultBranch,
-			"worktree_cleaned": true,
+			"into":             opts.BaseBranch,
+			"strategy":         opts.Strategy,
+			"worktree_cleaned": cleanupErr == nil,
+		}
+		if cleanupErr != nil {
+			result["cleanup_error"] = cleanupErr.Error()
+		}
+		if run != nil {
+			result["verify"] = run.Status
+		}
+		return jsonResult(result)
+	}
+}
+
+func queueEntryResult(e *registry.MergeQueueEntry) map[string]any {
+	item := map[string]any{
+		"id":          e.ID,
+		"worktree_id": e.WorktreeID,
+		"status":      e.Status,
+		"enqueued_at": e.EnqueuedAt,
+	}
+	if e.Strategy != "" {
+		item["strategy"] = e.Strategy
+	}
+	if e.Result != nil {
+		item["result"] = *e.Result
+	}
+	if e.AgentID != nil {
+		item["agent_id"] = *e.AgentID
+	}
+	return item
+}
+
+func handleSyncWorktree(db *registry.DB) mcpserver.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repoName, _ := request.Params.Arguments["repo"].(string)
+		if repoName == "" {
+			return nil, apperrors.NewUserError("repo parameter is required")
+		}
+		worktreeID, _ := request.Params.Arguments["worktree_id"].(string)
+		if worktreeID == "" {
+			return nil, apperrors.NewUserError("worktree_id parameter is required")
+		}
+
+		repo, err := db.GetRepo(repoName)
+		if err != nil {
+			return nil, err
+		}
+		wt, err := db.ResolveWorktree(repo.ID, worktreeID)
+		if err != nil {
+			return nil, err
+		}
+
+		if abort, _ := request.Params.Arguments["abort"].(bool); abort {
+			if m, _ := request.Params.Arguments["mode"].(string); m != "" {
+				return nil, apperrors.NewUserError("abort cannot be combined with mode")
+			}
+			mode, err := conflicts.AbortSync(db, repo, wt)
+			if err != nil {
+				return nil, err
+			}
+			return jsonResult(map[string]any{
+				"status":      "aborted",
+				"worktree_id": wt.ID,
+				"mode":        mode,
+			})
+		}
+
+		mode, _ := request.Params.Arguments["mode"].(string)
+		result, err := conflicts.Sync(db, repo, wt, gitops.SyncMode(mode))
+		if err != nil {
+			return nil, err
+		}
+		conflicted := result.ConflictedPaths
+		if conflicted == nil {
+			conflicted = []string{}
+		}
+		return jsonResult(map[string]any{
+			"status":           conflicts.SyncStatus(result),
+			"worktree_id":      wt.ID,
+			"branch":           wt.Branch,
+			"onto":             wt.Base(repo.DefaultBranch),
+			"mode":             result.Mode,
+			"old_head":         result.OldHead,
+			"new_head":         result.NewHead,
+			"conflicted_paths": conflicted,
+		})
+	}
+}
+
+func handleEnqueueMerge(db *registry.DB) mcpserver.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repoName, _ := request.Params.Arguments["repo"].(string)
+		if repoName == "" {
+			return nil, apperrors.NewUserError("repo parameter is required")
+		}
+		worktreeID, _ := request.Params.Arguments["worktree_id"].(string)
+		if worktreeID == "" {
+			return nil, apperrors.NewUserError("worktree_id parameter is required")
+		}
+
+		repo, err := db.GetRepo(repoName)
+		if err != nil {
+			return nil, err
+		}
+		wt, err := db.ResolveWorktree(repo.ID, worktreeID)
+		if err != nil {
+			return nil, err
+		}
+
+		strategy, _ := request.Params.Arguments["strategy"].(string)
+		cleanup := true
+		if v, ok := request.Params.Arguments["cleanup"].(bool); ok {
+			cleanup = v
+		}
+		var agentID *string
+		if id, _ := request.Params.Arguments["agent_id"].(string); id != "" {
+			agentID = &id
+		} else {
+			agentID = wt.AgentID
+		}
+
+		// Merging is left to a single queue worker (agit queue run or the
+		// scheduler), since verifying every entry ahead can take far longer
+		// than a tool call may
+		entry, err := mergequeue.Enqueue(db, repo, wt, strategy, cleanup, agentID)
+		if err != nil {
+			return nil, err
+		}
+
+		result := queueEntryResult(entry)
+		queued, err := db.ListMergeQueue(repo.ID, false)
+		if err != nil {
+			return nil, err
+		}
+		for i, e := range queued {
+			if e.ID == entry.ID {
+				result["position"] = i + 1
+			}
+		}
+		return jsonResult(result)
+	}
+}
+
+func handleQueueStatus(db *registry.DB) mcpserver.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repoName, _ := request.Params.Arguments["repo"].(string)
+		if repoName == "" {
+			return nil, apperrors.NewUserError("repo parameter is required")
+		}
+		repo, err := db.GetRepo(repoName)
+		if err != nil {
+			return nil, err
+		}
+
+		if entryID, _ := request.Params.Arguments["entry_id"].(string); entryID != "" {
+			entry, err := db.GetMergeQueueEntry(entryID)
+			if err != nil {
+				return nil, err
+			}
+			return jsonResult(queueEntryResult(entry))
+		}
+
+		all, _ := request.Params.Arguments["all"].(bool)
+		entries, err := db.ListMergeQueue(repo.ID, all)
+		if err != nil {
+			return nil, err
+		}
+		items := make([]map[string]any, 0, len(entries))
+		for _, e := range entries {
+			items = append(items, queueEntryResult(e))
+		}
+		return jsonResult(map[string]any{
+			"repo":    repo.Name,
+			"entries": items,
 		})
 	}
 }
@@ -502,8 +944,9 @@ func handleHeartbeat(db *registry.DB) mcpserver.ToolHandlerFunc {
 		}
 
 		return jsonResult(map[string]any{
-			"ok":       true,
-			"agent_id": agentID,
+			"ok":             true,
+			"agent_id":       agentID,
+			"lease_duration": db.LeasePolicy().Duration.String(),
 		})
 	}
 }
@@ -529,17 +972,45 @@ func handleCreateTask(db *registry.DB) mcpserver.ToolHandlerFunc {
 			return nil, err
 		}
 
-		task, err := db.CreateTask(repo.ID, description, priority)
+		dependsOn := stringSliceArg(request.Params.Arguments, "depends_on")
+		for _, depID := range dependsOn {
+			dep, err := db.GetTask(depID)
+			if err != nil {
+				return nil, apperrors.NewUserErrorf("invalid depends_on: %v", err)
+			}
+			if dep.RepoID != repo.ID {
+				return nil, apperrors.NewUserErrorf("invalid depends_on: task %q belongs to a different repository", depID)
+			}
+		}
+
+		scope := stringSliceArg(request.Params.Arguments, "scope")
+		for _, pattern := range scope {
+			if err := registry.ValidatePattern(pattern); err != nil {
+				return nil, apperrors.NewUserErrorf("invalid scope: %v", err)
+			}
+		}
+
+		task, err := db.CreateTaskWithDeps(repo.ID, description, priority, dependsOn, scope...)
 		if err != nil {
 			return nil, fmt.Errorf("could not create task: %w", err)
 		}
 
-		return jsonResult(map[string]any{
+		result := map[string]any{
 			"task_id":     task.ID,
 			"description": task.Description,
 			"priority":    task.Priority,
 			"status":      task.Status,
-		})
+			"depends_on":  dependsOn,
+		}
+		if len(task.Scope) > 0 {
+			overlaps, err := db.ScopeOverlapsFor(task)
+			if err != nil {
+				return nil, err
+			}
+			result["scope"] = task.Scope
+			result["scope_overlaps"] = scopeOverlapsJSON(overlaps)
+		}
+		return jsonResult(result)
 	}
 }
 
@@ -559,9 +1030,19 @@ func handleFailTask(db *registry.DB) mcpserver.ToolHandlerFunc {
 			return nil, err
 		}
 
+		var blocked []string
+		if cascade, _ := request.Params.Arguments["cascade"].(bool); cascade {
+			var err error
+			blocked, err = db.BlockDependents(taskID)
+			if err != nil {
+				return nil, err
+			}
+		}
+
 		return jsonResult(map[string]any{
 			"failed":  true,
 			"task_id": taskID,
+			"blocked": blocked,
 		})
 	}
 }
@@ -647,6 +1128,7 @@ func handleListWorktrees(db *registry.DB) mcpserver.ToolHandlerFunc {
 			ID     string  `json:"id"`
 			Path   string  `json:"path"`
 			Branch string  `json:"branch"`
+			Base   string  `json:"base"`
 			Status string  `json:"status"`
 			Agent  *string `json:"agent,omitempty"`
 			Task   *string `json:"task,omitempty"`
@@ -658,6 +1140,7 @@ func handleListWorktrees(db *registry.DB) mcpserver.ToolHandlerFunc {
 				ID:     wt.ID,
 				Path:   wt.Path,
 				Branch: wt.Branch,
+				Base:   wt.Base(repo.DefaultBranch),
 				Status: wt.Status,
 				Agent:  wt.AgentID,
 				Task:   wt.TaskDescription,
@@ -680,17 +1163,26 @@ func handleGetTask(db *registry.DB) mcpserver.ToolHandlerFunc {
 			return nil, err
 		}
 
+		dependsOn, err := db.GetTaskDependencies(task.ID)
+		if err != nil {
+			return nil, err
+		}
+
 		return jsonResult(map[string]any{
-			"id":           task.ID,
-			"repo_id":      task.RepoID,
-			"description":  task.Description,
-			"priority":     task.Priority,
-			"status":       task.Status,
-			"agent_id":     task.AssignedAgentID,
-			"worktree_id":  task.WorktreeID,
-			"created_at":   task.CreatedAt.Format("2006-01-02T15:04:05Z"),
-			"completed_at": task.CompletedAt,
-			"result":       task.Result,
+			"id":               task.ID,
+			"repo_id":          task.RepoID,
+			"description":      task.Description,
+			"priority":         task.Priority,
+			"status":           task.Status,
+			"agent_id":         task.AssignedAgentID,
+			"worktree_id":      task.WorktreeID,
+			"created_at":       task.CreatedAt.Format("2006-01-02T15:04:05Z"),
+			"completed_at":     task.CompletedAt,
+			"result":           task.Result,
+			"depends_on":       dependsOn,
+			"lease_expires_at": task.LeaseExpiresAt,
+			"attempts":         task.Attempts,
+			"scope":            task.Scope,
 		})
 	}
 }
@@ -735,7 +1227,7 @@ func handleAddRepo(db *registry.DB) mcpserver.ToolHandlerFunc {
 	}
 }
 
-func handleNextTask(db *registry.DB) mcpserver.ToolHandlerFunc {
+func handleNextTask(db *registry.DB, cfg *config.Config) mcpserver.ToolHandlerFunc {
 	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		repoName, _ := request.Params.Arguments["repo"].(string)
 		if repoName == "" {
@@ -751,7 +1243,14 @@ func handleNextTask(db *registry.DB) mcpserver.ToolHandlerFunc {
 			return nil, err
 		}
 
-		task, err := db.NextTask(repo.ID, agentID)
+		runner, err := repoHooks(db, cfg, repo)
+		if err != nil {
+			return nil, err
+		}
+		name := agentName(db, agentID)
+		task, err := db.NextTaskChecked(repo.ID, agentID, func(t *registry.Task) error {
+			return runner.Check(hooks.PreTaskClaim, hooks.TaskEnv(repo, t, agentID, name))
+		})
 		if err != nil {
 			return nil, err
 		}
@@ -765,11 +1264,14 @@ func handleNextTask(db *registry.DB) mcpserver.ToolHandlerFunc {
 
 		return jsonResult(map[string]any{
 			"task": map[string]any{
-				"id":          task.ID,
-				"description": task.Description,
-				"priority":    task.Priority,
-				"status":      task.Status,
-				"agent_id":    task.AssignedAgentID,
+				"id":               task.ID,
+				"description":      task.Description,
+				"priority":         task.Priority,
+				"status":           task.Status,
+				"agent_id":         task.AssignedAgentID,
+				"lease_expires_at": task.LeaseExpiresAt,
+				"attempts":         task.Attempts,
+				"scope":            task.Scope,
 			},
 		})
 	}
@@ -798,3 +1300,282 @@ func handleCleanupWorktrees(db *registry.DB) mcpserver.ToolHandlerFunc {
 		})
 	}
 }
+
+func handleGetEvents(db *registry.DB) mcpserver.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		filter := registry.EventFilter{Limit: 100}
+
+		if repoName, _ := request.Params.Arguments["repo"].(string); repoName != "" {
+			repo, err := db.GetRepo(repoName)
+			if err != nil {
+				return nil, err
+			}
+			filter.RepoID = repo.ID
+		}
+		if afterID, ok := request.Params.Arguments["after_id"].(float64); ok {
+			filter.AfterID = int64(afterID)
+		}
+		if since, _ := request.Params.Arguments["since"].(string); since != "" {
+			t, err := registry.ParseSince(since, time.Now())
+			if err != nil {
+				return nil, apperrors.NewUserError(err.Error())
+			}
+			filter.Since = t
+		}
+		filter.Agent, _ = request.Params.Arguments["agent"].(string)
+		filter.Type, _ = request.Params.Arguments["type"].(string)
+		if limit, ok := request.Params.Arguments["limit"].(float64); ok && limit > 0 {
+			filter.Limit = int(limit)
+		}
+
+		events, err := db.ListEvents(filter)
+		if err != nil {
+			return nil, err
+		}
+
+		repoNames := make(map[string]string)
+		if repos, err := db.ListRepos(); err == nil {
+			for _, r := range repos {
+				repoNames[r.ID] = r.Name
+			}
+		}
+
+		items := make([]map[string]any, 0, len(events))
+		lastID := filter.AfterID
+		for _, e := range events {
+			item := map[string]any{
+				"id":          e.ID,
+				"type":        e.Type,
+				"time":        e.CreatedAt.Format(time.RFC3339),
+				"entity_type": e.EntityType,
+				"entity_id":   e.EntityID,
+			}
+			if e.Actor != nil {
+				item["actor"] = *e.Actor
+			}
+			if e.AgentID != nil {
+				item["agent_id"] = *e.AgentID
+			}
+			if e.RepoID != nil {
+				item["repo"] = repoNames[*e.RepoID]
+			}
+			if len(e.Payload) > 0 {
+				item["payload"] = e.Payload
+			}
+			items = append(items, item)
+			lastID = e.ID
+		}
+
+		return jsonResult(map[string]any{
+			"events":  items,
+			"last_id": lastID,
+		})
+	}
+}
+
+func lockResult(l *registry.Lock) map[string]any {
+	item := map[string]any{
+		"id":         l.ID,
+		"pattern":    l.Pattern,
+		"holder":     l.Holder(),
+		"created_at": l.CreatedAt.Format(time.RFC3339),
+		"expires_at": l.ExpiresAt.Format(time.RFC3339),
+	}
+	if l.AgentID != nil {
+		item["agent_id"] = *l.AgentID
+	}
+	if l.WorktreeID != nil {
+		item["worktree_id"] = *l.WorktreeID
+	}
+	if l.Reason != nil {
+		item["reason"] = *l.Reason
+	}
+	return item
+}
+
+func lockViolationsJSON(violations []registry.LockViolation) []map[string]any {
+	items := make([]map[string]any, 0, len(violations))
+	for _, v := range violations {
+		item := map[string]any{
+			"file":     v.Path,
+			"worktree": v.WorktreeID,
+			"lock":     lockResult(v.Lock),
+		}
+		if v.Uncommitted {
+			item["uncommitted"] = true
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// lockHolderArgs resolves the agent_id and worktree_id arguments of the lock
+// tools. At least one of them is required. An agent authenticated by token
+// may only name a worktree assigned to it.
+func lockHolderArgs(ctx context.Context, db *registry.DB, repo *registry.Repo, args map[string]any) (agentID, worktreeID *string, err error) {
+	if id, _ := args["agent_id"].(string); id != "" {
+		agent, err := db.GetAgent(id)
+		if err != nil {
+			return nil, nil, err
+		}
+		agentID = &agent.ID
+	}
+	if id, _ := args["worktree_id"].(string); id != "" {
+		wt, err := db.ResolveWorktree(repo.ID, id)
+		if err != nil {
+			return nil, nil, err
+		}
+		if caller := callerFromContext(ctx); caller != nil && (wt.AgentID == nil || *wt.AgentID != caller.ID) {
+			return nil, nil, apperrors.NewUserErrorf("authenticated as agent %s; worktree %s is not assigned to it", caller.Name, wt.ID)
+		}
+		worktreeID = &wt.ID
+	}
+	if agentID == nil && worktreeID == nil {
+		return nil, nil, apperrors.NewUserError("agent_id or worktree_id parameter is required")
+	}
+	return agentID, worktreeID, nil
+}
+
+func handleAcquireLock(db *registry.DB) mcpserver.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repoName, _ := request.Params.Arguments["repo"].(string)
+		if repoName == "" {
+			return nil, apperrors.NewUserError("repo parameter is required")
+		}
+		pattern, _ := request.Params.Arguments["path"].(string)
+		if pattern == "" {
+			return nil, apperrors.NewUserError("path parameter is required")
+		}
+
+		repo, err := db.GetRepo(repoName)
+		if err != nil {
+			return nil, err
+		}
+		agentID, worktreeID, err := lockHolderArgs(ctx, db, repo, request.Params.Arguments)
+		if err != nil {
+			return nil, err
+		}
+
+		ttl := registry.DefaultLockTTL
+		if s, _ := request.Params.Arguments["ttl"].(string); s != "" {
+			if ttl, err = time.ParseDuration(s); err != nil || ttl <= 0 {
+				return nil, apperrors.NewUserErrorf("invalid ttl %q: expected a positive duration such as 30m", s)
+			}
+		}
+		var reason *string
+		if s, _ := request.Params.Arguments["reason"].(string); s != "" {
+			reason = &s
+		}
+
+		lock, err := db.AcquireLock(repo.ID, pattern, agentID, worktreeID, reason, ttl)
+		var held *registry.LockHeldError
+		if errors.As(err, &held) {
+			return jsonResult(map[string]any{
+				"acquired": false,
+				"error":    held.Error(),
+				"held_by":  lockResult(held.Lock),
+			})
+		}
+		if errors.Is(err, registry.ErrInvalidPattern) {
+			return nil, apperrors.NewUserError(err.Error())
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		result := lockResult(lock)
+		result["acquired"] = true
+		return jsonResult(result)
+	}
+}
+
+func handleReleaseLock(db *registry.DB) mcpserver.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		lockID, _ := request.Params.Arguments["lock_id"].(string)
+		if lockID == "" {
+			return nil, apperrors.NewUserError("lock_id parameter is required")
+		}
+
+		lock, err := db.GetLock(lockID)
+		if err != nil {
+			return nil, err
+		}
+		repo, err := db.GetRepoByID(lock.RepoID)
+		if err != nil {
+			return nil, err
+		}
+		agentID, worktreeID, err := lockHolderArgs(ctx, db, repo, request.Params.Arguments)
+		if err != nil {
+			return nil, err
+		}
+		if !lock.HeldBy(agentID, worktreeID) {
+			return nil, apperrors.NewUserErrorf("lock %s is held by %s", lock.ID, lock.Holder())
+		}
+
+		if err := db.ReleaseLock(lock.ID); err != nil {
+			return nil, err
+		}
+		return jsonResult(map[string]any{
+			"released": true,
+			"lock_id":  lock.ID,
+			"pattern":  lock.Pattern,
+		})
+	}
+}
+
+func handleListLocks(db *registry.DB) mcpserver.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repoID := ""
+		if repoName, _ := request.Params.Arguments["repo"].(string); repoName != "" {
+			repo, err := db.GetRepo(repoName)
+			if err != nil {
+				return nil, err
+			}
+			repoID = repo.ID
+		}
+
+		locks, err := db.ListLocks(repoID)
+		if err != nil {
+			return nil, err
+		}
+
+		repoNames := make(map[string]string)
+		if repos, err := db.ListRepos(); err == nil {
+			for _, r := range repos {
+				repoNames[r.ID] = r.Name
+			}
+		}
+
+		items := make([]map[string]any, 0, len(locks))
+		for _, l := range locks {
+			item := lockResult(l)
+			item["repo"] = repoNames[l.RepoID]
+			items = append(items, item)
+		}
+		return jsonResult(map[string]any{"locks": items})
+	}
+}
+
+func scopeOverlapsJSON(overlaps []registry.ScopeOverlap) []map[string]any {
+	items := make([]map[string]any, 0, len(overlaps))
+	for _, o := range overlaps {
+		item := map[string]any{
+			"task_id":   o.TaskID,
+			"pattern":   o.Pattern,
+			"path":      o.Path,
+			"in_flight": o.InFlight(),
+		}
+		if o.Changed {
+			item["changed"] = true
+		}
+		if o.OtherTaskID != "" {
+			item["other_task_id"] = o.OtherTaskID
+			item["other_status"] = o.OtherStatus
+		}
+		if o.WorktreeID != "" {
+			item["worktree_id"] = o.WorktreeID
+		}
+		items = append(items, item)
+	}
+	return items
+}
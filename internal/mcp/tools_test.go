@@ -10,6 +10,8 @@ import (
 	"github.com/mark3labs/mcp-go/mcp"
 
 	"github.com/fathindos/agit/internal/config"
+	apperrors "github.com/fathindos/agit/internal/errors"
+	"github.com/fathindos/agit/internal/mergequeue"
 	"github.com/fathindos/agit/internal/registry"
 )
 
@@ -105,7 +107,7 @@ func TestHandleClaimTask(t *testing.T) {
 	task, _ := db.CreateTask(repo.ID, "test task", 0)
 	agent, _ := db.RegisterAgent("claimer", "custom")
 
-	handler := handleClaimTask(db)
+	handler := handleClaimTask(db, config.DefaultConfig())
 	result := callTool(t, handler, map[string]any{
 		"task_id":  task.ID,
 		"agent_id": agent.ID,
@@ -115,6 +117,35 @@ func TestHandleClaimTask(t *testing.T) {
 	}
 }
 
+func TestHandleClaimTaskPreHookVeto(t *testing.T) {
+	db := mustDB(t)
+	repo, _ := db.AddRepo("veto-repo", "/tmp/veto", "", "main")
+	task, _ := db.CreateTask(repo.ID, "guarded task", 0)
+	agent, _ := db.RegisterAgent("vetoed", "custom")
+
+	cfg := config.DefaultConfig()
+	cfg.Hooks = map[string][]string{
+		"pre.task.claim": {`echo "$AGIT_AGENT may not take $AGIT_TASK" >&2; exit 1`},
+	}
+	args := map[string]any{"task_id": task.ID, "agent_id": agent.ID}
+
+	err := callToolExpectError(t, handleClaimTask(db, cfg), args)
+	if err == nil || err.Error() != "pre.task.claim hook refused: vetoed may not take guarded task" {
+		t.Fatalf("expected claim to be refused with the hook's stderr, got %v", err)
+	}
+	if !apperrors.IsUserError(err) {
+		t.Error("expected a veto to be reported as a user error")
+	}
+
+	err = callToolExpectError(t, handleNextTask(db, cfg), map[string]any{"repo": "veto-repo", "agent_id": agent.ID})
+	if err == nil {
+		t.Fatal("expected next task to be refused")
+	}
+	if got, _ := db.GetTask(task.ID); got.Status != "pending" {
+		t.Errorf("expected refused task to stay pending, got %s", got.Status)
+	}
+}
+
 func TestHandleCompleteTask(t *testing.T) {
 	db := mustDB(t)
 	repo, _ := db.AddRepo("comp-repo", "/tmp/comp", "", "main")
@@ -316,6 +347,128 @@ func TestHandleCleanupWorktrees(t *testing.T) {
 	}
 }
 
+func TestHandleQueueStatus(t *testing.T) {
+	db := mustDB(t)
+	repo, _ := db.AddRepo("q-repo", "/tmp/q", "", "main")
+	wt, _ := db.CreateWorktree(repo.ID, "/tmp/q1", "b1", nil, nil)
+	entry, _ := db.EnqueueMerge(repo.ID, wt.ID, "squash", true, nil)
+
+	result := callTool(t, handleQueueStatus(db), map[string]any{"repo": "q-repo"})
+	entries, ok := result["entries"].([]any)
+	if !ok || len(entries) != 1 {
+		t.Fatalf("expected 1 entry, got %v", result["entries"])
+	}
+	first := entries[0].(map[string]any)
+	if first["id"] != entry.ID || first["status"] != "queued" || first["strategy"] != "squash" {
+		t.Errorf("unexpected entry: %v", first)
+	}
+
+	single := callTool(t, handleQueueStatus(db), map[string]any{"repo": "q-repo", "entry_id": entry.ID})
+	if single["worktree_id"] != wt.ID {
+		t.Errorf("expected worktree %s, got %v", wt.ID, single["worktree_id"])
+	}
+}
+
+func TestHandleEnqueueMergeReturnsQueuedEntry(t *testing.T) {
+	db := mustDB(t)
+	repo, _ := db.AddRepo("eq-repo", "/tmp/nonexistent-eq-repo", "", "main")
+	wt, _ := db.CreateWorktree(repo.ID, "/tmp/nonexistent-eq-wt", "b1", nil, nil)
+
+	// Enqueuing doesn't merge; a queue worker does that later
+	result := callTool(t, handleEnqueueMerge(db), map[string]any{"repo": "eq-repo", "worktree_id": wt.ID})
+	if result["status"] != "queued" || result["position"] != float64(1) {
+		t.Errorf("expected a queued entry at position 1, got %v", result)
+	}
+
+	// A git failure while draining lands on the entry
+	if _, err := mergequeue.Drain(db, repo); err != nil {
+		t.Fatalf("Drain: %v", err)
+	}
+	single := callTool(t, handleQueueStatus(db), map[string]any{"repo": "eq-repo", "entry_id": result["id"]})
+	if single["status"] != "failed" || single["result"] == nil {
+		t.Errorf("expected a failed entry with its reason, got %v", single)
+	}
+
+	err := callToolExpectError(t, handleEnqueueMerge(db), map[string]any{"repo": "eq-repo"})
+	if err == nil {
+		t.Error("expected error without worktree_id")
+	}
+}
+
+func TestHandleSyncWorktreeErrors(t *testing.T) {
+	db := mustDB(t)
+	repo, _ := db.AddRepo("sync-repo", "/tmp/nonexistent-sync-repo", "", "main")
+	wt, _ := db.CreateWorktree(repo.ID, "/tmp/nonexistent-sync-wt", "b1", nil, nil)
+
+	handler := handleSyncWorktree(db)
+	if err := callToolExpectError(t, handler, map[string]any{"repo": "sync-repo"}); err == nil {
+		t.Error("expected error without worktree_id")
+	}
+	if err := callToolExpectError(t, handler, map[string]any{"repo": "sync-repo", "worktree_id": wt.ID, "mode": "cherry-pick"}); err == nil {
+		t.Error("expected error for unknown mode")
+	}
+	if err := callToolExpectError(t, handler, map[string]any{"repo": "sync-repo", "worktree_id": wt.ID, "abort": true}); err == nil {
+		t.Error("expected error aborting in a missing worktree")
+	}
+}
+
+func TestHandleSetupWorktree(t *testing.T) {
+	db := mustDB(t)
+	repoPath, wtPath := t.TempDir(), t.TempDir()
+	repo, _ := db.AddRepo("setup-repo", repoPath, "", "main")
+	wt, _ := db.CreateWorktree(repo.ID, wtPath, "b1", nil, nil)
+
+	handler := handleSetupWorktree(db)
+	if err := callToolExpectError(t, handler, map[string]any{"repo": "setup-repo", "worktree_id": wt.ID}); err == nil {
+		t.Error("expected error for a repo without a setup recipe")
+	}
+
+	recipe := filepath.Join(repoPath, ".agit.toml")
+	if err := os.WriteFile(recipe, []byte("[setup]\ncommands = [\"exit 2\"]\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	result := callTool(t, handler, map[string]any{"repo": "setup-repo", "worktree_id": wt.ID})
+	if result["status"] != "setup_failed" || result["setup"] != "failed" || result["setup_error"] == nil {
+		t.Fatalf("expected a failed setup, got %v", result)
+	}
+
+	if err := os.WriteFile(recipe, []byte("[setup]\ncommands = [\"true\"]\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	result = callTool(t, handler, map[string]any{"repo": "setup-repo", "worktree_id": wt.ID})
+	if result["status"] != "active" || result["setup"] != "passed" {
+		t.Errorf("expected a passing setup to reactivate the worktree, got %v", result)
+	}
+}
+
+func TestHandleGetEvents(t *testing.T) {
+	db := mustDB(t)
+	repo, _ := db.AddRepo("ev-repo", "/tmp/ev", "", "main")
+	agent, _ := db.RegisterAgent("ev-agent", "claude")
+	task, _ := db.CreateTask(repo.ID, "catch up", 0)
+
+	handler := handleGetEvents(db)
+	first := callTool(t, handler, map[string]any{"repo": "ev-repo"})
+	events, _ := first["events"].([]any)
+	if len(events) != 2 {
+		t.Fatalf("expected repo.added and task.created in repo, got %v", first["events"])
+	}
+	lastID := first["last_id"].(float64)
+
+	// Another agent acts while we are away
+	db.ClaimTask(task.ID, agent.ID)
+
+	next := callTool(t, handler, map[string]any{"repo": "ev-repo", "after_id": lastID})
+	events, _ = next["events"].([]any)
+	if len(events) != 1 {
+		t.Fatalf("expected only the new event, got %v", next["events"])
+	}
+	e := events[0].(map[string]any)
+	if e["type"] != "task.claimed" || e["actor"] != "ev-agent" || e["repo"] != "ev-repo" {
+		t.Errorf("unexpected event: %v", e)
+	}
+}
+
 func TestWithIssueLink(t *testing.T) {
 	// Verify wrapper doesn't interfere with normal operation
 	db := mustDB(t)
@@ -343,7 +496,7 @@ func TestHandleNextTask(t *testing.T) {
 	db.CreateTask(repo.ID, "high prio", 10)
 	db.CreateTask(repo.ID, "med prio", 5)
 
-	handler := handleNextTask(db)
+	handler := handleNextTask(db, config.DefaultConfig())
 	result := callTool(t, handler, map[string]any{
 		"repo":     "nt-repo",
 		"agent_id": agent.ID,
@@ -363,7 +516,7 @@ func TestHandleNextTaskNoPending(t *testing.T) {
 	db.AddRepo("nt2-repo", "/tmp/nt2", "", "main")
 	agent, _ := db.RegisterAgent("nt2-agent", "custom")
 
-	handler := handleNextTask(db)
+	handler := handleNextTask(db, config.DefaultConfig())
 	result := callTool(t, handler, map[string]any{
 		"repo":     "nt2-repo",
 		"agent_id": agent.ID,
@@ -380,7 +533,7 @@ func TestHandleNextTaskNoPending(t *testing.T) {
 func TestHandleNextTaskMissingParams(t *testing.T) {
 	db := mustDB(t)
 
-	handler := handleNextTask(db)
+	handler := handleNextTask(db, config.DefaultConfig())
 
 	if err := callToolExpectError(t, handler, map[string]any{}); err == nil {
 		t.Fatal("expected error for missing repo")
@@ -390,6 +543,130 @@ func TestHandleNextTaskMissingParams(t *testing.T) {
 	}
 }
 
+func TestHandleCreateTaskDependsOn(t *testing.T) {
+	db := mustDB(t)
+	repo, _ := db.AddRepo("dep-repo", "/tmp/dep", "", "main")
+	base, _ := db.CreateTask(repo.ID, "base", 0)
+	agent, _ := db.RegisterAgent("dep-agent", "custom")
+
+	result := callTool(t, handleCreateTask(db), map[string]any{
+		"repo":        "dep-repo",
+		"description": "dependent",
+		"priority":    float64(5),
+		"depends_on":  []any{base.ID},
+	})
+	depID, _ := result["task_id"].(string)
+
+	deps, _ := db.GetTaskDependencies(depID)
+	if len(deps) != 1 || deps[0] != base.ID {
+		t.Fatalf("expected dependency on %s, got %v", base.ID, deps)
+	}
+
+	// agit_next_task must hand out the base first
+	next := callTool(t, handleNextTask(db, config.DefaultConfig()), map[string]any{"repo": "dep-repo", "agent_id": agent.ID})
+	task, _ := next["task"].(map[string]any)
+	if task["id"] != base.ID {
+		t.Errorf("expected base task, got %v", task["id"])
+	}
+
+	// Failing with cascade blocks the dependent
+	failed := callTool(t, handleFailTask(db), map[string]any{"task_id": base.ID, "cascade": true})
+	blocked, _ := failed["blocked"].([]any)
+	if len(blocked) != 1 || blocked[0] != depID {
+		t.Errorf("expected %s blocked, got %v", depID, failed["blocked"])
+	}
+
+	if err := callToolExpectError(t, handleCreateTask(db), map[string]any{
+		"repo":        "dep-repo",
+		"description": "bad",
+		"depends_on":  []any{"t-missing0"},
+	}); err == nil {
+		t.Error("expected error for unknown dependency")
+	}
+}
+
+func TestHandleCreateTaskScope(t *testing.T) {
+	db := mustDB(t)
+	repo, _ := db.AddRepo("scope-repo", "/tmp/scope-repo", "", "main")
+	agent, _ := db.RegisterAgent("scope-agent", "custom")
+
+	running, _ := db.CreateTask(repo.ID, "running", 0, "api")
+	db.ClaimTask(running.ID, agent.ID)
+
+	result := callTool(t, handleCreateTask(db), map[string]any{
+		"repo":        "scope-repo",
+		"description": "fix handler",
+		"scope":       []any{"api/handler.go"},
+	})
+	if scope, _ := result["scope"].([]any); len(scope) != 1 || scope[0] != "api/handler.go" {
+		t.Errorf("expected scope in result, got %v", result["scope"])
+	}
+	overlaps, _ := result["scope_overlaps"].([]any)
+	if len(overlaps) != 1 {
+		t.Fatalf("expected 1 overlap, got %v", result["scope_overlaps"])
+	}
+	if o, _ := overlaps[0].(map[string]any); o["other_task_id"] != running.ID || o["in_flight"] != true {
+		t.Errorf("unexpected overlap %v", o)
+	}
+
+	if err := callToolExpectError(t, handleCreateTask(db), map[string]any{
+		"repo":        "scope-repo",
+		"description": "bad",
+		"scope":       []any{"a/[b"},
+	}); err == nil {
+		t.Error("expected error for malformed scope")
+	}
+}
+
+func TestHandleLockTools(t *testing.T) {
+	db := mustDB(t)
+	db.AddRepo("lock-repo", "/tmp/lock-repo", "", "main")
+	alice, _ := db.RegisterAgent("alice", "custom")
+	bob, _ := db.RegisterAgent("bob", "custom")
+
+	acquired := callTool(t, handleAcquireLock(db), map[string]any{
+		"repo": "lock-repo", "path": "internal/registry/**", "agent_id": alice.ID, "ttl": "10m", "reason": "schema",
+	})
+	if acquired["acquired"] != true {
+		t.Fatalf("expected lock to be acquired, got %v", acquired)
+	}
+	lockID, _ := acquired["id"].(string)
+
+	clash := callTool(t, handleAcquireLock(db), map[string]any{
+		"repo": "lock-repo", "path": "internal/registry/db.go", "agent_id": bob.ID,
+	})
+	if clash["acquired"] != false {
+		t.Fatalf("expected clash, got %v", clash)
+	}
+	heldBy, _ := clash["held_by"].(map[string]any)
+	if heldBy["id"] != lockID {
+		t.Errorf("expected held_by %s, got %v", lockID, heldBy["id"])
+	}
+
+	listed := callTool(t, handleListLocks(db), map[string]any{"repo": "lock-repo"})
+	if locks, _ := listed["locks"].([]any); len(locks) != 1 {
+		t.Fatalf("expected 1 lock, got %v", listed["locks"])
+	}
+
+	// Only the holder may release
+	if err := callToolExpectError(t, handleReleaseLock(db), map[string]any{"lock_id": lockID, "agent_id": bob.ID}); err == nil {
+		t.Error("expected error releasing someone else's lock")
+	}
+	released := callTool(t, handleReleaseLock(db), map[string]any{"lock_id": lockID, "agent_id": alice.ID})
+	if released["released"] != true {
+		t.Errorf("expected lock released, got %v", released)
+	}
+
+	if err := callToolExpectError(t, handleAcquireLock(db), map[string]any{"repo": "lock-repo", "path": "x.go"}); err == nil {
+		t.Error("expected error without agent_id or worktree_id")
+	}
+	if err := callToolExpectError(t, handleAcquireLock(db), map[string]any{
+		"repo": "lock-repo", "path": "x.go", "agent_id": alice.ID, "ttl": "soon",
+	}); err == nil {
+		t.Error("expected error for invalid ttl")
+	}
+}
+
 // Verify NewServer creates server with all tools
 func TestNewServer(t *testing.T) {
 	db := mustDB(t)
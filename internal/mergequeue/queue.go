@@ -0,0 +1,341 @@
+// Package mergequeue serializes merges of agent worktrees. Entries are stored
+// in the registry and merged one at a time per repo, each re-validated
+// against the default branch tip left by the previous merge and gated on the
+// repo's pre.merge hook and verify commands.
+package mergequeue
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fathindos/agit/internal/config"
+	"github.com/fathindos/agit/internal/conflicts"
+	apperrors "github.com/fathindos/agit/internal/errors"
+	gitops "github.com/fathindos/agit/internal/git"
+	"github.com/fathindos/agit/internal/hooks"
+	"github.com/fathindos/agit/internal/registry"
+	"github.com/fathindos/agit/internal/verify"
+)
+
+// MergeOptions resolves the git merge options for a worktree. An empty
+// strategy falls back to the repo's merge_strategy setting, then to merge.
+// Squash merges get a commit message built from the worktree's task.
+func MergeOptions(db *registry.DB, repo *registry.Repo, wt *registry.Worktree, strategyName string) (gitops.MergeOptions, error) {
+	if strategyName == "" {
+		strategyName = repo.Setting("merge_strategy")
+	}
+	strategy, err := gitops.ParseMergeStrategy(strategyName)
+	if err != nil {
+		return gitops.MergeOptions{}, apperrors.NewUserError(err.Error())
+	}
+
+	into, err := MergeTarget(db, repo, wt)
+	if err != nil {
+		return gitops.MergeOptions{}, err
+	}
+	opts := gitops.MergeOptions{
+		Strategy:     strategy,
+		BaseBranch:   into,
+		WorktreePath: wt.Path,
+	}
+	if strategy == gitops.StrategySquash {
+		desc, taskID := "", ""
+		if wt.TaskDescription != nil {
+			desc = *wt.TaskDescription
+		}
+		if task, err := db.FindTaskForWorktree(wt.ID); err == nil && task != nil {
+			desc, taskID = task.Description, task.ID
+		}
+		opts.Message = gitops.SquashMessage(wt.Branch, desc, taskID)
+	}
+	return opts, nil
+}
+
+// MergeTarget returns the branch a worktree merges into: its base when that
+// is a local branch, otherwise the repo's default branch. A worktree stacked
+// on the branch of another worktree that is still in progress is refused, so
+// a merge never moves a branch another agent is working on; it can merge once
+// that worktree has been merged.
+func MergeTarget(db *registry.DB, repo *registry.Repo, wt *registry.Worktree) (string, error) {
+	into := mergeInto(repo, wt)
+	if into == repo.DefaultBranch {
+		return into, nil
+	}
+	others, err := db.ListWorktrees(repo.ID, nil)
+	if err != nil {
+		return "", err
+	}
+	for _, other := range others {
+		if other.ID != wt.ID && other.Branch == into && other.Status != "completed" {
+			return "", apperrors.NewUserErrorf("worktree %s is stacked on %s, which worktree %s is still working on; merge that worktree first",
+				wt.ID[:12], into, other.ID[:12])
+		}
+	}
+	return into, nil
+}
+
+// mergeInto is MergeTarget without the check for stacked worktrees
+func mergeInto(repo *registry.Repo, wt *registry.Worktree) string {
+	if base := wt.Base(repo.DefaultBranch); gitops.LocalBranchExists(repo.Path, base) {
+		return base
+	}
+	return repo.DefaultBranch
+}
+
+// CheckMerge runs the repo's pre.merge hook for a worktree, returning a
+// *hooks.VetoError if the hook refuses the merge. The hook sees the strategy
+// in AGIT_STRATEGY.
+func CheckMerge(runner *hooks.Runner, repo *registry.Repo, wt *registry.Worktree, strategy gitops.MergeStrategy) error {
+	env := hooks.WorktreeEnv(repo, wt)
+	env["AGIT_STRATEGY"] = string(strategy)
+	return runner.Check(hooks.PreMerge, env)
+}
+
+// RecordMerged logs a worktree.merged event. The actor is the agent that
+// requested the merge, falling back to the worktree's own agent.
+func RecordMerged(db *registry.DB, repo *registry.Repo, wt *registry.Worktree, strategy gitops.MergeStrategy, tip string, agentID *string) error {
+	if agentID == nil {
+		agentID = wt.AgentID
+	}
+	repoID := repo.ID
+	return db.RecordEvent(&registry.Event{
+		Type:       registry.EventWorktreeMerged,
+		AgentID:    agentID,
+		RepoID:     &repoID,
+		EntityType: "worktree",
+		EntityID:   wt.ID,
+		Payload: map[string]any{
+			"branch":   wt.Branch,
+			"into":     mergeInto(repo, wt),
+			"strategy": string(strategy),
+			"commit":   tip,
+		},
+	})
+}
+
+// RecordMergeFailed logs a merge.failed event for a merge that was refused
+// or did not complete, with the reason. The actor is chosen as for
+// RecordMerged.
+func RecordMergeFailed(db *registry.DB, repo *registry.Repo, wt *registry.Worktree, strategy gitops.MergeStrategy, reason string, agentID *string) error {
+	if agentID == nil {
+		agentID = wt.AgentID
+	}
+	repoID := repo.ID
+	return db.RecordEvent(&registry.Event{
+		Type:       registry.EventMergeFailed,
+		AgentID:    agentID,
+		RepoID:     &repoID,
+		EntityType: "worktree",
+		EntityID:   wt.ID,
+		Payload: map[string]any{
+			"branch":   wt.Branch,
+			"into":     mergeInto(repo, wt),
+			"strategy": string(strategy),
+			"reason":   reason,
+		},
+	})
+}
+
+// Enqueue adds a worktree to its repo's merge queue. Worktrees previously
+// bounced with a conflict are reactivated so they can be retried.
+func Enqueue(db *registry.DB, repo *registry.Repo, wt *registry.Worktree, strategy string, cleanup bool, agentID *string) (*registry.MergeQueueEntry, error) {
+	if wt.Status != "active" && wt.Status != "conflict" {
+		return nil, apperrors.NewUserErrorf("worktree %s is %s and cannot be merged", wt.ID[:12], wt.Status)
+	}
+
+	if strategy != "" {
+		if _, err := gitops.ParseMergeStrategy(strategy); err != nil {
+			return nil, apperrors.NewUserError(err.Error())
+		}
+	}
+
+	entry, err := db.EnqueueMerge(repo.ID, wt.ID, strategy, cleanup, agentID)
+	if errors.Is(err, registry.ErrAlreadyQueued) {
+		return nil, apperrors.NewUserError(err.Error())
+	}
+	return entry, err
+}
+
+// Slot is a repo's merge slot, held by a merge that runs outside the queue
+type Slot struct {
+	db           *registry.DB
+	entry        *registry.MergeQueueEntry
+	stopRenewing func()
+}
+
+// ClaimSlot takes a repo's merge slot for a direct merge of wt, such as
+// agit merge, so that it cannot interleave with the queue worker or another
+// direct merge: the checks each merge runs still hold when it lands. Call
+// Release with the outcome once the merge is done. If the process dies
+// first, the entry is requeued after registry.MergeLockTimeout and the
+// queue finishes the merge.
+func ClaimSlot(db *registry.DB, repo *registry.Repo, wt *registry.Worktree, strategy string, agentID *string) (*Slot, error) {
+	entry, err := db.ClaimMergeSlot(repo.ID, wt.ID, strategy, agentID)
+	switch {
+	case errors.Is(err, registry.ErrMergeInProgress):
+		return nil, apperrors.NewUserErrorf("another merge into %s is in progress; try again once it finishes, or queue this one with agit queue add", repo.Name)
+	case errors.Is(err, registry.ErrAlreadyQueued):
+		return nil, apperrors.NewUserErrorf("%v; let the queue merge it, or cancel the entry first", err)
+	case err != nil:
+		return nil, err
+	}
+	return &Slot{db: db, entry: entry, stopRenewing: renewWhileMerging(db, entry.ID)}, nil
+}
+
+// Release records the outcome of a direct merge, merged or failed, on its
+// queue entry and frees the repo's merge slot
+func (s *Slot) Release(status, result string) error {
+	s.stopRenewing()
+	return s.db.FinishMerge(s.entry.ID, status, &result)
+}
+
+// ProcessNext claims the next queued entry for a repo and merges it. It
+// returns nil when the queue is empty or another worker is merging. Merge
+// problems are recorded on the entry, and as a merge.failed event, rather
+// than returned as errors: entries that no longer merge cleanly are marked
+// conflict and their worktree is bounced back with the conflict status.
+func ProcessNext(db *registry.DB, repo *registry.Repo) (*registry.MergeQueueEntry, error) {
+	entry, err := db.ClaimNextMerge(repo.ID)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+
+	stopRenewing := renewWhileMerging(db, entry.ID)
+	status, result := process(db, repo, entry)
+	stopRenewing()
+	if err := db.FinishMerge(entry.ID, status, &result); err != nil {
+		return nil, err
+	}
+	if status != "merged" {
+		if wt, err := db.GetWorktree(entry.WorktreeID); err == nil {
+			RecordMergeFailed(db, repo, wt, gitops.MergeStrategy(entry.Strategy), result, entry.AgentID)
+		}
+	}
+	return db.GetMergeQueueEntry(entry.ID)
+}
+
+// renewInterval is how often a merging entry's lock is renewed
+var renewInterval = registry.MergeLockTimeout / 10
+
+// renewWhileMerging keeps renewing a merging entry's lock, so that other
+// workers don't take it for abandoned while verify commands run, until the
+// returned function is called
+func renewWhileMerging(db *registry.DB, entryID string) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(renewInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				db.RenewMerge(entryID)
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// Drain processes queued entries in order until the queue is empty or held
+// by another worker, returning the entries it processed
+func Drain(db *registry.DB, repo *registry.Repo) ([]*registry.MergeQueueEntry, error) {
+	var processed []*registry.MergeQueueEntry
+	for {
+		entry, err := ProcessNext(db, repo)
+		if err != nil {
+			return processed, err
+		}
+		if entry == nil {
+			return processed, nil
+		}
+		processed = append(processed, entry)
+	}
+}
+
+func process(db *registry.DB, repo *registry.Repo, entry *registry.MergeQueueEntry) (status, result string) {
+	wt, err := db.GetWorktree(entry.WorktreeID)
+	if err != nil {
+		return "failed", err.Error()
+	}
+	if wt.Status != "active" {
+		return "failed", fmt.Sprintf("worktree is %s", wt.Status)
+	}
+
+	opts, err := MergeOptions(db, repo, wt, entry.Strategy)
+	if err != nil {
+		return "failed", err.Error()
+	}
+
+	// Re-check against the current tip, which earlier entries may have moved
+	sim, err := gitops.SimulateMerge(repo.Path, opts.BaseBranch, wt.Branch)
+	if err != nil {
+		return "failed", err.Error()
+	}
+	if !sim.Clean {
+		db.UpdateWorktreeStatus(wt.ID, "conflict")
+		repoID := repo.ID
+		db.RecordEvent(&registry.Event{
+			Type:       registry.EventConflictDetected,
+			AgentID:    wt.AgentID,
+			RepoID:     &repoID,
+			EntityType: "worktree",
+			EntityID:   wt.ID,
+			Payload:    map[string]any{"paths": sim.ConflictedPaths, "source": "merge_queue"},
+		})
+		return "conflict", "conflicts in " + strings.Join(sim.ConflictedPaths, ", ")
+	}
+
+	violations, err := conflicts.MergeLockViolations(db, repo, wt)
+	if err != nil {
+		return "failed", err.Error()
+	}
+	if len(violations) > 0 {
+		return "failed", "changes locked files: " + conflicts.FormatLockViolations(violations)
+	}
+
+	cfg, err := config.LoadForRepo(repo.Path, repo.ConfigOverrides())
+	if err != nil {
+		return "failed", fmt.Sprintf("could not load config: %v", err)
+	}
+	runner := hooks.NewRunner(cfg, db)
+	if err := CheckMerge(runner, repo, wt, opts.Strategy); err != nil {
+		return "failed", err.Error()
+	}
+
+	run, err := verify.Worktree(db, repo, wt)
+	if err != nil {
+		return "failed", err.Error()
+	}
+	if step := verify.FailedStep(run); step != nil {
+		return "failed", "verification failed: " + verify.Describe(step)
+	}
+
+	tip, err := gitops.Merge(repo.Path, wt.Branch, opts)
+	if err != nil {
+		return "failed", err.Error()
+	}
+
+	result = fmt.Sprintf("merged into %s at %s (%s)", opts.BaseBranch, gitops.ShortSHA(tip), opts.Strategy)
+	if entry.Cleanup {
+		if err := runner.Check(hooks.PreWorktreeRemove, hooks.WorktreeEnv(repo, wt)); err != nil {
+			result += "; worktree kept: " + err.Error()
+		} else {
+			gitops.RemoveWorktree(repo.Path, wt.Path)
+			if wt.OwnsBranch() {
+				gitops.DeleteBranch(repo.Path, wt.Branch)
+			}
+		}
+	}
+	db.UpdateWorktreeStatus(wt.ID, "completed")
+	RecordMerged(db, repo, wt, opts.Strategy, tip, entry.AgentID)
+
+	return "merged", result
+}
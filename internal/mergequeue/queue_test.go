@@ -0,0 +1,239 @@
+package mergequeue
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	apperrors "github.com/fathindos/agit/internal/errors"
+	"github.com/fathindos/agit/internal/registry"
+)
+
+func git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// setupRepo creates a repo with one commit on main and registers it
+func setupRepo(t *testing.T) (*registry.DB, *registry.Repo) {
+	t.Helper()
+	t.Setenv("AGIT_HOME", t.TempDir())
+	repoPath := filepath.Join(t.TempDir(), "repo")
+	os.MkdirAll(repoPath, 0755)
+	git(t, repoPath, "init", "-b", "main")
+	git(t, repoPath, "config", "user.email", "test@example.com")
+	git(t, repoPath, "config", "user.name", "Test")
+	os.WriteFile(filepath.Join(repoPath, "README.md"), []byte("# Repo\n"), 0644)
+	git(t, repoPath, "add", ".")
+	git(t, repoPath, "commit", "-m", "initial")
+
+	db, err := registry.OpenMemory()
+	if err != nil {
+		t.Fatalf("OpenMemory: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	repo, err := db.AddRepo("queue-repo", repoPath, "", "main")
+	if err != nil {
+		t.Fatalf("AddRepo: %v", err)
+	}
+	return db, repo
+}
+
+// addWorktree creates a worktree branched from base with one commit that
+// writes content to file
+func addWorktree(t *testing.T, db *registry.DB, repo *registry.Repo, name, base, file, content string) *registry.Worktree {
+	t.Helper()
+	wtPath := filepath.Join(t.TempDir(), name)
+	branch := "agit/" + name
+	git(t, repo.Path, "worktree", "add", "-b", branch, wtPath, base)
+	os.WriteFile(filepath.Join(wtPath, file), []byte(content), 0644)
+	git(t, wtPath, "add", file)
+	git(t, wtPath, "commit", "-m", name)
+
+	opts := registry.WorktreeOptions{}
+	if base != repo.DefaultBranch {
+		opts.BaseRef = &base
+	}
+	wt, err := db.CreateWorktreeWithOptions(repo.ID, wtPath, branch, opts)
+	if err != nil {
+		t.Fatalf("CreateWorktree: %v", err)
+	}
+	return wt
+}
+
+func enqueue(t *testing.T, db *registry.DB, repo *registry.Repo, wt *registry.Worktree) *registry.MergeQueueEntry {
+	t.Helper()
+	entry, err := Enqueue(db, repo, wt, "", false, nil)
+	if err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	return entry
+}
+
+func TestDrainMergesInOrder(t *testing.T) {
+	db, repo := setupRepo(t)
+	first := addWorktree(t, db, repo, "first", "main", "first.txt", "first\n")
+	second := addWorktree(t, db, repo, "second", "main", "second.txt", "second\n")
+	enqueue(t, db, repo, first)
+	enqueue(t, db, repo, second)
+
+	processed, err := Drain(db, repo)
+	if err != nil {
+		t.Fatalf("Drain: %v", err)
+	}
+	if len(processed) != 2 || processed[0].WorktreeID != first.ID || processed[1].WorktreeID != second.ID {
+		t.Fatalf("expected both entries processed in order, got %+v", processed)
+	}
+	for _, e := range processed {
+		if e.Status != "merged" {
+			t.Errorf("expected entry %s merged, got %s (%v)", e.ID, e.Status, *e.Result)
+		}
+	}
+	if subjects := git(t, repo.Path, "log", "--format=%s", "--first-parent", "main"); !strings.HasPrefix(subjects, "Merge agit/second via agit\nMerge agit/first via agit") {
+		t.Errorf("expected first then second merged into main, got:\n%s", subjects)
+	}
+	if wt, _ := db.GetWorktree(first.ID); wt.Status != "completed" {
+		t.Errorf("expected merged worktree completed, got %s", wt.Status)
+	}
+}
+
+func TestDrainBouncesConflicts(t *testing.T) {
+	db, repo := setupRepo(t)
+	landed := addWorktree(t, db, repo, "landed", "main", "shared.txt", "landed\n")
+	bounced := addWorktree(t, db, repo, "bounced", "main", "shared.txt", "bounced\n")
+	enqueue(t, db, repo, landed)
+	enqueue(t, db, repo, bounced)
+	mainBefore := git(t, repo.Path, "rev-parse", "main")
+
+	processed, err := Drain(db, repo)
+	if err != nil {
+		t.Fatalf("Drain: %v", err)
+	}
+	if len(processed) != 2 || processed[0].Status != "merged" || processed[1].Status != "conflict" {
+		t.Fatalf("expected the second entry bounced, got %+v", processed)
+	}
+	if !strings.Contains(*processed[1].Result, "shared.txt") {
+		t.Errorf("expected the conflicted path in the result, got %q", *processed[1].Result)
+	}
+	if git(t, repo.Path, "rev-parse", "main^") != mainBefore {
+		t.Error("expected only the first entry to land on main")
+	}
+	if wt, _ := db.GetWorktree(bounced.ID); wt.Status != "conflict" {
+		t.Errorf("expected the bounced worktree in conflict, got %s", wt.Status)
+	}
+	events, _ := db.ListEvents(registry.EventFilter{Type: registry.EventConflictDetected})
+	if len(events) != 1 || events[0].EntityID != bounced.ID {
+		t.Errorf("expected a conflict.detected event for the bounced worktree, got %+v", events)
+	}
+
+	// A bounced worktree can be queued again
+	if _, err := Enqueue(db, repo, bounced, "", false, nil); err != nil {
+		t.Errorf("expected a bounced worktree to be queued again, got %v", err)
+	}
+}
+
+func TestProcessNextRequeuesAbandonedMerge(t *testing.T) {
+	db, repo := setupRepo(t)
+	wt := addWorktree(t, db, repo, "abandoned", "main", "a.txt", "a\n")
+	entry := enqueue(t, db, repo, wt)
+
+	// A worker claims the entry and dies
+	if claimed, err := db.ClaimNextMerge(repo.ID); err != nil || claimed == nil {
+		t.Fatalf("ClaimNextMerge: %v, %v", claimed, err)
+	}
+	if next, err := ProcessNext(db, repo); err != nil || next != nil {
+		t.Fatalf("expected nothing processed while the entry is held, got %v, %v", next, err)
+	}
+
+	db.Conn().Exec(`UPDATE merge_queue SET started_at = ? WHERE id = ?`, time.Now().Add(-registry.MergeLockTimeout-time.Minute), entry.ID)
+	next, err := ProcessNext(db, repo)
+	if err != nil {
+		t.Fatalf("ProcessNext: %v", err)
+	}
+	if next == nil || next.ID != entry.ID || next.Status != "merged" {
+		t.Fatalf("expected the abandoned entry to be merged, got %+v", next)
+	}
+}
+
+func TestRenewWhileMerging(t *testing.T) {
+	db, repo := setupRepo(t)
+	wt := addWorktree(t, db, repo, "slow", "main", "slow.txt", "slow\n")
+	entry := enqueue(t, db, repo, wt)
+	if _, err := db.ClaimNextMerge(repo.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(d time.Duration) { renewInterval = d }(renewInterval)
+	renewInterval = 10 * time.Millisecond
+	db.Conn().Exec(`UPDATE merge_queue SET started_at = ? WHERE id = ?`, time.Now().Add(-registry.MergeLockTimeout-time.Minute), entry.ID)
+
+	stop := renewWhileMerging(db, entry.ID)
+	time.Sleep(50 * time.Millisecond)
+	stop()
+
+	if next, err := db.ClaimNextMerge(repo.ID); err != nil || next != nil {
+		t.Fatalf("expected a renewed entry to stay claimed, got %v, %v", next, err)
+	}
+	if e, _ := db.GetMergeQueueEntry(entry.ID); e.Status != "merging" {
+		t.Errorf("expected the entry still merging, got %s", e.Status)
+	}
+}
+
+func TestMergeTarget(t *testing.T) {
+	db, repo := setupRepo(t)
+	lower := addWorktree(t, db, repo, "lower", "main", "lower.txt", "lower\n")
+	upper := addWorktree(t, db, repo, "upper", lower.Branch, "upper.txt", "upper\n")
+
+	if into, err := MergeTarget(db, repo, lower); err != nil || into != "main" {
+		t.Errorf("expected lower to merge into main, got %q, %v", into, err)
+	}
+	if _, err := MergeTarget(db, repo, upper); err == nil || !apperrors.IsUserError(err) {
+		t.Fatalf("expected a stacked worktree to be refused while its base is in progress, got %v", err)
+	}
+
+	db.UpdateWorktreeStatus(lower.ID, "completed")
+	if into, err := MergeTarget(db, repo, upper); err != nil || into != lower.Branch {
+		t.Errorf("expected upper to merge into %s once lower is done, got %q, %v", lower.Branch, into, err)
+	}
+
+	// Once the base branch is gone, the worktree merges into the default branch
+	git(t, repo.Path, "worktree", "remove", "--force", lower.Path)
+	git(t, repo.Path, "branch", "-D", lower.Branch)
+	if into, err := MergeTarget(db, repo, upper); err != nil || into != "main" {
+		t.Errorf("expected upper to fall back to main, got %q, %v", into, err)
+	}
+}
+
+func TestClaimSlotExcludesQueueWorker(t *testing.T) {
+	db, repo := setupRepo(t)
+	direct := addWorktree(t, db, repo, "direct", "main", "direct.txt", "direct\n")
+	queued := addWorktree(t, db, repo, "queued", "main", "queued.txt", "queued\n")
+	enqueue(t, db, repo, queued)
+
+	slot, err := ClaimSlot(db, repo, direct, "", nil)
+	if err != nil {
+		t.Fatalf("ClaimSlot: %v", err)
+	}
+	if next, err := ProcessNext(db, repo); err != nil || next != nil {
+		t.Fatalf("expected the queue to wait for the direct merge, got %v, %v", next, err)
+	}
+	if _, err := ClaimSlot(db, repo, queued, "", nil); err == nil || !apperrors.IsUserError(err) {
+		t.Errorf("expected a second direct merge to be refused, got %v", err)
+	}
+
+	if err := slot.Release("merged", "merged"); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	if next, err := ProcessNext(db, repo); err != nil || next == nil || next.Status != "merged" {
+		t.Fatalf("expected the queue to merge once the slot is free, got %+v, %v", next, err)
+	}
+}
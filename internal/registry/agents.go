@@ -23,13 +23,25 @@ func (db *DB) RegisterAgent(name, agentType string) (*Agent, error) {
 	id := uuid.New().String()
 	now := time.Now()
 
-	_, err := db.conn.Exec(
+	tx, err := db.begin()
+	if err != nil {
+		return nil, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
 		`INSERT INTO agents (id, name, type, status, last_seen) VALUES (?, ?, ?, 'active', ?)`,
 		id, name, agentType, now,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("could not register agent: %w", err)
 	}
+	if err := recordAgentEvents(tx, EventAgentRegistered, map[string]any{"type": agentType}, "a.id = ?", id); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not commit transaction: %w", err)
+	}
 
 	return &Agent{
 		ID:       id,
@@ -93,7 +105,8 @@ func (db *DB) ListAgents() ([]*Agent, error) {
 	return agents, nil
 }
 
-// Heartbeat updates an agent's last_seen timestamp
+// Heartbeat updates an agent's last_seen timestamp and renews the leases on
+// the tasks it holds
 func (db *DB) Heartbeat(agentID string) error {
 	result, err := db.conn.Exec(
 		`UPDATE agents SET last_seen = ?, status = 'active' WHERE id = ?`,
@@ -106,7 +119,8 @@ func (db *DB) Heartbeat(agentID string) error {
 	if rows == 0 {
 		return fmt.Errorf("agent %q not found", agentID)
 	}
-	return nil
+	_, err = db.RenewTaskLeases(agentID)
+	return err
 }
 
 // UpdateAgentWorktree sets the current worktree for an agent
@@ -121,7 +135,16 @@ func (db *DB) UpdateAgentWorktree(agentID string, worktreeID *string) error {
 // SweepStaleAgents marks agents as disconnected if their last_seen exceeds staleAfter
 func (db *DB) SweepStaleAgents(staleAfter time.Duration) (int, error) {
 	cutoff := time.Now().Add(-staleAfter)
-	result, err := db.conn.Exec(
+	tx, err := db.begin()
+	if err != nil {
+		return 0, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := recordAgentEvents(tx, EventAgentDisconnected, map[string]any{"reason": "stale"}, "a.status = 'active' AND a.last_seen < ?", cutoff); err != nil {
+		return 0, err
+	}
+	result, err := tx.Exec(
 		`UPDATE agents SET status = 'disconnected' WHERE status = 'active' AND last_seen < ?`,
 		cutoff,
 	)
@@ -129,20 +152,32 @@ func (db *DB) SweepStaleAgents(staleAfter time.Duration) (int, error) {
 		return 0, fmt.Errorf("could not sweep stale agents: %w", err)
 	}
 	rows, _ := result.RowsAffected()
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("could not commit transaction: %w", err)
+	}
 	return int(rows), nil
 }
 
 // UnclaimAgentTasks reverts an agent's claimed/in_progress tasks to pending
 func (db *DB) UnclaimAgentTasks(agentID string) error {
-	_, err := db.conn.Exec(
-		`UPDATE tasks SET status = 'pending', assigned_agent_id = NULL
+	tx, err := db.begin()
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := recordTaskEvents(tx, EventTaskRequeued, map[string]any{"reason": "agent released"},
+		"t.assigned_agent_id = ? AND t.status IN ('claimed', 'in_progress')", agentID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(
+		`UPDATE tasks SET status = 'pending', assigned_agent_id = NULL, lease_expires_at = NULL
 		 WHERE assigned_agent_id = ? AND status IN ('claimed', 'in_progress')`,
 		agentID,
-	)
-	if err != nil {
+	); err != nil {
 		return fmt.Errorf("could not unclaim agent tasks: %w", err)
 	}
-	return nil
+	return tx.Commit()
 }
 
 // UnassignAgentWorktrees clears agent_id on worktrees assigned to this agent
@@ -167,15 +202,23 @@ func (db *DB) RemoveAgent(name string) error {
 		return fmt.Errorf("agent %q not found", name)
 	}
 
-	tx, err := db.conn.Begin()
+	tx, err := db.begin()
 	if err != nil {
 		return fmt.Errorf("could not begin transaction: %w", err)
 	}
 	defer tx.Rollback()
 
+	if err := recordAgentEvents(tx, EventAgentRemoved, nil, "a.id = ?", agent.ID); err != nil {
+		return err
+	}
+	if err := recordTaskEvents(tx, EventTaskRequeued, map[string]any{"reason": "agent removed"},
+		"t.assigned_agent_id = ? AND t.status IN ('claimed', 'in_progress')", agent.ID); err != nil {
+		return err
+	}
+
 	// Unclaim tasks
 	if _, err := tx.Exec(
-		`UPDATE tasks SET status = 'pending', assigned_agent_id = NULL
+		`UPDATE tasks SET status = 'pending', assigned_agent_id = NULL, lease_expires_at = NULL
 		 WHERE assigned_agent_id = ? AND status IN ('claimed', 'in_progress')`,
 		agent.ID,
 	); err != nil {
@@ -190,6 +233,11 @@ func (db *DB) RemoveAgent(name string) error {
 		return fmt.Errorf("could not unassign worktrees: %w", err)
 	}
 
+	// Revoke its tokens
+	if _, err := tx.Exec(`DELETE FROM agent_tokens WHERE agent_id = ?`, agent.ID); err != nil {
+		return fmt.Errorf("could not revoke tokens: %w", err)
+	}
+
 	// Delete agent
 	if _, err := tx.Exec(`DELETE FROM agents WHERE id = ?`, agent.ID); err != nil {
 		return fmt.Errorf("could not delete agent: %w", err)
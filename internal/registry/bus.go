@@ -0,0 +1,107 @@
+package registry
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Subscribe registers fn to be called with every event recorded through db,
+// once the change it describes has been committed. Events are delivered in
+// the order they were recorded, on the goroutine that made the change, so fn
+// should hand slow work off. Events recorded by other processes sharing the
+// database are not delivered.
+func (db *DB) Subscribe(fn func(*Event)) {
+	db.busMu.Lock()
+	defer db.busMu.Unlock()
+	db.subscribers = append(db.subscribers, fn)
+}
+
+// OnClose registers fn to run when db is closed, before the connection is.
+// Subscribers use it to finish work that still writes to db.
+func (db *DB) OnClose(fn func()) {
+	db.busMu.Lock()
+	defer db.busMu.Unlock()
+	db.closers = append(db.closers, fn)
+}
+
+func (db *DB) subscribed() bool {
+	db.busMu.Lock()
+	defer db.busMu.Unlock()
+	return len(db.subscribers) > 0
+}
+
+func (db *DB) publish(events []*Event) {
+	if len(events) == 0 {
+		return
+	}
+	db.busMu.Lock()
+	subscribers := make([]func(*Event), len(db.subscribers))
+	copy(subscribers, db.subscribers)
+	db.busMu.Unlock()
+
+	for _, e := range events {
+		for _, fn := range subscribers {
+			fn(e)
+		}
+	}
+}
+
+// eventTx is a transaction that collects the events recorded in it and
+// publishes them once it commits. Events recorded in a transaction that is
+// rolled back are never published.
+type eventTx struct {
+	*sql.Tx
+	db     *DB
+	events []*Event
+}
+
+// begin starts a transaction that publishes its events on commit
+func (db *DB) begin() (*eventTx, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &eventTx{Tx: tx, db: db}, nil
+}
+
+// Commit commits the transaction, then publishes its events
+func (tx *eventTx) Commit() error {
+	if err := tx.Tx.Commit(); err != nil {
+		return err
+	}
+	tx.db.publish(tx.events)
+	tx.events = nil
+	return nil
+}
+
+// collect reads back the events an INSERT INTO events just added, so they
+// can be published on commit. Inserts into events within a transaction get
+// consecutive IDs ending at the last insert ID. It does nothing while
+// nobody is subscribed.
+func (tx *eventTx) collect(res sql.Result) error {
+	if !tx.db.subscribed() {
+		return nil
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return err
+	}
+	last, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(`SELECT `+eventColumns+` FROM events WHERE id > ? AND id <= ? ORDER BY id`, last-n, last)
+	if err != nil {
+		return fmt.Errorf("could not read back events: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		e, err := scanEvent(rows)
+		if err != nil {
+			return fmt.Errorf("could not scan event: %w", err)
+		}
+		tx.events = append(tx.events, e)
+	}
+	return rows.Err()
+}
@@ -0,0 +1,219 @@
+package registry
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// TaskDependency is an edge in a repo's task graph: TaskID cannot be
+// scheduled until DependsOnID is completed.
+type TaskDependency struct {
+	TaskID      string
+	DependsOnID string
+}
+
+// CreateTaskWithDeps creates a task that depends on existing tasks of the
+// same repo. The task and its dependencies are added together: if any
+// dependency is invalid, nothing is created.
+func (db *DB) CreateTaskWithDeps(repoID, description string, priority int, dependsOn []string, scope ...string) (*Task, error) {
+	return db.createTask(repoID, description, priority, dependsOn, scope)
+}
+
+// addNewTaskDependencies records the dependencies of a task being created in
+// tx. Nothing depends on a new task yet, so its edges cannot close a cycle.
+func addNewTaskDependencies(tx *eventTx, repoID, taskID string, dependsOn []string) error {
+	for _, depID := range dependsOn {
+		var depRepoID string
+		err := tx.QueryRow(`SELECT repo_id FROM tasks WHERE id = ?`, depID).Scan(&depRepoID)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("task %q not found", depID)
+		}
+		if err != nil {
+			return fmt.Errorf("could not get task: %w", err)
+		}
+		if depRepoID != repoID {
+			return fmt.Errorf("task %q and %q belong to different repositories", taskID, depID)
+		}
+		if _, err := tx.Exec(
+			`INSERT OR IGNORE INTO task_dependencies (task_id, depends_on_id) VALUES (?, ?)`,
+			taskID, depID,
+		); err != nil {
+			return fmt.Errorf("could not add task dependency: %w", err)
+		}
+	}
+	return nil
+}
+
+// AddTaskDependency records that taskID depends on dependsOnID.
+// Both tasks must belong to the same repo, and the new edge must not
+// introduce a cycle into the graph.
+func (db *DB) AddTaskDependency(taskID, dependsOnID string) error {
+	if taskID == dependsOnID {
+		return fmt.Errorf("task %q cannot depend on itself", taskID)
+	}
+
+	task, err := db.GetTask(taskID)
+	if err != nil {
+		return err
+	}
+	dep, err := db.GetTask(dependsOnID)
+	if err != nil {
+		return err
+	}
+	if task.RepoID != dep.RepoID {
+		return fmt.Errorf("task %q and %q belong to different repositories", taskID, dependsOnID)
+	}
+
+	// Adding taskID -> dependsOnID closes a cycle if dependsOnID can
+	// already reach taskID through existing edges.
+	cycle, err := db.dependsOnTransitively(dependsOnID, taskID)
+	if err != nil {
+		return err
+	}
+	if cycle {
+		return fmt.Errorf("dependency %s -> %s would create a cycle", taskID, dependsOnID)
+	}
+
+	if _, err := db.conn.Exec(
+		`INSERT OR IGNORE INTO task_dependencies (task_id, depends_on_id) VALUES (?, ?)`,
+		taskID, dependsOnID,
+	); err != nil {
+		return fmt.Errorf("could not add task dependency: %w", err)
+	}
+	return nil
+}
+
+// dependsOnTransitively reports whether from reaches target by following
+// dependency edges.
+func (db *DB) dependsOnTransitively(from, target string) (bool, error) {
+	visited := map[string]bool{from: true}
+	queue := []string{from}
+
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		deps, err := db.GetTaskDependencies(current)
+		if err != nil {
+			return false, err
+		}
+		for _, d := range deps {
+			if d == target {
+				return true, nil
+			}
+			if !visited[d] {
+				visited[d] = true
+				queue = append(queue, d)
+			}
+		}
+	}
+	return false, nil
+}
+
+// GetTaskDependencies returns the IDs of the tasks that taskID depends on
+func (db *DB) GetTaskDependencies(taskID string) ([]string, error) {
+	rows, err := db.conn.Query(
+		`SELECT depends_on_id FROM task_dependencies WHERE task_id = ? ORDER BY depends_on_id`,
+		taskID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not get task dependencies: %w", err)
+	}
+	defer rows.Close()
+
+	var deps []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("could not scan task dependency: %w", err)
+		}
+		deps = append(deps, id)
+	}
+	return deps, nil
+}
+
+// ListTaskDependencies returns every dependency edge between tasks of a repo
+func (db *DB) ListTaskDependencies(repoID string) ([]TaskDependency, error) {
+	rows, err := db.conn.Query(
+		`SELECT d.task_id, d.depends_on_id
+		 FROM task_dependencies d
+		 JOIN tasks t ON t.id = d.task_id
+		 WHERE t.repo_id = ?
+		 ORDER BY d.task_id, d.depends_on_id`,
+		repoID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not list task dependencies: %w", err)
+	}
+	defer rows.Close()
+
+	var edges []TaskDependency
+	for rows.Next() {
+		var e TaskDependency
+		if err := rows.Scan(&e.TaskID, &e.DependsOnID); err != nil {
+			return nil, fmt.Errorf("could not scan task dependency: %w", err)
+		}
+		edges = append(edges, e)
+	}
+	return edges, nil
+}
+
+// BlockDependents transitively marks pending tasks that depend on taskID as
+// blocked. It is used to cascade a failure through the task graph and
+// returns the IDs of the tasks it blocked.
+func (db *DB) BlockDependents(taskID string) ([]string, error) {
+	tx, err := db.begin()
+	if err != nil {
+		return nil, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var blocked []string
+	visited := map[string]bool{taskID: true}
+	queue := []string{taskID}
+
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		rows, err := tx.Query(`SELECT task_id FROM task_dependencies WHERE depends_on_id = ?`, current)
+		if err != nil {
+			return nil, fmt.Errorf("could not find dependent tasks: %w", err)
+		}
+		var dependents []string
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("could not scan dependent task: %w", err)
+			}
+			dependents = append(dependents, id)
+		}
+		rows.Close()
+
+		for _, id := range dependents {
+			if visited[id] {
+				continue
+			}
+			visited[id] = true
+			queue = append(queue, id)
+
+			res, err := tx.Exec(`UPDATE tasks SET status = 'blocked' WHERE id = ? AND status = 'pending'`, id)
+			if err != nil {
+				return nil, fmt.Errorf("could not block task %s: %w", id, err)
+			}
+			if n, _ := res.RowsAffected(); n > 0 {
+				blocked = append(blocked, id)
+				if err := recordTaskEvents(tx, EventTaskBlocked,
+					map[string]any{"blocked_by": taskID}, "t.id = ?", id); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not commit transaction: %w", err)
+	}
+	return blocked, nil
+}
@@ -0,0 +1,243 @@
+package registry
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Event types recorded in the event log
+const (
+	EventWorktreeCreated   = "worktree.created"
+	EventWorktreeRemoved   = "worktree.removed"
+	EventWorktreeMerged    = "worktree.merged"
+	EventWorktreeSynced    = "worktree.synced"
+	EventWorktreeSetup     = "worktree.setup"
+	EventTaskCreated       = "task.created"
+	EventTaskClaimed       = "task.claimed"
+	EventTaskStarted       = "task.started"
+	EventTaskCompleted     = "task.completed"
+	EventTaskFailed        = "task.failed"
+	EventTaskRequeued      = "task.requeued"
+	EventTaskBlocked       = "task.blocked"
+	EventAgentRegistered   = "agent.registered"
+	EventAgentDisconnected = "agent.disconnected"
+	EventAgentRemoved      = "agent.removed"
+	EventRepoAdded         = "repo.added"
+	EventMergeFailed       = "merge.failed"
+	EventConflictDetected  = "conflict.detected"
+	EventTouchesUpdated    = "touches.updated"
+	EventLockAcquired      = "lock.acquired"
+	EventLockReleased      = "lock.released"
+)
+
+// Event is one entry in the append-only event log
+type Event struct {
+	ID         int64
+	Type       string
+	AgentID    *string
+	Actor      *string // agent name when the event was recorded
+	RepoID     *string
+	EntityType string // worktree, task, agent, repo, file or lock
+	EntityID   string
+	Payload    map[string]any
+	CreatedAt  time.Time
+}
+
+// EventFilter narrows ListEvents. Zero values match everything.
+type EventFilter struct {
+	RepoID   string
+	Agent    string // agent name or ID
+	Type     string // exact type, or a category such as "task"
+	EntityID string
+	Since    time.Time
+	AfterID  int64
+	Limit    int // keep only the most recent N events
+}
+
+const eventColumns = `id, type, agent_id, actor, repo_id, entity_type, entity_id, payload, created_at`
+
+func scanEvent(row rowScanner) (*Event, error) {
+	e := &Event{}
+	var payload sql.NullString
+	err := row.Scan(&e.ID, &e.Type, &e.AgentID, &e.Actor, &e.RepoID, &e.EntityType, &e.EntityID, &payload, &e.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if payload.Valid && payload.String != "" {
+		if err := json.Unmarshal([]byte(payload.String), &e.Payload); err != nil {
+			return nil, fmt.Errorf("could not decode event payload: %w", err)
+		}
+	}
+	return e, nil
+}
+
+func encodePayload(payload map[string]any) (*string, error) {
+	if len(payload) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode event payload: %w", err)
+	}
+	s := string(data)
+	return &s, nil
+}
+
+// RecordEvent appends an event to the log and publishes it to subscribers.
+// The actor name is resolved from AgentID.
+func (db *DB) RecordEvent(e *Event) error {
+	tx, err := db.begin()
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := recordEvent(tx, e); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// recordEvent appends an event in the same transaction as the change it
+// describes
+func recordEvent(tx *eventTx, e *Event) error {
+	payload, err := encodePayload(e.Payload)
+	if err != nil {
+		return err
+	}
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+	res, err := tx.Exec(
+		`INSERT INTO events (type, agent_id, actor, repo_id, entity_type, entity_id, payload, created_at)
+		 VALUES (?, ?, (SELECT name FROM agents WHERE id = ?), ?, ?, ?, ?, ?)`,
+		e.Type, e.AgentID, e.AgentID, e.RepoID, e.EntityType, e.EntityID, payload, e.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("could not record %s event: %w", e.Type, err)
+	}
+	return tx.collect(res)
+}
+
+// recordTaskEvents appends an event for every task matching where. The task's
+// assigned agent is recorded as the actor.
+func recordTaskEvents(tx *eventTx, eventType string, payload map[string]any, where string, args ...any) error {
+	data, err := encodePayload(payload)
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec(
+		`INSERT INTO events (type, agent_id, actor, repo_id, entity_type, entity_id, payload, created_at)
+		 SELECT ?, t.assigned_agent_id, a.name, t.repo_id, 'task', t.id, ?, ?
+		 FROM tasks t LEFT JOIN agents a ON a.id = t.assigned_agent_id
+		 WHERE `+where,
+		append([]any{eventType, data, time.Now()}, args...)...,
+	)
+	if err != nil {
+		return fmt.Errorf("could not record %s event: %w", eventType, err)
+	}
+	return tx.collect(res)
+}
+
+// recordAgentEvents appends an event for every agent matching where
+func recordAgentEvents(tx *eventTx, eventType string, payload map[string]any, where string, args ...any) error {
+	data, err := encodePayload(payload)
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec(
+		`INSERT INTO events (type, agent_id, actor, repo_id, entity_type, entity_id, payload, created_at)
+		 SELECT ?, a.id, a.name, NULL, 'agent', a.id, ?, ?
+		 FROM agents a
+		 WHERE `+where,
+		append([]any{eventType, data, time.Now()}, args...)...,
+	)
+	if err != nil {
+		return fmt.Errorf("could not record %s event: %w", eventType, err)
+	}
+	return tx.collect(res)
+}
+
+// ListEvents returns events matching the filter, oldest first
+func (db *DB) ListEvents(f EventFilter) ([]*Event, error) {
+	var where []string
+	var args []any
+	if f.RepoID != "" {
+		where = append(where, "repo_id = ?")
+		args = append(args, f.RepoID)
+	}
+	if f.Agent != "" {
+		where = append(where, "(agent_id = ? OR actor = ?)")
+		args = append(args, f.Agent, f.Agent)
+	}
+	if f.Type != "" {
+		if strings.Contains(f.Type, ".") {
+			where = append(where, "type = ?")
+			args = append(args, f.Type)
+		} else {
+			where = append(where, "type LIKE ?")
+			args = append(args, f.Type+".%")
+		}
+	}
+	if f.EntityID != "" {
+		where = append(where, "entity_id = ?")
+		args = append(args, f.EntityID)
+	}
+	if !f.Since.IsZero() {
+		where = append(where, "created_at >= ?")
+		args = append(args, f.Since)
+	}
+	if f.AfterID > 0 {
+		where = append(where, "id > ?")
+		args = append(args, f.AfterID)
+	}
+
+	query := `SELECT ` + eventColumns + ` FROM events`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	if f.Limit > 0 {
+		// Take the newest N, then restore chronological order
+		query = `SELECT * FROM (` + query + ` ORDER BY id DESC LIMIT ?) ORDER BY id ASC`
+		args = append(args, f.Limit)
+	} else {
+		query += ` ORDER BY id ASC`
+	}
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not list events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []*Event
+	for rows.Next() {
+		e, err := scanEvent(rows)
+		if err != nil {
+			return nil, fmt.Errorf("could not scan event: %w", err)
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// ParseSince parses a time filter: a duration before now such as 30m, 2h or
+// 7d, or an absolute date or RFC 3339 timestamp
+func ParseSince(s string, now time.Time) (time.Time, error) {
+	if days, err := strconv.Atoi(strings.TrimSuffix(s, "d")); err == nil && strings.HasSuffix(s, "d") && days >= 0 {
+		return now.AddDate(0, 0, -days), nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q: use a duration like 2h or 7d, or a date like 2006-01-02", s)
+}
@@ -0,0 +1,131 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// MatchPath reports whether a repo-relative file path matches a path
+// pattern. Within a segment, patterns use path.Match syntax (*, ?, [a-z]);
+// a ** segment matches any number of segments. A pattern without wildcards
+// also matches everything beneath it, so "internal/registry" covers the
+// whole directory.
+func MatchPath(pattern, p string) bool {
+	pattern = normalizePattern(pattern)
+	p = strings.Trim(p, "/")
+	if !strings.ContainsAny(pattern, "*?[") {
+		return p == pattern || strings.HasPrefix(p, pattern+"/")
+	}
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(p, "/"))
+}
+
+func matchSegments(pattern, segs []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(segs); i++ {
+				if matchSegments(pattern[1:], segs[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segs) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], segs[0]); !ok {
+			return false
+		}
+		pattern, segs = pattern[1:], segs[1:]
+	}
+	return len(segs) == 0
+}
+
+// PatternsOverlap reports whether two path patterns may cover the same file.
+// Patterns are compared segment by segment, letting a ** segment in either
+// absorb any run of the other's segments. Two wildcard segments are taken to
+// overlap unless their literal prefixes or suffixes rule it out, so the
+// answer errs on the side of an overlap: "internal/*.go" and "internal/reg*"
+// overlap, "internal/*.go" and "internal/*.sql" do not.
+func PatternsOverlap(a, b string) bool {
+	a, b = normalizePattern(a), normalizePattern(b)
+	return a == b || overlapSegments(patternSegments(a), patternSegments(b))
+}
+
+// patternSegments splits a pattern into segments. A pattern without
+// wildcards covers everything beneath it, so it gets a trailing **.
+func patternSegments(pattern string) []string {
+	segs := strings.Split(pattern, "/")
+	if !strings.ContainsAny(pattern, "*?[") {
+		segs = append(segs, "**")
+	}
+	return segs
+}
+
+func overlapSegments(a, b []string) bool {
+	switch {
+	case len(a) > 0 && a[0] == "**":
+		return overlapSegments(a[1:], b) || (len(b) > 0 && overlapSegments(a, b[1:]))
+	case len(b) > 0 && b[0] == "**":
+		return overlapSegments(a, b[1:]) || (len(a) > 0 && overlapSegments(a[1:], b))
+	case len(a) == 0 || len(b) == 0:
+		return len(a) == len(b)
+	}
+	return segmentsOverlap(a[0], b[0]) && overlapSegments(a[1:], b[1:])
+}
+
+// segmentsOverlap reports whether two path.Match segment patterns may match
+// the same name
+func segmentsOverlap(a, b string) bool {
+	aWild, bWild := strings.ContainsAny(a, "*?["), strings.ContainsAny(b, "*?[")
+	switch {
+	case !aWild && !bWild:
+		return a == b
+	case !bWild:
+		ok, _ := path.Match(a, b)
+		return ok
+	case !aWild:
+		ok, _ := path.Match(b, a)
+		return ok
+	}
+	aPrefix, aSuffix := literalEnds(a)
+	bPrefix, bSuffix := literalEnds(b)
+	return (strings.HasPrefix(aPrefix, bPrefix) || strings.HasPrefix(bPrefix, aPrefix)) &&
+		(strings.HasSuffix(aSuffix, bSuffix) || strings.HasSuffix(bSuffix, aSuffix))
+}
+
+// literalEnds returns the literal text before the first and after the last
+// wildcard of a segment pattern
+func literalEnds(seg string) (prefix, suffix string) {
+	first := strings.IndexAny(seg, `*?[\`)
+	last := strings.LastIndexAny(seg, `*?]\`)
+	return seg[:first], seg[last+1:]
+}
+
+// ErrInvalidPattern is returned for a malformed path pattern
+var ErrInvalidPattern = errors.New("invalid path pattern")
+
+// ValidatePattern checks that a path pattern is well formed. Its errors wrap
+// ErrInvalidPattern.
+func ValidatePattern(pattern string) error {
+	pattern = normalizePattern(pattern)
+	if pattern == "" || pattern == "." {
+		return fmt.Errorf("%w: must not be empty", ErrInvalidPattern)
+	}
+	for _, seg := range strings.Split(pattern, "/") {
+		if seg == ".." {
+			return fmt.Errorf("%w %q: must stay inside the repo", ErrInvalidPattern, pattern)
+		}
+		if _, err := path.Match(seg, ""); err != nil {
+			return fmt.Errorf("%w %q: %w", ErrInvalidPattern, pattern, err)
+		}
+	}
+	return nil
+}
+
+// normalizePattern cleans a pattern into the slash-separated, repo-relative
+// form that file touches use
+func normalizePattern(pattern string) string {
+	return strings.Trim(strings.TrimPrefix(pattern, "./"), "/")
+}
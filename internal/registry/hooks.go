@@ -0,0 +1,115 @@
+package registry
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MaxHookRuns is how many hook runs are kept; older ones are pruned as new
+// ones are recorded
+const MaxHookRuns = 1000
+
+// HookRun records one execution of a hook command. Stdout and Stderr hold
+// the tail of the command's output.
+type HookRun struct {
+	ID         int64
+	Event      string
+	Command    string
+	Repo       string // empty for hooks fired outside a repository
+	ExitCode   int    // -1 if the command timed out or could not start
+	DurationMS int64
+	Stdout     string
+	Stderr     string
+	TimedOut   bool
+	CreatedAt  time.Time
+}
+
+// HookRunFilter narrows ListHookRuns. Zero values match everything.
+type HookRunFilter struct {
+	Event  string
+	Repo   string
+	Failed bool // only runs that exited non-zero or timed out
+	Limit  int  // keep only the most recent N runs
+}
+
+const hookRunColumns = `id, event, command, repo, exit_code, duration_ms, stdout, stderr, timed_out, created_at`
+
+func scanHookRun(row rowScanner) (*HookRun, error) {
+	r := &HookRun{}
+	err := row.Scan(&r.ID, &r.Event, &r.Command, &r.Repo, &r.ExitCode, &r.DurationMS,
+		&r.Stdout, &r.Stderr, &r.TimedOut, &r.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// RecordHookRun stores a hook run and prunes runs beyond MaxHookRuns
+func (db *DB) RecordHookRun(r *HookRun) error {
+	if r.CreatedAt.IsZero() {
+		r.CreatedAt = time.Now()
+	}
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(
+		`INSERT INTO hook_runs (event, command, repo, exit_code, duration_ms, stdout, stderr, timed_out, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.Event, r.Command, r.Repo, r.ExitCode, r.DurationMS, r.Stdout, r.Stderr, r.TimedOut, r.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("could not record hook run: %w", err)
+	}
+	r.ID, _ = res.LastInsertId()
+	if _, err := tx.Exec(`DELETE FROM hook_runs WHERE id <= ?`, r.ID-MaxHookRuns); err != nil {
+		return fmt.Errorf("could not prune hook runs: %w", err)
+	}
+	return tx.Commit()
+}
+
+// ListHookRuns returns hook runs matching the filter, newest first
+func (db *DB) ListHookRuns(f HookRunFilter) ([]*HookRun, error) {
+	var where []string
+	var args []any
+	if f.Event != "" {
+		where = append(where, "event = ?")
+		args = append(args, f.Event)
+	}
+	if f.Repo != "" {
+		where = append(where, "repo = ?")
+		args = append(args, f.Repo)
+	}
+	if f.Failed {
+		where = append(where, "(exit_code != 0 OR timed_out = 1)")
+	}
+
+	query := `SELECT ` + hookRunColumns + ` FROM hook_runs`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	query += ` ORDER BY id DESC`
+	if f.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, f.Limit)
+	}
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not list hook runs: %w", err)
+	}
+	defer rows.Close()
+
+	var runs []*HookRun
+	for rows.Next() {
+		r, err := scanHookRun(rows)
+		if err != nil {
+			return nil, fmt.Errorf("could not scan hook run: %w", err)
+		}
+		runs = append(runs, r)
+	}
+	return runs, rows.Err()
+}
@@ -0,0 +1,140 @@
+package registry
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/fathindos/agit/internal/config"
+)
+
+// LeasePolicy controls how long a task claim stays valid without a heartbeat
+// and how many times a task may be claimed before it is failed outright.
+type LeasePolicy struct {
+	Duration    time.Duration
+	MaxAttempts int // 0 means unlimited
+}
+
+// DefaultLeasePolicy mirrors the default [agent] configuration
+func DefaultLeasePolicy() LeasePolicy {
+	return LeasePolicy{
+		Duration:    5 * time.Minute,
+		MaxAttempts: 3,
+	}
+}
+
+// LeasePolicyFromConfig derives the lease policy from the [agent] section.
+// A lease lasts agent.stale_after, but never less than two heartbeat
+// intervals so a single late heartbeat does not drop a live claim.
+func LeasePolicyFromConfig(cfg *config.Config) LeasePolicy {
+	p := DefaultLeasePolicy()
+	if d, err := time.ParseDuration(cfg.Agent.StaleAfter); err == nil && d > 0 {
+		p.Duration = d
+	}
+	if hb, err := time.ParseDuration(cfg.Agent.HeartbeatInterval); err == nil && p.Duration < 2*hb {
+		p.Duration = 2 * hb
+	}
+	p.MaxAttempts = cfg.Agent.MaxTaskAttempts
+	return p
+}
+
+// SetLeasePolicy overrides the lease policy used for new claims and reclaims
+func (db *DB) SetLeasePolicy(p LeasePolicy) {
+	db.lease = p
+}
+
+// LeasePolicy returns the lease policy in effect
+func (db *DB) LeasePolicy() LeasePolicy {
+	return db.lease
+}
+
+// RenewTaskLeases extends the lease on every task an agent currently holds
+func (db *DB) RenewTaskLeases(agentID string) (int, error) {
+	result, err := db.conn.Exec(
+		`UPDATE tasks SET lease_expires_at = ?
+		 WHERE assigned_agent_id = ? AND status IN ('claimed', 'in_progress')`,
+		time.Now().Add(db.lease.Duration), agentID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("could not renew task leases: %w", err)
+	}
+	rows, _ := result.RowsAffected()
+	return int(rows), nil
+}
+
+// ReclaimResult lists the tasks affected by ReclaimExpiredTasks
+type ReclaimResult struct {
+	Requeued []string // returned to pending
+	Failed   []string // exceeded the max attempts
+}
+
+// ReclaimExpiredTasks releases claimed or in-progress tasks whose lease has
+// expired. Tasks that have used up their attempts are failed; the rest are
+// returned to pending so another agent can pick them up.
+func (db *DB) ReclaimExpiredTasks() (*ReclaimResult, error) {
+	tx, err := db.begin()
+	if err != nil {
+		return nil, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	rows, err := tx.Query(
+		`SELECT id, attempts FROM tasks
+		 WHERE status IN ('claimed', 'in_progress') AND lease_expires_at IS NOT NULL AND lease_expires_at < ?`,
+		now,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not find expired leases: %w", err)
+	}
+	type expired struct {
+		id       string
+		attempts int
+	}
+	var candidates []expired
+	for rows.Next() {
+		var e expired
+		if err := rows.Scan(&e.id, &e.attempts); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("could not scan expired lease: %w", err)
+		}
+		candidates = append(candidates, e)
+	}
+	rows.Close()
+
+	result := &ReclaimResult{Requeued: []string{}, Failed: []string{}}
+	for _, c := range candidates {
+		if db.lease.MaxAttempts > 0 && c.attempts >= db.lease.MaxAttempts {
+			reason := fmt.Sprintf("lease expired after %d attempt(s)", c.attempts)
+			if err := recordTaskEvents(tx, EventTaskFailed,
+				map[string]any{"result": reason, "attempts": c.attempts}, "t.id = ?", c.id); err != nil {
+				return nil, err
+			}
+			if _, err := tx.Exec(
+				`UPDATE tasks SET status = 'failed', completed_at = ?, result = ?, lease_expires_at = NULL WHERE id = ?`,
+				now, reason, c.id,
+			); err != nil {
+				return nil, fmt.Errorf("could not fail task %s: %w", c.id, err)
+			}
+			result.Failed = append(result.Failed, c.id)
+			continue
+		}
+
+		// Recorded before the update so the agent that lost the lease is the actor
+		if err := recordTaskEvents(tx, EventTaskRequeued,
+			map[string]any{"reason": "lease expired", "attempts": c.attempts}, "t.id = ?", c.id); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(
+			`UPDATE tasks SET status = 'pending', assigned_agent_id = NULL, lease_expires_at = NULL WHERE id = ?`,
+			c.id,
+		); err != nil {
+			return nil, fmt.Errorf("could not requeue task %s: %w", c.id, err)
+		}
+		result.Requeued = append(result.Requeued, c.id)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not commit transaction: %w", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,350 @@
+package registry
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DefaultLockTTL is how long a lock is held when no TTL is given
+const DefaultLockTTL = 30 * time.Minute
+
+// Lock is an advisory claim on a path or glob in a repo. It is held by an
+// agent, a worktree, or both, and lapses at ExpiresAt unless renewed.
+type Lock struct {
+	ID         string
+	RepoID     string
+	Pattern    string
+	AgentID    *string
+	AgentName  *string // resolved from AgentID
+	WorktreeID *string
+	Reason     *string
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+}
+
+// Holder describes who holds the lock, for messages
+func (l *Lock) Holder() string {
+	if l.AgentName != nil {
+		return "agent " + *l.AgentName
+	}
+	if l.WorktreeID != nil {
+		id := *l.WorktreeID
+		if len(id) > 12 {
+			id = id[:12]
+		}
+		return "worktree " + id
+	}
+	return "unknown holder"
+}
+
+// HeldBy reports whether the lock belongs to the given agent or worktree
+func (l *Lock) HeldBy(agentID, worktreeID *string) bool {
+	if agentID != nil && l.AgentID != nil && *agentID == *l.AgentID {
+		return true
+	}
+	return worktreeID != nil && l.WorktreeID != nil && *worktreeID == *l.WorktreeID
+}
+
+// Matches reports whether a repo-relative file path falls under the lock
+func (l *Lock) Matches(path string) bool {
+	return MatchPath(l.Pattern, path)
+}
+
+// LockHeldError is returned when a lock overlaps one held by someone else
+type LockHeldError struct {
+	Pattern string
+	Lock    *Lock
+}
+
+func (e *LockHeldError) Error() string {
+	return fmt.Sprintf("%s is locked by %s until %s (lock %s on %s)",
+		e.Pattern, e.Lock.Holder(), e.Lock.ExpiresAt.Format(time.RFC3339), e.Lock.ID[:8], e.Lock.Pattern)
+}
+
+// LockViolation is a file changed in a worktree that falls under a lock held
+// by another agent or worktree
+type LockViolation struct {
+	WorktreeID  string
+	Path        string
+	Uncommitted bool
+	Lock        *Lock
+}
+
+const lockColumns = `l.id, l.repo_id, l.pattern, l.agent_id, a.name, l.worktree_id, l.reason, l.created_at, l.expires_at`
+
+const lockFrom = ` FROM locks l LEFT JOIN agents a ON a.id = l.agent_id`
+
+func scanLock(row rowScanner) (*Lock, error) {
+	l := &Lock{}
+	err := row.Scan(&l.ID, &l.RepoID, &l.Pattern, &l.AgentID, &l.AgentName, &l.WorktreeID, &l.Reason, &l.CreatedAt, &l.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func queryLocks(q interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}, where string, args ...any) ([]*Lock, error) {
+	rows, err := q.Query(`SELECT `+lockColumns+lockFrom+` WHERE `+where+` ORDER BY l.created_at ASC`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not list locks: %w", err)
+	}
+	defer rows.Close()
+
+	var locks []*Lock
+	for rows.Next() {
+		l, err := scanLock(rows)
+		if err != nil {
+			return nil, fmt.Errorf("could not scan lock: %w", err)
+		}
+		locks = append(locks, l)
+	}
+	return locks, rows.Err()
+}
+
+// AcquireLock claims a path or glob in a repo for an agent and/or worktree.
+// It fails with a *LockHeldError if an unexpired lock held by someone else
+// overlaps the pattern. If the holder already has a lock on the same
+// pattern, that lock is renewed instead.
+func (db *DB) AcquireLock(repoID, pattern string, agentID, worktreeID, reason *string, ttl time.Duration) (*Lock, error) {
+	if agentID == nil && worktreeID == nil {
+		return nil, fmt.Errorf("a lock must be held by an agent or a worktree")
+	}
+	if err := ValidatePattern(pattern); err != nil {
+		return nil, err
+	}
+	pattern = normalizePattern(pattern)
+	if ttl <= 0 {
+		ttl = DefaultLockTTL
+	}
+
+	tx, err := db.begin()
+	if err != nil {
+		return nil, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	if err := purgeExpiredLocks(tx, now); err != nil {
+		return nil, err
+	}
+
+	held, err := queryLocks(tx, `l.repo_id = ?`, repoID)
+	if err != nil {
+		return nil, err
+	}
+	var own *Lock
+	for _, l := range held {
+		if !PatternsOverlap(l.Pattern, pattern) {
+			continue
+		}
+		if !l.HeldBy(agentID, worktreeID) {
+			return nil, &LockHeldError{Pattern: pattern, Lock: l}
+		}
+		if l.Pattern == pattern {
+			own = l
+		}
+	}
+
+	expires := now.Add(ttl)
+	if own != nil {
+		if _, err := tx.Exec(
+			`UPDATE locks SET expires_at = ?, reason = COALESCE(?, reason) WHERE id = ?`,
+			expires, reason, own.ID,
+		); err != nil {
+			return nil, fmt.Errorf("could not renew lock: %w", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, fmt.Errorf("could not commit transaction: %w", err)
+		}
+		return db.GetLock(own.ID)
+	}
+
+	id := uuid.New().String()
+	if _, err := tx.Exec(
+		`INSERT INTO locks (id, repo_id, pattern, agent_id, worktree_id, reason, created_at, expires_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, repoID, pattern, agentID, worktreeID, reason, now, expires,
+	); err != nil {
+		return nil, fmt.Errorf("could not acquire lock: %w", err)
+	}
+	payload := map[string]any{"pattern": pattern, "expires_at": expires.Format(time.RFC3339)}
+	if reason != nil {
+		payload["reason"] = *reason
+	}
+	if err := recordEvent(tx, &Event{
+		Type:       EventLockAcquired,
+		AgentID:    agentID,
+		RepoID:     &repoID,
+		EntityType: "lock",
+		EntityID:   id,
+		Payload:    payload,
+		CreatedAt:  now,
+	}); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not commit transaction: %w", err)
+	}
+	return db.GetLock(id)
+}
+
+// GetLock retrieves a lock by ID
+func (db *DB) GetLock(id string) (*Lock, error) {
+	l, err := scanLock(db.conn.QueryRow(`SELECT `+lockColumns+lockFrom+` WHERE l.id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("lock %q not found", id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get lock: %w", err)
+	}
+	return l, nil
+}
+
+// ListLocks returns a repo's unexpired locks, oldest first. An empty repoID
+// lists locks in every repo.
+func (db *DB) ListLocks(repoID string) ([]*Lock, error) {
+	if repoID == "" {
+		return queryLocks(db.conn, `l.expires_at > ?`, time.Now())
+	}
+	return queryLocks(db.conn, `l.repo_id = ? AND l.expires_at > ?`, repoID, time.Now())
+}
+
+// ReleaseLock deletes a lock
+func (db *DB) ReleaseLock(id string) error {
+	tx, err := db.begin()
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := recordLockEvents(tx, EventLockReleased, nil, `l.id = ?`, id); err != nil {
+		return err
+	}
+	result, err := tx.Exec(`DELETE FROM locks WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("could not release lock: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("lock %q not found", id)
+	}
+	return tx.Commit()
+}
+
+// PurgeExpiredLocks deletes locks whose TTL has lapsed and returns how many
+// there were
+func (db *DB) PurgeExpiredLocks() (int, error) {
+	tx, err := db.begin()
+	if err != nil {
+		return 0, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var n int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM locks WHERE expires_at <= ?`, now).Scan(&n); err != nil {
+		return 0, fmt.Errorf("could not count expired locks: %w", err)
+	}
+	if err := purgeExpiredLocks(tx, now); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("could not commit transaction: %w", err)
+	}
+	return n, nil
+}
+
+func purgeExpiredLocks(tx *eventTx, now time.Time) error {
+	if err := recordLockEvents(tx, EventLockReleased, map[string]any{"reason": "expired"}, `l.expires_at <= ?`, now); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM locks WHERE expires_at <= ?`, now); err != nil {
+		return fmt.Errorf("could not purge expired locks: %w", err)
+	}
+	return nil
+}
+
+// recordLockEvents appends an event for every lock matching where, carrying
+// the lock's pattern
+func recordLockEvents(tx *eventTx, eventType string, payload map[string]any, where string, args ...any) error {
+	data, err := encodePayload(payload)
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec(
+		`INSERT INTO events (type, agent_id, actor, repo_id, entity_type, entity_id, payload, created_at)
+		 SELECT ?, l.agent_id, a.name, l.repo_id, 'lock', l.id,
+		        json_patch(COALESCE(?, '{}'), json_object('pattern', l.pattern)), ?
+		 FROM locks l LEFT JOIN agents a ON a.id = l.agent_id
+		 WHERE `+where,
+		append([]any{eventType, data, time.Now()}, args...)...,
+	)
+	if err != nil {
+		return fmt.Errorf("could not record %s event: %w", eventType, err)
+	}
+	return tx.collect(res)
+}
+
+// LockViolations returns the paths a worktree changes that fall under an
+// unexpired lock held by another agent or worktree in the same repo
+func (db *DB) LockViolations(wt *Worktree, paths []string) ([]LockViolation, error) {
+	locks, err := db.ListLocks(wt.RepoID)
+	if err != nil {
+		return nil, err
+	}
+
+	var violations []LockViolation
+	for _, l := range locks {
+		if l.HeldBy(wt.AgentID, &wt.ID) {
+			continue
+		}
+		for _, p := range paths {
+			if l.Matches(p) {
+				violations = append(violations, LockViolation{WorktreeID: wt.ID, Path: p, Lock: l})
+			}
+		}
+	}
+	return violations, nil
+}
+
+// FindLockViolations checks the recorded file touches of every active
+// worktree in a repo against the repo's locks
+func (db *DB) FindLockViolations(repoID string) ([]LockViolation, error) {
+	locks, err := db.ListLocks(repoID)
+	if err != nil || len(locks) == 0 {
+		return nil, err
+	}
+
+	rows, err := db.conn.Query(
+		`SELECT ft.worktree_id, ft.file_path, ft.uncommitted, w.agent_id
+		 FROM file_touches ft
+		 JOIN worktrees w ON ft.worktree_id = w.id
+		 WHERE ft.repo_id = ? AND w.status = 'active'
+		 ORDER BY ft.file_path, ft.worktree_id`,
+		repoID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not query file touches: %w", err)
+	}
+	defer rows.Close()
+
+	var violations []LockViolation
+	for rows.Next() {
+		var v LockViolation
+		var agentID *string
+		if err := rows.Scan(&v.WorktreeID, &v.Path, &v.Uncommitted, &agentID); err != nil {
+			return nil, fmt.Errorf("could not scan row: %w", err)
+		}
+		for _, l := range locks {
+			if !l.HeldBy(agentID, &v.WorktreeID) && l.Matches(v.Path) {
+				v.Lock = l
+				violations = append(violations, v)
+			}
+		}
+	}
+	return violations, rows.Err()
+}
@@ -0,0 +1,644 @@
+package registry
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrSchemaTooNew is returned when the database was migrated by a newer
+// agit binary than the one opening it.
+var ErrSchemaTooNew = errors.New("database schema is newer than this agit binary")
+
+// migration is a single numbered schema change. Each migration runs in its
+// own transaction together with its schema_migrations bookkeeping row.
+//
+// Migrations must be idempotent: databases created before versioning was
+// introduced have no schema_migrations table, so every migration is replayed
+// against them once.
+type migration struct {
+	version int
+	name    string
+	up      func(tx *sql.Tx) error
+}
+
+// migrations is the ordered schema history. Append new entries; never edit
+// or renumber one that has shipped.
+var migrations = []migration{
+	{1, "initial schema", migrateInitialSchema},
+	{2, "task priority", migrateTaskPriority},
+	{3, "task dependencies and blocked status", migrateTaskDependencies},
+	{4, "task leases", migrateTaskLeases},
+	{5, "file touch line ranges", migrateFileTouchRanges},
+	{6, "merge queue", migrateMergeQueue},
+	{7, "verify runs", migrateVerifyRuns},
+	{8, "event log", migrateEvents},
+	{9, "uncommitted file touches", migrateFileTouchUncommitted},
+	{10, "advisory locks", migrateLocks},
+	{11, "task file scopes", migrateTaskScope},
+	{12, "worktree base refs", migrateWorktreeBase},
+	{13, "worktree setup status", migrateWorktreeSetup},
+	{14, "webhook deliveries", migrateWebhookDeliveries},
+	{15, "hook runs", migrateHookRuns},
+	{16, "agent tokens", migrateAgentTokens},
+	{17, "one pending merge per worktree", migrateMergeQueueUnique},
+}
+
+// MigrationStatus describes whether a known migration has been applied
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt *time.Time
+}
+
+// LatestSchemaVersion returns the highest migration version this binary knows
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// SchemaVersion returns the highest migration version recorded in the database,
+// or 0 if no migrations have been recorded.
+func (db *DB) SchemaVersion() (int, error) {
+	if err := db.ensureMigrationsTable(); err != nil {
+		return 0, err
+	}
+	var version int
+	if err := db.conn.QueryRow(
+		`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`,
+	).Scan(&version); err != nil {
+		return 0, fmt.Errorf("could not read schema version: %w", err)
+	}
+	return version, nil
+}
+
+// MigrationStatus returns every known migration and whether it has been applied
+func (db *DB) MigrationStatus() ([]MigrationStatus, error) {
+	if err := db.ensureMigrationsTable(); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.conn.Query(`SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("could not read schema migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, fmt.Errorf("could not scan schema migration: %w", err)
+		}
+		applied[version] = at
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		st := MigrationStatus{Version: m.version, Name: m.name}
+		if at, ok := applied[m.version]; ok {
+			st.Applied = true
+			st.AppliedAt = &at
+		}
+		statuses = append(statuses, st)
+	}
+	return statuses, nil
+}
+
+// PendingMigrations returns the migrations that have not been applied yet
+func (db *DB) PendingMigrations() ([]MigrationStatus, error) {
+	if err := db.checkSchemaNotNewer(); err != nil {
+		return nil, err
+	}
+
+	statuses, err := db.MigrationStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []MigrationStatus
+	for _, st := range statuses {
+		if !st.Applied {
+			pending = append(pending, st)
+		}
+	}
+	return pending, nil
+}
+
+// Migrate applies all pending migrations in order and returns the ones it
+// applied. It returns ErrSchemaTooNew if the database records a version this
+// binary does not know about.
+func (db *DB) Migrate() ([]MigrationStatus, error) {
+	pending, err := db.PendingMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]migration)
+	for _, m := range migrations {
+		byVersion[m.version] = m
+	}
+
+	var applied []MigrationStatus
+	for _, st := range pending {
+		m := byVersion[st.Version]
+		at, ok, err := db.applyMigration(m)
+		if err != nil {
+			return applied, err
+		}
+		if !ok {
+			continue
+		}
+		st.Applied = true
+		st.AppliedAt = &at
+		applied = append(applied, st)
+	}
+	return applied, nil
+}
+
+// applyMigration runs a single migration and records it atomically. It
+// reports false if another process applied the migration first.
+//
+// The transaction takes SQLite's write lock before checking
+// schema_migrations, so that two processes opening an old database at once
+// apply each migration only once: the second waits for the first to commit,
+// then sees its bookkeeping row.
+//
+// Foreign key enforcement is switched off on the migration's connection so
+// that table rebuilds can drop a table other tables reference without the
+// drop cascading into them; rebuilds keep every row's ID, so references stay
+// valid once the new table is renamed into place.
+func (db *DB) applyMigration(m migration) (time.Time, bool, error) {
+	ctx := context.Background()
+	conn, err := db.conn.Conn(ctx)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("could not get connection: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys=OFF"); err != nil {
+		return time.Time{}, false, fmt.Errorf("could not disable foreign keys: %w", err)
+	}
+	defer conn.ExecContext(ctx, "PRAGMA foreign_keys=ON")
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// database/sql cannot issue BEGIN IMMEDIATE, but a write as the
+	// transaction's first statement takes the write lock the same way,
+	// waiting out the busy timeout instead of failing on a stale snapshot
+	if _, err := tx.Exec(`DELETE FROM schema_migrations WHERE version < 0`); err != nil {
+		return time.Time{}, false, fmt.Errorf("could not lock database for migration %d: %w", m.version, err)
+	}
+	var done bool
+	if err := tx.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = ?)`, m.version,
+	).Scan(&done); err != nil {
+		return time.Time{}, false, fmt.Errorf("could not read schema migrations: %w", err)
+	}
+	if done {
+		return time.Time{}, false, nil
+	}
+
+	if err := m.up(tx); err != nil {
+		return time.Time{}, false, fmt.Errorf("migration %d (%s) failed: %w", m.version, m.name, err)
+	}
+
+	now := time.Now()
+	if _, err := tx.Exec(
+		`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`,
+		m.version, m.name, now,
+	); err != nil {
+		return time.Time{}, false, fmt.Errorf("could not record migration %d: %w", m.version, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return time.Time{}, false, fmt.Errorf("could not commit migration %d: %w", m.version, err)
+	}
+	return now, true, nil
+}
+
+// ensureMigrationsTable creates the schema_migrations bookkeeping table
+func (db *DB) ensureMigrationsTable() error {
+	_, err := db.conn.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return fmt.Errorf("could not create schema_migrations table: %w", err)
+	}
+	return nil
+}
+
+// checkSchemaNotNewer guards against opening a database from a newer binary
+func (db *DB) checkSchemaNotNewer() error {
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if latest := LatestSchemaVersion(); version > latest {
+		return fmt.Errorf("%w: database is at version %d, this binary supports up to %d; upgrade agit",
+			ErrSchemaTooNew, version, latest)
+	}
+	return nil
+}
+
+// execAll runs each statement in order within the transaction
+func execAll(tx *sql.Tx, stmts ...string) error {
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasColumn reports whether table has a column with the given name
+func hasColumn(tx *sql.Tx, table, column string) (bool, error) {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dflt, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// tableSQL returns the CREATE statement SQLite stored for a table
+func tableSQL(tx *sql.Tx, table string) (string, error) {
+	var schema string
+	err := tx.QueryRow(
+		`SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?`, table,
+	).Scan(&schema)
+	return schema, err
+}
+
+func migrateInitialSchema(tx *sql.Tx) error {
+	return execAll(tx,
+		`CREATE TABLE IF NOT EXISTS repos (
+			id TEXT PRIMARY KEY,
+			name TEXT UNIQUE NOT NULL,
+			path TEXT NOT NULL,
+			remote_url TEXT NOT NULL DEFAULT '',
+			default_branch TEXT NOT NULL DEFAULT 'main',
+			added_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			last_synced TIMESTAMP,
+			metadata JSON DEFAULT '{}'
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS worktrees (
+			id TEXT PRIMARY KEY,
+			repo_id TEXT NOT NULL REFERENCES repos(id) ON DELETE CASCADE,
+			path TEXT NOT NULL,
+			branch TEXT NOT NULL,
+			agent_id TEXT,
+			task_description TEXT,
+			status TEXT NOT NULL DEFAULT 'active' CHECK(status IN ('active', 'completed', 'stale', 'conflict')),
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS agents (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			type TEXT NOT NULL DEFAULT 'custom',
+			status TEXT NOT NULL DEFAULT 'active' CHECK(status IN ('active', 'idle', 'disconnected')),
+			current_worktree_id TEXT REFERENCES worktrees(id) ON DELETE SET NULL,
+			last_seen TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS tasks (
+			id TEXT PRIMARY KEY,
+			repo_id TEXT NOT NULL REFERENCES repos(id) ON DELETE CASCADE,
+			description TEXT NOT NULL,
+			status TEXT NOT NULL DEFAULT 'pending' CHECK(status IN ('pending', 'claimed', 'in_progress', 'completed', 'failed')),
+			assigned_agent_id TEXT REFERENCES agents(id) ON DELETE SET NULL,
+			worktree_id TEXT REFERENCES worktrees(id) ON DELETE SET NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			completed_at TIMESTAMP,
+			result TEXT
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS file_touches (
+			repo_id TEXT NOT NULL REFERENCES repos(id) ON DELETE CASCADE,
+			worktree_id TEXT NOT NULL REFERENCES worktrees(id) ON DELETE CASCADE,
+			file_path TEXT NOT NULL,
+			change_type TEXT NOT NULL DEFAULT 'modified' CHECK(change_type IN ('added', 'modified', 'deleted', 'renamed')),
+			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (repo_id, worktree_id, file_path)
+		)`,
+
+		// Indexes for common queries
+		`CREATE INDEX IF NOT EXISTS idx_worktrees_repo_id ON worktrees(repo_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_worktrees_status ON worktrees(status)`,
+		`CREATE INDEX IF NOT EXISTS idx_tasks_repo_id ON tasks(repo_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_tasks_status ON tasks(status)`,
+		`CREATE INDEX IF NOT EXISTS idx_file_touches_repo_worktree ON file_touches(repo_id, worktree_id)`,
+	)
+}
+
+func migrateTaskPriority(tx *sql.Tx) error {
+	exists, err := hasColumn(tx, "tasks", "priority")
+	if err != nil || exists {
+		return err
+	}
+	return execAll(tx, `ALTER TABLE tasks ADD COLUMN priority INTEGER NOT NULL DEFAULT 0`)
+}
+
+// migrateTaskDependencies adds the 'blocked' task status and the
+// task_dependencies join table. SQLite cannot alter a CHECK constraint in
+// place, so the tasks table is copied into a new definition and swapped in.
+func migrateTaskDependencies(tx *sql.Tx) error {
+	schema, err := tableSQL(tx, "tasks")
+	if err != nil {
+		return err
+	}
+
+	if !strings.Contains(schema, "'blocked'") {
+		if err := execAll(tx,
+			`CREATE TABLE tasks_new (
+				id TEXT PRIMARY KEY,
+				repo_id TEXT NOT NULL REFERENCES repos(id) ON DELETE CASCADE,
+				description TEXT NOT NULL,
+				status TEXT NOT NULL DEFAULT 'pending' CHECK(status IN ('pending', 'claimed', 'in_progress', 'completed', 'failed', 'blocked')),
+				assigned_agent_id TEXT REFERENCES agents(id) ON DELETE SET NULL,
+				worktree_id TEXT REFERENCES worktrees(id) ON DELETE SET NULL,
+				created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				completed_at TIMESTAMP,
+				result TEXT,
+				priority INTEGER NOT NULL DEFAULT 0
+			)`,
+			`INSERT INTO tasks_new (id, repo_id, description, status, assigned_agent_id, worktree_id, created_at, completed_at, result, priority)
+			 SELECT id, repo_id, description, status, assigned_agent_id, worktree_id, created_at, completed_at, result, priority FROM tasks`,
+			`DROP TABLE tasks`,
+			`ALTER TABLE tasks_new RENAME TO tasks`,
+			`CREATE INDEX IF NOT EXISTS idx_tasks_repo_id ON tasks(repo_id)`,
+			`CREATE INDEX IF NOT EXISTS idx_tasks_status ON tasks(status)`,
+		); err != nil {
+			return fmt.Errorf("could not rebuild tasks table: %w", err)
+		}
+	}
+
+	return execAll(tx,
+		`CREATE TABLE IF NOT EXISTS task_dependencies (
+			task_id TEXT NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+			depends_on_id TEXT NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+			PRIMARY KEY (task_id, depends_on_id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_task_dependencies_depends_on ON task_dependencies(depends_on_id)`,
+	)
+}
+
+func migrateTaskLeases(tx *sql.Tx) error {
+	for _, col := range []struct{ name, def string }{
+		{"lease_expires_at", `ALTER TABLE tasks ADD COLUMN lease_expires_at TIMESTAMP`},
+		{"attempts", `ALTER TABLE tasks ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0`},
+	} {
+		exists, err := hasColumn(tx, "tasks", col.name)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			if err := execAll(tx, col.def); err != nil {
+				return err
+			}
+		}
+	}
+	return execAll(tx, `CREATE INDEX IF NOT EXISTS idx_tasks_lease_expires_at ON tasks(lease_expires_at)`)
+}
+
+func migrateFileTouchRanges(tx *sql.Tx) error {
+	exists, err := hasColumn(tx, "file_touches", "line_ranges")
+	if err != nil || exists {
+		return err
+	}
+	return execAll(tx, `ALTER TABLE file_touches ADD COLUMN line_ranges TEXT`)
+}
+
+func migrateMergeQueue(tx *sql.Tx) error {
+	return execAll(tx,
+		`CREATE TABLE IF NOT EXISTS merge_queue (
+			id TEXT PRIMARY KEY,
+			repo_id TEXT NOT NULL REFERENCES repos(id) ON DELETE CASCADE,
+			worktree_id TEXT NOT NULL REFERENCES worktrees(id) ON DELETE CASCADE,
+			strategy TEXT NOT NULL DEFAULT '',
+			cleanup BOOLEAN NOT NULL DEFAULT 0,
+			agent_id TEXT,
+			status TEXT NOT NULL DEFAULT 'queued' CHECK(status IN ('queued', 'merging', 'merged', 'conflict', 'failed', 'cancelled')),
+			result TEXT,
+			enqueued_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			started_at TIMESTAMP,
+			finished_at TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_merge_queue_repo_status ON merge_queue(repo_id, status)`,
+	)
+}
+
+func migrateVerifyRuns(tx *sql.Tx) error {
+	return execAll(tx,
+		`CREATE TABLE IF NOT EXISTS verify_runs (
+			id TEXT PRIMARY KEY,
+			worktree_id TEXT NOT NULL REFERENCES worktrees(id) ON DELETE CASCADE,
+			commit_sha TEXT NOT NULL DEFAULT '',
+			status TEXT NOT NULL CHECK(status IN ('passed', 'failed', 'skipped')),
+			skipped_by TEXT,
+			steps TEXT,
+			started_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			finished_at TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_verify_runs_worktree ON verify_runs(worktree_id, started_at)`,
+	)
+}
+
+func migrateEvents(tx *sql.Tx) error {
+	return execAll(tx,
+		`CREATE TABLE IF NOT EXISTS events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			type TEXT NOT NULL,
+			agent_id TEXT,
+			actor TEXT,
+			repo_id TEXT,
+			entity_type TEXT NOT NULL,
+			entity_id TEXT NOT NULL,
+			payload TEXT,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_events_repo ON events(repo_id, id)`,
+		`CREATE INDEX IF NOT EXISTS idx_events_type ON events(type)`,
+		`CREATE INDEX IF NOT EXISTS idx_events_created_at ON events(created_at)`,
+	)
+}
+
+// migrateFileTouchUncommitted flags touches that come from edits still in a
+// worktree's working tree rather than from its commits
+func migrateFileTouchUncommitted(tx *sql.Tx) error {
+	exists, err := hasColumn(tx, "file_touches", "uncommitted")
+	if err != nil || exists {
+		return err
+	}
+	return execAll(tx, `ALTER TABLE file_touches ADD COLUMN uncommitted BOOLEAN NOT NULL DEFAULT 0`)
+}
+
+func migrateLocks(tx *sql.Tx) error {
+	return execAll(tx,
+		`CREATE TABLE IF NOT EXISTS locks (
+			id TEXT PRIMARY KEY,
+			repo_id TEXT NOT NULL REFERENCES repos(id) ON DELETE CASCADE,
+			pattern TEXT NOT NULL,
+			agent_id TEXT REFERENCES agents(id) ON DELETE CASCADE,
+			worktree_id TEXT REFERENCES worktrees(id) ON DELETE CASCADE,
+			reason TEXT,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			expires_at TIMESTAMP NOT NULL,
+			CHECK(agent_id IS NOT NULL OR worktree_id IS NOT NULL)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_locks_repo ON locks(repo_id, expires_at)`,
+	)
+}
+
+// migrateTaskScope adds the path globs a task is expected to touch, stored as
+// a JSON array
+func migrateTaskScope(tx *sql.Tx) error {
+	exists, err := hasColumn(tx, "tasks", "scope")
+	if err != nil || exists {
+		return err
+	}
+	return execAll(tx, `ALTER TABLE tasks ADD COLUMN scope TEXT`)
+}
+
+// migrateWorktreeBase records the ref each worktree was branched from and
+// whether its branch already existed when the worktree was spawned
+func migrateWorktreeBase(tx *sql.Tx) error {
+	exists, err := hasColumn(tx, "worktrees", "base_ref")
+	if err != nil || exists {
+		return err
+	}
+	return execAll(tx,
+		`ALTER TABLE worktrees ADD COLUMN base_ref TEXT`,
+		`ALTER TABLE worktrees ADD COLUMN existing_branch BOOLEAN NOT NULL DEFAULT 0`,
+	)
+}
+
+// migrateWorktreeSetup adds the 'setup_failed' worktree status and records
+// the outcome of each worktree's setup recipe. Like migrateTaskDependencies,
+// it copies the worktrees table into a new definition to change the CHECK
+// constraint.
+func migrateWorktreeSetup(tx *sql.Tx) error {
+	schema, err := tableSQL(tx, "worktrees")
+	if err != nil {
+		return err
+	}
+	if strings.Contains(schema, "'setup_failed'") {
+		return nil
+	}
+
+	if err := execAll(tx,
+		`CREATE TABLE worktrees_new (
+			id TEXT PRIMARY KEY,
+			repo_id TEXT NOT NULL REFERENCES repos(id) ON DELETE CASCADE,
+			path TEXT NOT NULL,
+			branch TEXT NOT NULL,
+			agent_id TEXT,
+			task_description TEXT,
+			status TEXT NOT NULL DEFAULT 'active' CHECK(status IN ('active', 'completed', 'stale', 'conflict', 'setup_failed')),
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			base_ref TEXT,
+			existing_branch BOOLEAN NOT NULL DEFAULT 0,
+			setup_status TEXT CHECK(setup_status IN ('passed', 'failed')),
+			setup_steps TEXT
+		)`,
+		`INSERT INTO worktrees_new (id, repo_id, path, branch, agent_id, task_description, status, created_at, updated_at, base_ref, existing_branch)
+		 SELECT id, repo_id, path, branch, agent_id, task_description, status, created_at, updated_at, base_ref, existing_branch FROM worktrees`,
+		`DROP TABLE worktrees`,
+		`ALTER TABLE worktrees_new RENAME TO worktrees`,
+		`CREATE INDEX IF NOT EXISTS idx_worktrees_repo_id ON worktrees(repo_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_worktrees_status ON worktrees(status)`,
+	); err != nil {
+		return fmt.Errorf("could not rebuild worktrees table: %w", err)
+	}
+	return nil
+}
+
+func migrateWebhookDeliveries(tx *sql.Tx) error {
+	return execAll(tx,
+		`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id TEXT PRIMARY KEY,
+			webhook TEXT NOT NULL,
+			event TEXT NOT NULL,
+			url TEXT NOT NULL,
+			body TEXT NOT NULL,
+			status TEXT NOT NULL DEFAULT 'failed' CHECK(status IN ('failed', 'delivered')),
+			attempts INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			delivered_at TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_status ON webhook_deliveries(status, created_at)`,
+	)
+}
+
+func migrateHookRuns(tx *sql.Tx) error {
+	return execAll(tx,
+		`CREATE TABLE IF NOT EXISTS hook_runs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			event TEXT NOT NULL,
+			command TEXT NOT NULL,
+			repo TEXT NOT NULL DEFAULT '',
+			exit_code INTEGER NOT NULL DEFAULT 0,
+			duration_ms INTEGER NOT NULL DEFAULT 0,
+			stdout TEXT NOT NULL DEFAULT '',
+			stderr TEXT NOT NULL DEFAULT '',
+			timed_out INTEGER NOT NULL DEFAULT 0,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_hook_runs_event ON hook_runs(event, id)`,
+	)
+}
+
+func migrateAgentTokens(tx *sql.Tx) error {
+	return execAll(tx,
+		`CREATE TABLE IF NOT EXISTS agent_tokens (
+			id TEXT PRIMARY KEY,
+			agent_id TEXT NOT NULL REFERENCES agents(id) ON DELETE CASCADE,
+			token_hash TEXT NOT NULL UNIQUE,
+			hint TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			last_used_at TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_agent_tokens_agent ON agent_tokens(agent_id)`,
+	)
+}
+
+// migrateMergeQueueUnique allows a worktree at most one queued or merging
+// entry. Duplicates that concurrent enqueues let in before the index existed
+// are cancelled, keeping each worktree's oldest entry.
+func migrateMergeQueueUnique(tx *sql.Tx) error {
+	return execAll(tx,
+		`UPDATE merge_queue SET status = 'cancelled', finished_at = CURRENT_TIMESTAMP
+		 WHERE status IN ('queued', 'merging') AND rowid NOT IN (
+		   SELECT MIN(rowid) FROM merge_queue WHERE status IN ('queued', 'merging') GROUP BY worktree_id
+		 )`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_merge_queue_pending_worktree
+		 ON merge_queue(worktree_id) WHERE status IN ('queued', 'merging')`,
+	)
+}
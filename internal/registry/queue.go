@@ -0,0 +1,311 @@
+package registry
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// MergeLockTimeout is how long a queue entry may stay in the merging state
+// without being renewed before it is assumed abandoned by a crashed worker
+// and requeued. Workers renew their entry with RenewMerge while they merge.
+const MergeLockTimeout = 10 * time.Minute
+
+// ErrAlreadyQueued is returned when a worktree already has a queued or
+// merging entry
+var ErrAlreadyQueued = errors.New("already in the merge queue")
+
+// ErrMergeInProgress is returned by ClaimMergeSlot while another merge into
+// the repo is running
+var ErrMergeInProgress = errors.New("another merge into this repository is in progress")
+
+// MergeQueueEntry is a worktree waiting to be merged into its repo's default branch
+type MergeQueueEntry struct {
+	ID         string
+	RepoID     string
+	WorktreeID string
+	Strategy   string // empty means the repo default
+	Cleanup    bool
+	AgentID    *string
+	Status     string // queued, merging, merged, conflict, failed, cancelled
+	Result     *string
+	EnqueuedAt time.Time
+	StartedAt  *time.Time
+	FinishedAt *time.Time
+}
+
+const queueColumns = `id, repo_id, worktree_id, strategy, cleanup, agent_id, status, result,
+	enqueued_at, started_at, finished_at`
+
+func scanQueueEntry(row rowScanner) (*MergeQueueEntry, error) {
+	e := &MergeQueueEntry{}
+	err := row.Scan(&e.ID, &e.RepoID, &e.WorktreeID, &e.Strategy, &e.Cleanup, &e.AgentID, &e.Status,
+		&e.Result, &e.EnqueuedAt, &e.StartedAt, &e.FinishedAt)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// EnqueueMerge adds a worktree to the end of its repo's merge queue.
+// A worktree can only have one queued or merging entry at a time. A worktree
+// an earlier merge bounced with the conflict status is made active again in
+// the same transaction, so that it can be retried.
+func (db *DB) EnqueueMerge(repoID, worktreeID, strategy string, cleanup bool, agentID *string) (*MergeQueueEntry, error) {
+	e := &MergeQueueEntry{
+		ID:         uuid.New().String(),
+		RepoID:     repoID,
+		WorktreeID: worktreeID,
+		Strategy:   strategy,
+		Cleanup:    cleanup,
+		AgentID:    agentID,
+		Status:     "queued",
+		EnqueuedAt: time.Now(),
+	}
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
+		`INSERT INTO merge_queue (id, repo_id, worktree_id, strategy, cleanup, agent_id, status, enqueued_at)
+		 VALUES (?, ?, ?, ?, ?, ?, 'queued', ?)`,
+		e.ID, e.RepoID, e.WorktreeID, e.Strategy, e.Cleanup, e.AgentID, e.EnqueuedAt,
+	); err != nil {
+		if isUniqueViolation(err) {
+			tx.Rollback()
+			return nil, db.alreadyQueued(worktreeID)
+		}
+		return nil, fmt.Errorf("could not enqueue merge: %w", err)
+	}
+	if _, err := tx.Exec(
+		`UPDATE worktrees SET status = 'active', updated_at = ? WHERE id = ? AND status = 'conflict'`,
+		e.EnqueuedAt, worktreeID,
+	); err != nil {
+		return nil, fmt.Errorf("could not reactivate worktree: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not commit transaction: %w", err)
+	}
+	return e, nil
+}
+
+// alreadyQueued describes the pending entry that kept a worktree from being
+// queued again
+func (db *DB) alreadyQueued(worktreeID string) error {
+	var existing string
+	if err := db.conn.QueryRow(
+		`SELECT id FROM merge_queue WHERE worktree_id = ? AND status IN ('queued', 'merging')`,
+		worktreeID,
+	).Scan(&existing); err != nil {
+		return fmt.Errorf("worktree %q is %w", worktreeID, ErrAlreadyQueued)
+	}
+	return fmt.Errorf("worktree %q is %w (entry %s)", worktreeID, ErrAlreadyQueued, existing)
+}
+
+// GetMergeQueueEntry retrieves a queue entry by ID
+func (db *DB) GetMergeQueueEntry(id string) (*MergeQueueEntry, error) {
+	e, err := scanQueueEntry(db.conn.QueryRow(
+		`SELECT `+queueColumns+` FROM merge_queue WHERE id = ?`, id,
+	))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("merge queue entry %q not found", id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get merge queue entry: %w", err)
+	}
+	return e, nil
+}
+
+// ListMergeQueue returns a repo's queue in merge order. Finished entries are
+// included only when all is true.
+func (db *DB) ListMergeQueue(repoID string, all bool) ([]*MergeQueueEntry, error) {
+	query := `SELECT ` + queueColumns + ` FROM merge_queue WHERE repo_id = ?`
+	if !all {
+		query += ` AND status IN ('queued', 'merging')`
+	}
+	query += ` ORDER BY enqueued_at ASC, rowid ASC`
+
+	rows, err := db.conn.Query(query, repoID)
+	if err != nil {
+		return nil, fmt.Errorf("could not list merge queue: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []*MergeQueueEntry
+	for rows.Next() {
+		e, err := scanQueueEntry(rows)
+		if err != nil {
+			return nil, fmt.Errorf("could not scan merge queue entry: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// ClaimNextMerge atomically moves the oldest queued entry of a repo to the
+// merging state and returns it. Only one entry per repo may be merging at a
+// time, so this returns nil while another worker holds the queue, or when
+// the queue is empty. Merging entries older than MergeLockTimeout are
+// treated as abandoned and requeued first.
+func (db *DB) ClaimNextMerge(repoID string) (*MergeQueueEntry, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	if err := requeueAbandonedMerges(tx, repoID, now); err != nil {
+		return nil, err
+	}
+
+	result, err := tx.Exec(
+		`UPDATE merge_queue SET status = 'merging', started_at = ?
+		 WHERE id = (
+		   SELECT id FROM merge_queue
+		   WHERE repo_id = ? AND status = 'queued'
+		   ORDER BY enqueued_at ASC, rowid ASC
+		   LIMIT 1
+		 )
+		 AND NOT EXISTS (SELECT 1 FROM merge_queue WHERE repo_id = ? AND status = 'merging')`,
+		now, repoID, repoID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not claim next merge: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, nil
+	}
+
+	e, err := scanQueueEntry(tx.QueryRow(
+		`SELECT `+queueColumns+` FROM merge_queue WHERE repo_id = ? AND status = 'merging'`, repoID,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch claimed merge: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not commit transaction: %w", err)
+	}
+	return e, nil
+}
+
+// ClaimMergeSlot records a merge that runs outside the queue, such as
+// agit merge, as a merging entry of its repo's queue, so that it holds the
+// same one-merge-per-repo slot as queued merges. It returns
+// ErrMergeInProgress while another merge holds the slot, and
+// ErrAlreadyQueued if the worktree is waiting in the queue.
+func (db *DB) ClaimMergeSlot(repoID, worktreeID, strategy string, agentID *string) (*MergeQueueEntry, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	if err := requeueAbandonedMerges(tx, repoID, now); err != nil {
+		return nil, err
+	}
+	e := &MergeQueueEntry{
+		ID:         uuid.New().String(),
+		RepoID:     repoID,
+		WorktreeID: worktreeID,
+		Strategy:   strategy,
+		AgentID:    agentID,
+		Status:     "merging",
+		EnqueuedAt: now,
+		StartedAt:  &now,
+	}
+	result, err := tx.Exec(
+		`INSERT INTO merge_queue (id, repo_id, worktree_id, strategy, cleanup, agent_id, status, enqueued_at, started_at)
+		 SELECT ?, ?, ?, ?, 0, ?, 'merging', ?, ?
+		 WHERE NOT EXISTS (SELECT 1 FROM merge_queue WHERE repo_id = ? AND status = 'merging')`,
+		e.ID, e.RepoID, e.WorktreeID, e.Strategy, e.AgentID, now, now, repoID,
+	)
+	if err != nil {
+		if isUniqueViolation(err) {
+			tx.Rollback()
+			return nil, db.alreadyQueued(worktreeID)
+		}
+		return nil, fmt.Errorf("could not claim merge slot: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, ErrMergeInProgress
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not commit transaction: %w", err)
+	}
+	return e, nil
+}
+
+// requeueAbandonedMerges puts merging entries whose lock has timed out back
+// in the queue
+func requeueAbandonedMerges(tx *sql.Tx, repoID string, now time.Time) error {
+	if _, err := tx.Exec(
+		`UPDATE merge_queue SET status = 'queued', started_at = NULL
+		 WHERE repo_id = ? AND status = 'merging' AND started_at < ?`,
+		repoID, now.Add(-MergeLockTimeout),
+	); err != nil {
+		return fmt.Errorf("could not requeue abandoned merges: %w", err)
+	}
+	return nil
+}
+
+// RenewMerge restarts the lock timeout of a merging entry, so that other
+// workers don't requeue it while slow verify commands run
+func (db *DB) RenewMerge(id string) error {
+	if _, err := db.conn.Exec(
+		`UPDATE merge_queue SET started_at = ? WHERE id = ? AND status = 'merging'`,
+		time.Now(), id,
+	); err != nil {
+		return fmt.Errorf("could not renew merge: %w", err)
+	}
+	return nil
+}
+
+// FinishMerge records the outcome of a merging entry
+func (db *DB) FinishMerge(id, status string, result *string) error {
+	res, err := db.conn.Exec(
+		`UPDATE merge_queue SET status = ?, result = ?, finished_at = ? WHERE id = ?`,
+		status, result, time.Now(), id,
+	)
+	if err != nil {
+		return fmt.Errorf("could not finish merge: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("merge queue entry %q not found", id)
+	}
+	return nil
+}
+
+// CancelMerge removes a queued entry from the queue. Entries already being
+// merged cannot be cancelled.
+func (db *DB) CancelMerge(id string) error {
+	res, err := db.conn.Exec(
+		`UPDATE merge_queue SET status = 'cancelled', finished_at = ? WHERE id = ? AND status = 'queued'`,
+		time.Now(), id,
+	)
+	if err != nil {
+		return fmt.Errorf("could not cancel merge: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("merge queue entry %q not found or not queued", id)
+	}
+	return nil
+}
+
+// isUniqueViolation reports whether err is SQLite refusing a row that breaks
+// a unique constraint
+func isUniqueViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+}
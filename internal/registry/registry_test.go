@@ -1,9 +1,17 @@
 package registry
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/fathindos/agit/internal/lines"
 )
 
 func mustOpenMemory(t *testing.T) *DB {
@@ -261,6 +269,66 @@ func TestResolveWorktree(t *testing.T) {
 	}
 }
 
+func TestCreateWorktreeWithOptions(t *testing.T) {
+	db := mustOpenMemory(t)
+
+	repo, _ := db.AddRepo("wo", "/tmp/wo", "", "main")
+	base := "release/1.2"
+	wt, err := db.CreateWorktreeWithOptions(repo.ID, "/tmp/wo1", "hotfix", WorktreeOptions{BaseRef: &base, ExistingBranch: true})
+	if err != nil {
+		t.Fatalf("CreateWorktreeWithOptions: %v", err)
+	}
+
+	got, err := db.GetWorktree(wt.ID)
+	if err != nil {
+		t.Fatalf("GetWorktree: %v", err)
+	}
+	if got.Base("main") != "release/1.2" {
+		t.Errorf("expected base release/1.2, got %s", got.Base("main"))
+	}
+	if got.OwnsBranch() {
+		t.Error("expected an existing branch not to be owned")
+	}
+
+	plain, _ := db.CreateWorktree(repo.ID, "/tmp/wo2", "feat", nil, nil)
+	got, _ = db.GetWorktree(plain.ID)
+	if got.Base("main") != "main" || !got.OwnsBranch() {
+		t.Errorf("expected default base and owned branch, got base %s owned %v", got.Base("main"), got.OwnsBranch())
+	}
+}
+
+func TestRecordWorktreeSetup(t *testing.T) {
+	db := mustOpenMemory(t)
+
+	repo, _ := db.AddRepo("ws", "/tmp/ws", "", "main")
+	wt, _ := db.CreateWorktree(repo.ID, "/tmp/ws1", "feat", nil, nil)
+
+	steps := []VerifyStep{{Command: "copy .env"}, {Command: "npm ci", ExitCode: 1, Output: "boom"}}
+	if err := db.RecordWorktreeSetup(wt.ID, false, steps); err != nil {
+		t.Fatalf("RecordWorktreeSetup: %v", err)
+	}
+	got, _ := db.GetWorktree(wt.ID)
+	if got.Status != "setup_failed" || got.SetupStatus == nil || *got.SetupStatus != "failed" || len(got.SetupSteps) != 2 {
+		t.Fatalf("expected failed setup recorded, got %+v", got)
+	}
+	if got.SetupSteps[1].Output != "boom" {
+		t.Errorf("expected step output kept, got %+v", got.SetupSteps[1])
+	}
+
+	events, _ := db.ListEvents(EventFilter{EntityID: wt.ID, Type: EventWorktreeSetup})
+	if len(events) != 1 || events[0].Payload["failed_step"] != "npm ci" {
+		t.Errorf("expected a worktree.setup event naming the failed step, got %+v", events)
+	}
+
+	if err := db.RecordWorktreeSetup(wt.ID, true, steps[:1]); err != nil {
+		t.Fatalf("RecordWorktreeSetup: %v", err)
+	}
+	got, _ = db.GetWorktree(wt.ID)
+	if got.Status != "active" || *got.SetupStatus != "passed" {
+		t.Errorf("expected a passing re-run to reactivate the worktree, got %s/%s", got.Status, *got.SetupStatus)
+	}
+}
+
 // --- Agents ---
 
 func TestRegisterAndGetAgent(t *testing.T) {
@@ -546,6 +614,40 @@ func TestRecordFileTouchesAndFindConflicts(t *testing.T) {
 	}
 }
 
+func TestRecordFileTouchesLogsChanges(t *testing.T) {
+	db := mustOpenMemory(t)
+
+	repo, _ := db.AddRepo("tu", "/tmp/tu", "", "main")
+	wt, _ := db.CreateWorktree(repo.ID, "/tmp/tu1", "b1", nil, nil)
+	updates := func() int {
+		t.Helper()
+		events, err := db.ListEvents(EventFilter{Type: EventTouchesUpdated})
+		if err != nil {
+			t.Fatalf("ListEvents: %v", err)
+		}
+		return len(events)
+	}
+
+	touches := []FileTouch{{FilePath: "main.go", Ranges: []lines.Range{{Start: 1, End: 4}}}}
+	db.RecordFileTouches(repo.ID, wt.ID, touches)
+	if n := updates(); n != 1 {
+		t.Fatalf("expected 1 touches.updated event, got %d", n)
+	}
+
+	// An identical rescan changes nothing
+	db.RecordFileTouches(repo.ID, wt.ID, touches)
+	if n := updates(); n != 1 {
+		t.Fatalf("expected an unchanged rescan to log nothing, got %d events", n)
+	}
+
+	touches[0].Uncommitted = true
+	db.RecordFileTouches(repo.ID, wt.ID, touches)
+	db.RecordFileTouches(repo.ID, wt.ID, nil)
+	if n := updates(); n != 3 {
+		t.Fatalf("expected 3 touches.updated events, got %d", n)
+	}
+}
+
 func TestFindConflictsNoOverlap(t *testing.T) {
 	db := mustOpenMemory(t)
 
@@ -676,6 +778,43 @@ func TestNextTaskConcurrentClaim(t *testing.T) {
 	}
 }
 
+func TestNextTaskCheckedRefusal(t *testing.T) {
+	db := mustOpenMemory(t)
+
+	repo, _ := db.AddRepo("ntc", "/tmp/ntc", "", "main")
+	agent, _ := db.RegisterAgent("ntc-agent", "custom")
+	task, _ := db.CreateTask(repo.ID, "guarded", 5)
+
+	refused := fmt.Errorf("not today")
+	var checked string
+	_, err := db.NextTaskChecked(repo.ID, agent.ID, func(t *Task) error {
+		checked = t.ID
+		return refused
+	})
+	if err != refused || checked != task.ID {
+		t.Fatalf("expected the check's error for %s, got %v (checked %q)", task.ID, err, checked)
+	}
+	if got, _ := db.GetTask(task.ID); got.Status != "pending" || got.Attempts != 0 {
+		t.Errorf("expected refused task to stay pending and unattempted, got %s/%d", got.Status, got.Attempts)
+	}
+
+	// A task claimed while the check runs is skipped for the next candidate
+	other, _ := db.CreateTask(repo.ID, "fallback", 1)
+	rival, _ := db.RegisterAgent("ntc-rival", "custom")
+	claimed, err := db.NextTaskChecked(repo.ID, agent.ID, func(t *Task) error {
+		if t.ID == task.ID {
+			return db.ClaimTask(task.ID, rival.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("NextTaskChecked: %v", err)
+	}
+	if claimed == nil || claimed.ID != other.ID || claimed.Status != "claimed" {
+		t.Errorf("expected fallback task to be claimed, got %+v", claimed)
+	}
+}
+
 func TestRecordFileTouchesReplace(t *testing.T) {
 	db := mustOpenMemory(t)
 
@@ -734,3 +873,1472 @@ func BenchmarkNextTask(b *testing.B) {
 		}
 	}
 }
+
+// --- Task Dependencies ---
+
+func TestAddTaskDependencyRejectsCycles(t *testing.T) {
+	db := mustOpenMemory(t)
+
+	repo, _ := db.AddRepo("dep", "/tmp/dep", "", "main")
+	a, _ := db.CreateTask(repo.ID, "a", 0)
+	b, _ := db.CreateTask(repo.ID, "b", 0)
+	c, _ := db.CreateTask(repo.ID, "c", 0)
+
+	if err := db.AddTaskDependency(b.ID, a.ID); err != nil {
+		t.Fatalf("AddTaskDependency b->a: %v", err)
+	}
+	if err := db.AddTaskDependency(c.ID, b.ID); err != nil {
+		t.Fatalf("AddTaskDependency c->b: %v", err)
+	}
+
+	if err := db.AddTaskDependency(a.ID, c.ID); err == nil {
+		t.Fatal("expected cycle a->c->b->a to be rejected")
+	}
+	if err := db.AddTaskDependency(a.ID, a.ID); err == nil {
+		t.Fatal("expected self-dependency to be rejected")
+	}
+
+	deps, err := db.GetTaskDependencies(c.ID)
+	if err != nil {
+		t.Fatalf("GetTaskDependencies: %v", err)
+	}
+	if len(deps) != 1 || deps[0] != b.ID {
+		t.Errorf("expected c to depend on b, got %v", deps)
+	}
+
+	edges, err := db.ListTaskDependencies(repo.ID)
+	if err != nil {
+		t.Fatalf("ListTaskDependencies: %v", err)
+	}
+	if len(edges) != 2 {
+		t.Errorf("expected 2 edges, got %d", len(edges))
+	}
+}
+
+func TestAddTaskDependencyCrossRepo(t *testing.T) {
+	db := mustOpenMemory(t)
+
+	r1, _ := db.AddRepo("r1", "/tmp/r1", "", "main")
+	r2, _ := db.AddRepo("r2", "/tmp/r2", "", "main")
+	a, _ := db.CreateTask(r1.ID, "a", 0)
+	b, _ := db.CreateTask(r2.ID, "b", 0)
+
+	if err := db.AddTaskDependency(a.ID, b.ID); err == nil {
+		t.Fatal("expected error for cross-repo dependency")
+	}
+}
+
+func TestCreateTaskWithDeps(t *testing.T) {
+	db := mustOpenMemory(t)
+
+	r1, _ := db.AddRepo("r1", "/tmp/r1", "", "main")
+	r2, _ := db.AddRepo("r2", "/tmp/r2", "", "main")
+	a, _ := db.CreateTask(r1.ID, "a", 0)
+	other, _ := db.CreateTask(r2.ID, "other", 0)
+
+	for _, deps := range [][]string{{a.ID, "t-missing"}, {a.ID, other.ID}} {
+		if _, err := db.CreateTaskWithDeps(r1.ID, "b", 0, deps); err == nil {
+			t.Fatalf("expected error for dependencies %v", deps)
+		}
+	}
+	if tasks, _ := db.ListTasks(r1.ID, nil); len(tasks) != 1 {
+		t.Fatalf("expected a failed create to leave no task behind, got %d tasks", len(tasks))
+	}
+
+	b, err := db.CreateTaskWithDeps(r1.ID, "b", 0, []string{a.ID}, "src/**")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deps, _ := db.GetTaskDependencies(b.ID); len(deps) != 1 || deps[0] != a.ID {
+		t.Errorf("expected b to depend on a, got %v", deps)
+	}
+}
+
+func TestNextTaskSkipsUnmetDependencies(t *testing.T) {
+	db := mustOpenMemory(t)
+
+	repo, _ := db.AddRepo("ntd", "/tmp/ntd", "", "main")
+	agent, _ := db.RegisterAgent("ntd-agent", "custom")
+
+	base, _ := db.CreateTask(repo.ID, "base", 0)
+	follow, _ := db.CreateTask(repo.ID, "follow-up", 10)
+	db.AddTaskDependency(follow.ID, base.ID)
+
+	task, err := db.NextTask(repo.ID, agent.ID)
+	if err != nil {
+		t.Fatalf("NextTask: %v", err)
+	}
+	if task == nil || task.ID != base.ID {
+		t.Fatalf("expected base task despite lower priority, got %+v", task)
+	}
+
+	// The follow-up stays unavailable until its dependency completes
+	task, _ = db.NextTask(repo.ID, agent.ID)
+	if task != nil {
+		t.Fatalf("expected no ready task, got %s", task.ID)
+	}
+
+	db.CompleteTask(base.ID, nil)
+	task, _ = db.NextTask(repo.ID, agent.ID)
+	if task == nil || task.ID != follow.ID {
+		t.Fatalf("expected follow-up task after base completed, got %+v", task)
+	}
+}
+
+func TestBlockDependents(t *testing.T) {
+	db := mustOpenMemory(t)
+
+	repo, _ := db.AddRepo("bd", "/tmp/bd", "", "main")
+	a, _ := db.CreateTask(repo.ID, "a", 0)
+	b, _ := db.CreateTask(repo.ID, "b", 0)
+	c, _ := db.CreateTask(repo.ID, "c", 0)
+	other, _ := db.CreateTask(repo.ID, "unrelated", 0)
+	db.AddTaskDependency(b.ID, a.ID)
+	db.AddTaskDependency(c.ID, b.ID)
+
+	db.FailTask(a.ID, nil)
+	blocked, err := db.BlockDependents(a.ID)
+	if err != nil {
+		t.Fatalf("BlockDependents: %v", err)
+	}
+	if len(blocked) != 2 {
+		t.Fatalf("expected 2 blocked tasks, got %v", blocked)
+	}
+
+	for _, id := range []string{b.ID, c.ID} {
+		got, _ := db.GetTask(id)
+		if got.Status != "blocked" {
+			t.Errorf("task %s: expected blocked, got %s", id, got.Status)
+		}
+	}
+	got, _ := db.GetTask(other.ID)
+	if got.Status != "pending" {
+		t.Errorf("unrelated task should stay pending, got %s", got.Status)
+	}
+}
+
+func TestOpenRebuildsLegacyTasksTable(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	if err := os.MkdirAll(filepath.Join(home, ".agit"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Create a database with the pre-dependency tasks schema
+	legacy, err := sql.Open("sqlite", filepath.Join(home, ".agit", "agit.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range []string{
+		`CREATE TABLE repos (id TEXT PRIMARY KEY, name TEXT UNIQUE NOT NULL, path TEXT NOT NULL,
+			remote_url TEXT NOT NULL DEFAULT '', default_branch TEXT NOT NULL DEFAULT 'main',
+			added_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, last_synced TIMESTAMP, metadata JSON DEFAULT '{}')`,
+		`CREATE TABLE tasks (id TEXT PRIMARY KEY, repo_id TEXT NOT NULL REFERENCES repos(id) ON DELETE CASCADE,
+			description TEXT NOT NULL,
+			status TEXT NOT NULL DEFAULT 'pending' CHECK(status IN ('pending', 'claimed', 'in_progress', 'completed', 'failed')),
+			assigned_agent_id TEXT, worktree_id TEXT, created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			completed_at TIMESTAMP, result TEXT)`,
+		`INSERT INTO repos (id, name, path) VALUES ('r1', 'legacy', '/tmp/legacy')`,
+		`INSERT INTO tasks (id, repo_id, description) VALUES ('t-legacy', 'r1', 'old task')`,
+	} {
+		if _, err := legacy.Exec(stmt); err != nil {
+			t.Fatalf("legacy schema: %v", err)
+		}
+	}
+	legacy.Close()
+
+	db, err := Open()
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+
+	task, err := db.GetTask("t-legacy")
+	if err != nil {
+		t.Fatalf("legacy task lost during rebuild: %v", err)
+	}
+	if task.Priority != 0 {
+		t.Errorf("expected default priority 0, got %d", task.Priority)
+	}
+	if _, err := db.Conn().Exec(`UPDATE tasks SET status = 'blocked' WHERE id = 't-legacy'`); err != nil {
+		t.Fatalf("blocked status should be allowed after rebuild: %v", err)
+	}
+}
+
+func TestWorktreeRebuildKeepsReferences(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	if err := os.MkdirAll(filepath.Join(home, ".agit"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Bring a database up to the schema before the worktrees rebuild
+	conn, err := sql.Open("sqlite", filepath.Join(home, ".agit", "agit.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetMaxOpenConns(1)
+	if _, err := conn.Exec("PRAGMA foreign_keys=ON"); err != nil {
+		t.Fatal(err)
+	}
+	old := &DB{conn: conn}
+	if err := old.ensureMigrationsTable(); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range migrations {
+		if m.up == nil || m.version >= 13 {
+			break
+		}
+		if _, _, err := old.applyMigration(m); err != nil {
+			t.Fatalf("migration %d: %v", m.version, err)
+		}
+	}
+	repo, _ := old.AddRepo("rebuild", "/tmp/rebuild", "", "main")
+	wt, err := old.CreateWorktree(repo.ID, "/tmp/rebuild-wt", "b1", nil, nil)
+	if err != nil {
+		t.Fatalf("CreateWorktree: %v", err)
+	}
+	task, _ := old.CreateTask(repo.ID, "attached", 0)
+	if _, err := conn.Exec(`UPDATE tasks SET worktree_id = ? WHERE id = ?`, wt.ID, task.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := old.RecordFileTouches(repo.ID, wt.ID, []FileTouch{{FilePath: "a.go", ChangeType: "modified"}}); err != nil {
+		t.Fatalf("RecordFileTouches: %v", err)
+	}
+	conn.Close()
+
+	db, err := Open()
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+
+	// Dropping the old table must not cascade into rows that reference it
+	got, err := db.GetTask(task.ID)
+	if err != nil || got.WorktreeID == nil || *got.WorktreeID != wt.ID {
+		t.Errorf("task lost its worktree during rebuild: %+v, %v", got, err)
+	}
+	var touches int
+	db.Conn().QueryRow(`SELECT COUNT(*) FROM file_touches WHERE worktree_id = ?`, wt.ID).Scan(&touches)
+	if touches != 1 {
+		t.Errorf("expected file touch to survive rebuild, got %d", touches)
+	}
+	if err := db.UpdateWorktreeStatus(wt.ID, "setup_failed"); err != nil {
+		t.Errorf("setup_failed status should be allowed after rebuild: %v", err)
+	}
+}
+
+// --- Migrations ---
+
+func TestMigrationsRecorded(t *testing.T) {
+	db := mustOpenMemory(t)
+
+	version, err := db.SchemaVersion()
+	if err != nil {
+		t.Fatalf("SchemaVersion: %v", err)
+	}
+	if version != LatestSchemaVersion() {
+		t.Errorf("expected schema version %d, got %d", LatestSchemaVersion(), version)
+	}
+
+	pending, err := db.PendingMigrations()
+	if err != nil {
+		t.Fatalf("PendingMigrations: %v", err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("expected no pending migrations, got %d", len(pending))
+	}
+
+	// Re-running is a no-op
+	applied, err := db.Migrate()
+	if err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	if len(applied) != 0 {
+		t.Errorf("expected nothing applied on second run, got %d", len(applied))
+	}
+}
+
+func TestOpenRefusesNewerSchema(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	if err := os.MkdirAll(filepath.Join(home, ".agit"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := Open()
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if _, err := db.Conn().Exec(
+		`INSERT INTO schema_migrations (version, name) VALUES (?, 'from the future')`,
+		LatestSchemaVersion()+1,
+	); err != nil {
+		t.Fatalf("insert future migration: %v", err)
+	}
+	db.Close()
+
+	_, err = Open()
+	if !errors.Is(err, ErrSchemaTooNew) {
+		t.Fatalf("expected ErrSchemaTooNew, got %v", err)
+	}
+}
+
+func TestConcurrentOpenMigratesOnce(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	if err := os.MkdirAll(filepath.Join(home, ".agit"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two processes opening an unmigrated database at once, as when the
+	// daemon and the MCP server start after an upgrade
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			db, err := Open()
+			if err == nil {
+				db.Close()
+			}
+			errs[i] = err
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("Open %d: %v", i, err)
+		}
+	}
+
+	db, err := Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	statuses, err := db.MigrationStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, st := range statuses {
+		if !st.Applied {
+			t.Errorf("expected migration %d to be applied", st.Version)
+		}
+	}
+}
+
+// --- Leases ---
+
+func TestClaimTaskSetsLease(t *testing.T) {
+	db := mustOpenMemory(t)
+
+	repo, _ := db.AddRepo("lease", "/tmp/lease", "", "main")
+	agent, _ := db.RegisterAgent("leaser", "custom")
+	task, _ := db.CreateTask(repo.ID, "leased work", 0)
+
+	before := time.Now()
+	if err := db.ClaimTask(task.ID, agent.ID); err != nil {
+		t.Fatalf("ClaimTask: %v", err)
+	}
+
+	got, _ := db.GetTask(task.ID)
+	if got.Attempts != 1 {
+		t.Errorf("expected 1 attempt, got %d", got.Attempts)
+	}
+	if got.LeaseExpiresAt == nil || got.LeaseExpiresAt.Before(before.Add(db.LeasePolicy().Duration)) {
+		t.Fatalf("expected lease to expire after %s, got %v", db.LeasePolicy().Duration, got.LeaseExpiresAt)
+	}
+
+	if err := db.CompleteTask(task.ID, nil); err != nil {
+		t.Fatalf("CompleteTask: %v", err)
+	}
+	got, _ = db.GetTask(task.ID)
+	if got.LeaseExpiresAt != nil {
+		t.Errorf("expected lease cleared on completion, got %v", got.LeaseExpiresAt)
+	}
+}
+
+func TestHeartbeatRenewsLease(t *testing.T) {
+	db := mustOpenMemory(t)
+
+	repo, _ := db.AddRepo("renew", "/tmp/renew", "", "main")
+	agent, _ := db.RegisterAgent("renewer", "custom")
+	task, _ := db.CreateTask(repo.ID, "long job", 0)
+
+	db.SetLeasePolicy(LeasePolicy{Duration: -time.Minute, MaxAttempts: 3})
+	if err := db.ClaimTask(task.ID, agent.ID); err != nil {
+		t.Fatalf("ClaimTask: %v", err)
+	}
+
+	db.SetLeasePolicy(LeasePolicy{Duration: time.Hour, MaxAttempts: 3})
+	if err := db.Heartbeat(agent.ID); err != nil {
+		t.Fatalf("Heartbeat: %v", err)
+	}
+
+	res, err := db.ReclaimExpiredTasks()
+	if err != nil {
+		t.Fatalf("ReclaimExpiredTasks: %v", err)
+	}
+	if len(res.Requeued)+len(res.Failed) != 0 {
+		t.Fatalf("expected renewed lease to survive reclaim, got %+v", res)
+	}
+	got, _ := db.GetTask(task.ID)
+	if got.Status != "claimed" {
+		t.Errorf("expected claimed, got %s", got.Status)
+	}
+}
+
+func TestReclaimExpiredTasks(t *testing.T) {
+	db := mustOpenMemory(t)
+
+	repo, _ := db.AddRepo("reclaim", "/tmp/reclaim", "", "main")
+	agent, _ := db.RegisterAgent("flaky", "custom")
+	task, _ := db.CreateTask(repo.ID, "retry me", 0)
+
+	// Every claim is born expired
+	db.SetLeasePolicy(LeasePolicy{Duration: -time.Minute, MaxAttempts: 2})
+
+	if err := db.ClaimTask(task.ID, agent.ID); err != nil {
+		t.Fatalf("first claim: %v", err)
+	}
+	res, err := db.ReclaimExpiredTasks()
+	if err != nil {
+		t.Fatalf("ReclaimExpiredTasks: %v", err)
+	}
+	if len(res.Requeued) != 1 || res.Requeued[0] != task.ID {
+		t.Fatalf("expected task requeued, got %+v", res)
+	}
+	got, _ := db.GetTask(task.ID)
+	if got.Status != "pending" || got.AssignedAgentID != nil {
+		t.Fatalf("expected pending and unassigned, got %s / %v", got.Status, got.AssignedAgentID)
+	}
+
+	// Second expiry reaches MaxAttempts and fails the task
+	if err := db.ClaimTask(task.ID, agent.ID); err != nil {
+		t.Fatalf("second claim: %v", err)
+	}
+	res, err = db.ReclaimExpiredTasks()
+	if err != nil {
+		t.Fatalf("ReclaimExpiredTasks: %v", err)
+	}
+	if len(res.Failed) != 1 || res.Failed[0] != task.ID {
+		t.Fatalf("expected task failed, got %+v", res)
+	}
+	got, _ = db.GetTask(task.ID)
+	if got.Status != "failed" {
+		t.Errorf("expected failed, got %s", got.Status)
+	}
+	if got.Attempts != 2 {
+		t.Errorf("expected 2 attempts, got %d", got.Attempts)
+	}
+}
+
+func TestNextTaskReclaimsExpiredLeases(t *testing.T) {
+	db := mustOpenMemory(t)
+
+	repo, _ := db.AddRepo("nt-lease", "/tmp/nt-lease", "", "main")
+	gone, _ := db.RegisterAgent("gone", "custom")
+	next, _ := db.RegisterAgent("next", "custom")
+	task, _ := db.CreateTask(repo.ID, "abandoned", 0)
+
+	db.SetLeasePolicy(LeasePolicy{Duration: -time.Minute, MaxAttempts: 0})
+	if err := db.ClaimTask(task.ID, gone.ID); err != nil {
+		t.Fatalf("ClaimTask: %v", err)
+	}
+
+	db.SetLeasePolicy(DefaultLeasePolicy())
+	got, err := db.NextTask(repo.ID, next.ID)
+	if err != nil {
+		t.Fatalf("NextTask: %v", err)
+	}
+	if got == nil || got.ID != task.ID {
+		t.Fatalf("expected abandoned task to be reclaimed, got %+v", got)
+	}
+	if got.Attempts != 2 {
+		t.Errorf("expected 2 attempts, got %d", got.Attempts)
+	}
+}
+
+// --- Hunk conflicts ---
+
+func TestFindConflictsHunkOverlap(t *testing.T) {
+	db := mustOpenMemory(t)
+
+	repo, _ := db.AddRepo("hunks", "/tmp/hunks", "", "main")
+	wt1, _ := db.CreateWorktree(repo.ID, "/tmp/h1", "b1", nil, nil)
+	wt2, _ := db.CreateWorktree(repo.ID, "/tmp/h2", "b2", nil, nil)
+	wt3, _ := db.CreateWorktree(repo.ID, "/tmp/h3", "b3", nil, nil)
+
+	db.RecordFileTouches(repo.ID, wt1.ID, []FileTouch{
+		{FilePath: "root.go", ChangeType: "modified", Ranges: []lines.Range{{Start: 10, End: 12}, {Start: 80, End: 80}}},
+	})
+	db.RecordFileTouches(repo.ID, wt2.ID, []FileTouch{
+		{FilePath: "root.go", ChangeType: "modified", Ranges: []lines.Range{{Start: 40, End: 45}}},
+	})
+	db.RecordFileTouches(repo.ID, wt3.ID, []FileTouch{
+		{FilePath: "root.go", ChangeType: "modified", Ranges: []lines.Range{{Start: 82, End: 90}}},
+	})
+
+	db.SetConflictContext(0)
+	conflicts, err := db.FindConflicts(repo.ID)
+	if err != nil {
+		t.Fatalf("FindConflicts: %v", err)
+	}
+	if len(conflicts) != 0 {
+		t.Fatalf("expected no conflicts without context, got %+v", conflicts)
+	}
+
+	// 80 and 82 are within 3 lines of each other; wt2 stays out of it
+	db.SetConflictContext(3)
+	conflicts, err = db.FindConflicts(repo.ID)
+	if err != nil {
+		t.Fatalf("FindConflicts: %v", err)
+	}
+	if len(conflicts) != 1 {
+		t.Fatalf("expected 1 conflict, got %d", len(conflicts))
+	}
+	c := conflicts[0]
+	if len(c.Worktrees) != 2 {
+		t.Fatalf("expected 2 worktrees, got %v", c.Worktrees)
+	}
+	for i, wtID := range c.Worktrees {
+		switch wtID {
+		case wt1.ID:
+			if len(c.Ranges[i]) != 1 || c.Ranges[i][0] != (lines.Range{Start: 80, End: 80}) {
+				t.Errorf("wt1: expected only 80, got %v", c.Ranges[i])
+			}
+		case wt3.ID:
+			if len(c.Ranges[i]) != 1 || c.Ranges[i][0] != (lines.Range{Start: 82, End: 90}) {
+				t.Errorf("wt3: expected 82-90, got %v", c.Ranges[i])
+			}
+		default:
+			t.Errorf("unexpected worktree %s in conflict", wtID)
+		}
+	}
+}
+
+func TestFindConflictsWholeFileOverlapsHunks(t *testing.T) {
+	db := mustOpenMemory(t)
+
+	repo, _ := db.AddRepo("whole", "/tmp/whole", "", "main")
+	wt1, _ := db.CreateWorktree(repo.ID, "/tmp/w1", "b1", nil, nil)
+	wt2, _ := db.CreateWorktree(repo.ID, "/tmp/w2", "b2", nil, nil)
+
+	db.RecordFileTouches(repo.ID, wt1.ID, []FileTouch{
+		{FilePath: "data.bin", ChangeType: "modified"},
+	})
+	db.RecordFileTouches(repo.ID, wt2.ID, []FileTouch{
+		{FilePath: "data.bin", ChangeType: "modified", Ranges: []lines.Range{{Start: 5, End: 5}}},
+	})
+
+	conflicts, err := db.FindConflicts(repo.ID)
+	if err != nil {
+		t.Fatalf("FindConflicts: %v", err)
+	}
+	if len(conflicts) != 1 {
+		t.Fatalf("expected 1 conflict, got %d", len(conflicts))
+	}
+	for i, wtID := range conflicts[0].Worktrees {
+		if wtID == wt1.ID && conflicts[0].Ranges[i] != nil {
+			t.Errorf("expected whole-file touch to have nil ranges, got %v", conflicts[0].Ranges[i])
+		}
+	}
+}
+
+func TestFindConflictsUncommitted(t *testing.T) {
+	db := mustOpenMemory(t)
+
+	repo, _ := db.AddRepo("inflight", "/tmp/inflight", "", "main")
+	wt1, _ := db.CreateWorktree(repo.ID, "/tmp/if1", "b1", nil, nil)
+	wt2, _ := db.CreateWorktree(repo.ID, "/tmp/if2", "b2", nil, nil)
+
+	db.RecordFileTouches(repo.ID, wt1.ID, []FileTouch{
+		{FilePath: "committed.go", ChangeType: "modified", Ranges: []lines.Range{{Start: 1, End: 2}}},
+		{FilePath: "editing.go", ChangeType: "modified", Ranges: []lines.Range{{Start: 10, End: 12}}, Uncommitted: true},
+	})
+	db.RecordFileTouches(repo.ID, wt2.ID, []FileTouch{
+		{FilePath: "committed.go", ChangeType: "modified", Ranges: []lines.Range{{Start: 2, End: 3}}},
+		{FilePath: "editing.go", ChangeType: "modified", Ranges: []lines.Range{{Start: 11, End: 11}}},
+	})
+
+	conflicts, err := db.FindConflicts(repo.ID)
+	if err != nil {
+		t.Fatalf("FindConflicts: %v", err)
+	}
+	if len(conflicts) != 2 {
+		t.Fatalf("expected 2 conflicts, got %d", len(conflicts))
+	}
+	for _, c := range conflicts {
+		switch c.FilePath {
+		case "committed.go":
+			if c.InFlight() {
+				t.Error("expected committed.go to be a committed conflict")
+			}
+		case "editing.go":
+			if !c.InFlight() {
+				t.Error("expected editing.go to be an in-flight conflict")
+			}
+			for i, wtID := range c.Worktrees {
+				if c.Uncommitted[i] != (wtID == wt1.ID) {
+					t.Errorf("worktree %s: uncommitted = %v", wtID, c.Uncommitted[i])
+				}
+			}
+		}
+	}
+}
+
+// --- Merge queue ---
+
+func TestMergeQueueSerializesClaims(t *testing.T) {
+	db := mustOpenMemory(t)
+
+	repo, _ := db.AddRepo("mq", "/tmp/mq", "", "main")
+	wt1, _ := db.CreateWorktree(repo.ID, "/tmp/mq1", "b1", nil, nil)
+	wt2, _ := db.CreateWorktree(repo.ID, "/tmp/mq2", "b2", nil, nil)
+
+	e1, err := db.EnqueueMerge(repo.ID, wt1.ID, "", false, nil)
+	if err != nil {
+		t.Fatalf("EnqueueMerge wt1: %v", err)
+	}
+	e2, err := db.EnqueueMerge(repo.ID, wt2.ID, "squash", true, nil)
+	if err != nil {
+		t.Fatalf("EnqueueMerge wt2: %v", err)
+	}
+	if _, err := db.EnqueueMerge(repo.ID, wt1.ID, "", false, nil); err == nil || !strings.Contains(err.Error(), e1.ID) {
+		t.Errorf("expected error naming the pending entry when enqueuing a worktree twice, got %v", err)
+	}
+
+	claimed, err := db.ClaimNextMerge(repo.ID)
+	if err != nil || claimed == nil {
+		t.Fatalf("ClaimNextMerge: %v, %v", claimed, err)
+	}
+	if claimed.ID != e1.ID || claimed.Status != "merging" {
+		t.Errorf("expected first entry merging, got %s (%s)", claimed.ID, claimed.Status)
+	}
+
+	// Nothing else may be claimed while a merge is in progress
+	if next, err := db.ClaimNextMerge(repo.ID); err != nil || next != nil {
+		t.Fatalf("expected no claim while merging, got %v, %v", next, err)
+	}
+
+	result := "merged"
+	if err := db.FinishMerge(e1.ID, "merged", &result); err != nil {
+		t.Fatalf("FinishMerge: %v", err)
+	}
+
+	next, err := db.ClaimNextMerge(repo.ID)
+	if err != nil || next == nil {
+		t.Fatalf("ClaimNextMerge after finish: %v, %v", next, err)
+	}
+	if next.ID != e2.ID || next.Strategy != "squash" || !next.Cleanup {
+		t.Errorf("expected second entry with its options, got %+v", next)
+	}
+}
+
+func TestConcurrentEnqueueMergeQueuesOnce(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	if err := os.MkdirAll(filepath.Join(home, ".agit"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	db, err := Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	repo, _ := db.AddRepo("mq", "/tmp/mq", "", "main")
+	wt, _ := db.CreateWorktree(repo.ID, "/tmp/mq1", "b1", nil, nil)
+
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = db.EnqueueMerge(repo.ID, wt.ID, "", false, nil)
+		}(i)
+	}
+	wg.Wait()
+
+	queued := 0
+	for _, err := range errs {
+		if err == nil {
+			queued++
+		} else if !strings.Contains(err.Error(), "already in the merge queue") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if entries, _ := db.ListMergeQueue(repo.ID, false); queued != 1 || len(entries) != 1 {
+		t.Errorf("expected one queued entry, got %d successes and %d entries", queued, len(entries))
+	}
+}
+
+func TestEnqueueMergeReactivatesBouncedWorktree(t *testing.T) {
+	db := mustOpenMemory(t)
+
+	repo, _ := db.AddRepo("mb", "/tmp/mb", "", "main")
+	wt, _ := db.CreateWorktree(repo.ID, "/tmp/mb1", "b1", nil, nil)
+	e, _ := db.EnqueueMerge(repo.ID, wt.ID, "", false, nil)
+
+	// A failed enqueue leaves the worktree as it was
+	db.UpdateWorktreeStatus(wt.ID, "conflict")
+	if _, err := db.EnqueueMerge(repo.ID, wt.ID, "", false, nil); !errors.Is(err, ErrAlreadyQueued) {
+		t.Fatalf("expected ErrAlreadyQueued, got %v", err)
+	}
+	if got, _ := db.GetWorktree(wt.ID); got.Status != "conflict" {
+		t.Errorf("expected the worktree to stay in conflict, got %s", got.Status)
+	}
+
+	db.CancelMerge(e.ID)
+	if _, err := db.EnqueueMerge(repo.ID, wt.ID, "", false, nil); err != nil {
+		t.Fatalf("EnqueueMerge: %v", err)
+	}
+	if got, _ := db.GetWorktree(wt.ID); got.Status != "active" {
+		t.Errorf("expected the worktree to be active again, got %s", got.Status)
+	}
+}
+
+func TestMergeQueueRenew(t *testing.T) {
+	db := mustOpenMemory(t)
+
+	repo, _ := db.AddRepo("mr", "/tmp/mr", "", "main")
+	wt, _ := db.CreateWorktree(repo.ID, "/tmp/mr1", "b1", nil, nil)
+	e, _ := db.EnqueueMerge(repo.ID, wt.ID, "", false, nil)
+	if _, err := db.ClaimNextMerge(repo.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// A merge that runs past the lock timeout but keeps renewing stays claimed
+	db.conn.Exec(`UPDATE merge_queue SET started_at = ? WHERE id = ?`, time.Now().Add(-MergeLockTimeout-time.Minute), e.ID)
+	if err := db.RenewMerge(e.ID); err != nil {
+		t.Fatalf("RenewMerge: %v", err)
+	}
+	if next, err := db.ClaimNextMerge(repo.ID); err != nil || next != nil {
+		t.Fatalf("expected a renewed merge to stay claimed, got %v, %v", next, err)
+	}
+
+	// One that stops renewing is requeued and claimed again
+	db.conn.Exec(`UPDATE merge_queue SET started_at = ? WHERE id = ?`, time.Now().Add(-MergeLockTimeout-time.Minute), e.ID)
+	if next, err := db.ClaimNextMerge(repo.ID); err != nil || next == nil || next.ID != e.ID {
+		t.Fatalf("expected an abandoned merge to be reclaimed, got %v, %v", next, err)
+	}
+}
+
+func TestMergeQueueCancel(t *testing.T) {
+	db := mustOpenMemory(t)
+
+	repo, _ := db.AddRepo("mc", "/tmp/mc", "", "main")
+	wt, _ := db.CreateWorktree(repo.ID, "/tmp/mc1", "b1", nil, nil)
+
+	e, _ := db.EnqueueMerge(repo.ID, wt.ID, "", false, nil)
+	if err := db.CancelMerge(e.ID); err != nil {
+		t.Fatalf("CancelMerge: %v", err)
+	}
+	if err := db.CancelMerge(e.ID); err == nil {
+		t.Error("expected error cancelling an entry that is no longer queued")
+	}
+
+	pending, _ := db.ListMergeQueue(repo.ID, false)
+	if len(pending) != 0 {
+		t.Errorf("expected empty queue, got %d entries", len(pending))
+	}
+	all, _ := db.ListMergeQueue(repo.ID, true)
+	if len(all) != 1 || all[0].Status != "cancelled" {
+		t.Errorf("expected one cancelled entry, got %+v", all)
+	}
+
+	// A cancelled worktree can be enqueued again
+	if _, err := db.EnqueueMerge(repo.ID, wt.ID, "", false, nil); err != nil {
+		t.Errorf("re-enqueue after cancel: %v", err)
+	}
+}
+
+// --- Verify runs ---
+
+func TestRecordAndLatestVerifyRun(t *testing.T) {
+	db := mustOpenMemory(t)
+
+	repo, _ := db.AddRepo("vr", "/tmp/vr", "", "main")
+	wt, _ := db.CreateWorktree(repo.ID, "/tmp/vr1", "b1", nil, nil)
+
+	if run, err := db.LatestVerifyRun(wt.ID); err != nil || run != nil {
+		t.Fatalf("expected no verify run, got %v, %v", run, err)
+	}
+
+	failed := &VerifyRun{
+		WorktreeID: wt.ID,
+		CommitSHA:  "abc123",
+		Status:     "failed",
+		Steps:      []VerifyStep{{Command: "go test ./...", ExitCode: 1, Output: "FAIL"}},
+		StartedAt:  time.Now().Add(-time.Minute),
+	}
+	if err := db.RecordVerifyRun(failed); err != nil {
+		t.Fatalf("RecordVerifyRun: %v", err)
+	}
+	by := "alice"
+	if err := db.RecordVerifyRun(&VerifyRun{WorktreeID: wt.ID, Status: "skipped", SkippedBy: &by}); err != nil {
+		t.Fatalf("RecordVerifyRun skipped: %v", err)
+	}
+
+	run, err := db.LatestVerifyRun(wt.ID)
+	if err != nil {
+		t.Fatalf("LatestVerifyRun: %v", err)
+	}
+	if run.Status != "skipped" || run.SkippedBy == nil || *run.SkippedBy != "alice" {
+		t.Errorf("expected latest run skipped by alice, got %+v", run)
+	}
+
+	if err := db.RecordVerifyRun(&VerifyRun{WorktreeID: wt.ID, Status: "bogus"}); err == nil {
+		t.Error("expected error for invalid status")
+	}
+}
+
+func TestRepoSettingList(t *testing.T) {
+	db := mustOpenMemory(t)
+	repo, _ := db.AddRepo("sl", "/tmp/sl", "", "main")
+
+	if err := db.SetRepoSettingList(repo.ID, "verify", []string{"go build ./...", "go test ./..."}); err != nil {
+		t.Fatalf("SetRepoSettingList: %v", err)
+	}
+	repo, _ = db.GetRepoByID(repo.ID)
+	got := repo.SettingList("verify")
+	if len(got) != 2 || got[1] != "go test ./..." {
+		t.Errorf("unexpected list: %v", got)
+	}
+
+	db.SetRepoSettingList(repo.ID, "verify", nil)
+	repo, _ = db.GetRepoByID(repo.ID)
+	if got := repo.SettingList("verify"); got != nil {
+		t.Errorf("expected setting removed, got %v", got)
+	}
+}
+
+func TestRepoConfigOverrides(t *testing.T) {
+	db := mustOpenMemory(t)
+	repo, _ := db.AddRepo("co", "/tmp/co", "", "main")
+	db.SetRepoSetting(repo.ID, "merge_strategy", "squash")
+
+	if err := db.SetRepoConfig(repo.ID, "defaults.branch_prefix", "fe/"); err != nil {
+		t.Fatalf("SetRepoConfig: %v", err)
+	}
+	db.SetRepoConfig(repo.ID, "hooks.task.claimed", "echo hi")
+	repo, _ = db.GetRepoByID(repo.ID)
+	got := repo.ConfigOverrides()
+	if len(got) != 2 || got["defaults.branch_prefix"] != "fe/" || got["hooks.task.claimed"] != "echo hi" {
+		t.Errorf("unexpected overrides: %v", got)
+	}
+	if repo.Setting("merge_strategy") != "squash" {
+		t.Error("expected other repo settings to be kept")
+	}
+
+	db.SetRepoConfig(repo.ID, "defaults.branch_prefix", "")
+	db.SetRepoConfig(repo.ID, "hooks.task.claimed", "")
+	repo, _ = db.GetRepoByID(repo.ID)
+	if got := repo.ConfigOverrides(); len(got) != 0 || repo.Metadata != `{"merge_strategy":"squash"}` {
+		t.Errorf("expected overrides removed, got %v in %s", got, repo.Metadata)
+	}
+}
+
+// --- Event log ---
+
+func TestEventLogRecordsTransitions(t *testing.T) {
+	db := mustOpenMemory(t)
+
+	repo, _ := db.AddRepo("ev", "/tmp/ev", "", "main")
+	agent, _ := db.RegisterAgent("ev-agent", "claude")
+	task, _ := db.CreateTask(repo.ID, "write docs", 2)
+	if err := db.ClaimTask(task.ID, agent.ID); err != nil {
+		t.Fatalf("ClaimTask: %v", err)
+	}
+	wt, _ := db.CreateWorktree(repo.ID, "/tmp/ev1", "b1", &agent.ID, nil)
+	db.StartTask(task.ID, wt.ID)
+	result := "done"
+	db.CompleteTask(task.ID, &result)
+	db.DeleteWorktree(wt.ID)
+
+	events, err := db.ListEvents(EventFilter{})
+	if err != nil {
+		t.Fatalf("ListEvents: %v", err)
+	}
+	want := []string{
+		EventRepoAdded, EventAgentRegistered, EventTaskCreated, EventTaskClaimed, EventWorktreeCreated,
+		EventTaskStarted, EventTaskCompleted, EventWorktreeRemoved,
+	}
+	if len(events) != len(want) {
+		t.Fatalf("expected %d events, got %d", len(want), len(events))
+	}
+	for i, e := range events {
+		if e.Type != want[i] {
+			t.Errorf("event %d: expected %s, got %s", i, want[i], e.Type)
+		}
+	}
+
+	claimed := events[3]
+	if claimed.Actor == nil || *claimed.Actor != "ev-agent" || claimed.RepoID == nil || *claimed.RepoID != repo.ID {
+		t.Errorf("expected claim by ev-agent in repo, got %+v", claimed)
+	}
+	if events[6].Payload["result"] != "done" {
+		t.Errorf("expected result in completed payload, got %v", events[6].Payload)
+	}
+	if events[7].Payload["branch"] != "b1" {
+		t.Errorf("expected branch in removed payload, got %v", events[7].Payload)
+	}
+}
+
+func TestListEventsFilters(t *testing.T) {
+	db := mustOpenMemory(t)
+
+	repo1, _ := db.AddRepo("ef1", "/tmp/ef1", "", "main")
+	repo2, _ := db.AddRepo("ef2", "/tmp/ef2", "", "main")
+	agent, _ := db.RegisterAgent("ef-agent", "claude")
+	t1, _ := db.CreateTask(repo1.ID, "one", 0)
+	db.CreateTask(repo2.ID, "two", 0)
+	db.ClaimTask(t1.ID, agent.ID)
+
+	byRepo, _ := db.ListEvents(EventFilter{RepoID: repo2.ID})
+	if len(byRepo) != 2 || byRepo[0].Type != EventRepoAdded || byRepo[1].Type != EventTaskCreated {
+		t.Errorf("expected repo.added and task.created in repo2, got %d", len(byRepo))
+	}
+
+	tasks, _ := db.ListEvents(EventFilter{Type: "task"})
+	if len(tasks) != 3 {
+		t.Errorf("expected 3 task events, got %d", len(tasks))
+	}
+
+	byAgent, _ := db.ListEvents(EventFilter{Agent: "ef-agent"})
+	if len(byAgent) != 2 {
+		t.Errorf("expected 2 events by ef-agent (registered, claimed), got %d", len(byAgent))
+	}
+
+	all, _ := db.ListEvents(EventFilter{})
+	after, _ := db.ListEvents(EventFilter{AfterID: all[1].ID})
+	if len(after) != len(all)-2 {
+		t.Errorf("expected %d events after cursor, got %d", len(all)-2, len(after))
+	}
+
+	latest, _ := db.ListEvents(EventFilter{Limit: 1})
+	if len(latest) != 1 || latest[0].Type != EventTaskClaimed {
+		t.Errorf("expected the most recent event, got %+v", latest)
+	}
+}
+
+func TestSubscribePublishesCommittedEvents(t *testing.T) {
+	db, err := OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var published []*Event
+	db.Subscribe(func(e *Event) { published = append(published, e) })
+	closed := false
+	db.OnClose(func() { closed = true })
+
+	repo, _ := db.AddRepo("bus", "/tmp/bus", "", "main")
+	agent, _ := db.RegisterAgent("bus-agent", "claude")
+	task, _ := db.CreateTask(repo.ID, "publish", 0)
+	if err := db.ClaimTask(task.ID, agent.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ClaimTask(task.ID, agent.ID); err == nil {
+		t.Fatal("expected a second claim to fail")
+	}
+	if _, err := db.SweepStaleAgents(-time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	logged, _ := db.ListEvents(EventFilter{})
+	if len(published) != len(logged) {
+		t.Fatalf("expected %d published events, got %d", len(logged), len(published))
+	}
+	for i, e := range published {
+		if e.ID != logged[i].ID || e.Type != logged[i].Type {
+			t.Errorf("event %d: published %s #%d, logged %s #%d", i, e.Type, e.ID, logged[i].Type, logged[i].ID)
+		}
+	}
+	last := published[len(published)-1]
+	if last.Type != EventAgentDisconnected || last.Payload["reason"] != "stale" || last.EntityID != agent.ID {
+		t.Errorf("expected the agent to be disconnected as stale, got %+v", last)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !closed {
+		t.Error("expected OnClose functions to run on Close")
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		in   string
+		want time.Time
+	}{
+		{"2h", now.Add(-2 * time.Hour)},
+		{"7d", now.AddDate(0, 0, -7)},
+		{"2026-03-01", time.Date(2026, 3, 1, 0, 0, 0, 0, time.Local)},
+	}
+	for _, tt := range tests {
+		got, err := ParseSince(tt.in, now)
+		if err != nil {
+			t.Errorf("ParseSince(%q): %v", tt.in, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("ParseSince(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+
+	if _, err := ParseSince("yesterday", now); err == nil {
+		t.Error("expected error for unparseable value")
+	}
+}
+
+// --- Locks ---
+
+func TestMatchPath(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"internal/registry/db.go", "internal/registry/db.go", true},
+		{"internal/registry/db.go", "internal/registry/db.go.orig", false},
+		{"internal/registry", "internal/registry/db.go", true},
+		{"internal/registry/", "internal/registry/sub/x.go", true},
+		{"internal/reg", "internal/registry/db.go", false},
+		{"internal/*/db.go", "internal/registry/db.go", true},
+		{"internal/*.go", "internal/registry/db.go", false},
+		{"**/*.sql", "migrations/001.sql", true},
+		{"**/*.sql", "schema.sql", true},
+		{"internal/**", "internal/a/b/c.go", true},
+		{"cmd/**/x.go", "cmd/x.go", true},
+		{"./cmd/root.go", "cmd/root.go", true},
+	}
+	for _, tt := range tests {
+		if got := MatchPath(tt.pattern, tt.path); got != tt.want {
+			t.Errorf("MatchPath(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestPatternsOverlap(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"internal/registry", "internal/registry/db.go", true},
+		{"internal/registry/*.go", "internal/registry/db.go", true},
+		{"internal/registry", "internal/git", false},
+		{"cmd/root.go", "cmd/spawn.go", false},
+		{"internal/*.go", "internal/reg*", true},
+		{"**/*.sql", "db/*", true},
+		{"**/*.sql", "db", true},
+		{"internal/**", "**/registry/*.go", true},
+		{"internal/*/db.go", "*/registry/*", true},
+		{"internal/registry/*.go", "internal/registry/*.sql", false},
+		{"internal/*.go", "cmd/*", false},
+		{"**/*.sql", "db/*.go", false},
+		{"cmd/*", "cmd/sub/*.go", false},
+	}
+	for _, tt := range tests {
+		if got := PatternsOverlap(tt.a, tt.b); got != tt.want {
+			t.Errorf("PatternsOverlap(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+		if got := PatternsOverlap(tt.b, tt.a); got != tt.want {
+			t.Errorf("PatternsOverlap(%q, %q) = %v, want %v", tt.b, tt.a, got, tt.want)
+		}
+	}
+}
+
+func TestAcquireLock(t *testing.T) {
+	db := mustOpenMemory(t)
+
+	repo, _ := db.AddRepo("locked", "/tmp/locked", "", "main")
+	a1, _ := db.RegisterAgent("agent-1", "custom")
+	a2, _ := db.RegisterAgent("agent-2", "custom")
+
+	reason := "rewriting the schema"
+	lock, err := db.AcquireLock(repo.ID, "internal/registry/", &a1.ID, nil, &reason, time.Minute)
+	if err != nil {
+		t.Fatalf("AcquireLock: %v", err)
+	}
+	if lock.Pattern != "internal/registry" {
+		t.Errorf("expected normalized pattern, got %q", lock.Pattern)
+	}
+	if lock.AgentName == nil || *lock.AgentName != "agent-1" {
+		t.Errorf("expected lock held by agent-1, got %v", lock.AgentName)
+	}
+
+	// Overlapping lock held by someone else
+	_, err = db.AcquireLock(repo.ID, "internal/registry/db.go", &a2.ID, nil, nil, time.Minute)
+	var held *LockHeldError
+	if !errors.As(err, &held) {
+		t.Fatalf("expected LockHeldError, got %v", err)
+	}
+	if held.Lock.ID != lock.ID {
+		t.Errorf("expected clash with %s, got %s", lock.ID, held.Lock.ID)
+	}
+
+	// Disjoint paths don't clash
+	if _, err := db.AcquireLock(repo.ID, "cmd/*.go", &a2.ID, nil, nil, time.Minute); err != nil {
+		t.Fatalf("AcquireLock disjoint: %v", err)
+	}
+
+	// Globs that cross without either covering the other still clash
+	if _, err := db.AcquireLock(repo.ID, "cmd/root*", &a1.ID, nil, nil, time.Minute); !errors.As(err, &held) {
+		t.Fatalf("expected crossing globs to clash, got %v", err)
+	}
+
+	// Re-acquiring renews rather than duplicating
+	renewed, err := db.AcquireLock(repo.ID, "internal/registry", &a1.ID, nil, nil, time.Hour)
+	if err != nil {
+		t.Fatalf("AcquireLock renew: %v", err)
+	}
+	if renewed.ID != lock.ID || !renewed.ExpiresAt.After(lock.ExpiresAt) {
+		t.Errorf("expected lock %s to be renewed, got %s expiring %v", lock.ID, renewed.ID, renewed.ExpiresAt)
+	}
+	if renewed.Reason == nil || *renewed.Reason != reason {
+		t.Errorf("expected reason to be kept, got %v", renewed.Reason)
+	}
+
+	locks, err := db.ListLocks(repo.ID)
+	if err != nil {
+		t.Fatalf("ListLocks: %v", err)
+	}
+	if len(locks) != 2 {
+		t.Fatalf("expected 2 locks, got %d", len(locks))
+	}
+
+	if err := db.ReleaseLock(lock.ID); err != nil {
+		t.Fatalf("ReleaseLock: %v", err)
+	}
+	if _, err := db.AcquireLock(repo.ID, "internal/registry/db.go", &a2.ID, nil, nil, time.Minute); err != nil {
+		t.Fatalf("AcquireLock after release: %v", err)
+	}
+
+	events, _ := db.ListEvents(EventFilter{Type: "lock"})
+	if len(events) != 4 {
+		t.Errorf("expected 4 lock events, got %d", len(events))
+	}
+}
+
+func TestExpiredLocksArePurged(t *testing.T) {
+	db := mustOpenMemory(t)
+
+	repo, _ := db.AddRepo("expiry", "/tmp/expiry", "", "main")
+	a1, _ := db.RegisterAgent("agent-1", "custom")
+	a2, _ := db.RegisterAgent("agent-2", "custom")
+
+	lock, _ := db.AcquireLock(repo.ID, "go.mod", &a1.ID, nil, nil, time.Minute)
+	db.conn.Exec(`UPDATE locks SET expires_at = ? WHERE id = ?`, time.Now().Add(-time.Second), lock.ID)
+
+	locks, _ := db.ListLocks(repo.ID)
+	if len(locks) != 0 {
+		t.Fatalf("expected expired lock to be hidden, got %d locks", len(locks))
+	}
+	if _, err := db.AcquireLock(repo.ID, "go.mod", &a2.ID, nil, nil, time.Minute); err != nil {
+		t.Fatalf("expected expired lock to be replaced: %v", err)
+	}
+	if _, err := db.GetLock(lock.ID); err == nil {
+		t.Error("expected expired lock to be purged")
+	}
+	n, err := db.PurgeExpiredLocks()
+	if err != nil || n != 0 {
+		t.Errorf("PurgeExpiredLocks = %d, %v; want 0, nil", n, err)
+	}
+}
+
+func TestFindLockViolations(t *testing.T) {
+	db := mustOpenMemory(t)
+
+	repo, _ := db.AddRepo("violations", "/tmp/violations", "", "main")
+	owner, _ := db.RegisterAgent("owner", "custom")
+	other, _ := db.RegisterAgent("other", "custom")
+	wt1, _ := db.CreateWorktree(repo.ID, "/tmp/v1", "b1", &owner.ID, nil)
+	wt2, _ := db.CreateWorktree(repo.ID, "/tmp/v2", "b2", &other.ID, nil)
+
+	db.AcquireLock(repo.ID, "internal/registry", &owner.ID, nil, nil, time.Minute)
+
+	db.RecordFileTouches(repo.ID, wt1.ID, []FileTouch{{FilePath: "internal/registry/db.go", ChangeType: "modified"}})
+	db.RecordFileTouches(repo.ID, wt2.ID, []FileTouch{
+		{FilePath: "internal/registry/db.go", ChangeType: "modified", Uncommitted: true},
+		{FilePath: "cmd/root.go", ChangeType: "modified"},
+	})
+
+	violations, err := db.FindLockViolations(repo.ID)
+	if err != nil {
+		t.Fatalf("FindLockViolations: %v", err)
+	}
+	if len(violations) != 1 {
+		t.Fatalf("expected 1 violation, got %d", len(violations))
+	}
+	v := violations[0]
+	if v.WorktreeID != wt2.ID || v.Path != "internal/registry/db.go" || !v.Uncommitted {
+		t.Errorf("unexpected violation %+v", v)
+	}
+
+	own, err := db.LockViolations(wt1, []string{"internal/registry/db.go"})
+	if err != nil || len(own) != 0 {
+		t.Errorf("expected the holder's own changes to pass, got %v, %v", own, err)
+	}
+}
+
+// --- Task scopes ---
+
+func TestCreateTaskScope(t *testing.T) {
+	db := mustOpenMemory(t)
+
+	repo, _ := db.AddRepo("scoped", "/tmp/scoped", "", "main")
+	task, err := db.CreateTask(repo.ID, "rewrite registry", 0, "./internal/registry/", "**/*.sql")
+	if err != nil {
+		t.Fatalf("CreateTask: %v", err)
+	}
+
+	got, err := db.GetTask(task.ID)
+	if err != nil {
+		t.Fatalf("GetTask: %v", err)
+	}
+	if len(got.Scope) != 2 || got.Scope[0] != "internal/registry" || got.Scope[1] != "**/*.sql" {
+		t.Errorf("unexpected scope %v", got.Scope)
+	}
+
+	if _, err := db.CreateTask(repo.ID, "escape", 0, "../elsewhere"); err == nil {
+		t.Error("expected error for a scope outside the repo")
+	}
+}
+
+func TestFindScopeOverlaps(t *testing.T) {
+	db := mustOpenMemory(t)
+
+	repo, _ := db.AddRepo("overlap", "/tmp/overlap", "", "main")
+	agent, _ := db.RegisterAgent("agent-1", "custom")
+	wt, _ := db.CreateWorktree(repo.ID, "/tmp/ov1", "b1", &agent.ID, nil)
+
+	running, _ := db.CreateTask(repo.ID, "running", 0, "internal/registry")
+	db.ClaimTask(running.ID, agent.ID)
+	db.StartTask(running.ID, wt.ID)
+	db.RecordFileTouches(repo.ID, wt.ID, []FileTouch{
+		{FilePath: "internal/registry/db.go", ChangeType: "modified"},
+		{FilePath: "cmd/root.go", ChangeType: "modified"},
+	})
+
+	collides, _ := db.CreateTask(repo.ID, "collides", 0, "internal/registry/*.go")
+	pendingA, _ := db.CreateTask(repo.ID, "docs a", 0, "docs/**")
+	pendingB, _ := db.CreateTask(repo.ID, "docs b", 0, "docs/guide.md")
+	db.CreateTask(repo.ID, "unscoped", 0)
+
+	overlaps, err := db.FindScopeOverlaps(repo.ID)
+	if err != nil {
+		t.Fatalf("FindScopeOverlaps: %v", err)
+	}
+
+	var scopeHit, fileHit, pendingHit bool
+	for _, o := range overlaps {
+		switch {
+		case o.TaskID == collides.ID && o.OtherTaskID == running.ID && !o.Changed:
+			scopeHit = o.InFlight()
+		case o.TaskID == collides.ID && o.Changed && o.Path == "internal/registry/db.go":
+			fileHit = o.WorktreeID == wt.ID
+		case (o.TaskID == pendingA.ID && o.OtherTaskID == pendingB.ID) || (o.TaskID == pendingB.ID && o.OtherTaskID == pendingA.ID):
+			pendingHit = !o.InFlight()
+		default:
+			t.Errorf("unexpected overlap %+v", o)
+		}
+	}
+	if !scopeHit || !fileHit || !pendingHit {
+		t.Errorf("missing overlaps (scope=%v file=%v pending=%v): %+v", scopeHit, fileHit, pendingHit, overlaps)
+	}
+
+	drift, err := db.FindScopeDrift(repo.ID)
+	if err != nil {
+		t.Fatalf("FindScopeDrift: %v", err)
+	}
+	if len(drift) != 1 || drift[0].TaskID != running.ID || drift[0].Path != "cmd/root.go" {
+		t.Errorf("expected cmd/root.go to be out of scope, got %+v", drift)
+	}
+}
+
+func TestNextTaskPrefersNonOverlappingScope(t *testing.T) {
+	db := mustOpenMemory(t)
+
+	repo, _ := db.AddRepo("prefer", "/tmp/prefer", "", "main")
+	agent1, _ := db.RegisterAgent("agent-1", "custom")
+	agent2, _ := db.RegisterAgent("agent-2", "custom")
+
+	first, _ := db.CreateTask(repo.ID, "schema", 5, "internal/registry")
+	if got, _ := db.NextTask(repo.ID, agent1.ID); got == nil || got.ID != first.ID {
+		t.Fatalf("expected %s to be claimed first, got %v", first.ID, got)
+	}
+
+	risky, _ := db.CreateTask(repo.ID, "more schema", 10, "internal/registry/db.go")
+	safe, _ := db.CreateTask(repo.ID, "docs", 1, "docs")
+
+	got, err := db.NextTask(repo.ID, agent2.ID)
+	if err != nil {
+		t.Fatalf("NextTask: %v", err)
+	}
+	if got == nil || got.ID != safe.ID {
+		t.Fatalf("expected non-overlapping %s despite lower priority, got %v", safe.ID, got)
+	}
+
+	// With nothing else left, the overlapping task is still handed out
+	got, _ = db.NextTask(repo.ID, agent2.ID)
+	if got == nil || got.ID != risky.ID {
+		t.Errorf("expected %s once nothing else is pending, got %v", risky.ID, got)
+	}
+}
+
+// --- Webhook deliveries ---
+
+func TestWebhookDeliveryLifecycle(t *testing.T) {
+	db := mustOpenMemory(t)
+
+	msg := "HTTP 500"
+	for _, id := range []string{"abc123-one", "abc456-two"} {
+		if err := db.RecordFailedDelivery(&WebhookDelivery{
+			ID: id, Webhook: "ci", Event: "task.failed", URL: "http://example.test", Body: `{}`, Attempts: 3, LastError: &msg,
+		}); err != nil {
+			t.Fatalf("RecordFailedDelivery: %v", err)
+		}
+	}
+
+	failed, err := db.ListFailedDeliveries()
+	if err != nil || len(failed) != 2 {
+		t.Fatalf("expected 2 failed deliveries, got %d, %v", len(failed), err)
+	}
+
+	if _, err := db.FindDelivery("abc"); err == nil {
+		t.Error("expected an ambiguous prefix to be rejected")
+	}
+	if _, err := db.FindDelivery("zzz"); err == nil {
+		t.Error("expected an unknown prefix to be rejected")
+	}
+	d, err := db.FindDelivery("abc4")
+	if err != nil || d.ID != "abc456-two" {
+		t.Fatalf("expected prefix to find abc456-two, got %v, %v", d, err)
+	}
+
+	if err := db.RecordRedelivery(d.ID, errors.New("HTTP 502")); err != nil {
+		t.Fatal(err)
+	}
+	d, _ = db.FindDelivery(d.ID)
+	if d.Status != "failed" || d.Attempts != 4 || *d.LastError != "HTTP 502" {
+		t.Errorf("expected failed redelivery to be counted, got %+v", d)
+	}
+
+	if err := db.RecordRedelivery(d.ID, nil); err != nil {
+		t.Fatal(err)
+	}
+	d, _ = db.FindDelivery(d.ID)
+	if d.Status != "delivered" || d.DeliveredAt == nil {
+		t.Errorf("expected delivered, got %+v", d)
+	}
+	if failed, _ := db.ListFailedDeliveries(); len(failed) != 1 {
+		t.Errorf("expected 1 delivery left to redeliver, got %d", len(failed))
+	}
+}
+
+// --- Hook runs ---
+
+func TestHookRunHistory(t *testing.T) {
+	db := mustOpenMemory(t)
+
+	runs := []*HookRun{
+		{Event: "worktree.created", Command: "make bootstrap", Repo: "app", DurationMS: 12},
+		{Event: "task.failed", Command: "notify", Repo: "app", ExitCode: 2, Stderr: "no route"},
+		{Event: "pre.merge", Command: "sleep 60", ExitCode: -1, TimedOut: true},
+	}
+	for _, r := range runs {
+		if err := db.RecordHookRun(r); err != nil {
+			t.Fatalf("RecordHookRun: %v", err)
+		}
+	}
+
+	all, err := db.ListHookRuns(HookRunFilter{})
+	if err != nil || len(all) != 3 {
+		t.Fatalf("expected 3 runs, got %d, %v", len(all), err)
+	}
+	if all[0].Event != "pre.merge" || !all[0].TimedOut {
+		t.Errorf("expected newest run first, got %+v", all[0])
+	}
+
+	failed, _ := db.ListHookRuns(HookRunFilter{Failed: true})
+	if len(failed) != 2 {
+		t.Errorf("expected 2 failed runs, got %d", len(failed))
+	}
+	byRepo, _ := db.ListHookRuns(HookRunFilter{Repo: "app", Event: "task.failed"})
+	if len(byRepo) != 1 || byRepo[0].Stderr != "no route" || byRepo[0].ExitCode != 2 {
+		t.Errorf("unexpected filtered runs: %+v", byRepo)
+	}
+	if limited, _ := db.ListHookRuns(HookRunFilter{Limit: 1}); len(limited) != 1 {
+		t.Errorf("expected limit to apply, got %d", len(limited))
+	}
+}
+
+func TestHookRunsArePruned(t *testing.T) {
+	db := mustOpenMemory(t)
+
+	for i := 0; i < MaxHookRuns+5; i++ {
+		if err := db.RecordHookRun(&HookRun{Event: "task.claimed", Command: fmt.Sprintf("echo %d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runs, _ := db.ListHookRuns(HookRunFilter{})
+	if len(runs) != MaxHookRuns {
+		t.Fatalf("expected %d runs kept, got %d", MaxHookRuns, len(runs))
+	}
+	if runs[len(runs)-1].Command != "echo 5" {
+		t.Errorf("expected the oldest runs to be pruned, oldest kept is %q", runs[len(runs)-1].Command)
+	}
+}
+
+// --- Agent tokens ---
+
+func TestAgentTokens(t *testing.T) {
+	db := mustOpenMemory(t)
+
+	agent, _ := db.RegisterAgent("builder", "claude")
+	token, record, err := db.CreateAgentToken(agent.ID)
+	if err != nil {
+		t.Fatalf("CreateAgentToken: %v", err)
+	}
+	if !strings.HasPrefix(token, TokenPrefix) || !strings.HasPrefix(token, record.Hint) {
+		t.Errorf("expected token %q to start with %s and hint %q", token, TokenPrefix, record.Hint)
+	}
+
+	got, err := db.AgentForToken(token)
+	if err != nil || got.ID != agent.ID {
+		t.Fatalf("expected the token to identify builder, got %v, %v", got, err)
+	}
+	if _, err := db.AgentForToken(token + "x"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected ErrInvalidToken for an unknown token, got %v", err)
+	}
+	tokens, _ := db.ListAgentTokens(agent.ID)
+	if len(tokens) != 1 || tokens[0].LastUsedAt == nil {
+		t.Fatalf("expected one used token, got %+v", tokens)
+	}
+
+	found, err := db.FindAgentToken(record.ID[:8])
+	if err != nil || found.ID != record.ID {
+		t.Fatalf("expected prefix to find the token, got %v, %v", found, err)
+	}
+	if err := db.RevokeAgentToken(found.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.AgentForToken(token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected a revoked token to be rejected, got %v", err)
+	}
+
+	// Removing an agent revokes its tokens
+	token, _, _ = db.CreateAgentToken(agent.ID)
+	if err := db.RemoveAgent("builder"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.AgentForToken(token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected the removed agent's token to be rejected, got %v", err)
+	}
+}
@@ -2,6 +2,7 @@ package registry
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"time"
 
@@ -25,7 +26,13 @@ func (db *DB) AddRepo(name, path, remoteURL, defaultBranch string) (*Repo, error
 	id := uuid.New().String()
 	now := time.Now()
 
-	_, err := db.conn.Exec(
+	tx, err := db.begin()
+	if err != nil {
+		return nil, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
 		`INSERT INTO repos (id, name, path, remote_url, default_branch, added_at)
 		 VALUES (?, ?, ?, ?, ?, ?)`,
 		id, name, path, remoteURL, defaultBranch, now,
@@ -33,6 +40,15 @@ func (db *DB) AddRepo(name, path, remoteURL, defaultBranch string) (*Repo, error
 	if err != nil {
 		return nil, fmt.Errorf("could not add repo: %w", err)
 	}
+	if err := recordEvent(tx, &Event{
+		Type: EventRepoAdded, RepoID: &id, EntityType: "repo", EntityID: id,
+		Payload: map[string]any{"name": name, "path": path, "default_branch": defaultBranch}, CreatedAt: now,
+	}); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not commit transaction: %w", err)
+	}
 
 	return &Repo{
 		ID:            id,
@@ -103,6 +119,127 @@ func (db *DB) ListRepos() ([]*Repo, error) {
 	return repos, nil
 }
 
+// Setting returns a per-repo setting stored in the repo's metadata, or ""
+// if it is not set
+func (r *Repo) Setting(key string) string {
+	settings, err := decodeRepoMetadata(r.Metadata)
+	if err != nil {
+		return ""
+	}
+	v, _ := settings[key].(string)
+	return v
+}
+
+// SettingList returns a list-valued per-repo setting, or nil if unset
+func (r *Repo) SettingList(key string) []string {
+	settings, err := decodeRepoMetadata(r.Metadata)
+	if err != nil {
+		return nil
+	}
+	items, _ := settings[key].([]any)
+	var values []string
+	for _, item := range items {
+		if v, ok := item.(string); ok {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// ConfigOverrides returns the config values overridden for this repo in the
+// registry, keyed by dot-notation config key
+func (r *Repo) ConfigOverrides() map[string]string {
+	settings, err := decodeRepoMetadata(r.Metadata)
+	if err != nil {
+		return nil
+	}
+	stored, _ := settings["config"].(map[string]any)
+	overrides := make(map[string]string, len(stored))
+	for key, value := range stored {
+		if v, ok := value.(string); ok {
+			overrides[key] = v
+		}
+	}
+	return overrides
+}
+
+// SetRepoConfig overrides a dot-notation config key for one repo. An empty
+// value removes the override.
+func (db *DB) SetRepoConfig(repoID, key, value string) error {
+	repo, err := db.GetRepoByID(repoID)
+	if err != nil {
+		return err
+	}
+	overrides := repo.ConfigOverrides()
+	if overrides == nil {
+		overrides = make(map[string]string)
+	}
+	if value == "" {
+		delete(overrides, key)
+	} else {
+		overrides[key] = value
+	}
+	if len(overrides) == 0 {
+		return db.setRepoMetadata(repoID, "config", nil)
+	}
+	return db.setRepoMetadata(repoID, "config", overrides)
+}
+
+// SetRepoSetting stores a per-repo setting in the repo's metadata.
+// An empty value removes the setting.
+func (db *DB) SetRepoSetting(repoID, key, value string) error {
+	if value == "" {
+		return db.setRepoMetadata(repoID, key, nil)
+	}
+	return db.setRepoMetadata(repoID, key, value)
+}
+
+// SetRepoSettingList stores a list-valued per-repo setting. An empty list
+// removes the setting.
+func (db *DB) SetRepoSettingList(repoID, key string, values []string) error {
+	if len(values) == 0 {
+		return db.setRepoMetadata(repoID, key, nil)
+	}
+	return db.setRepoMetadata(repoID, key, values)
+}
+
+// setRepoMetadata sets one key in the repo's metadata; nil removes it
+func (db *DB) setRepoMetadata(repoID, key string, value any) error {
+	repo, err := db.GetRepoByID(repoID)
+	if err != nil {
+		return err
+	}
+	settings, err := decodeRepoMetadata(repo.Metadata)
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		delete(settings, key)
+	} else {
+		settings[key] = value
+	}
+
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return fmt.Errorf("could not encode repo metadata: %w", err)
+	}
+	if _, err := db.conn.Exec(`UPDATE repos SET metadata = ? WHERE id = ?`, string(data), repoID); err != nil {
+		return fmt.Errorf("could not update repo metadata: %w", err)
+	}
+	return nil
+}
+
+func decodeRepoMetadata(metadata string) (map[string]any, error) {
+	settings := make(map[string]any)
+	if metadata == "" {
+		return settings, nil
+	}
+	if err := json.Unmarshal([]byte(metadata), &settings); err != nil {
+		return nil, fmt.Errorf("could not decode repo metadata: %w", err)
+	}
+	return settings, nil
+}
+
 // RemoveRepo deletes a repo by name (cascades to worktrees, tasks, file_touches)
 func (db *DB) RemoveRepo(name string) error {
 	result, err := db.conn.Exec(`DELETE FROM repos WHERE name = ?`, name)
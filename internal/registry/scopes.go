@@ -0,0 +1,195 @@
+package registry
+
+import "fmt"
+
+// ScopeOverlap is a pending task whose declared scope overlaps other work:
+// the scope of a claimed, in-progress or other pending task, or a file that
+// an active worktree has already changed
+type ScopeOverlap struct {
+	TaskID      string // the pending task
+	Pattern     string // its scope pattern that overlaps
+	OtherTaskID string // the other task, if known
+	OtherStatus string // the other task's status
+	WorktreeID  string // the worktree doing the other work, if any
+	Path        string // the other task's scope pattern, or the changed file
+	Changed     bool   // Path is a file the worktree has changed
+}
+
+// InFlight reports whether the overlap is with work already under way rather
+// than with another pending task
+func (o ScopeOverlap) InFlight() bool {
+	return o.OtherStatus != "pending"
+}
+
+// ScopeDrift is a file changed in a task's worktree that falls outside the
+// task's declared scope
+type ScopeDrift struct {
+	TaskID     string
+	WorktreeID string
+	Path       string
+}
+
+type worktreeTouch struct {
+	worktreeID string
+	path       string
+}
+
+// activeTouches returns the recorded file touches of a repo's active worktrees
+func (db *DB) activeTouches(repoID string) ([]worktreeTouch, error) {
+	rows, err := db.conn.Query(
+		`SELECT ft.worktree_id, ft.file_path
+		 FROM file_touches ft
+		 JOIN worktrees w ON ft.worktree_id = w.id
+		 WHERE ft.repo_id = ? AND w.status = 'active'
+		 ORDER BY ft.worktree_id, ft.file_path`,
+		repoID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not query file touches: %w", err)
+	}
+	defer rows.Close()
+
+	var touches []worktreeTouch
+	for rows.Next() {
+		var t worktreeTouch
+		if err := rows.Scan(&t.worktreeID, &t.path); err != nil {
+			return nil, fmt.Errorf("could not scan row: %w", err)
+		}
+		touches = append(touches, t)
+	}
+	return touches, rows.Err()
+}
+
+// FindScopeOverlaps reports pending tasks whose declared scope overlaps the
+// scope of a claimed or in-progress task, a file an active worktree has
+// already changed, or the scope of another pending task. Each pair of pending
+// tasks is reported once. Tasks without a scope are never reported.
+func (db *DB) FindScopeOverlaps(repoID string) ([]ScopeOverlap, error) {
+	tasks, err := db.ListTasks(repoID, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending, inFlight []*Task
+	taskByWorktree := make(map[string]*Task)
+	for _, t := range tasks {
+		switch t.Status {
+		case "pending":
+			if len(t.Scope) > 0 {
+				pending = append(pending, t)
+			}
+		case "claimed", "in_progress":
+			if len(t.Scope) > 0 {
+				inFlight = append(inFlight, t)
+			}
+			if t.WorktreeID != nil {
+				taskByWorktree[*t.WorktreeID] = t
+			}
+		}
+	}
+	if len(pending) == 0 {
+		return nil, nil
+	}
+
+	touches, err := db.activeTouches(repoID)
+	if err != nil {
+		return nil, err
+	}
+
+	var overlaps []ScopeOverlap
+	for i, p := range pending {
+		for _, pattern := range p.Scope {
+			for _, other := range inFlight {
+				for _, otherPattern := range other.Scope {
+					if PatternsOverlap(pattern, otherPattern) {
+						o := ScopeOverlap{TaskID: p.ID, Pattern: pattern, OtherTaskID: other.ID, OtherStatus: other.Status, Path: otherPattern}
+						if other.WorktreeID != nil {
+							o.WorktreeID = *other.WorktreeID
+						}
+						overlaps = append(overlaps, o)
+					}
+				}
+			}
+			for _, touch := range touches {
+				if !MatchPath(pattern, touch.path) {
+					continue
+				}
+				o := ScopeOverlap{TaskID: p.ID, Pattern: pattern, WorktreeID: touch.worktreeID, Path: touch.path, Changed: true}
+				if other := taskByWorktree[touch.worktreeID]; other != nil {
+					o.OtherTaskID, o.OtherStatus = other.ID, other.Status
+				}
+				overlaps = append(overlaps, o)
+			}
+			for _, other := range pending[i+1:] {
+				for _, otherPattern := range other.Scope {
+					if PatternsOverlap(pattern, otherPattern) {
+						overlaps = append(overlaps, ScopeOverlap{
+							TaskID: p.ID, Pattern: pattern, OtherTaskID: other.ID, OtherStatus: other.Status, Path: otherPattern,
+						})
+					}
+				}
+			}
+		}
+	}
+	return overlaps, nil
+}
+
+// FindScopeDrift reports files changed in the worktrees of scoped tasks that
+// fall outside the task's declared scope, i.e. agents that wandered outside
+// their assignment
+func (db *DB) FindScopeDrift(repoID string) ([]ScopeDrift, error) {
+	tasks, err := db.ListTasks(repoID, nil)
+	if err != nil {
+		return nil, err
+	}
+	scoped := make(map[string]*Task)
+	for _, t := range tasks {
+		if len(t.Scope) > 0 && t.WorktreeID != nil {
+			scoped[*t.WorktreeID] = t
+		}
+	}
+	if len(scoped) == 0 {
+		return nil, nil
+	}
+
+	touches, err := db.activeTouches(repoID)
+	if err != nil {
+		return nil, err
+	}
+
+	var drift []ScopeDrift
+	for _, touch := range touches {
+		t := scoped[touch.worktreeID]
+		if t == nil || InScope(t.Scope, touch.path) {
+			continue
+		}
+		drift = append(drift, ScopeDrift{TaskID: t.ID, WorktreeID: touch.worktreeID, Path: touch.path})
+	}
+	return drift, nil
+}
+
+// InScope reports whether a file path matches any pattern in a task scope
+func InScope(scope []string, path string) bool {
+	for _, pattern := range scope {
+		if MatchPath(pattern, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// ScopeOverlapsFor returns the overlaps FindScopeOverlaps reports for one
+// pending task, whichever side of the pair it is on
+func (db *DB) ScopeOverlapsFor(task *Task) ([]ScopeOverlap, error) {
+	all, err := db.FindScopeOverlaps(task.RepoID)
+	if err != nil {
+		return nil, err
+	}
+	var overlaps []ScopeOverlap
+	for _, o := range all {
+		if o.TaskID == task.ID || o.OtherTaskID == task.ID {
+			overlaps = append(overlaps, o)
+		}
+	}
+	return overlaps, nil
+}
@@ -2,6 +2,7 @@ package registry
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"time"
 
@@ -20,20 +21,90 @@ type Task struct {
 	CreatedAt       time.Time
 	CompletedAt     *time.Time
 	Result          *string
+	LeaseExpiresAt  *time.Time
+	Attempts        int
+	Scope           []string // path globs the task is expected to touch
 }
 
-// CreateTask creates a new task
-func (db *DB) CreateTask(repoID, description string, priority int) (*Task, error) {
+// taskColumns is the column list shared by every task query; keep it in
+// sync with scanTask.
+const taskColumns = `id, repo_id, description, priority, status, assigned_agent_id, worktree_id,
+	created_at, completed_at, result, lease_expires_at, attempts, scope`
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanTask(row rowScanner) (*Task, error) {
+	t := &Task{}
+	var scope sql.NullString
+	err := row.Scan(&t.ID, &t.RepoID, &t.Description, &t.Priority, &t.Status, &t.AssignedAgentID,
+		&t.WorktreeID, &t.CreatedAt, &t.CompletedAt, &t.Result, &t.LeaseExpiresAt, &t.Attempts, &scope)
+	if err != nil {
+		return nil, err
+	}
+	if scope.Valid && scope.String != "" {
+		if err := json.Unmarshal([]byte(scope.String), &t.Scope); err != nil {
+			return nil, fmt.Errorf("could not decode task scope: %w", err)
+		}
+	}
+	return t, nil
+}
+
+// CreateTask creates a new task. The optional scope lists the path globs the
+// task is expected to touch; see FindScopeOverlaps.
+func (db *DB) CreateTask(repoID, description string, priority int, scope ...string) (*Task, error) {
+	return db.createTask(repoID, description, priority, nil, scope)
+}
+
+func (db *DB) createTask(repoID, description string, priority int, dependsOn, scope []string) (*Task, error) {
 	id := "t-" + uuid.New().String()[:8]
 	now := time.Now()
 
-	_, err := db.conn.Exec(
-		`INSERT INTO tasks (id, repo_id, description, priority, status, created_at) VALUES (?, ?, ?, ?, 'pending', ?)`,
-		id, repoID, description, priority, now,
+	var scopeJSON sql.NullString
+	if len(scope) > 0 {
+		normalized := make([]string, len(scope))
+		for i, pattern := range scope {
+			if err := ValidatePattern(pattern); err != nil {
+				return nil, err
+			}
+			normalized[i] = normalizePattern(pattern)
+		}
+		scope = normalized
+		data, err := json.Marshal(scope)
+		if err != nil {
+			return nil, fmt.Errorf("could not encode task scope: %w", err)
+		}
+		scopeJSON = sql.NullString{String: string(data), Valid: true}
+	}
+
+	tx, err := db.begin()
+	if err != nil {
+		return nil, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		`INSERT INTO tasks (id, repo_id, description, priority, status, created_at, scope) VALUES (?, ?, ?, ?, 'pending', ?, ?)`,
+		id, repoID, description, priority, now, scopeJSON,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("could not create task: %w", err)
 	}
+	payload := map[string]any{"description": description, "priority": priority}
+	if len(scope) > 0 {
+		payload["scope"] = scope
+	}
+	if err := recordTaskEvents(tx, EventTaskCreated, payload, "t.id = ?", id); err != nil {
+		return nil, err
+	}
+	if err := addNewTaskDependencies(tx, repoID, id, dependsOn); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not commit transaction: %w", err)
+	}
 
 	return &Task{
 		ID:          id,
@@ -42,14 +113,23 @@ func (db *DB) CreateTask(repoID, description string, priority int) (*Task, error
 		Priority:    priority,
 		Status:      "pending",
 		CreatedAt:   now,
+		Scope:       scope,
 	}, nil
 }
 
-// ClaimTask assigns a task to an agent
+// ClaimTask assigns a task to an agent. The claim is held under a lease that
+// the agent must keep renewing with heartbeats; see ReclaimExpiredTasks.
 func (db *DB) ClaimTask(taskID, agentID string) error {
-	result, err := db.conn.Exec(
-		`UPDATE tasks SET status = 'claimed', assigned_agent_id = ? WHERE id = ? AND status = 'pending'`,
-		agentID, taskID,
+	tx, err := db.begin()
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		`UPDATE tasks SET status = 'claimed', assigned_agent_id = ?, lease_expires_at = ?, attempts = attempts + 1
+		 WHERE id = ? AND status = 'pending'`,
+		agentID, time.Now().Add(db.lease.Duration), taskID,
 	)
 	if err != nil {
 		return fmt.Errorf("could not claim task: %w", err)
@@ -58,84 +138,171 @@ func (db *DB) ClaimTask(taskID, agentID string) error {
 	if rows == 0 {
 		return fmt.Errorf("task %q not found or already claimed", taskID)
 	}
+	if err := recordTaskEvents(tx, EventTaskClaimed, nil, "t.id = ?", taskID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %w", err)
+	}
 	return nil
 }
 
 // StartTask marks a task as in_progress and associates a worktree
 func (db *DB) StartTask(taskID, worktreeID string) error {
-	_, err := db.conn.Exec(
+	tx, err := db.begin()
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
 		`UPDATE tasks SET status = 'in_progress', worktree_id = ? WHERE id = ?`,
 		worktreeID, taskID,
-	)
-	return err
+	); err != nil {
+		return err
+	}
+	if err := recordTaskEvents(tx, EventTaskStarted,
+		map[string]any{"worktree_id": worktreeID}, "t.id = ?", taskID); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 // CompleteTask marks a task as completed
 func (db *DB) CompleteTask(taskID string, result *string) error {
-	now := time.Now()
-	res, err := db.conn.Exec(
-		`UPDATE tasks SET status = 'completed', completed_at = ?, result = ? WHERE id = ?`,
-		now, result, taskID,
+	return db.finishTask(taskID, "completed", EventTaskCompleted, result)
+}
+
+// FailTask marks a task as failed
+func (db *DB) FailTask(taskID string, result *string) error {
+	return db.finishTask(taskID, "failed", EventTaskFailed, result)
+}
+
+func (db *DB) finishTask(taskID, status, eventType string, result *string) error {
+	tx, err := db.begin()
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(
+		`UPDATE tasks SET status = ?, completed_at = ?, result = ?, lease_expires_at = NULL WHERE id = ?`,
+		status, time.Now(), result, taskID,
 	)
 	if err != nil {
-		return fmt.Errorf("could not complete task: %w", err)
+		return fmt.Errorf("could not mark task %s: %w", status, err)
 	}
 	rows, _ := res.RowsAffected()
 	if rows == 0 {
 		return fmt.Errorf("task %q not found", taskID)
 	}
-	return nil
-}
 
-// FailTask marks a task as failed
-func (db *DB) FailTask(taskID string, result *string) error {
-	now := time.Now()
-	_, err := db.conn.Exec(
-		`UPDATE tasks SET status = 'failed', completed_at = ?, result = ? WHERE id = ?`,
-		now, result, taskID,
-	)
-	return err
+	var payload map[string]any
+	if result != nil {
+		payload = map[string]any{"result": *result}
+	}
+	if err := recordTaskEvents(tx, eventType, payload, "t.id = ?", taskID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %w", err)
+	}
+	return nil
 }
 
 // NextTask atomically claims the highest-priority pending task for a repo.
+// Tasks with a dependency that is not yet completed are skipped, and expired
+// leases are reclaimed first so abandoned tasks become available again.
+// Tasks whose declared scope overlaps work already in flight are only handed
+// out once no other task is available.
 // Returns nil if no pending tasks exist. Priority DESC, then FIFO by created_at ASC.
 func (db *DB) NextTask(repoID, agentID string) (*Task, error) {
-	tx, err := db.conn.Begin()
+	return db.NextTaskChecked(repoID, agentID, nil)
+}
+
+// NextTaskChecked is NextTask with a check run on the chosen task before it
+// is claimed, outside any transaction. An error from check leaves the task
+// pending and is returned as is. If another agent claims the task while the
+// check runs, the next candidate is checked instead.
+func (db *DB) NextTaskChecked(repoID, agentID string, check func(*Task) error) (*Task, error) {
+	if _, err := db.ReclaimExpiredTasks(); err != nil {
+		return nil, err
+	}
+
+	overlaps, err := db.FindScopeOverlaps(repoID)
+	if err != nil {
+		return nil, err
+	}
+	var risky []string
+	for _, o := range overlaps {
+		if o.InFlight() {
+			risky = append(risky, o.TaskID)
+		}
+	}
+	riskyJSON, err := json.Marshal(risky)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode task IDs: %w", err)
+	}
+
+	for {
+		candidate, err := scanTask(db.conn.QueryRow(
+			`SELECT `+taskColumns+` FROM tasks WHERE id = (`+nextTaskQuery+`)`,
+			repoID, string(riskyJSON),
+		))
+		if err == sql.ErrNoRows {
+			return nil, nil // No pending tasks
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not find next task: %w", err)
+		}
+		if check != nil {
+			if err := check(candidate); err != nil {
+				return nil, err
+			}
+		}
+
+		t, err := db.claimPending(candidate.ID, agentID)
+		if err != nil || t != nil {
+			return t, err
+		}
+	}
+}
+
+// nextTaskQuery selects the ID of the task NextTask hands out next. It takes
+// the repo ID and a JSON array of task IDs to hand out last.
+const nextTaskQuery = `SELECT t.id FROM tasks t
+		   WHERE t.repo_id = ? AND t.status = 'pending'
+		     AND NOT EXISTS (
+		       SELECT 1 FROM task_dependencies d
+		       JOIN tasks dep ON dep.id = d.depends_on_id
+		       WHERE d.task_id = t.id AND dep.status != 'completed'
+		     )
+		   ORDER BY t.id IN (SELECT value FROM json_each(?)) ASC, t.priority DESC, t.created_at ASC
+		   LIMIT 1`
+
+// claimPending claims a task for an agent if it is still pending, returning
+// nil if another agent got to it first
+func (db *DB) claimPending(taskID, agentID string) (*Task, error) {
+	tx, err := db.begin()
 	if err != nil {
 		return nil, fmt.Errorf("could not begin transaction: %w", err)
 	}
 	defer tx.Rollback()
 
-	// Atomically update the highest-priority pending task
-	result, err := tx.Exec(
-		`UPDATE tasks SET status = 'claimed', assigned_agent_id = ?
-		 WHERE id = (
-		   SELECT id FROM tasks
-		   WHERE repo_id = ? AND status = 'pending'
-		   ORDER BY priority DESC, created_at ASC
-		   LIMIT 1
-		 )`,
-		agentID, repoID,
-	)
+	t, err := scanTask(tx.QueryRow(
+		`UPDATE tasks SET status = 'claimed', assigned_agent_id = ?, lease_expires_at = ?, attempts = attempts + 1
+		 WHERE id = ? AND status = 'pending'
+		 RETURNING `+taskColumns,
+		agentID, time.Now().Add(db.lease.Duration), taskID,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
 	if err != nil {
 		return nil, fmt.Errorf("could not claim next task: %w", err)
 	}
-	rows, _ := result.RowsAffected()
-	if rows == 0 {
-		return nil, nil // No pending tasks
-	}
-
-	// Fetch the task we just claimed
-	t := &Task{}
-	err = tx.QueryRow(
-		`SELECT id, repo_id, description, priority, status, assigned_agent_id, worktree_id, created_at, completed_at, result
-		 FROM tasks WHERE repo_id = ? AND assigned_agent_id = ? AND status = 'claimed'
-		 ORDER BY priority DESC, created_at ASC LIMIT 1`,
-		repoID, agentID,
-	).Scan(&t.ID, &t.RepoID, &t.Description, &t.Priority, &t.Status, &t.AssignedAgentID,
-		&t.WorktreeID, &t.CreatedAt, &t.CompletedAt, &t.Result)
-	if err != nil {
-		return nil, fmt.Errorf("could not fetch claimed task: %w", err)
+	if err := recordTaskEvents(tx, EventTaskClaimed, nil, "t.id = ?", t.ID); err != nil {
+		return nil, err
 	}
 
 	if err := tx.Commit(); err != nil {
@@ -146,12 +313,9 @@ func (db *DB) NextTask(repoID, agentID string) (*Task, error) {
 
 // GetTask retrieves a task by ID
 func (db *DB) GetTask(id string) (*Task, error) {
-	t := &Task{}
-	err := db.conn.QueryRow(
-		`SELECT id, repo_id, description, priority, status, assigned_agent_id, worktree_id, created_at, completed_at, result
-		 FROM tasks WHERE id = ?`, id,
-	).Scan(&t.ID, &t.RepoID, &t.Description, &t.Priority, &t.Status, &t.AssignedAgentID,
-		&t.WorktreeID, &t.CreatedAt, &t.CompletedAt, &t.Result)
+	t, err := scanTask(db.conn.QueryRow(
+		`SELECT `+taskColumns+` FROM tasks WHERE id = ?`, id,
+	))
 
 	if err == sql.ErrNoRows {
 		return nil, fmt.Errorf("task %q not found", id)
@@ -162,6 +326,22 @@ func (db *DB) GetTask(id string) (*Task, error) {
 	return t, nil
 }
 
+// FindTaskForWorktree returns the most recently created task linked to a
+// worktree, or nil if the worktree has no task
+func (db *DB) FindTaskForWorktree(worktreeID string) (*Task, error) {
+	t, err := scanTask(db.conn.QueryRow(
+		`SELECT `+taskColumns+` FROM tasks WHERE worktree_id = ? ORDER BY created_at DESC LIMIT 1`,
+		worktreeID,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not find task for worktree: %w", err)
+	}
+	return t, nil
+}
+
 // ListTasks returns tasks for a repo, optionally filtered by status
 func (db *DB) ListTasks(repoID string, status *string) ([]*Task, error) {
 	var rows *sql.Rows
@@ -169,13 +349,13 @@ func (db *DB) ListTasks(repoID string, status *string) ([]*Task, error) {
 
 	if status != nil {
 		rows, err = db.conn.Query(
-			`SELECT id, repo_id, description, priority, status, assigned_agent_id, worktree_id, created_at, completed_at, result
+			`SELECT `+taskColumns+`
 			 FROM tasks WHERE repo_id = ? AND status = ? ORDER BY priority DESC, created_at DESC`,
 			repoID, *status,
 		)
 	} else {
 		rows, err = db.conn.Query(
-			`SELECT id, repo_id, description, priority, status, assigned_agent_id, worktree_id, created_at, completed_at, result
+			`SELECT `+taskColumns+`
 			 FROM tasks WHERE repo_id = ? ORDER BY priority DESC, created_at DESC`,
 			repoID,
 		)
@@ -187,9 +367,8 @@ func (db *DB) ListTasks(repoID string, status *string) ([]*Task, error) {
 
 	var tasks []*Task
 	for rows.Next() {
-		t := &Task{}
-		if err := rows.Scan(&t.ID, &t.RepoID, &t.Description, &t.Priority, &t.Status, &t.AssignedAgentID,
-			&t.WorktreeID, &t.CreatedAt, &t.CompletedAt, &t.Result); err != nil {
+		t, err := scanTask(rows)
+		if err != nil {
 			return nil, fmt.Errorf("could not scan task: %w", err)
 		}
 		tasks = append(tasks, t)
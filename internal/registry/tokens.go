@@ -0,0 +1,162 @@
+package registry
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TokenPrefix starts every agent token, so that a leaked token is easy to
+// recognise
+const TokenPrefix = "agit_"
+
+// ErrInvalidToken is returned for a token that was never issued or has been
+// revoked
+var ErrInvalidToken = errors.New("invalid agent token")
+
+// AgentToken is a bearer token that authenticates an agent to agit serve over
+// HTTP. Only a hash of the token is stored; the token itself is shown once,
+// when it is created.
+type AgentToken struct {
+	ID         string
+	AgentID    string
+	Hint       string // the start of the token, to tell tokens apart
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+}
+
+const tokenColumns = `id, agent_id, hint, created_at, last_used_at`
+
+func scanToken(row rowScanner) (*AgentToken, error) {
+	t := &AgentToken{}
+	if err := row.Scan(&t.ID, &t.AgentID, &t.Hint, &t.CreatedAt, &t.LastUsedAt); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAgentToken issues a new token for an agent. It returns the token,
+// which cannot be recovered later, along with its record.
+func (db *DB) CreateAgentToken(agentID string) (string, *AgentToken, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, fmt.Errorf("could not generate token: %w", err)
+	}
+	token := TokenPrefix + hex.EncodeToString(secret)
+
+	t := &AgentToken{
+		ID:        uuid.New().String(),
+		AgentID:   agentID,
+		Hint:      token[:len(TokenPrefix)+6],
+		CreatedAt: time.Now(),
+	}
+	if _, err := db.conn.Exec(
+		`INSERT INTO agent_tokens (id, agent_id, token_hash, hint, created_at) VALUES (?, ?, ?, ?, ?)`,
+		t.ID, t.AgentID, hashToken(token), t.Hint, t.CreatedAt,
+	); err != nil {
+		return "", nil, fmt.Errorf("could not create token: %w", err)
+	}
+	return token, t, nil
+}
+
+// AgentForToken returns the agent a token belongs to and records that the
+// token was used
+func (db *DB) AgentForToken(token string) (*Agent, error) {
+	hash := hashToken(token)
+	var agentID string
+	err := db.conn.QueryRow(`SELECT agent_id FROM agent_tokens WHERE token_hash = ?`, hash).Scan(&agentID)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not look up token: %w", err)
+	}
+	if _, err := db.conn.Exec(`UPDATE agent_tokens SET last_used_at = ? WHERE token_hash = ?`, time.Now(), hash); err != nil {
+		return nil, fmt.Errorf("could not record token use: %w", err)
+	}
+	return db.GetAgent(agentID)
+}
+
+// ListAgentTokens returns an agent's tokens, or every agent's when agentID
+// is empty, newest first
+func (db *DB) ListAgentTokens(agentID string) ([]*AgentToken, error) {
+	query := `SELECT ` + tokenColumns + ` FROM agent_tokens`
+	var args []any
+	if agentID != "" {
+		query += ` WHERE agent_id = ?`
+		args = append(args, agentID)
+	}
+	rows, err := db.conn.Query(query+` ORDER BY created_at DESC, rowid DESC`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not list tokens: %w", err)
+	}
+	defer rows.Close()
+
+	var tokens []*AgentToken
+	for rows.Next() {
+		t, err := scanToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("could not scan token: %w", err)
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// FindAgentToken looks up a token by ID or unique ID prefix
+func (db *DB) FindAgentToken(idOrPrefix string) (*AgentToken, error) {
+	rows, err := db.conn.Query(
+		`SELECT `+tokenColumns+` FROM agent_tokens WHERE id = ? OR id LIKE ? || '%' LIMIT 2`,
+		idOrPrefix, idOrPrefix,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not find token: %w", err)
+	}
+	defer rows.Close()
+
+	var found []*AgentToken
+	for rows.Next() {
+		t, err := scanToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("could not scan token: %w", err)
+		}
+		if t.ID == idOrPrefix {
+			return t, nil
+		}
+		found = append(found, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("token %q not found", idOrPrefix)
+	case 1:
+		return found[0], nil
+	default:
+		return nil, fmt.Errorf("token prefix %q is ambiguous", idOrPrefix)
+	}
+}
+
+// RevokeAgentToken deletes a token; it no longer authenticates its agent
+func (db *DB) RevokeAgentToken(id string) error {
+	res, err := db.conn.Exec(`DELETE FROM agent_tokens WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("could not revoke token: %w", err)
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return fmt.Errorf("token %q not found", id)
+	}
+	return nil
+}
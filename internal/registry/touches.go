@@ -1,27 +1,55 @@
 package registry
 
 import (
+	"database/sql"
+	"encoding/json"
 	"fmt"
+	"sort"
 	"time"
+
+	"github.com/fathindos/agit/internal/lines"
 )
 
-// FileTouch represents a file modification in a worktree
+// DefaultConflictContext is the number of lines around a hunk that still
+// counts as overlapping another worktree's hunk
+const DefaultConflictContext = 3
+
+// FileTouch represents a file modification in a worktree.
+// Ranges holds the changed hunks; nil means the whole file is considered
+// touched (added, deleted or binary files, or no hunk information).
+// Uncommitted is set when the file has edits that are only in the working
+// tree; its ranges then cover both committed and uncommitted hunks.
 type FileTouch struct {
-	RepoID     string
-	WorktreeID string
-	FilePath   string
-	ChangeType string
-	UpdatedAt  time.Time
+	RepoID      string
+	WorktreeID  string
+	FilePath    string
+	ChangeType  string
+	Ranges      []lines.Range
+	Uncommitted bool
+	UpdatedAt   time.Time
 }
 
-// RecordFileTouches replaces all file touch records for a worktree
+// SetConflictContext sets how many lines apart two hunks may be and still be
+// reported as a conflict
+func (db *DB) SetConflictContext(lines int) {
+	db.conflictContext = lines
+}
+
+// RecordFileTouches replaces all file touch records for a worktree. When the
+// new records differ from the old ones, a touches.updated event is logged so
+// that whoever follows the repo's conflicts sees the change.
 func (db *DB) RecordFileTouches(repoID, worktreeID string, touches []FileTouch) error {
-	tx, err := db.conn.Begin()
+	tx, err := db.begin()
 	if err != nil {
 		return fmt.Errorf("could not begin transaction: %w", err)
 	}
 	defer tx.Rollback()
 
+	previous, err := touchKeys(tx, repoID, worktreeID)
+	if err != nil {
+		return err
+	}
+
 	// Clear existing touches for this worktree
 	if _, err := tx.Exec(
 		`DELETE FROM file_touches WHERE repo_id = ? AND worktree_id = ?`,
@@ -33,39 +61,145 @@ func (db *DB) RecordFileTouches(repoID, worktreeID string, touches []FileTouch)
 	// Insert new touches
 	now := time.Now()
 	stmt, err := tx.Prepare(
-		`INSERT INTO file_touches (repo_id, worktree_id, file_path, change_type, updated_at)
-		 VALUES (?, ?, ?, ?, ?)`,
+		`INSERT INTO file_touches (repo_id, worktree_id, file_path, change_type, line_ranges, uncommitted, updated_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?)`,
 	)
 	if err != nil {
 		return fmt.Errorf("could not prepare statement: %w", err)
 	}
 	defer stmt.Close()
 
+	current := make(map[string]bool, len(touches))
 	for _, t := range touches {
 		changeType := t.ChangeType
 		if changeType == "" {
 			changeType = "modified"
 		}
-		if _, err := stmt.Exec(repoID, worktreeID, t.FilePath, changeType, now); err != nil {
+		ranges, err := encodeLineRanges(t.Ranges)
+		if err != nil {
+			return err
+		}
+		if _, err := stmt.Exec(repoID, worktreeID, t.FilePath, changeType, ranges, t.Uncommitted, now); err != nil {
 			return fmt.Errorf("could not insert file touch: %w", err)
 		}
+		current[touchKey(t.FilePath, changeType, ranges, t.Uncommitted)] = true
+	}
+
+	if !sameKeys(previous, current) {
+		if err := recordEvent(tx, &Event{
+			Type:       EventTouchesUpdated,
+			RepoID:     &repoID,
+			EntityType: "worktree",
+			EntityID:   worktreeID,
+			Payload:    map[string]any{"files": len(touches)},
+			CreatedAt:  now,
+		}); err != nil {
+			return err
+		}
 	}
 
 	return tx.Commit()
 }
 
+// touchKeys returns the stored touches of a worktree, keyed by touchKey
+func touchKeys(tx *eventTx, repoID, worktreeID string) (map[string]bool, error) {
+	rows, err := tx.Query(
+		`SELECT file_path, change_type, line_ranges, uncommitted FROM file_touches
+		 WHERE repo_id = ? AND worktree_id = ?`,
+		repoID, worktreeID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not query file touches: %w", err)
+	}
+	defer rows.Close()
+
+	keys := make(map[string]bool)
+	for rows.Next() {
+		var filePath, changeType string
+		var ranges sql.NullString
+		var uncommitted bool
+		if err := rows.Scan(&filePath, &changeType, &ranges, &uncommitted); err != nil {
+			return nil, fmt.Errorf("could not scan row: %w", err)
+		}
+		keys[touchKey(filePath, changeType, ranges, uncommitted)] = true
+	}
+	return keys, rows.Err()
+}
+
+func touchKey(filePath, changeType string, ranges sql.NullString, uncommitted bool) string {
+	return fmt.Sprintf("%s\x00%s\x00%t\x00%s\x00%t", filePath, changeType, ranges.Valid, ranges.String, uncommitted)
+}
+
+func sameKeys(a, b map[string]bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k := range a {
+		if !b[k] {
+			return false
+		}
+	}
+	return true
+}
+
+// encodeLineRanges stores ranges as a JSON array of [start, end] pairs
+func encodeLineRanges(ranges []lines.Range) (sql.NullString, error) {
+	if ranges == nil {
+		return sql.NullString{}, nil
+	}
+	pairs := make([][2]int, len(ranges))
+	for i, r := range ranges {
+		pairs[i] = [2]int{r.Start, r.End}
+	}
+	data, err := json.Marshal(pairs)
+	if err != nil {
+		return sql.NullString{}, fmt.Errorf("could not encode line ranges: %w", err)
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+func decodeLineRanges(data sql.NullString) ([]lines.Range, error) {
+	if !data.Valid {
+		return nil, nil
+	}
+	var pairs [][2]int
+	if err := json.Unmarshal([]byte(data.String), &pairs); err != nil {
+		return nil, fmt.Errorf("could not decode line ranges: %w", err)
+	}
+	ranges := make([]lines.Range, len(pairs))
+	for i, p := range pairs {
+		ranges[i] = lines.Range{Start: p[0], End: p[1]}
+	}
+	return ranges, nil
+}
+
 // Conflict represents overlapping file modifications across worktrees
 type Conflict struct {
-	FilePath  string
-	Worktrees []string // worktree IDs
-	AgentIDs  []string // corresponding agent IDs (may be empty)
-	TaskDescs []string // corresponding task descriptions (may be empty)
+	FilePath    string
+	Worktrees   []string        // worktree IDs
+	AgentIDs    []string        // corresponding agent IDs (may be empty)
+	TaskDescs   []string        // corresponding task descriptions (may be empty)
+	Ranges      [][]lines.Range // corresponding overlapping hunks (nil = whole file)
+	Uncommitted []bool          // corresponding worktree has uncommitted edits to the file
 }
 
-// FindConflicts detects files modified in multiple active worktrees for a repo
+// InFlight reports whether any side of the conflict is an uncommitted edit,
+// as opposed to every worktree having committed its change
+func (c Conflict) InFlight() bool {
+	for _, u := range c.Uncommitted {
+		if u {
+			return true
+		}
+	}
+	return false
+}
+
+// FindConflicts detects overlapping changes across active worktrees for a
+// repo. Two worktrees conflict on a file when either touches the whole file
+// or their hunks overlap or sit within the configured context window.
 func (db *DB) FindConflicts(repoID string) ([]Conflict, error) {
 	rows, err := db.conn.Query(
-		`SELECT ft.file_path, ft.worktree_id, w.agent_id, w.task_description
+		`SELECT ft.file_path, ft.worktree_id, ft.line_ranges, ft.uncommitted, w.agent_id, w.task_description
 		 FROM file_touches ft
 		 JOIN worktrees w ON ft.worktree_id = w.id
 		 WHERE ft.repo_id = ? AND w.status = 'active'
@@ -79,18 +213,26 @@ func (db *DB) FindConflicts(repoID string) ([]Conflict, error) {
 
 	// Build map of file -> worktree details
 	type wtDetail struct {
-		worktreeID string
-		agentID    string
-		taskDesc   string
+		worktreeID  string
+		agentID     string
+		taskDesc    string
+		ranges      []lines.Range
+		uncommitted bool
 	}
 	fileMap := make(map[string][]wtDetail)
 
 	for rows.Next() {
 		var filePath, worktreeID string
+		var lineRanges sql.NullString
+		var uncommitted bool
 		var agentID, taskDesc *string
-		if err := rows.Scan(&filePath, &worktreeID, &agentID, &taskDesc); err != nil {
+		if err := rows.Scan(&filePath, &worktreeID, &lineRanges, &uncommitted, &agentID, &taskDesc); err != nil {
 			return nil, fmt.Errorf("could not scan row: %w", err)
 		}
+		ranges, err := decodeLineRanges(lineRanges)
+		if err != nil {
+			return nil, err
+		}
 		aid := ""
 		if agentID != nil {
 			aid = *agentID
@@ -99,23 +241,95 @@ func (db *DB) FindConflicts(repoID string) ([]Conflict, error) {
 		if taskDesc != nil {
 			td = *taskDesc
 		}
-		fileMap[filePath] = append(fileMap[filePath], wtDetail{worktreeID, aid, td})
+		fileMap[filePath] = append(fileMap[filePath], wtDetail{worktreeID, aid, td, ranges, uncommitted})
 	}
 
-	// Filter to files with 2+ worktrees
+	// Keep files where at least one pair of worktrees has overlapping hunks
 	var conflicts []Conflict
 	for filePath, details := range fileMap {
 		if len(details) < 2 {
 			continue
 		}
+
+		overlapping := make([]map[int]bool, len(details))
+		involved := make([]bool, len(details))
+		for i := range details {
+			for j := i + 1; j < len(details); j++ {
+				hit, ri, rj := overlappingRanges(details[i].ranges, details[j].ranges, db.conflictContext)
+				if !hit {
+					continue
+				}
+				involved[i], involved[j] = true, true
+				overlapping[i] = mergeIndexes(overlapping[i], ri)
+				overlapping[j] = mergeIndexes(overlapping[j], rj)
+			}
+		}
+
 		c := Conflict{FilePath: filePath}
-		for _, d := range details {
+		for i, d := range details {
+			if !involved[i] {
+				continue
+			}
 			c.Worktrees = append(c.Worktrees, d.worktreeID)
 			c.AgentIDs = append(c.AgentIDs, d.agentID)
 			c.TaskDescs = append(c.TaskDescs, d.taskDesc)
+			c.Ranges = append(c.Ranges, selectRanges(d.ranges, overlapping[i]))
+			c.Uncommitted = append(c.Uncommitted, d.uncommitted)
+		}
+		if len(c.Worktrees) > 0 {
+			conflicts = append(conflicts, c)
 		}
-		conflicts = append(conflicts, c)
 	}
 
 	return conflicts, nil
 }
+
+// overlappingRanges compares the hunks of two worktrees. It returns whether
+// they overlap and, for each side, the indexes of the hunks involved. A nil
+// range list stands for the whole file and overlaps everything.
+func overlappingRanges(a, b []lines.Range, context int) (bool, []int, []int) {
+	if a == nil || b == nil {
+		return true, allIndexes(a), allIndexes(b)
+	}
+	var ai, bi []int
+	for i, ra := range a {
+		for j, rb := range b {
+			if ra.Overlaps(rb, context) {
+				ai = append(ai, i)
+				bi = append(bi, j)
+			}
+		}
+	}
+	return len(ai) > 0, ai, bi
+}
+
+func allIndexes(ranges []lines.Range) []int {
+	idx := make([]int, len(ranges))
+	for i := range ranges {
+		idx[i] = i
+	}
+	return idx
+}
+
+func mergeIndexes(set map[int]bool, idx []int) map[int]bool {
+	if set == nil {
+		set = make(map[int]bool)
+	}
+	for _, i := range idx {
+		set[i] = true
+	}
+	return set
+}
+
+// selectRanges returns the ranges at the given indexes in file order
+func selectRanges(ranges []lines.Range, idx map[int]bool) []lines.Range {
+	if ranges == nil {
+		return nil
+	}
+	selected := make([]lines.Range, 0, len(idx))
+	for i := range idx {
+		selected = append(selected, ranges[i])
+	}
+	sort.Slice(selected, func(i, j int) bool { return selected[i].Start < selected[j].Start })
+	return selected
+}
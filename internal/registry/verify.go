@@ -0,0 +1,98 @@
+package registry
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// VerifyStep is the outcome of one verify command
+type VerifyStep struct {
+	Command    string `json:"command"`
+	ExitCode   int    `json:"exit_code"`
+	TimedOut   bool   `json:"timed_out,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+	Output     string `json:"output,omitempty"` // combined stdout and stderr, truncated
+}
+
+// VerifyRun records a pre-merge verification of a worktree, or an explicit skip
+type VerifyRun struct {
+	ID         string
+	WorktreeID string
+	CommitSHA  string
+	Status     string // passed, failed, skipped
+	SkippedBy  *string
+	Steps      []VerifyStep
+	StartedAt  time.Time
+	FinishedAt *time.Time
+}
+
+const verifyColumns = `id, worktree_id, commit_sha, status, skipped_by, steps, started_at, finished_at`
+
+func scanVerifyRun(row rowScanner) (*VerifyRun, error) {
+	r := &VerifyRun{}
+	var steps sql.NullString
+	err := row.Scan(&r.ID, &r.WorktreeID, &r.CommitSHA, &r.Status, &r.SkippedBy, &steps, &r.StartedAt, &r.FinishedAt)
+	if err != nil {
+		return nil, err
+	}
+	if steps.Valid && steps.String != "" {
+		if err := json.Unmarshal([]byte(steps.String), &r.Steps); err != nil {
+			return nil, fmt.Errorf("could not decode verify steps: %w", err)
+		}
+	}
+	return r, nil
+}
+
+// RecordVerifyRun stores a verify run against its worktree. ID and
+// timestamps are filled in when empty.
+func (db *DB) RecordVerifyRun(run *VerifyRun) error {
+	if run.ID == "" {
+		run.ID = uuid.New().String()
+	}
+	if run.StartedAt.IsZero() {
+		run.StartedAt = time.Now()
+	}
+	if run.FinishedAt == nil {
+		now := time.Now()
+		run.FinishedAt = &now
+	}
+
+	var steps *string
+	if len(run.Steps) > 0 {
+		data, err := json.Marshal(run.Steps)
+		if err != nil {
+			return fmt.Errorf("could not encode verify steps: %w", err)
+		}
+		s := string(data)
+		steps = &s
+	}
+
+	_, err := db.conn.Exec(
+		`INSERT INTO verify_runs (`+verifyColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		run.ID, run.WorktreeID, run.CommitSHA, run.Status, run.SkippedBy, steps, run.StartedAt, run.FinishedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("could not record verify run: %w", err)
+	}
+	return nil
+}
+
+// LatestVerifyRun returns the most recent verify run for a worktree, or nil if
+// it has never been verified
+func (db *DB) LatestVerifyRun(worktreeID string) (*VerifyRun, error) {
+	run, err := scanVerifyRun(db.conn.QueryRow(
+		`SELECT `+verifyColumns+` FROM verify_runs WHERE worktree_id = ?
+		 ORDER BY started_at DESC, rowid DESC LIMIT 1`, worktreeID,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get verify run: %w", err)
+	}
+	return run, nil
+}
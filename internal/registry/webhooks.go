@@ -0,0 +1,133 @@
+package registry
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// WebhookDelivery is a webhook POST that failed every attempt, kept so it can
+// be redelivered later. Body is the JSON document exactly as first sent.
+type WebhookDelivery struct {
+	ID          string
+	Webhook     string // name of the [webhooks.<name>] entry
+	Event       string
+	URL         string
+	Body        string
+	Status      string // failed or delivered
+	Attempts    int
+	LastError   *string
+	CreatedAt   time.Time
+	DeliveredAt *time.Time
+}
+
+const deliveryColumns = `id, webhook, event, url, body, status, attempts, last_error, created_at, delivered_at`
+
+func scanDelivery(row rowScanner) (*WebhookDelivery, error) {
+	d := &WebhookDelivery{}
+	err := row.Scan(&d.ID, &d.Webhook, &d.Event, &d.URL, &d.Body, &d.Status, &d.Attempts,
+		&d.LastError, &d.CreatedAt, &d.DeliveredAt)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// RecordFailedDelivery stores a webhook delivery that could not be made. The
+// delivery keeps the ID it was sent with, so receivers can deduplicate
+// redeliveries.
+func (db *DB) RecordFailedDelivery(d *WebhookDelivery) error {
+	if d.CreatedAt.IsZero() {
+		d.CreatedAt = time.Now()
+	}
+	d.Status = "failed"
+	if _, err := db.conn.Exec(
+		`INSERT INTO webhook_deliveries (`+deliveryColumns+`) VALUES (?, ?, ?, ?, ?, 'failed', ?, ?, ?, NULL)`,
+		d.ID, d.Webhook, d.Event, d.URL, d.Body, d.Attempts, d.LastError, d.CreatedAt,
+	); err != nil {
+		return fmt.Errorf("could not record webhook delivery: %w", err)
+	}
+	return nil
+}
+
+// ListFailedDeliveries returns the webhook deliveries still waiting to be
+// redelivered, oldest first
+func (db *DB) ListFailedDeliveries() ([]*WebhookDelivery, error) {
+	rows, err := db.conn.Query(
+		`SELECT ` + deliveryColumns + ` FROM webhook_deliveries WHERE status = 'failed' ORDER BY created_at, rowid`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not list webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	var deliveries []*WebhookDelivery
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, fmt.Errorf("could not scan webhook delivery: %w", err)
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// FindDelivery looks up a webhook delivery by ID or unique ID prefix
+func (db *DB) FindDelivery(idOrPrefix string) (*WebhookDelivery, error) {
+	rows, err := db.conn.Query(
+		`SELECT `+deliveryColumns+` FROM webhook_deliveries WHERE id = ? OR id LIKE ? || '%' LIMIT 2`,
+		idOrPrefix, idOrPrefix,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not find webhook delivery: %w", err)
+	}
+	defer rows.Close()
+
+	var found []*WebhookDelivery
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, fmt.Errorf("could not scan webhook delivery: %w", err)
+		}
+		if d.ID == idOrPrefix {
+			return d, nil
+		}
+		found = append(found, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("webhook delivery %q not found", idOrPrefix)
+	case 1:
+		return found[0], nil
+	default:
+		return nil, fmt.Errorf("webhook delivery prefix %q is ambiguous", idOrPrefix)
+	}
+}
+
+// RecordRedelivery counts another attempt at a failed delivery. A nil
+// deliveryErr marks it delivered; otherwise it stays failed with the new error.
+func (db *DB) RecordRedelivery(id string, deliveryErr error) error {
+	var res sql.Result
+	var err error
+	if deliveryErr == nil {
+		res, err = db.conn.Exec(
+			`UPDATE webhook_deliveries SET status = 'delivered', attempts = attempts + 1, delivered_at = ? WHERE id = ?`,
+			time.Now(), id,
+		)
+	} else {
+		res, err = db.conn.Exec(
+			`UPDATE webhook_deliveries SET attempts = attempts + 1, last_error = ? WHERE id = ?`,
+			deliveryErr.Error(), id,
+		)
+	}
+	if err != nil {
+		return fmt.Errorf("could not update webhook delivery: %w", err)
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return fmt.Errorf("webhook delivery %q not found", id)
+	}
+	return nil
+}
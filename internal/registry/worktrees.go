@@ -2,6 +2,7 @@ package registry
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"os"
 	"time"
@@ -17,32 +18,111 @@ type Worktree struct {
 	Branch          string
 	AgentID         *string
 	TaskDescription *string
+	BaseRef         *string // ref the branch was spawned from; nil means the repo's default branch
+	ExistingBranch  bool    // the branch predates the worktree, so agit never deletes it
 	Status          string
+	SetupStatus     *string      // passed or failed; nil if the repo has no setup recipe
+	SetupSteps      []VerifyStep // outcome of each setup step, stopping at the first failure
 	CreatedAt       time.Time
 	UpdatedAt       time.Time
 }
 
+// WorktreeOptions are the optional parts of a new worktree record
+type WorktreeOptions struct {
+	AgentID         *string
+	TaskDescription *string
+	BaseRef         *string
+	ExistingBranch  bool
+}
+
+// Base returns the ref the worktree is compared against and merged into:
+// the ref it was spawned from, or the repo's default branch
+func (w *Worktree) Base(defaultBranch string) string {
+	if w.BaseRef != nil && *w.BaseRef != "" {
+		return *w.BaseRef
+	}
+	return defaultBranch
+}
+
+// OwnsBranch reports whether agit created the worktree's branch and may
+// delete it when the worktree is cleaned up
+func (w *Worktree) OwnsBranch() bool {
+	return !w.ExistingBranch
+}
+
+const worktreeColumns = `id, repo_id, path, branch, agent_id, task_description, base_ref, existing_branch, status, setup_status, setup_steps, created_at, updated_at`
+
+func scanWorktree(row rowScanner) (*Worktree, error) {
+	wt := &Worktree{}
+	var steps sql.NullString
+	err := row.Scan(&wt.ID, &wt.RepoID, &wt.Path, &wt.Branch, &wt.AgentID,
+		&wt.TaskDescription, &wt.BaseRef, &wt.ExistingBranch, &wt.Status, &wt.SetupStatus, &steps, &wt.CreatedAt, &wt.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if steps.Valid && steps.String != "" {
+		if err := json.Unmarshal([]byte(steps.String), &wt.SetupSteps); err != nil {
+			return nil, fmt.Errorf("could not decode setup steps: %w", err)
+		}
+	}
+	return wt, nil
+}
+
 // CreateWorktree records a new worktree in the registry
 func (db *DB) CreateWorktree(repoID, path, branch string, agentID, taskDesc *string) (*Worktree, error) {
+	return db.CreateWorktreeWithOptions(repoID, path, branch, WorktreeOptions{AgentID: agentID, TaskDescription: taskDesc})
+}
+
+// CreateWorktreeWithOptions records a new worktree in the registry, including
+// the ref it was spawned from and whether it attached to an existing branch
+func (db *DB) CreateWorktreeWithOptions(repoID, path, branch string, opts WorktreeOptions) (*Worktree, error) {
 	id := uuid.New().String()
 	now := time.Now()
 
-	_, err := db.conn.Exec(
-		`INSERT INTO worktrees (id, repo_id, path, branch, agent_id, task_description, status, created_at, updated_at)
-		 VALUES (?, ?, ?, ?, ?, ?, 'active', ?, ?)`,
-		id, repoID, path, branch, agentID, taskDesc, now, now,
+	tx, err := db.begin()
+	if err != nil {
+		return nil, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		`INSERT INTO worktrees (id, repo_id, path, branch, agent_id, task_description, base_ref, existing_branch, status, created_at, updated_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, 'active', ?, ?)`,
+		id, repoID, path, branch, opts.AgentID, opts.TaskDescription, opts.BaseRef, opts.ExistingBranch, now, now,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("could not create worktree record: %w", err)
 	}
 
+	payload := map[string]any{"branch": branch, "path": path}
+	if opts.TaskDescription != nil {
+		payload["task"] = *opts.TaskDescription
+	}
+	if opts.BaseRef != nil {
+		payload["base"] = *opts.BaseRef
+	}
+	if opts.ExistingBranch {
+		payload["existing_branch"] = true
+	}
+	if err := recordEvent(tx, &Event{
+		Type: EventWorktreeCreated, AgentID: opts.AgentID, RepoID: &repoID,
+		EntityType: "worktree", EntityID: id, Payload: payload, CreatedAt: now,
+	}); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not commit transaction: %w", err)
+	}
+
 	return &Worktree{
 		ID:              id,
 		RepoID:          repoID,
 		Path:            path,
 		Branch:          branch,
-		AgentID:         agentID,
-		TaskDescription: taskDesc,
+		AgentID:         opts.AgentID,
+		TaskDescription: opts.TaskDescription,
+		BaseRef:         opts.BaseRef,
+		ExistingBranch:  opts.ExistingBranch,
 		Status:          "active",
 		CreatedAt:       now,
 		UpdatedAt:       now,
@@ -51,12 +131,9 @@ func (db *DB) CreateWorktree(repoID, path, branch string, agentID, taskDesc *str
 
 // GetWorktree retrieves a worktree by ID
 func (db *DB) GetWorktree(id string) (*Worktree, error) {
-	wt := &Worktree{}
-	err := db.conn.QueryRow(
-		`SELECT id, repo_id, path, branch, agent_id, task_description, status, created_at, updated_at
-		 FROM worktrees WHERE id = ?`, id,
-	).Scan(&wt.ID, &wt.RepoID, &wt.Path, &wt.Branch, &wt.AgentID,
-		&wt.TaskDescription, &wt.Status, &wt.CreatedAt, &wt.UpdatedAt)
+	wt, err := scanWorktree(db.conn.QueryRow(
+		`SELECT `+worktreeColumns+` FROM worktrees WHERE id = ?`, id,
+	))
 
 	if err == sql.ErrNoRows {
 		return nil, fmt.Errorf("worktree %q not found", id)
@@ -74,13 +151,13 @@ func (db *DB) ListWorktrees(repoID string, status *string) ([]*Worktree, error)
 
 	if status != nil {
 		rows, err = db.conn.Query(
-			`SELECT id, repo_id, path, branch, agent_id, task_description, status, created_at, updated_at
+			`SELECT `+worktreeColumns+`
 			 FROM worktrees WHERE repo_id = ? AND status = ? ORDER BY created_at DESC`,
 			repoID, *status,
 		)
 	} else {
 		rows, err = db.conn.Query(
-			`SELECT id, repo_id, path, branch, agent_id, task_description, status, created_at, updated_at
+			`SELECT `+worktreeColumns+`
 			 FROM worktrees WHERE repo_id = ? ORDER BY created_at DESC`,
 			repoID,
 		)
@@ -92,9 +169,8 @@ func (db *DB) ListWorktrees(repoID string, status *string) ([]*Worktree, error)
 
 	var worktrees []*Worktree
 	for rows.Next() {
-		wt := &Worktree{}
-		if err := rows.Scan(&wt.ID, &wt.RepoID, &wt.Path, &wt.Branch, &wt.AgentID,
-			&wt.TaskDescription, &wt.Status, &wt.CreatedAt, &wt.UpdatedAt); err != nil {
+		wt, err := scanWorktree(rows)
+		if err != nil {
 			return nil, fmt.Errorf("could not scan worktree: %w", err)
 		}
 		worktrees = append(worktrees, wt)
@@ -118,10 +194,80 @@ func (db *DB) UpdateWorktreeStatus(id, status string) error {
 	return nil
 }
 
+// RecordWorktreeSetup stores the outcome of running a repo's setup recipe in
+// a worktree. A failed setup moves the worktree to setup_failed so it is not
+// mistaken for a usable workspace; a passing one makes it active again.
+func (db *DB) RecordWorktreeSetup(id string, passed bool, steps []VerifyStep) error {
+	setupStatus, status := "passed", "active"
+	if !passed {
+		setupStatus, status = "failed", "setup_failed"
+	}
+	data, err := json.Marshal(steps)
+	if err != nil {
+		return fmt.Errorf("could not encode setup steps: %w", err)
+	}
+
+	wt, err := db.GetWorktree(id)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+
+	tx, err := db.begin()
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
+		`UPDATE worktrees SET status = ?, setup_status = ?, setup_steps = ?, updated_at = ? WHERE id = ?`,
+		status, setupStatus, string(data), now, id,
+	); err != nil {
+		return fmt.Errorf("could not record worktree setup: %w", err)
+	}
+
+	payload := map[string]any{"status": setupStatus, "steps": len(steps)}
+	if !passed && len(steps) > 0 {
+		payload["failed_step"] = steps[len(steps)-1].Command
+	}
+	if err := recordEvent(tx, &Event{
+		Type: EventWorktreeSetup, AgentID: wt.AgentID, RepoID: &wt.RepoID,
+		EntityType: "worktree", EntityID: id, Payload: payload, CreatedAt: now,
+	}); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %w", err)
+	}
+	return nil
+}
+
 // DeleteWorktree removes a worktree record
 func (db *DB) DeleteWorktree(id string) error {
-	_, err := db.conn.Exec(`DELETE FROM worktrees WHERE id = ?`, id)
-	return err
+	tx, err := db.begin()
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(
+		`INSERT INTO events (type, agent_id, actor, repo_id, entity_type, entity_id, payload, created_at)
+		 SELECT ?, w.agent_id, a.name, w.repo_id, 'worktree', w.id,
+		        json_object('branch', w.branch, 'path', w.path, 'base', w.base_ref, 'task', w.task_description), ?
+		 FROM worktrees w LEFT JOIN agents a ON a.id = w.agent_id
+		 WHERE w.id = ?`,
+		EventWorktreeRemoved, time.Now(), id,
+	)
+	if err != nil {
+		return fmt.Errorf("could not record %s event: %w", EventWorktreeRemoved, err)
+	}
+	if err := tx.collect(res); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM worktrees WHERE id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 // PruneOrphanedWorktrees marks active worktrees as stale if their directory no longer exists
@@ -144,6 +290,37 @@ func (db *DB) PruneOrphanedWorktrees(repoID string) (int, error) {
 	return count, nil
 }
 
+// MarkIdleWorktreesStale marks active worktrees stale when they have not been
+// updated within idleAfter and no active agent is working in them. It
+// returns the IDs of the worktrees it marked.
+func (db *DB) MarkIdleWorktreesStale(idleAfter time.Duration) ([]string, error) {
+	rows, err := db.conn.Query(
+		`SELECT w.id FROM worktrees w LEFT JOIN agents a ON a.id = w.agent_id
+		 WHERE w.status = 'active' AND w.updated_at < ? AND (a.id IS NULL OR a.status != 'active')`,
+		time.Now().Add(-idleAfter),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not find idle worktrees: %w", err)
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("could not scan worktree: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		if err := db.UpdateWorktreeStatus(id, "stale"); err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
+
 // FindWorktreeByPrefix finds a worktree by ID prefix within a repo
 func (db *DB) FindWorktreeByPrefix(repoID, prefix string) (*Worktree, error) {
 	if len(prefix) < 4 {
@@ -151,7 +328,7 @@ func (db *DB) FindWorktreeByPrefix(repoID, prefix string) (*Worktree, error) {
 	}
 
 	rows, err := db.conn.Query(
-		`SELECT id, repo_id, path, branch, agent_id, task_description, status, created_at, updated_at
+		`SELECT `+worktreeColumns+`
 		 FROM worktrees WHERE repo_id = ? AND id LIKE ?`,
 		repoID, prefix+"%",
 	)
@@ -162,9 +339,8 @@ func (db *DB) FindWorktreeByPrefix(repoID, prefix string) (*Worktree, error) {
 
 	var matches []*Worktree
 	for rows.Next() {
-		wt := &Worktree{}
-		if err := rows.Scan(&wt.ID, &wt.RepoID, &wt.Path, &wt.Branch, &wt.AgentID,
-			&wt.TaskDescription, &wt.Status, &wt.CreatedAt, &wt.UpdatedAt); err != nil {
+		wt, err := scanWorktree(rows)
+		if err != nil {
 			return nil, fmt.Errorf("could not scan worktree: %w", err)
 		}
 		matches = append(matches, wt)
@@ -195,7 +371,7 @@ func (db *DB) ResolveWorktree(repoID, idOrPrefix string) (*Worktree, error) {
 // ListAllActiveWorktrees returns all active worktrees across all repos
 func (db *DB) ListAllActiveWorktrees() ([]*Worktree, error) {
 	rows, err := db.conn.Query(
-		`SELECT id, repo_id, path, branch, agent_id, task_description, status, created_at, updated_at
+		`SELECT ` + worktreeColumns + `
 		 FROM worktrees WHERE status = 'active' ORDER BY created_at DESC`,
 	)
 	if err != nil {
@@ -205,9 +381,8 @@ func (db *DB) ListAllActiveWorktrees() ([]*Worktree, error) {
 
 	var worktrees []*Worktree
 	for rows.Next() {
-		wt := &Worktree{}
-		if err := rows.Scan(&wt.ID, &wt.RepoID, &wt.Path, &wt.Branch, &wt.AgentID,
-			&wt.TaskDescription, &wt.Status, &wt.CreatedAt, &wt.UpdatedAt); err != nil {
+		wt, err := scanWorktree(rows)
+		if err != nil {
 			return nil, fmt.Errorf("could not scan worktree: %w", err)
 		}
 		worktrees = append(worktrees, wt)
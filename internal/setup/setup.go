@@ -0,0 +1,255 @@
+// Package setup bootstraps freshly spawned worktrees with a per-repo recipe:
+// files copied or symlinked from the main checkout, such as .env, followed by
+// commands such as go mod download or npm ci. The outcome is recorded on the
+// worktree, and a failed recipe leaves it in the setup_failed state.
+package setup
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	toml "github.com/pelletier/go-toml/v2"
+
+	"github.com/fathindos/agit/internal/config"
+	"github.com/fathindos/agit/internal/registry"
+	"github.com/fathindos/agit/internal/verify"
+)
+
+// RepoFile is the repo-local config file, read from the main checkout
+const RepoFile = config.RepoFile
+
+// Recipe lists what a new worktree needs before an agent can work in it.
+// Copy and Link paths are relative to the main checkout and land at the same
+// path in the worktree.
+type Recipe struct {
+	Commands []string `toml:"commands"`
+	Copy     []string `toml:"copy"`
+	Link     []string `toml:"link"`
+	Timeout  string   `toml:"timeout"`
+}
+
+// Empty reports whether the recipe has nothing to do
+func (r *Recipe) Empty() bool {
+	return len(r.Commands) == 0 && len(r.Copy) == 0 && len(r.Link) == 0
+}
+
+// CommandTimeout returns the per-command timeout, defaulting to verify's
+func (r *Recipe) CommandTimeout() time.Duration {
+	if d, err := time.ParseDuration(r.Timeout); err == nil && d > 0 {
+		return d
+	}
+	return verify.DefaultTimeout
+}
+
+// LoadRecipe reads the [setup] section of the repo's .agit.toml, then applies
+// the registry's setup, setup_copy, setup_link and setup_timeout settings,
+// each of which replaces the matching field from the file.
+func LoadRecipe(repo *registry.Repo) (*Recipe, error) {
+	var file struct {
+		Setup Recipe `toml:"setup"`
+	}
+	data, err := os.ReadFile(filepath.Join(repo.Path, RepoFile))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("could not read %s: %w", RepoFile, err)
+	}
+	if err == nil {
+		if err := toml.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("could not parse %s: %w", RepoFile, err)
+		}
+	}
+
+	recipe := file.Setup
+	if v := repo.SettingList("setup"); len(v) > 0 {
+		recipe.Commands = v
+	}
+	if v := repo.SettingList("setup_copy"); len(v) > 0 {
+		recipe.Copy = v
+	}
+	if v := repo.SettingList("setup_link"); len(v) > 0 {
+		recipe.Link = v
+	}
+	if v := repo.Setting("setup_timeout"); v != "" {
+		recipe.Timeout = v
+	}
+
+	for _, p := range append(append([]string{}, recipe.Copy...), recipe.Link...) {
+		if err := ValidatePath(p); err != nil {
+			return nil, err
+		}
+	}
+	if recipe.Timeout != "" {
+		if d, err := time.ParseDuration(recipe.Timeout); err != nil || d <= 0 {
+			return nil, fmt.Errorf("setup timeout must be a positive duration like 5m, got %q", recipe.Timeout)
+		}
+	}
+	return &recipe, nil
+}
+
+// ValidatePath rejects copy and link paths that would reach outside the main
+// checkout or the worktree
+func ValidatePath(p string) error {
+	if p == "" || !filepath.IsLocal(p) || filepath.Clean(p) == "." {
+		return fmt.Errorf("setup path %q must be relative to the repository root and stay inside it", p)
+	}
+	return nil
+}
+
+// Run applies a recipe to a worktree: copies, then links, then commands,
+// stopping at the first step that fails. Copy and link steps are reported as
+// steps named "copy <path>" and "link <path>".
+func Run(recipe *Recipe, mainCheckout, worktreePath string, env []string) []registry.VerifyStep {
+	var steps []registry.VerifyStep
+	files := func(verb string, paths []string, apply func(src, dst string) error) bool {
+		for _, p := range paths {
+			start := time.Now()
+			step := registry.VerifyStep{Command: verb + " " + p}
+			if err := apply(filepath.Join(mainCheckout, p), filepath.Join(worktreePath, p)); err != nil {
+				step.ExitCode = 1
+				step.Output = err.Error()
+			}
+			step.DurationMS = time.Since(start).Milliseconds()
+			steps = append(steps, step)
+			if step.ExitCode != 0 {
+				return false
+			}
+		}
+		return true
+	}
+	if !files("copy", recipe.Copy, copyPath) || !files("link", recipe.Link, linkPath) {
+		return steps
+	}
+	return append(steps, verify.Run(worktreePath, recipe.Commands, recipe.CommandTimeout(), env)...)
+}
+
+// Worktree runs the repo's setup recipe in a worktree and records the outcome
+// on it, updating wt in place. A recipe that cannot be loaded counts as a
+// failed setup. Worktrees of repos without a recipe are left untouched.
+func Worktree(db *registry.DB, repo *registry.Repo, wt *registry.Worktree) error {
+	var steps []registry.VerifyStep
+	recipe, err := LoadRecipe(repo)
+	if err != nil {
+		steps = []registry.VerifyStep{{Command: "load setup recipe", ExitCode: 1, Output: err.Error()}}
+	} else if recipe.Empty() {
+		return nil
+	} else {
+		env := []string{
+			"AGIT_REPO=" + repo.Name,
+			"AGIT_WORKTREE_ID=" + wt.ID,
+			"AGIT_BRANCH=" + wt.Branch,
+			"AGIT_BASE_BRANCH=" + wt.Base(repo.DefaultBranch),
+			"AGIT_MAIN_CHECKOUT=" + repo.Path,
+		}
+		steps = Run(recipe, repo.Path, wt.Path, env)
+	}
+
+	passed := len(steps) == 0 || steps[len(steps)-1].ExitCode == 0
+	if err := db.RecordWorktreeSetup(wt.ID, passed, steps); err != nil {
+		return err
+	}
+	status, setupStatus := "active", "passed"
+	if !passed {
+		status, setupStatus = "setup_failed", "failed"
+	}
+	wt.Status, wt.SetupStatus, wt.SetupSteps = status, &setupStatus, steps
+	return nil
+}
+
+// FailedStep returns the step that failed a worktree's setup, or nil if the
+// setup passed or never ran
+func FailedStep(wt *registry.Worktree) *registry.VerifyStep {
+	if wt.SetupStatus == nil || *wt.SetupStatus != "failed" || len(wt.SetupSteps) == 0 {
+		return nil
+	}
+	return &wt.SetupSteps[len(wt.SetupSteps)-1]
+}
+
+// Describe summarizes a failed setup step, with the last line of its output
+func Describe(step *registry.VerifyStep) string {
+	desc := verify.Describe(step)
+	lines := strings.Split(strings.TrimSpace(step.Output), "\n")
+	if last := strings.TrimSpace(lines[len(lines)-1]); last != "" {
+		desc += ": " + last
+	}
+	return desc
+}
+
+// copyPath copies a file or directory tree, keeping file modes and symlinks
+func copyPath(src, dst string) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return fmt.Errorf("could not read %s: %w", src, err)
+	}
+	if !info.IsDir() {
+		return copyEntry(src, dst, info)
+	}
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, path)
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0o755)
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return copyEntry(path, target, info)
+	})
+}
+
+func copyEntry(src, dst string, info fs.FileInfo) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	if info.Mode()&fs.ModeSymlink != 0 {
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		os.Remove(dst)
+		return os.Symlink(target, dst)
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// linkPath symlinks dst to src. An existing symlink is replaced so the recipe
+// can be re-run; anything else already at dst is left alone.
+func linkPath(src, dst string) error {
+	if _, err := os.Stat(src); err != nil {
+		return fmt.Errorf("could not read %s: %w", src, err)
+	}
+	if info, err := os.Lstat(dst); err == nil {
+		if info.Mode()&fs.ModeSymlink == 0 {
+			return fmt.Errorf("%s already exists in the worktree", dst)
+		}
+		if err := os.Remove(dst); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	return os.Symlink(src, dst)
+}
@@ -0,0 +1,108 @@
+package setup
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fathindos/agit/internal/registry"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadRecipeFileAndSettings(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, RepoFile), `
+[setup]
+commands = ["go mod download"]
+copy = [".env"]
+link = ["node_modules"]
+timeout = "2m"
+`)
+	repo := &registry.Repo{Path: dir}
+
+	recipe, err := LoadRecipe(repo)
+	if err != nil {
+		t.Fatalf("LoadRecipe: %v", err)
+	}
+	if len(recipe.Commands) != 1 || recipe.Copy[0] != ".env" || recipe.Link[0] != "node_modules" || recipe.CommandTimeout().String() != "2m0s" {
+		t.Errorf("unexpected recipe from file: %+v", recipe)
+	}
+
+	// Registry settings replace the file's entries field by field
+	metadata, _ := json.Marshal(map[string]any{"setup": []string{"npm ci", "make"}})
+	repo.Metadata = string(metadata)
+	recipe, err = LoadRecipe(repo)
+	if err != nil {
+		t.Fatalf("LoadRecipe: %v", err)
+	}
+	if strings.Join(recipe.Commands, ",") != "npm ci,make" || recipe.Copy[0] != ".env" {
+		t.Errorf("expected settings to override commands only, got %+v", recipe)
+	}
+}
+
+func TestLoadRecipeRejectsEscapingPaths(t *testing.T) {
+	for _, p := range []string{"../secrets", "/etc/passwd", ".", ""} {
+		if err := ValidatePath(p); err == nil {
+			t.Errorf("expected %q to be rejected", p)
+		}
+	}
+
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, RepoFile), "[setup]\ncopy = [\"../outside\"]\n")
+	if _, err := LoadRecipe(&registry.Repo{Path: dir}); err == nil {
+		t.Error("expected recipe with an escaping path to be rejected")
+	}
+}
+
+func TestRunCopiesLinksAndRunsCommands(t *testing.T) {
+	main, wt := t.TempDir(), t.TempDir()
+	writeFile(t, filepath.Join(main, ".env"), "SECRET=1\n")
+	writeFile(t, filepath.Join(main, "config", "local", "a.json"), "{}\n")
+	writeFile(t, filepath.Join(main, "cache", "blob"), "data")
+
+	recipe := &Recipe{
+		Copy:     []string{".env", "config/local"},
+		Link:     []string{"cache"},
+		Commands: []string{`test -f .env && test -f config/local/a.json && test -f cache/blob && test "$AGIT_REPO" = demo`},
+	}
+	steps := Run(recipe, main, wt, []string{"AGIT_REPO=demo"})
+	if len(steps) != 4 {
+		t.Fatalf("expected 4 steps, got %+v", steps)
+	}
+	for _, s := range steps {
+		if s.ExitCode != 0 {
+			t.Fatalf("step %q failed: %s", s.Command, s.Output)
+		}
+	}
+	if target, err := os.Readlink(filepath.Join(wt, "cache")); err != nil || target != filepath.Join(main, "cache") {
+		t.Errorf("expected cache symlinked to main checkout, got %q, %v", target, err)
+	}
+
+	// Re-running replaces the symlink rather than failing on it
+	if steps := Run(recipe, main, wt, []string{"AGIT_REPO=demo"}); steps[len(steps)-1].ExitCode != 0 {
+		t.Errorf("expected re-run to pass, got %+v", steps)
+	}
+}
+
+func TestRunStopsAtFailedCopy(t *testing.T) {
+	recipe := &Recipe{Copy: []string{"missing.env"}, Commands: []string{"echo never"}}
+	steps := Run(recipe, t.TempDir(), t.TempDir(), nil)
+
+	if len(steps) != 1 || steps[0].ExitCode == 0 || steps[0].Command != "copy missing.env" {
+		t.Fatalf("expected a single failed copy step, got %+v", steps)
+	}
+	if !strings.Contains(Describe(&steps[0]), "missing.env") {
+		t.Errorf("expected description to name the missing file, got %q", Describe(&steps[0]))
+	}
+}
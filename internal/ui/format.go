@@ -31,3 +31,12 @@ func RenderJSON(v interface{}) error {
 	}
 	return nil
 }
+
+// RenderJSONLine writes v as a single line of compact JSON, for streaming
+// output such as agit log --follow.
+func RenderJSONLine(v interface{}) error {
+	if err := json.NewEncoder(os.Stdout).Encode(v); err != nil {
+		return fmt.Errorf("could not encode JSON: %w", err)
+	}
+	return nil
+}
@@ -55,15 +55,15 @@ func StatusColor(status string) string {
 	switch status {
 	case "active":
 		return T.Success(status)
-	case "stale", "disconnected":
+	case "stale", "disconnected", "blocked", "conflict", "setup_failed":
 		return T.Warning(status)
-	case "completed":
+	case "completed", "merged", "cancelled":
 		return T.Muted(status)
-	case "pending":
+	case "pending", "queued":
 		return T.Info(status)
 	case "failed":
 		return T.Error(status)
-	case "claimed", "in_progress":
+	case "claimed", "in_progress", "merging":
 		return T.Accent(status)
 	default:
 		return status
@@ -0,0 +1,171 @@
+// Package verify runs a repo's pre-merge verification commands, such as a
+// build or test suite, inside a worktree and records the outcome in the
+// registry so merges can be gated on it.
+package verify
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	apperrors "github.com/fathindos/agit/internal/errors"
+	gitops "github.com/fathindos/agit/internal/git"
+	"github.com/fathindos/agit/internal/registry"
+)
+
+// DefaultTimeout bounds each verify command when the repo sets no verify_timeout
+const DefaultTimeout = 10 * time.Minute
+
+// MaxOutput is how many trailing bytes of a command's output are kept
+const MaxOutput = 16 * 1024
+
+// Commands returns the verify commands configured for a repo
+func Commands(repo *registry.Repo) []string {
+	return repo.SettingList("verify")
+}
+
+// Timeout returns the per-command timeout configured for a repo
+func Timeout(repo *registry.Repo) time.Duration {
+	if d, err := time.ParseDuration(repo.Setting("verify_timeout")); err == nil && d > 0 {
+		return d
+	}
+	return DefaultTimeout
+}
+
+// Run executes commands in order with sh -c in dir, stopping at the first
+// failure. Each command is killed once it exceeds timeout.
+func Run(dir string, commands []string, timeout time.Duration, env []string) []registry.VerifyStep {
+	steps := make([]registry.VerifyStep, 0, len(commands))
+	for _, command := range commands {
+		step := runStep(dir, command, timeout, env)
+		steps = append(steps, step)
+		if step.ExitCode != 0 {
+			break
+		}
+	}
+	return steps
+}
+
+func runStep(dir, command string, timeout time.Duration, env []string) registry.VerifyStep {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Dir = dir
+	cmd.Env = append(cmd.Environ(), env...)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	// Don't wait on pipes held open by grandchildren once the shell is killed
+	cmd.WaitDelay = time.Second
+
+	start := time.Now()
+	err := cmd.Run()
+	step := registry.VerifyStep{
+		Command:    command,
+		DurationMS: time.Since(start).Milliseconds(),
+		Output:     tail(out.Bytes(), MaxOutput),
+	}
+
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+	case ctx.Err() == context.DeadlineExceeded:
+		step.ExitCode = -1
+		step.TimedOut = true
+	case errors.As(err, &exitErr):
+		step.ExitCode = exitErr.ExitCode()
+	default:
+		step.ExitCode = -1
+		step.Output = err.Error()
+	}
+	return step
+}
+
+func tail(b []byte, max int) string {
+	if len(b) <= max {
+		return string(b)
+	}
+	return "...\n" + string(b[len(b)-max:])
+}
+
+// Worktree runs the repo's verify commands in a worktree and records the run.
+// It returns nil if the repo has no verify commands configured. The commands
+// run in the working tree while only the branch's commits get merged, so a
+// worktree with uncommitted changes to tracked files is refused rather than
+// verified.
+func Worktree(db *registry.DB, repo *registry.Repo, wt *registry.Worktree) (*registry.VerifyRun, error) {
+	commands := Commands(repo)
+	if len(commands) == 0 {
+		return nil, nil
+	}
+
+	dirty, err := gitops.UncommittedTrackedFiles(wt.Path)
+	if err != nil {
+		return nil, err
+	}
+	if len(dirty) > 0 {
+		paths := make([]string, 0, len(dirty))
+		for path := range dirty {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		return nil, apperrors.NewUserErrorf("%s has uncommitted changes to %s; commit or stash them so that verification checks what will be merged",
+			wt.Branch, strings.Join(paths, ", "))
+	}
+
+	run := &registry.VerifyRun{WorktreeID: wt.ID, StartedAt: time.Now()}
+	run.CommitSHA, _ = gitops.GetHeadCommit(wt.Path)
+
+	env := []string{
+		"AGIT_REPO=" + repo.Name,
+		"AGIT_WORKTREE_ID=" + wt.ID,
+		"AGIT_BRANCH=" + wt.Branch,
+		"AGIT_BASE_BRANCH=" + wt.Base(repo.DefaultBranch),
+	}
+	run.Steps = Run(wt.Path, commands, Timeout(repo), env)
+	run.Status = "passed"
+	if len(run.Steps) > 0 && run.Steps[len(run.Steps)-1].ExitCode != 0 {
+		run.Status = "failed"
+	}
+
+	if err := db.RecordVerifyRun(run); err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+// Skip records that verification of a worktree was bypassed, and by whom.
+// It returns nil if the repo has no verify commands configured.
+func Skip(db *registry.DB, repo *registry.Repo, wt *registry.Worktree, by string) (*registry.VerifyRun, error) {
+	if len(Commands(repo)) == 0 {
+		return nil, nil
+	}
+	run := &registry.VerifyRun{WorktreeID: wt.ID, Status: "skipped", SkippedBy: &by}
+	run.CommitSHA, _ = gitops.GetHeadCommit(wt.Path)
+	if err := db.RecordVerifyRun(run); err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+// FailedStep returns the step that failed a run, or nil if it passed
+func FailedStep(run *registry.VerifyRun) *registry.VerifyStep {
+	if run == nil || run.Status != "failed" || len(run.Steps) == 0 {
+		return nil
+	}
+	return &run.Steps[len(run.Steps)-1]
+}
+
+// Describe summarizes a failed step for error messages
+func Describe(step *registry.VerifyStep) string {
+	if step.TimedOut {
+		return fmt.Sprintf("%q timed out", step.Command)
+	}
+	return fmt.Sprintf("%q exited with status %d", step.Command, step.ExitCode)
+}
@@ -0,0 +1,56 @@
+package verify
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunStopsAtFirstFailure(t *testing.T) {
+	dir := t.TempDir()
+	steps := Run(dir, []string{"echo ok", "echo broken >&2; exit 3", "echo never"}, 5*time.Second, nil)
+
+	if len(steps) != 2 {
+		t.Fatalf("expected 2 steps, got %d", len(steps))
+	}
+	if steps[0].ExitCode != 0 || strings.TrimSpace(steps[0].Output) != "ok" {
+		t.Errorf("unexpected first step: %+v", steps[0])
+	}
+	if steps[1].ExitCode != 3 {
+		t.Errorf("expected exit code 3, got %d", steps[1].ExitCode)
+	}
+	if !strings.Contains(steps[1].Output, "broken") {
+		t.Errorf("expected stderr captured, got %q", steps[1].Output)
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	start := time.Now()
+	steps := Run(t.TempDir(), []string{"sleep 10"}, 100*time.Millisecond, nil)
+
+	if time.Since(start) > 3*time.Second {
+		t.Error("command was not killed at the timeout")
+	}
+	if len(steps) != 1 || !steps[0].TimedOut || steps[0].ExitCode == 0 {
+		t.Errorf("expected timed out step, got %+v", steps)
+	}
+}
+
+func TestRunEnvAndDir(t *testing.T) {
+	dir := t.TempDir()
+	steps := Run(dir, []string{`test "$AGIT_BRANCH" = feature && pwd`}, 5*time.Second, []string{"AGIT_BRANCH=feature"})
+
+	if steps[0].ExitCode != 0 {
+		t.Fatalf("expected env to be passed, got %+v", steps[0])
+	}
+	if !strings.Contains(steps[0].Output, dir) {
+		t.Errorf("expected command to run in %s, got %q", dir, steps[0].Output)
+	}
+}
+
+func TestTailTruncates(t *testing.T) {
+	got := tail([]byte("abcdef"), 3)
+	if got != "...\ndef" {
+		t.Errorf("expected tail of output, got %q", got)
+	}
+}
@@ -0,0 +1,285 @@
+// Package watch keeps file touches current while agents edit. It watches
+// every active worktree with fsnotify and rescans a worktree, including its
+// uncommitted edits, once changes to it have settled.
+package watch
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/fathindos/agit/internal/conflicts"
+	gitops "github.com/fathindos/agit/internal/git"
+	"github.com/fathindos/agit/internal/registry"
+)
+
+// DefaultDebounce is how long a worktree must be quiet before it is rescanned
+const DefaultDebounce = 500 * time.Millisecond
+
+// Watcher rescans worktrees as their files change
+type Watcher struct {
+	db       *registry.DB
+	debounce time.Duration
+	fs       *fsnotify.Watcher
+
+	mu        sync.Mutex
+	worktrees map[string]*watched // by worktree ID
+	dirs      map[string]string   // watched directory -> worktree ID
+	closed    bool
+
+	// OnScan, if set, is called after a worktree's touches are refreshed
+	OnScan func(repo *registry.Repo, wt *registry.Worktree)
+}
+
+type watched struct {
+	repo   *registry.Repo
+	wt     *registry.Worktree
+	dirs   []string
+	gitDir string
+	timer  *time.Timer
+}
+
+// New creates a Watcher. Nothing is watched until Sync or Run is called.
+func New(db *registry.DB, debounce time.Duration) (*Watcher, error) {
+	if debounce <= 0 {
+		debounce = DefaultDebounce
+	}
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	return &Watcher{
+		db:        db,
+		debounce:  debounce,
+		fs:        fsw,
+		worktrees: make(map[string]*watched),
+		dirs:      make(map[string]string),
+	}, nil
+}
+
+// Len returns the number of worktrees being watched
+func (w *Watcher) Len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.worktrees)
+}
+
+// Run watches until ctx is cancelled, re-syncing the set of active worktrees
+// every refresh interval
+func (w *Watcher) Run(ctx context.Context, refresh time.Duration) {
+	defer w.close()
+
+	if err := w.Sync(); err != nil {
+		log.Printf("watch: %v", err)
+	}
+	ticker := time.NewTicker(refresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := w.Sync(); err != nil {
+				log.Printf("watch: %v", err)
+			}
+		case event, ok := <-w.fs.Events:
+			if !ok {
+				return
+			}
+			w.handle(event)
+		case err, ok := <-w.fs.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("watch: %v", err)
+		}
+	}
+}
+
+// Sync starts watching active worktrees that are new and stops watching ones
+// that are no longer active
+func (w *Watcher) Sync() error {
+	repos, err := w.db.ListRepos()
+	if err != nil {
+		return err
+	}
+
+	active := make(map[string]bool)
+	activeStatus := "active"
+	for _, repo := range repos {
+		worktrees, err := w.db.ListWorktrees(repo.ID, &activeStatus)
+		if err != nil {
+			return err
+		}
+		for _, wt := range worktrees {
+			active[wt.ID] = true
+			w.mu.Lock()
+			_, ok := w.worktrees[wt.ID]
+			w.mu.Unlock()
+			if !ok {
+				w.add(repo, wt)
+			}
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for id, entry := range w.worktrees {
+		if !active[id] {
+			w.removeLocked(entry)
+		}
+	}
+	return nil
+}
+
+// add starts watching a worktree's directories and its git directory, so
+// commits and staging are noticed as well as edits
+func (w *Watcher) add(repo *registry.Repo, wt *registry.Worktree) {
+	entry := &watched{repo: repo, wt: wt}
+	if gitDir, err := gitops.GitDir(wt.Path); err == nil {
+		entry.gitDir = gitDir
+	}
+
+	ignored := make(map[string]bool)
+	if dirs, err := gitops.IgnoredDirs(wt.Path); err == nil {
+		for _, d := range dirs {
+			ignored[filepath.Join(wt.Path, filepath.FromSlash(d))] = true
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	w.worktrees[wt.ID] = entry
+	w.addTreeLocked(entry, wt.Path, ignored)
+	if entry.gitDir != "" {
+		w.watchLocked(entry, entry.gitDir)
+	}
+}
+
+// addTreeLocked watches root and every directory below it, skipping .git and
+// ignored directories
+func (w *Watcher) addTreeLocked(entry *watched, root string, ignored map[string]bool) {
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		if d.Name() == ".git" || ignored[path] {
+			return filepath.SkipDir
+		}
+		w.watchLocked(entry, path)
+		return nil
+	})
+}
+
+func (w *Watcher) watchLocked(entry *watched, dir string) {
+	if _, ok := w.dirs[dir]; ok {
+		return
+	}
+	if err := w.fs.Add(dir); err != nil {
+		log.Printf("watch: could not watch %s: %v", dir, err)
+		return
+	}
+	w.dirs[dir] = entry.wt.ID
+	entry.dirs = append(entry.dirs, dir)
+}
+
+func (w *Watcher) removeLocked(entry *watched) {
+	if entry.timer != nil {
+		entry.timer.Stop()
+	}
+	for _, dir := range entry.dirs {
+		w.fs.Remove(dir)
+		delete(w.dirs, dir)
+	}
+	delete(w.worktrees, entry.wt.ID)
+}
+
+func (w *Watcher) handle(event fsnotify.Event) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	dir := filepath.Dir(event.Name)
+	id, ok := w.dirs[dir]
+	if !ok {
+		// Events for a watched directory itself, e.g. when it is removed
+		if id, ok = w.dirs[event.Name]; !ok {
+			return
+		}
+	}
+	entry := w.worktrees[id]
+	if entry == nil {
+		return
+	}
+
+	if dir == entry.gitDir {
+		// Only HEAD and index moves matter: a commit, reset or git add
+		if name := filepath.Base(event.Name); name != "HEAD" && name != "index" {
+			return
+		}
+	} else if event.Has(fsnotify.Create) {
+		// New directories need their own watches
+		if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+			w.addTreeLocked(entry, event.Name, nil)
+		}
+	}
+
+	if event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+		// fsnotify drops watches on removed directories
+		delete(w.dirs, event.Name)
+	}
+
+	w.scheduleLocked(entry)
+}
+
+// scheduleLocked (re)starts the worktree's debounce timer
+func (w *Watcher) scheduleLocked(entry *watched) {
+	if entry.timer != nil {
+		entry.timer.Reset(w.debounce)
+		return
+	}
+	id := entry.wt.ID
+	entry.timer = time.AfterFunc(w.debounce, func() { w.scan(id) })
+}
+
+func (w *Watcher) scan(id string) {
+	w.mu.Lock()
+	entry := w.worktrees[id]
+	closed := w.closed
+	w.mu.Unlock()
+	if entry == nil || closed {
+		return
+	}
+
+	if err := conflicts.ScanWorktree(w.db, entry.repo, entry.wt); err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("watch: could not scan %s: %v", entry.wt.Path, err)
+		}
+		return
+	}
+	if w.OnScan != nil {
+		w.OnScan(entry.repo, entry.wt)
+	}
+}
+
+func (w *Watcher) close() {
+	w.mu.Lock()
+	w.closed = true
+	for _, entry := range w.worktrees {
+		if entry.timer != nil {
+			entry.timer.Stop()
+		}
+	}
+	w.mu.Unlock()
+	w.fs.Close()
+}
@@ -0,0 +1,138 @@
+package watch
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fathindos/agit/internal/registry"
+)
+
+func git(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, out)
+	}
+}
+
+// setupWorktree creates a repo with one commit on main and a linked worktree
+func setupWorktree(t *testing.T) (*registry.DB, *registry.Repo, *registry.Worktree) {
+	t.Helper()
+	root := t.TempDir()
+	repoPath := filepath.Join(root, "repo")
+	os.MkdirAll(repoPath, 0755)
+	git(t, repoPath, "init", "-b", "main")
+	git(t, repoPath, "config", "user.email", "test@example.com")
+	git(t, repoPath, "config", "user.name", "Test")
+	os.WriteFile(filepath.Join(repoPath, "README.md"), []byte("# Repo\n"), 0644)
+	git(t, repoPath, "add", ".")
+	git(t, repoPath, "commit", "-m", "initial")
+
+	wtPath := filepath.Join(root, "wt")
+	git(t, repoPath, "worktree", "add", "-b", "agit/watch", wtPath, "main")
+
+	db, err := registry.OpenMemory()
+	if err != nil {
+		t.Fatalf("OpenMemory: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	repo, err := db.AddRepo("watch-repo", repoPath, "", "main")
+	if err != nil {
+		t.Fatalf("AddRepo: %v", err)
+	}
+	wt, err := db.CreateWorktree(repo.ID, wtPath, "agit/watch", nil, nil)
+	if err != nil {
+		t.Fatalf("CreateWorktree: %v", err)
+	}
+	return db, repo, wt
+}
+
+func TestWatcherRecordsUncommittedEdits(t *testing.T) {
+	db, repo, wt := setupWorktree(t)
+
+	w, err := New(db, 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	scanned := make(chan struct{}, 10)
+	w.OnScan = func(*registry.Repo, *registry.Worktree) { scanned <- struct{}{} }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w.Run(ctx, time.Hour)
+	}()
+	defer func() { cancel(); <-done }()
+
+	for deadline := time.Now().Add(3 * time.Second); w.Len() == 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("worktree was not watched")
+		}
+	}
+
+	// A burst of edits, including in a new directory, is scanned once settled
+	os.MkdirAll(filepath.Join(wt.Path, "pkg"), 0755)
+	time.Sleep(20 * time.Millisecond)
+	os.WriteFile(filepath.Join(wt.Path, "pkg", "a.go"), []byte("package pkg\n"), 0644)
+	os.WriteFile(filepath.Join(wt.Path, "README.md"), []byte("# Edited\n"), 0644)
+
+	select {
+	case <-scanned:
+	case <-time.After(3 * time.Second):
+		t.Fatal("worktree was not rescanned")
+	}
+
+	// Both sides edit README.md; the other side has committed its change
+	other, _ := db.CreateWorktree(repo.ID, "/nonexistent/other", "other", nil, nil)
+	db.RecordFileTouches(repo.ID, other.ID, []registry.FileTouch{{FilePath: "README.md", ChangeType: "modified"}})
+
+	list, err := db.FindConflicts(repo.ID)
+	if err != nil {
+		t.Fatalf("FindConflicts: %v", err)
+	}
+	if len(list) != 1 || list[0].FilePath != "README.md" {
+		t.Fatalf("expected a README.md conflict, got %+v", list)
+	}
+	if !list[0].InFlight() {
+		t.Error("expected the conflict to be in flight")
+	}
+	for i, id := range list[0].Worktrees {
+		if list[0].Uncommitted[i] != (id == wt.ID) {
+			t.Errorf("worktree %s: uncommitted = %v", id, list[0].Uncommitted[i])
+		}
+	}
+}
+
+func TestSyncDropsInactiveWorktrees(t *testing.T) {
+	db, _, wt := setupWorktree(t)
+
+	w, err := New(db, 0)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer w.close()
+
+	if err := w.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if w.Len() != 1 {
+		t.Fatalf("expected 1 watched worktree, got %d", w.Len())
+	}
+
+	db.UpdateWorktreeStatus(wt.ID, "completed")
+	if err := w.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if w.Len() != 0 {
+		t.Errorf("expected no watched worktrees, got %d", w.Len())
+	}
+	if len(w.dirs) != 0 {
+		t.Errorf("expected all directory watches removed, got %v", w.dirs)
+	}
+}